@@ -9,6 +9,8 @@ require (
 	github.com/fatih/camelcase v1.0.0 // indirect
 	github.com/fatih/structs v1.1.0 // indirect
 	github.com/koding/multiconfig v0.0.0-20171124222453-69c27309b2d7
+	github.com/syndtr/goleveldb v1.0.1-0.20190923125748-758128399b1d
 	github.com/urfave/cli/v2 v2.2.0
+	golang.org/x/crypto v0.0.0-20200311171314-f7b00557c8c4
 	rsc.io/qr v0.2.0
 )
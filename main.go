@@ -9,18 +9,23 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/urfave/cli/v2"
 	"rsc.io/qr"
 
+	"xcoin/HayekTool/pkg/addressbook"
 	"xcoin/HayekTool/pkg/config"
 	"xcoin/HayekTool/pkg/mainpkg"
+	"xcoin/HayekTool/pkg/stratum"
 	"xcoin/HayekTool/pkg/util"
 )
 
 var (
 	pkgVersion string = "(devel)"
 	pkgCoinId  string = "hyk"
+
+	consoleApp *mainpkg.App // 控制台中正在执行命令时的 App
 )
 
 func main() {
@@ -62,6 +67,9 @@ func main() {
 			pkgCoinId = id
 		}
 
+		if consoleApp != nil {
+			return nil
+		}
 		if cfg := config.MustLoad(c.String("config")); cfg.DebugMode {
 			log.SetFlags(log.LstdFlags | log.Llongfile)
 		} else {
@@ -172,33 +180,427 @@ func main() {
 			},
 
 			Action: func(c *cli.Context) error {
-				cfg := config.MustLoad(c.String("config"))
-				if s := c.String("host"); s != "" {
-					cfg.Host = s
+				return newApp(c).CmdGetWork()
+			},
+		},
+
+		{
+			Name:  "work-monitor",
+			Usage: "monitor work changes and flag stale work",
+
+			Flags: []cli.Flag{
+				&cli.StringFlag{
+					Name:  "host",
+					Usage: "set host url",
+				},
+				&cli.DurationFlag{
+					Name:  "interval",
+					Usage: "set polling interval",
+					Value: time.Second,
+				},
+				&cli.DurationFlag{
+					Name:  "stale-after",
+					Usage: "flag work when height or timestamp is older than duration",
+					Value: time.Minute * 2,
+				},
+				&cli.StringFlag{
+					Name:  "format",
+					Usage: "set output format (text|json)",
+					Value: "text",
+				},
+			},
+
+			Action: func(c *cli.Context) error {
+				return newApp(c).CmdWorkMonitor(
+					c.Duration("interval"),
+					c.Duration("stale-after"),
+					c.String("format"),
+				)
+			},
+		},
+
+		{
+			Name:  "stratum-proxy",
+			Usage: "run stratum mining proxy in front of getWork/submitWork",
+
+			Flags: []cli.Flag{
+				&cli.StringFlag{
+					Name:  "host",
+					Usage: "set host url",
+				},
+				&cli.StringFlag{
+					Name:  "listen",
+					Usage: "set stratum listen address",
+					Value: stratum.DefaultListen,
+				},
+				&cli.Float64Flag{
+					Name:  "difficulty",
+					Usage: "set share difficulty (1 = 2^32 hashes)",
+					Value: stratum.DefaultDifficulty,
+				},
+				&cli.DurationFlag{
+					Name:  "interval",
+					Usage: "set getWork polling interval",
+					Value: time.Second,
+				},
+				&cli.DurationFlag{
+					Name:  "stats-interval",
+					Usage: "set worker stats logging interval",
+					Value: time.Minute,
+				},
+			},
+
+			Action: func(c *cli.Context) error {
+				return newApp(c).CmdStratumProxy(stratum.Config{
+					Listen:       c.String("listen"),
+					Difficulty:   c.Float64("difficulty"),
+					PollInterval: c.Duration("interval"),
+				}, c.Duration("stats-interval"))
+			},
+		},
+
+		{
+			Name:  "submit-work",
+			Usage: "submit mining result",
+
+			Flags: []cli.Flag{
+				&cli.StringFlag{
+					Name:  "host",
+					Usage: "set host url",
+				},
+				&cli.StringFlag{
+					Name:     "nonce",
+					Usage:    "set nonce (8 bytes hex)",
+					Required: true,
+				},
+				&cli.StringFlag{
+					Name:     "header",
+					Usage:    "set header hash (32 bytes hex)",
+					Required: true,
+				},
+				&cli.StringFlag{
+					Name:     "mix-digest",
+					Usage:    "set mix digest (32 bytes hex)",
+					Required: true,
+				},
+			},
+
+			Action: func(c *cli.Context) error {
+				return newApp(c).CmdSubmitWork(
+					c.String("nonce"),
+					c.String("header"),
+					c.String("mix-digest"),
+				)
+			},
+		},
+
+		{
+			Name:      "rpc-call",
+			Usage:     "call any rpc method and show the raw result",
+			ArgsUsage: "[params...]",
+
+			Flags: []cli.Flag{
+				&cli.StringFlag{
+					Name:  "host",
+					Usage: "set host url",
+				},
+				&cli.StringFlag{
+					Name:     "method",
+					Usage:    "set rpc method, e.g. txpool_status",
+					Required: true,
+				},
+				&cli.StringFlag{
+					Name:  "params",
+					Usage: "set params as JSON array (or pass arguments, numbers are hex encoded)",
+				},
+			},
+
+			Action: func(c *cli.Context) error {
+				return newApp(c).CmdRPCCall(
+					c.String("method"),
+					c.String("params"),
+					c.Args().Slice(),
+				)
+			},
+		},
+
+		{
+			Name:  "console",
+			Usage: "interactive console with completion, history and result pipes",
+
+			Flags: []cli.Flag{
+				&cli.StringFlag{
+					Name:  "host",
+					Usage: "set host url",
+				},
+				&cli.StringFlag{
+					Name:  "history-file",
+					Usage: "set command history file",
+					Value: mainpkg.DefaultConsoleHistoryFile,
+				},
+			},
+
+			Action: func(c *cli.Context) error {
+				var names []string
+				for _, cmd := range app.Commands {
+					if cmd.Name != "console" && cmd.Name != "help" {
+						names = append(names, cmd.Name)
+					}
 				}
 
-				return mainpkg.NewApp(cfg).CmdGetWork()
+				return newApp(c).CmdConsole(mainpkg.ConsoleOptions{
+					HistoryFile: c.String("history-file"),
+					Commands:    names,
+					Run: func(a *mainpkg.App, args []string) error {
+						// 使用控制台的配置和节点连接, 不重新加载
+						consoleApp = a
+						defer func() { consoleApp = nil }()
+						return app.Run(append([]string{os.Args[0]}, args...))
+					},
+				})
 			},
 		},
 
 		{
-			Name:  "get-pending-block",
-			Usage: "get pending block",
+			Name:  "serve",
+			Usage: "run HTTP JSON API for balances, blocks, address book and payouts",
 
 			Flags: []cli.Flag{
 				&cli.StringFlag{
 					Name:  "host",
 					Usage: "set host url",
 				},
+				&cli.StringFlag{
+					Name:  "listen",
+					Usage: "set http listen address",
+					Value: mainpkg.DefaultServeListen,
+				},
+				&cli.StringFlag{
+					Name:    "token",
+					Usage:   "set bearer token required by all requests (transfers are disabled without it)",
+					EnvVars: []string{"HAYEK_TOOL_API_TOKEN"},
+				},
+				&cli.Float64Flag{
+					Name:  "rate-limit",
+					Usage: "set requests per second per client (0 = unlimited)",
+				},
+				&cli.IntFlag{
+					Name:  "burst",
+					Usage: "set burst requests per client (default 2x rate limit)",
+				},
+				&cli.StringFlag{
+					Name:  "history-file",
+					Usage: "set payouts history file",
+					Value: mainpkg.DefaultPayoutsHistoryFile,
+				},
 			},
 
 			Action: func(c *cli.Context) error {
-				cfg := config.MustLoad(c.String("config"))
-				if s := c.String("host"); s != "" {
-					cfg.Host = s
+				return newApp(c).CmdServe(mainpkg.ServeOptions{
+					Listen:      c.String("listen"),
+					Token:       c.String("token"),
+					RateLimit:   c.Float64("rate-limit"),
+					Burst:       c.Int("burst"),
+					HistoryFile: c.String("history-file"),
+				})
+			},
+		},
+
+		{
+			Name:  "exporter",
+			Usage: "run Prometheus exporter for node, wallet and rpc metrics",
+
+			Flags: []cli.Flag{
+				&cli.StringFlag{
+					Name:  "host",
+					Usage: "set host url",
+				},
+				&cli.StringFlag{
+					Name:  "listen",
+					Usage: "set metrics listen address",
+					Value: mainpkg.DefaultExporterListen,
+				},
+				&cli.StringSliceFlag{
+					Name:  "node",
+					Usage: "add node url to scrape (default host)",
+				},
+				&cli.StringSliceFlag{
+					Name:  "address",
+					Usage: "add address or address book name to watch (default user address)",
+				},
+				&cli.DurationFlag{
+					Name:  "interval",
+					Usage: "set scrape interval",
+					Value: time.Second * 15,
+				},
+			},
+
+			Action: func(c *cli.Context) error {
+				app := newApp(c)
+				addresses := c.StringSlice("address")
+				if len(addresses) == 0 && app.Config().UserAddress != "" {
+					addresses = []string{app.Config().UserAddress}
 				}
+				return app.CmdExporter(mainpkg.ExporterOptions{
+					Listen:    c.String("listen"),
+					Nodes:     c.StringSlice("node"),
+					Addresses: addresses,
+					Interval:  c.Duration("interval"),
+				})
+			},
+		},
+
+		{
+			Name:  "mock-node",
+			Usage: "run in-memory simulated node for testing",
+
+			Flags: []cli.Flag{
+				&cli.StringFlag{
+					Name:  "listen",
+					Usage: "set rpc listen address",
+					Value: mainpkg.DefaultMockNodeListen,
+				},
+				&cli.StringSliceFlag{
+					Name:  "balance",
+					Usage: "set initial balance as address=amount (HYK), repeatable",
+				},
+				&cli.StringFlag{
+					Name:  "coinbase",
+					Usage: "set block miner address",
+				},
+				&cli.Float64Flag{
+					Name:  "reward",
+					Usage: "set block reward (HYK)",
+				},
+				&cli.Int64Flag{
+					Name:  "difficulty",
+					Usage: "set block difficulty",
+				},
+				&cli.DurationFlag{
+					Name:  "block-time",
+					Usage: "set block interval (0 = mine every transaction immediately)",
+				},
+			},
+
+			Action: func(c *cli.Context) error {
+				return newApp(c).CmdMockNode(mainpkg.MockNodeOptions{
+					Listen:     c.String("listen"),
+					Balances:   c.StringSlice("balance"),
+					Coinbase:   c.String("coinbase"),
+					Reward:     c.Float64("reward"),
+					Difficulty: c.Int64("difficulty"),
+					BlockTime:  c.Duration("block-time"),
+				})
+			},
+		},
+
+		{
+			Name:  "chain-stats",
+			Usage: "show block time, difficulty and hashrate of recent blocks",
+
+			Flags: []cli.Flag{
+				&cli.StringFlag{
+					Name:  "host",
+					Usage: "set host url",
+				},
+				&cli.IntFlag{
+					Name:  "blocks",
+					Usage: "set number of recent blocks",
+					Value: 100,
+				},
+				&cli.IntFlag{
+					Name:  "top",
+					Usage: "set number of top miners",
+					Value: 10,
+				},
+				&cli.StringFlag{
+					Name:  "format",
+					Usage: "set output format (table|json)",
+					Value: "table",
+				},
+			},
+
+			Action: func(c *cli.Context) error {
+				return newApp(c).CmdChainStats(
+					c.Int("blocks"),
+					c.Int("top"),
+					c.String("format"),
+				)
+			},
+		},
+
+		{
+			Name:  "verify-block",
+			Usage: "verify block hash, transactions root, receipts root and parent hash linkage",
+
+			Flags: []cli.Flag{
+				&cli.StringFlag{
+					Name:  "host",
+					Usage: "set host url",
+				},
+				&cli.StringFlag{
+					Name:     "height",
+					Usage:    "set block height, hash or latest",
+					Required: true,
+				},
+				&cli.StringFlag{
+					Name:  "to",
+					Usage: "set end height of range (inclusive)",
+				},
+			},
+
+			Action: func(c *cli.Context) error {
+				return newApp(c).CmdVerifyBlock(c.String("height"), c.String("to"))
+			},
+		},
+
+		{
+			Name:  "verify-pow",
+			Usage: "verify ethash nonce and mix digest of blocks against their difficulty",
+
+			Flags: []cli.Flag{
+				&cli.StringFlag{
+					Name:  "host",
+					Usage: "set host url",
+				},
+				&cli.StringFlag{
+					Name:     "height",
+					Usage:    "set block height, hash or latest",
+					Required: true,
+				},
+				&cli.StringFlag{
+					Name:  "to",
+					Usage: "set end height of range (inclusive)",
+				},
+				&cli.IntFlag{
+					Name:  "caches",
+					Usage: "set number of epoch caches kept",
+					Value: 2,
+				},
+			},
+
+			Action: func(c *cli.Context) error {
+				return newApp(c).CmdVerifyPow(
+					c.String("height"),
+					c.String("to"),
+					c.Int("caches"),
+				)
+			},
+		},
+
+		{
+			Name:  "get-pending-block",
+			Usage: "get pending block",
+
+			Flags: []cli.Flag{
+				&cli.StringFlag{
+					Name:  "host",
+					Usage: "set host url",
+				},
+			},
 
-				return mainpkg.NewApp(cfg).CmdGetPendingBlock()
+			Action: func(c *cli.Context) error {
+				return newApp(c).CmdGetPendingBlock()
 			},
 		},
 
@@ -214,12 +616,7 @@ func main() {
 			},
 
 			Action: func(c *cli.Context) error {
-				cfg := config.MustLoad(c.String("config"))
-				if s := c.String("host"); s != "" {
-					cfg.Host = s
-				}
-
-				return mainpkg.NewApp(cfg).CmdGetLatestBlock()
+				return newApp(c).CmdGetLatestBlock()
 			},
 		},
 
@@ -234,22 +631,18 @@ func main() {
 				},
 				&cli.StringFlag{
 					Name:  "address",
-					Usage: "set address",
+					Usage: "set address or address book name",
 				},
 			},
 
 			Action: func(c *cli.Context) error {
-				cfg := config.MustLoad(c.String("config"))
-				if s := c.String("host"); s != "" {
-					cfg.Host = s
-				}
-
-				address := cfg.UserAddress
+				app := newApp(c)
+				address := app.Config().UserAddress
 				if s := c.String("address"); s != "" {
 					address = s
 				}
 
-				return mainpkg.NewApp(cfg).CmdGetBalance(address)
+				return app.CmdGetBalance(address)
 			},
 		},
 
@@ -265,12 +658,7 @@ func main() {
 			},
 
 			Action: func(c *cli.Context) error {
-				cfg := config.MustLoad(c.String("config"))
-				if s := c.String("host"); s != "" {
-					cfg.Host = s
-				}
-
-				return mainpkg.NewApp(cfg).CmdGetPeerCount()
+				return newApp(c).CmdGetPeerCount()
 			},
 		},
 		{
@@ -285,12 +673,7 @@ func main() {
 			},
 
 			Action: func(c *cli.Context) error {
-				cfg := config.MustLoad(c.String("config"))
-				if s := c.String("host"); s != "" {
-					cfg.Host = s
-				}
-
-				return mainpkg.NewApp(cfg).CmdNetVersion()
+				return newApp(c).CmdNetVersion()
 			},
 		},
 
@@ -310,18 +693,13 @@ func main() {
 			},
 
 			Action: func(c *cli.Context) error {
-				cfg := config.MustLoad(c.String("config"))
-				if s := c.String("host"); s != "" {
-					cfg.Host = s
-				}
-
 				hash := c.String("hash")
 				if hash == "" {
 					fmt.Println("no tx hash")
 					os.Exit(1)
 				}
 
-				return mainpkg.NewApp(cfg).CmdGetTxReceipt(hash)
+				return newApp(c).CmdGetTxReceipt(hash)
 			},
 		},
 
@@ -341,18 +719,13 @@ func main() {
 			},
 
 			Action: func(c *cli.Context) error {
-				cfg := config.MustLoad(c.String("config"))
-				if s := c.String("host"); s != "" {
-					cfg.Host = s
-				}
-
 				hash := c.String("hash")
 				if hash == "" {
 					fmt.Println("no block hash")
 					os.Exit(1)
 				}
 
-				return mainpkg.NewApp(cfg).CmdGetBlockByHash(hash)
+				return newApp(c).CmdGetBlockByHash(hash)
 			},
 		},
 
@@ -372,18 +745,13 @@ func main() {
 			},
 
 			Action: func(c *cli.Context) error {
-				cfg := config.MustLoad(c.String("config"))
-				if s := c.String("host"); s != "" {
-					cfg.Host = s
-				}
-
 				height := c.Int("height")
 				if height <= 0 {
 					fmt.Println("no block height")
 					os.Exit(1)
 				}
 
-				return mainpkg.NewApp(cfg).CmdGetBlockByHeight(int64(height))
+				return newApp(c).CmdGetBlockByHeight(int64(height))
 			},
 		},
 
@@ -398,7 +766,7 @@ func main() {
 				},
 				&cli.StringFlag{
 					Name:  "to",
-					Usage: "set send to address",
+					Usage: "set send to address or address book name",
 				},
 				&cli.IntFlag{
 					Name:  "value",
@@ -417,18 +785,13 @@ func main() {
 			},
 
 			Action: func(c *cli.Context) error {
-				cfg := config.MustLoad(c.String("config"))
-				if s := c.String("host"); s != "" {
-					cfg.Host = s
-				}
-
 				to := c.String("to")
 				if to == "" {
 					fmt.Println("missing to address")
 					os.Exit(1)
 				}
 
-				return mainpkg.NewApp(cfg).CmdSendTx(to,
+				return newApp(c).CmdSendTx(to,
 					c.Int64("value"),
 					c.Int64("gas-limit"),
 					c.Int64("gas-price"),
@@ -436,6 +799,222 @@ func main() {
 			},
 		},
 
+		{
+			Name:  "send-batch",
+			Usage: "send transfers from csv file (to,amount[,memo])",
+
+			Flags: []cli.Flag{
+				&cli.StringFlag{
+					Name:  "host",
+					Usage: "set host url",
+				},
+				&cli.StringFlag{
+					Name:     "file",
+					Usage:    "set transfers csv file",
+					Required: true,
+				},
+				&cli.StringFlag{
+					Name:  "result",
+					Usage: "set result csv file (default: <file>.result.csv)",
+				},
+				&cli.IntFlag{
+					Name:  "gas-limit",
+					Usage: "set gas limit",
+					Value: mainpkg.DefaultGasLimit,
+				},
+				&cli.IntFlag{
+					Name:  "gas-price",
+					Usage: "set gas price",
+					Value: mainpkg.DefaultGasPrice,
+				},
+				&cli.BoolFlag{
+					Name:  "yes",
+					Usage: "send without confirming the batch",
+				},
+			},
+
+			Action: func(c *cli.Context) error {
+				return newApp(c).CmdSendBatch(
+					c.String("file"),
+					c.String("result"),
+					c.Int64("gas-limit"),
+					c.Int64("gas-price"),
+					c.Bool("yes"),
+				)
+			},
+		},
+
+		{
+			Name:  "watch",
+			Usage: "watch addresses for transfers and balance changes",
+
+			Flags: []cli.Flag{
+				&cli.StringFlag{
+					Name:  "host",
+					Usage: "set host url",
+				},
+				&cli.StringSliceFlag{
+					Name:  "address",
+					Usage: "add address or address book name to watch",
+				},
+				&cli.StringFlag{
+					Name:  "tag",
+					Usage: "watch address book entries with tag",
+				},
+				&cli.StringFlag{
+					Name:  "state-file",
+					Usage: "set state file for resuming",
+					Value: mainpkg.DefaultWatchStateFile,
+				},
+				&cli.Int64Flag{
+					Name:  "from-height",
+					Usage: "start from height when there is no state (default: latest)",
+				},
+				&cli.Int64Flag{
+					Name:  "confirmations",
+					Usage: "only process blocks with enough confirmations",
+				},
+				&cli.DurationFlag{
+					Name:  "interval",
+					Usage: "set polling interval",
+					Value: time.Second * 5,
+				},
+				&cli.Float64Flag{
+					Name:  "min-balance",
+					Usage: "alert when balance falls below value (HYK)",
+				},
+				&cli.StringFlag{
+					Name:  "webhook",
+					Usage: "post events to webhook url",
+				},
+				&cli.StringFlag{
+					Name:  "webhook-secret",
+					Usage: "set webhook signing secret",
+				},
+				&cli.StringFlag{
+					Name:  "out",
+					Usage: "append events to file (default: stdout)",
+				},
+			},
+
+			Action: func(c *cli.Context) error {
+				opt := &mainpkg.WatchOptions{
+					Addresses:     c.StringSlice("address"),
+					Tag:           c.String("tag"),
+					StateFile:     c.String("state-file"),
+					FromHeight:    c.Int64("from-height"),
+					Confirmations: c.Int64("confirmations"),
+					Interval:      c.Duration("interval"),
+					MinBalance:    c.Float64("min-balance"),
+					Webhook:       c.String("webhook"),
+					WebhookSecret: c.String("webhook-secret"),
+				}
+				if s := c.String("out"); s != "" {
+					f, err := os.OpenFile(s, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+					if err != nil {
+						return err
+					}
+					defer f.Close()
+					opt.Output = f
+				}
+
+				return newApp(c).CmdWatch(opt)
+			},
+		},
+
+		{
+			Name:  "index",
+			Usage: "index blocks and transactions into local database",
+
+			Flags: []cli.Flag{
+				&cli.StringFlag{
+					Name:  "host",
+					Usage: "set host url",
+				},
+				&cli.StringFlag{
+					Name:  "dir",
+					Usage: "set index directory (default: IndexDir in config)",
+				},
+				&cli.Int64Flag{
+					Name:  "from-height",
+					Usage: "start from height when index is empty",
+				},
+				&cli.Int64Flag{
+					Name:  "confirmations",
+					Usage: "only index blocks with enough confirmations",
+				},
+				&cli.BoolFlag{
+					Name:  "follow",
+					Usage: "keep following new blocks",
+				},
+				&cli.DurationFlag{
+					Name:  "interval",
+					Usage: "set polling interval when following",
+					Value: time.Second * 5,
+				},
+			},
+
+			Action: func(c *cli.Context) error {
+				app := newApp(c)
+				if s := c.String("dir"); s != "" {
+					app = app.WithIndexDir(s)
+				}
+
+				return app.CmdIndex(&mainpkg.IndexOptions{
+					FromHeight:    c.Int64("from-height"),
+					Confirmations: c.Int64("confirmations"),
+					Follow:        c.Bool("follow"),
+					Interval:      c.Duration("interval"),
+				})
+			},
+		},
+
+		{
+			Name:  "history",
+			Usage: "show address transactions from local index",
+
+			Flags: []cli.Flag{
+				&cli.StringFlag{
+					Name:     "address",
+					Usage:    "set address or address book name",
+					Required: true,
+				},
+				&cli.StringFlag{
+					Name:  "dir",
+					Usage: "set index directory (default: IndexDir in config)",
+				},
+				&cli.IntFlag{
+					Name:  "page",
+					Usage: "set page number",
+					Value: 1,
+				},
+				&cli.IntFlag{
+					Name:  "page-size",
+					Usage: "set page size",
+					Value: 20,
+				},
+				&cli.StringFlag{
+					Name:  "format",
+					Usage: "set output format (table|json)",
+					Value: "table",
+				},
+			},
+
+			Action: func(c *cli.Context) error {
+				app := newApp(c)
+				if s := c.String("dir"); s != "" {
+					app = app.WithIndexDir(s)
+				}
+
+				return app.CmdHistory(
+					c.String("address"),
+					c.Int("page"),
+					c.Int("page-size"),
+					c.String("format"),
+				)
+			},
+		},
+
 		{
 			Hidden: true, // 内部功能
 
@@ -452,18 +1031,310 @@ func main() {
 					Usage: "set payouts file",
 					Value: "payouts-file.json",
 				},
+				&cli.StringFlag{
+					Name:  "metrics-listen",
+					Usage: "set Prometheus metrics listen address, exports the payout wallets (disabled if empty)",
+				},
 			},
 
 			Action: func(c *cli.Context) error {
-				cfg := config.MustLoad(c.String("config"))
-				if s := c.String("host"); s != "" {
-					cfg.Host = s
+				app := newApp(c)
+				if s := c.String("metrics-listen"); s != "" {
+					app.SetPayoutsMetrics(mainpkg.ExporterOptions{Listen: s})
 				}
-
-				mainpkg.NewApp(cfg).CmdRunPayoutsService(
+				return app.CmdRunPayoutsService(
 					c.String("payouts-file"),
+					c.String("config"),
 				)
-				return nil
+			},
+		},
+
+		{
+			Name:  "addressbook",
+			Usage: "manage address book",
+
+			Subcommands: []*cli.Command{
+				{
+					Name:  "add",
+					Usage: "add address",
+
+					Flags: []cli.Flag{
+						&cli.StringFlag{
+							Name:     "name",
+							Usage:    "set name",
+							Required: true,
+						},
+						&cli.StringFlag{
+							Name:     "address",
+							Usage:    "set address",
+							Required: true,
+						},
+						&cli.StringFlag{
+							Name:  "label",
+							Usage: "set label",
+						},
+						&cli.StringSliceFlag{
+							Name:  "tag",
+							Usage: "add tag (repeatable)",
+						},
+						&cli.StringFlag{
+							Name:  "note",
+							Usage: "set note",
+						},
+						&cli.BoolFlag{
+							Name:  "replace",
+							Usage: "replace existing name",
+						},
+					},
+
+					Action: func(c *cli.Context) error {
+						return newApp(c).CmdAddressBookAdd(&addressbook.Entry{
+							Name:    c.String("name"),
+							Address: c.String("address"),
+							Label:   c.String("label"),
+							Tags:    c.StringSlice("tag"),
+							Note:    c.String("note"),
+						}, c.Bool("replace"))
+					},
+				},
+
+				{
+					Name:  "rm",
+					Usage: "remove address",
+
+					Flags: []cli.Flag{
+						&cli.StringFlag{
+							Name:     "name",
+							Usage:    "set name",
+							Required: true,
+						},
+					},
+
+					Action: func(c *cli.Context) error {
+						return newApp(c).CmdAddressBookRemove(c.String("name"))
+					},
+				},
+
+				{
+					Name:  "ls",
+					Usage: "list addresses",
+
+					Flags: []cli.Flag{
+						&cli.StringFlag{
+							Name:  "tag",
+							Usage: "only list addresses with tag",
+						},
+						&cli.StringFlag{
+							Name:  "format",
+							Usage: "output format: table, json or csv",
+							Value: "table",
+						},
+					},
+
+					Action: func(c *cli.Context) error {
+						return newApp(c).CmdAddressBookList(c.String("tag"), c.String("format"))
+					},
+				},
+
+				{
+					Name:  "import",
+					Usage: "import addresses from json/csv file",
+
+					Flags: []cli.Flag{
+						&cli.StringFlag{
+							Name:  "file",
+							Usage: "set json or csv file (name,address,label,tags,note)",
+						},
+						&cli.BoolFlag{
+							Name:  "from-config",
+							Usage: "import XUserAddressBook of config file",
+						},
+						&cli.BoolFlag{
+							Name:  "replace",
+							Usage: "replace existing names",
+						},
+					},
+
+					Action: func(c *cli.Context) error {
+						return newApp(c).CmdAddressBookImport(
+							c.String("file"),
+							c.Bool("from-config"),
+							c.Bool("replace"),
+						)
+					},
+				},
+
+				{
+					Name:  "export",
+					Usage: "export addresses to json/csv file",
+
+					Flags: []cli.Flag{
+						&cli.StringFlag{
+							Name:  "file",
+							Usage: "set output file (default: stdout)",
+						},
+						&cli.StringFlag{
+							Name:  "format",
+							Usage: "output format: json or csv (default: by file extension)",
+						},
+						&cli.StringFlag{
+							Name:  "tag",
+							Usage: "only export addresses with tag",
+						},
+					},
+
+					Action: func(c *cli.Context) error {
+						return newApp(c).CmdAddressBookExport(
+							c.String("file"),
+							c.String("format"),
+							c.String("tag"),
+						)
+					},
+				},
+			},
+		},
+
+		{
+			Name:  "payouts",
+			Usage: "payouts tools",
+
+			Subcommands: []*cli.Command{
+				{
+					Name:  "validate",
+					Usage: "validate payouts file",
+
+					Flags: []cli.Flag{
+						&cli.StringFlag{
+							Name:  "payouts-file",
+							Usage: "set payouts file",
+							Value: "payouts-file.json",
+						},
+					},
+
+					Action: func(c *cli.Context) error {
+						return newApp(c).CmdPayoutsValidate(
+							c.String("payouts-file"),
+						)
+					},
+				},
+
+				{
+					Name:  "flush",
+					Usage: "pay out all carried balances now",
+
+					Flags: []cli.Flag{
+						&cli.StringFlag{
+							Name:  "host",
+							Usage: "set host url",
+						},
+						&cli.StringFlag{
+							Name:  "payouts-file",
+							Usage: "set payouts file",
+							Value: "payouts-file.json",
+						},
+						&cli.StringFlag{
+							Name:  "group",
+							Usage: "only flush the named payouts group",
+						},
+					},
+
+					Action: func(c *cli.Context) error {
+						return newApp(c).CmdPayoutsFlush(
+							c.String("payouts-file"),
+							c.String("group"),
+						)
+					},
+				},
+
+				{
+					Name:  "approve",
+					Usage: "approve a pending payouts plan",
+
+					Flags: []cli.Flag{
+						&cli.StringFlag{
+							Name:  "payouts-file",
+							Usage: "set payouts file",
+							Value: "payouts-file.json",
+						},
+						&cli.StringFlag{
+							Name:     "plan",
+							Usage:    "set plan file",
+							Required: true,
+						},
+						&cli.StringFlag{
+							Name:     "key",
+							Usage:    "approver private key: env:NAME or file:PATH",
+							Required: true,
+						},
+					},
+
+					Action: func(c *cli.Context) error {
+						return newApp(c).CmdPayoutsApprove(
+							c.String("payouts-file"),
+							c.String("plan"),
+							c.String("key"),
+						)
+					},
+				},
+
+				{
+					Name:  "report",
+					Usage: "payouts history report",
+
+					Flags: []cli.Flag{
+						&cli.StringFlag{
+							Name:  "host",
+							Usage: "set host url",
+						},
+						&cli.StringFlag{
+							Name:  "payouts-file",
+							Usage: "set payouts file",
+							Value: "payouts-file.json",
+						},
+						&cli.StringFlag{
+							Name:  "history-file",
+							Usage: "set payouts history file (default from payouts file)",
+						},
+						&cli.StringFlag{
+							Name:  "from",
+							Usage: "start date, like 2020-07-01",
+						},
+						&cli.StringFlag{
+							Name:  "to",
+							Usage: "end date (inclusive), like 2020-07-31",
+						},
+						&cli.StringFlag{
+							Name:  "format",
+							Usage: "output format: csv or json",
+							Value: "json",
+						},
+					},
+
+					Action: func(c *cli.Context) error {
+						var from, to time.Time
+						if s := c.String("from"); s != "" {
+							t, err := time.ParseInLocation("2006-01-02", s, time.Local)
+							if err != nil {
+								return err
+							}
+							from = t
+						}
+						if s := c.String("to"); s != "" {
+							t, err := time.ParseInLocation("2006-01-02", s, time.Local)
+							if err != nil {
+								return err
+							}
+							to = t.AddDate(0, 0, 1)
+						}
+
+						return newApp(c).CmdPayoutsReportFile(
+							c.String("payouts-file"),
+							c.String("history-file"),
+							from, to,
+							c.String("format"),
+						)
+					},
+				},
 			},
 		},
 	}
@@ -472,5 +1343,23 @@ func main() {
 		fmt.Fprintf(ctx.App.Writer, "not found '%v'!\n", command)
 	}
 
-	app.Run(os.Args)
+	if err := app.Run(os.Args); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// 控制台中执行的命令使用控制台的 App, 否则加载配置文件
+func newApp(c *cli.Context) *mainpkg.App {
+	if consoleApp != nil {
+		if s := c.String("host"); s != "" {
+			return consoleApp.WithHost(s)
+		}
+		return consoleApp
+	}
+
+	cfg := config.MustLoad(c.String("config"))
+	if s := c.String("host"); s != "" {
+		cfg.Host = s
+	}
+	return mainpkg.NewApp(cfg)
 }
@@ -0,0 +1,277 @@
+// 地址簿: 名字到地址的映射, 每个地址可以有标签/分类/备注, 保存在独立的 JSON 文件中
+package addressbook
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/common"
+
+	"xcoin/HayekTool/pkg/util"
+)
+
+const DefaultFile = "addressbook.json"
+
+// 名字不能是 0x 开头, 避免和地址混淆
+var namePattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_.-]*$`)
+
+// 地址簿中的一项
+type Entry struct {
+	Name    string
+	Address string
+	Label   string   `json:",omitempty"` // 显示名称
+	Tags    []string `json:",omitempty"` // 分类
+	Note    string   `json:",omitempty"` // 备注
+}
+
+// 地址簿, 按名字排序
+type Book struct {
+	Entries []*Entry
+}
+
+// 读取地址簿, 文件不存在时返回空的地址簿
+func Load(path string) (*Book, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return &Book{}, nil
+		}
+		return nil, err
+	}
+
+	var entries []*Entry
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+
+	b := &Book{}
+	for _, e := range entries {
+		if err := b.Add(e, false); err != nil {
+			return nil, fmt.Errorf("%s: %v", path, err)
+		}
+	}
+	return b, nil
+}
+
+// 原子地写入, 避免中途退出时损坏
+func (b *Book) Save(path string) error {
+	data, err := json.MarshalIndent(b.Entries, "", "\t")
+	if err != nil {
+		return err
+	}
+	return util.WriteFileAtomic(path, append(data, '\n'), 0644)
+}
+
+// 检查名字和地址, 成功时返回规范化的项
+func (e *Entry) validate() (*Entry, error) {
+	if !namePattern.MatchString(e.Name) || strings.HasPrefix(strings.ToLower(e.Name), "0x") {
+		return nil, fmt.Errorf("invalid name %q", e.Name)
+	}
+	if err := ValidateAddress(e.Address); err != nil {
+		return nil, fmt.Errorf("%s: %v", e.Name, err)
+	}
+
+	x := *e
+	x.Tags = nil
+	for _, tag := range e.Tags {
+		if tag = strings.TrimSpace(tag); tag != "" && !util.StringInSlice(tag, x.Tags) {
+			x.Tags = append(x.Tags, tag)
+		}
+	}
+	return &x, nil
+}
+
+// 添加一项, 名字已经存在时只有 replace 为 true 才覆盖
+func (b *Book) Add(e *Entry, replace bool) error {
+	x, err := e.validate()
+	if err != nil {
+		return err
+	}
+
+	if i := b.index(x.Name); i >= 0 {
+		if !replace {
+			return fmt.Errorf("%s: already exists", x.Name)
+		}
+		b.Entries[i] = x
+		return nil
+	}
+
+	b.Entries = append(b.Entries, x)
+	sort.SliceStable(b.Entries, func(i, j int) bool {
+		return b.Entries[i].Name < b.Entries[j].Name
+	})
+	return nil
+}
+
+// 删除一项
+func (b *Book) Remove(name string) error {
+	i := b.index(name)
+	if i < 0 {
+		return fmt.Errorf("%s: not found", name)
+	}
+	b.Entries = append(b.Entries[:i], b.Entries[i+1:]...)
+	return nil
+}
+
+// 按名字查找
+func (b *Book) Get(name string) *Entry {
+	if i := b.index(name); i >= 0 {
+		return b.Entries[i]
+	}
+	return nil
+}
+
+// 包含某个分类的项, tag 为空时返回全部
+func (b *Book) Filter(tag string) []*Entry {
+	if tag == "" {
+		return b.Entries
+	}
+	var list []*Entry
+	for _, e := range b.Entries {
+		if util.StringInSlice(tag, e.Tags) {
+			list = append(list, e)
+		}
+	}
+	return list
+}
+
+func (b *Book) index(name string) int {
+	for i, e := range b.Entries {
+		if e.Name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// 把名字或者地址解析为地址
+//
+// 0x 开头的按地址检查(大小写混合时按 EIP-55 校验), 其它的必须是地址簿中的名字.
+func (b *Book) Resolve(nameOrAddress string) (string, error) {
+	s := strings.TrimSpace(nameOrAddress)
+	if strings.HasPrefix(s, "0x") || strings.HasPrefix(s, "0X") {
+		if err := ValidateAddress(s); err != nil {
+			return "", err
+		}
+		return s, nil
+	}
+	if e := b.Get(s); e != nil {
+		return e.Address, nil
+	}
+	return "", fmt.Errorf("unknown address name %q", s)
+}
+
+// 检查地址格式, 大小写混合时按 EIP-55 校验
+func ValidateAddress(s string) error {
+	if !util.IsValidHexAddress(s) {
+		return fmt.Errorf("invalid address %q", s)
+	}
+	hex := s[2:]
+	if hex != strings.ToLower(hex) && hex != strings.ToUpper(hex) {
+		if expect := common.HexToAddress(s).Hex(); s != expect {
+			return fmt.Errorf("invalid EIP-55 checksum %q, expect %q", s, expect)
+		}
+	}
+	return nil
+}
+
+var csvHeader = []string{"name", "address", "label", "tags", "note"}
+
+// 导出为 json 或 csv, csv 中多个分类用 ; 分隔
+func Export(w io.Writer, format string, entries []*Entry) error {
+	switch format {
+	case "json", "":
+		data, err := json.MarshalIndent(entries, "", "\t")
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintln(w, string(data))
+		return err
+
+	case "csv":
+		cw := csv.NewWriter(w)
+		cw.Write(csvHeader)
+		for _, e := range entries {
+			cw.Write([]string{e.Name, e.Address, e.Label, strings.Join(e.Tags, ";"), e.Note})
+		}
+		cw.Flush()
+		return cw.Error()
+	}
+	return fmt.Errorf("unknown format %q, expect json or csv", format)
+}
+
+// 从 json 或 csv 读取, 格式和 Export 相同
+func ReadEntries(r io.Reader, format string) ([]*Entry, error) {
+	switch format {
+	case "json":
+		var entries []*Entry
+		if err := json.NewDecoder(r).Decode(&entries); err != nil {
+			return nil, err
+		}
+		return entries, nil
+
+	case "csv":
+		cr := csv.NewReader(r)
+		cr.FieldsPerRecord = -1
+		cr.TrimLeadingSpace = true
+		records, err := cr.ReadAll()
+		if err != nil {
+			return nil, err
+		}
+
+		var entries []*Entry
+		for i, record := range records {
+			if i == 0 && strings.EqualFold(record[0], csvHeader[0]) {
+				continue
+			}
+			if len(record) < 2 {
+				return nil, fmt.Errorf("line %d: expect name,address[,label,tags,note]", i+1)
+			}
+			e := &Entry{Name: record[0], Address: record[1]}
+			if len(record) > 2 {
+				e.Label = record[2]
+			}
+			if len(record) > 3 && record[3] != "" {
+				e.Tags = strings.Split(record[3], ";")
+			}
+			if len(record) > 4 {
+				e.Note = record[4]
+			}
+			entries = append(entries, e)
+		}
+		return entries, nil
+	}
+	return nil, fmt.Errorf("unknown format %q, expect json or csv", format)
+}
+
+// 导入多项, 全部检查通过才修改地址簿
+func (b *Book) Import(entries []*Entry, replace bool) error {
+	x := &Book{Entries: append([]*Entry(nil), b.Entries...)}
+	var errs []string
+	for _, e := range entries {
+		if err := x.Add(e, replace); err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("import: %s", strings.Join(errs, "; "))
+	}
+	b.Entries = x.Entries
+	return nil
+}
+
+// 根据文件扩展名判断格式
+func FormatOf(path string) string {
+	if strings.EqualFold(filepath.Ext(path), ".csv") {
+		return "csv"
+	}
+	return "json"
+}
@@ -0,0 +1,109 @@
+package addressbook
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const (
+	alice = "0x3eb41fc94f240242c9bbb8bf46b9feb356fd09e2"
+	bob   = "0x5205f45c6399c41e11e533926ca69a0aedfdbb8d"
+)
+
+func TestResolve(t *testing.T) {
+	b := &Book{}
+	if err := b.Add(&Entry{Name: "alice", Address: alice, Tags: []string{"team", " team", ""}}, false); err != nil {
+		t.Fatal(err)
+	}
+	if got := b.Get("alice").Tags; len(got) != 1 || got[0] != "team" {
+		t.Fatalf("tags = %v", got)
+	}
+
+	for _, tt := range []struct {
+		in, out string
+		ok      bool
+	}{
+		{"alice", alice, true},
+		{bob, bob, true},
+		{"0x52908400098527886E0F7030069857D2E4169EE7", "0x52908400098527886E0F7030069857D2E4169EE7", true},
+		{"alicee", "", false}, // 不认识的名字
+		{"0x5205f45c6399c41e11e533926ca69a0aedfdbb8", "", false},  // 长度不对
+		{"0x52908400098527886E0F7030069857D2E4169Ee7", "", false}, // EIP-55 校验失败
+	} {
+		got, err := b.Resolve(tt.in)
+		if (err == nil) != tt.ok || got != tt.out {
+			t.Errorf("Resolve(%q) = %q, %v", tt.in, got, err)
+		}
+	}
+
+	for _, e := range []*Entry{
+		{Name: "alice", Address: bob},
+		{Name: "0xabc", Address: bob},
+		{Name: "bad name", Address: bob},
+		{Name: "bob", Address: "bob"},
+	} {
+		if err := b.Add(e, false); err == nil {
+			t.Errorf("Add(%+v): expect error", e)
+		}
+	}
+	if err := b.Add(&Entry{Name: "alice", Address: bob}, true); err != nil || b.Get("alice").Address != bob {
+		t.Fatalf("replace: %v", err)
+	}
+}
+
+func TestImportExport(t *testing.T) {
+	dir, err := ioutil.TempDir("", "addressbook")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, DefaultFile)
+
+	b, err := Load(path)
+	if err != nil || len(b.Entries) != 0 {
+		t.Fatal(b, err)
+	}
+
+	csvData := "name,address,label,tags,note\n" +
+		"bob," + bob + ",Bob,team;ops,\"hi, there\"\n" +
+		"alice," + alice + "\n"
+	entries, err := ReadEntries(bytes.NewBufferString(csvData), "csv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Import(entries, false); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Save(path); err != nil {
+		t.Fatal(err)
+	}
+
+	// 有一项错误时整体不导入
+	bad := []*Entry{{Name: "carol", Address: bob}, {Name: "alice", Address: bob}}
+	if err := b.Import(bad, false); err == nil || b.Get("carol") != nil {
+		t.Fatalf("import with conflict: %v", err)
+	}
+
+	loaded, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(loaded.Entries) != 2 || loaded.Entries[0].Name != "alice" || loaded.Get("bob").Note != "hi, there" {
+		t.Fatalf("loaded = %+v", loaded.Entries)
+	}
+	if list := loaded.Filter("ops"); len(list) != 1 || list[0].Name != "bob" {
+		t.Fatalf("filter = %+v", list)
+	}
+
+	var buf bytes.Buffer
+	if err := Export(&buf, "csv", loaded.Entries); err != nil {
+		t.Fatal(err)
+	}
+	again, err := ReadEntries(&buf, "csv")
+	if err != nil || len(again) != 2 || again[1].Tags[1] != "ops" {
+		t.Fatalf("csv round trip: %+v %v", again, err)
+	}
+}
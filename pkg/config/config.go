@@ -18,7 +18,13 @@ type Config struct {
 	UserKey     string `default:""`
 	UserAddress string `default:"0x3eb41fc94f240242c9bbb8bf46b9feb356fd09e2"`
 
-	XUserAddressBook map[string]string // 其它地址簿 map[name]address
+	XUserAddressBook map[string]string // 其它地址簿 map[name]address, 建议使用 AddressBookFile
+
+	AddressBookFile string `default:"addressbook.json"` // 地址簿文件(addressbook 命令管理)
+
+	PolicyFile string `default:""` // 支出策略文件(限额/收款地址名单), 为空时不限制
+
+	IndexDir string `default:"index"` // 本地区块索引目录(index/history 命令使用)
 }
 
 func Default() *Config {
@@ -53,15 +59,6 @@ func MustLoad(path string) *Config {
 	return conf
 }
 
-func (m *Config) GetAddress(id string) string {
-	if len(m.XUserAddressBook) > 0 {
-		if v, ok := m.XUserAddressBook[id]; ok {
-			return v
-		}
-	}
-	return id
-}
-
 func (m *Config) Clone() *Config {
 	var q = *m
 	return &q
@@ -0,0 +1,42 @@
+package ethash
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common/hexutil"
+)
+
+func TestHashimotoLight(t *testing.T) {
+	cache := make([]uint32, 1024/4)
+	generateCache(cache, make([]byte, 32))
+
+	hash := hexutil.MustDecode("0xc9149cc0386e689d789a1c2f3d5d169a61a6218ed30e74414dc736e442ef3d1f")
+	wantDigest := hexutil.MustDecode("0xe4073cffaef931d37117cefd9afd27ea0f1cad6a981dd2605c4a1ac97c519800")
+	wantResult := hexutil.MustDecode("0xd3539235ee2e6f8db665c0a72169f55b7f6c605712330b778ec3944f0eb5a557")
+
+	digest, result := hashimotoLight(32*1024, cache, hash, 0)
+	if !bytes.Equal(digest, wantDigest) {
+		t.Errorf("digest mismatch: have %x, want %x", digest, wantDigest)
+	}
+	if !bytes.Equal(result, wantResult) {
+		t.Errorf("result mismatch: have %x, want %x", result, wantResult)
+	}
+}
+
+func TestSeedEpoch(t *testing.T) {
+	l := NewLight(1)
+	for _, epoch := range []uint64{0, 1, 37} {
+		got, err := l.Epoch(SeedHash(epoch))
+		if err != nil || got != epoch {
+			t.Errorf("Epoch(SeedHash(%d)) = %d, %v", epoch, got, err)
+		}
+	}
+	if _, err := l.Epoch(make([]byte, 31)); err == nil {
+		t.Error("expect error for unknown seed")
+	}
+
+	if c0, c1 := l.Cache(0), l.Cache(EpochLength); c0 == c1 || l.Cache(EpochLength) != c1 || len(l.caches) != 1 {
+		t.Errorf("lru: %v", l.order)
+	}
+}
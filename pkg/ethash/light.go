@@ -0,0 +1,107 @@
+package ethash
+
+import (
+	"bytes"
+	"fmt"
+	"sync"
+
+	"golang.org/x/crypto/sha3"
+)
+
+// 查找 seed 对应的 epoch 时最多尝试的 epoch 数
+const maxSeedEpoch = 2048
+
+// 一个 epoch 的验证缓存
+type Cache struct {
+	Epoch uint64
+
+	once  sync.Once
+	cache []uint32
+	size  uint64 // dataset 大小
+}
+
+func (c *Cache) generate() {
+	c.once.Do(func() {
+		c.cache = make([]uint32, calcCacheSize(c.Epoch)/4)
+		generateCache(c.cache, SeedHash(c.Epoch))
+		c.size = calcDatasetSize(c.Epoch)
+	})
+}
+
+// 计算 header 哈希(不含 nonce 和 mix digest)和 nonce 对应的 mix digest 和结果
+func (c *Cache) Compute(hash []byte, nonce uint64) (mix, result []byte) {
+	c.generate()
+	return hashimotoLight(c.size, c.cache, hash, nonce)
+}
+
+// 轻量验证, 保留最近使用的几个 epoch 的缓存
+//
+// 每个缓存至少 16MB, 第一次使用时生成, 需要一两秒.
+type Light struct {
+	mu     sync.Mutex
+	max    int
+	caches map[uint64]*Cache
+	order  []uint64 // 最近使用的排在最后
+	seeds  map[string]uint64
+}
+
+func NewLight(maxCaches int) *Light {
+	if maxCaches <= 0 {
+		maxCaches = 2
+	}
+	return &Light{
+		max:    maxCaches,
+		caches: make(map[uint64]*Cache),
+		seeds:  make(map[string]uint64),
+	}
+}
+
+// 区块高度对应的缓存
+func (l *Light) Cache(height uint64) *Cache {
+	epoch := height / EpochLength
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for i, e := range l.order {
+		if e == epoch {
+			l.order = append(append(l.order[:i:i], l.order[i+1:]...), epoch)
+			return l.caches[epoch]
+		}
+	}
+
+	c := &Cache{Epoch: epoch}
+	l.caches[epoch] = c
+	l.order = append(l.order, epoch)
+	if len(l.order) > l.max {
+		delete(l.caches, l.order[0])
+		l.order = l.order[1:]
+	}
+	return c
+}
+
+// seed 哈希对应的 epoch
+func (l *Light) Epoch(seed []byte) (uint64, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if epoch, ok := l.seeds[string(seed)]; ok {
+		return epoch, nil
+	}
+
+	s := make([]byte, 32)
+	keccak256 := makeHasher(sha3.NewLegacyKeccak256())
+	for epoch := uint64(0); epoch < maxSeedEpoch; epoch++ {
+		if bytes.Equal(s, seed) {
+			l.seeds[string(seed)] = epoch
+			return epoch, nil
+		}
+		keccak256(s, s)
+	}
+	return 0, fmt.Errorf("ethash: unknown seed hash %x", seed)
+}
+
+// 计算区块的 mix digest 和结果
+func (l *Light) Compute(height uint64, hash []byte, nonce uint64) (mix, result []byte) {
+	return l.Cache(height).Compute(hash, nonce)
+}
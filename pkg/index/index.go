@@ -0,0 +1,333 @@
+// 本地区块索引
+//
+// 按高度保存区块, 按哈希保存交易和收据, 并按地址建立交易索引,
+// 用于在本地查询地址的交易历史(节点不提供这个功能).
+package index
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/opt"
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+const DefaultDir = "index"
+
+var ErrNotChild = errors.New("index: block is not a child of head")
+
+// 数据库中的键:
+//
+//	m:head              => 最新区块高度
+//	m:start             => 开始索引的高度
+//	b:<height>          => Block
+//	t:<hash>            => Tx
+//	a:<address><height><index> => 交易哈希
+//	c:<address>         => 地址的交易数量
+var (
+	keyHead  = []byte("m:head")
+	keyStart = []byte("m:start")
+)
+
+// 区块
+type Block struct {
+	Number       int64
+	Hash         string
+	ParentHash   string
+	Timestamp    int64
+	Miner        string
+	Difficulty   string
+	GasLimit     string
+	GasUsed      string
+	Uncles       int
+	Transactions []string // 交易哈希
+}
+
+// 交易和收据
+type Tx struct {
+	Hash        string
+	BlockNumber int64
+	BlockHash   string
+	Index       int
+	Timestamp   int64
+	From        string
+	To          string // 创建合约时为空
+	Value       string
+	Gas         string
+	GasPrice    string
+	Nonce       string
+	Input       string `json:",omitempty"`
+
+	Status          string // 收据中的状态, 0x1 表示成功
+	GasUsed         string
+	ContractAddress string `json:",omitempty"`
+}
+
+// 交易涉及的地址(小写, 去重)
+func (tx *Tx) addresses() []string {
+	from, to := strings.ToLower(tx.From), strings.ToLower(tx.To)
+	if to == "" && tx.ContractAddress != "" {
+		to = strings.ToLower(tx.ContractAddress)
+	}
+	if to == "" || to == from {
+		return []string{from}
+	}
+	return []string{from, to}
+}
+
+type DB struct {
+	db *leveldb.DB
+}
+
+// 打开数据库, 同一时间只能有一个进程打开
+func Open(dir string) (*DB, error) {
+	db, err := leveldb.OpenFile(dir, &opt.Options{})
+	if err != nil {
+		return nil, fmt.Errorf("index: open %s: %v", dir, err)
+	}
+	return &DB{db: db}, nil
+}
+
+// 只读打开, 多个只读的进程可以同时打开; 正在同步的进程关闭数据库之前返回错误
+func OpenReadOnly(dir string) (*DB, error) {
+	if _, err := os.Stat(dir); err != nil {
+		return nil, fmt.Errorf("index: open %s: %v", dir, err)
+	}
+	db, err := leveldb.OpenFile(dir, &opt.Options{ReadOnly: true})
+	if err != nil {
+		return nil, fmt.Errorf("index: open %s: %v", dir, err)
+	}
+	return &DB{db: db}, nil
+}
+
+func (d *DB) Close() error {
+	return d.db.Close()
+}
+
+func blockKey(height int64) []byte {
+	key := []byte("b:")
+	return append(key, encodeUint64(uint64(height))...)
+}
+
+func txKey(hash string) []byte {
+	return []byte("t:" + strings.ToLower(hash))
+}
+
+func addressPrefix(address string) []byte {
+	return []byte("a:" + strings.ToLower(address))
+}
+
+func addressKey(address string, tx *Tx) []byte {
+	key := addressPrefix(address)
+	key = append(key, encodeUint64(uint64(tx.BlockNumber))...)
+	return append(key, encodeUint64(uint64(tx.Index))[4:]...)
+}
+
+func countKey(address string) []byte {
+	return []byte("c:" + strings.ToLower(address))
+}
+
+func encodeUint64(v uint64) []byte {
+	var buf [8]byte
+	binary.BigEndian.PutUint64(buf[:], v)
+	return buf[:]
+}
+
+func (d *DB) getUint64(key []byte) (uint64, bool, error) {
+	data, err := d.db.Get(key, nil)
+	if err == leveldb.ErrNotFound {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, err
+	}
+	return binary.BigEndian.Uint64(data), true, nil
+}
+
+func (d *DB) getJSON(key []byte, v interface{}) (bool, error) {
+	data, err := d.db.Get(key, nil)
+	if err == leveldb.ErrNotFound {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, json.Unmarshal(data, v)
+}
+
+// 最新的区块, 数据库为空时返回 nil
+func (d *DB) Head() (*Block, error) {
+	height, ok, err := d.getUint64(keyHead)
+	if err != nil || !ok {
+		return nil, err
+	}
+	return d.Block(int64(height))
+}
+
+// 开始索引的高度, 数据库为空时返回 -1
+func (d *DB) Start() (int64, error) {
+	height, ok, err := d.getUint64(keyStart)
+	if err != nil || !ok {
+		return -1, err
+	}
+	return int64(height), nil
+}
+
+// 按高度查询区块, 不存在时返回 nil
+func (d *DB) Block(height int64) (*Block, error) {
+	b := new(Block)
+	if ok, err := d.getJSON(blockKey(height), b); err != nil || !ok {
+		return nil, err
+	}
+	return b, nil
+}
+
+// 按哈希查询交易, 不存在时返回 nil
+func (d *DB) Tx(hash string) (*Tx, error) {
+	tx := new(Tx)
+	if ok, err := d.getJSON(txKey(hash), tx); err != nil || !ok {
+		return nil, err
+	}
+	return tx, nil
+}
+
+// 地址的交易数量
+func (d *DB) Count(address string) (int, error) {
+	n, _, err := d.getUint64(countKey(address))
+	return int(n), err
+}
+
+// 添加新的最新区块; b 不是当前最新区块的子区块时返回 ErrNotChild
+func (d *DB) Put(b *Block, txs []*Tx) error {
+	head, err := d.Head()
+	if err != nil {
+		return err
+	}
+	if head != nil && (b.Number != head.Number+1 || !strings.EqualFold(b.ParentHash, head.Hash)) {
+		return ErrNotChild
+	}
+
+	batch := new(leveldb.Batch)
+	counts := make(map[string]uint64)
+
+	for _, tx := range txs {
+		data, err := json.Marshal(tx)
+		if err != nil {
+			return err
+		}
+		batch.Put(txKey(tx.Hash), data)
+
+		for _, address := range tx.addresses() {
+			batch.Put(addressKey(address, tx), []byte(strings.ToLower(tx.Hash)))
+			if err := d.addCount(counts, address, 1); err != nil {
+				return err
+			}
+		}
+	}
+	for address, n := range counts {
+		batch.Put(countKey(address), encodeUint64(n))
+	}
+
+	data, err := json.Marshal(b)
+	if err != nil {
+		return err
+	}
+	batch.Put(blockKey(b.Number), data)
+	batch.Put(keyHead, encodeUint64(uint64(b.Number)))
+	if head == nil {
+		batch.Put(keyStart, encodeUint64(uint64(b.Number)))
+	}
+
+	return d.db.Write(batch, &opt.WriteOptions{Sync: true})
+}
+
+// 删除最新区块(分叉时使用), 返回删除的区块; 数据库为空时返回 nil
+func (d *DB) Rewind() (*Block, error) {
+	head, err := d.Head()
+	if err != nil || head == nil {
+		return nil, err
+	}
+
+	batch := new(leveldb.Batch)
+	counts := make(map[string]uint64)
+
+	for _, hash := range head.Transactions {
+		tx, err := d.Tx(hash)
+		if err != nil {
+			return nil, err
+		}
+		if tx == nil {
+			continue
+		}
+		batch.Delete(txKey(hash))
+
+		for _, address := range tx.addresses() {
+			batch.Delete(addressKey(address, tx))
+			if err := d.addCount(counts, address, -1); err != nil {
+				return nil, err
+			}
+		}
+	}
+	for address, n := range counts {
+		batch.Put(countKey(address), encodeUint64(n))
+	}
+
+	batch.Delete(blockKey(head.Number))
+	if start, err := d.Start(); err != nil {
+		return nil, err
+	} else if head.Number <= start {
+		batch.Delete(keyHead)
+		batch.Delete(keyStart)
+	} else {
+		batch.Put(keyHead, encodeUint64(uint64(head.Number-1)))
+	}
+
+	if err := d.db.Write(batch, &opt.WriteOptions{Sync: true}); err != nil {
+		return nil, err
+	}
+	return head, nil
+}
+
+func (d *DB) addCount(counts map[string]uint64, address string, delta int) error {
+	n, ok := counts[address]
+	if !ok {
+		v, _, err := d.getUint64(countKey(address))
+		if err != nil {
+			return err
+		}
+		n = v
+	}
+	if delta < 0 && n == 0 {
+		return nil
+	}
+	counts[address] = uint64(int64(n) + int64(delta))
+	return nil
+}
+
+// 查询地址的交易, 按时间倒序跳过 offset 个后最多返回 limit 个
+func (d *DB) History(address string, offset, limit int) ([]*Tx, error) {
+	iter := d.db.NewIterator(util.BytesPrefix(addressPrefix(address)), nil)
+	defer iter.Release()
+
+	var txs []*Tx
+	for ok := iter.Last(); ok && len(txs) < limit; ok = iter.Prev() {
+		if offset > 0 {
+			offset--
+			continue
+		}
+		tx, err := d.Tx(string(iter.Value()))
+		if err != nil {
+			return nil, err
+		}
+		if tx == nil {
+			return nil, fmt.Errorf("index: tx %s not found", iter.Value())
+		}
+		txs = append(txs, tx)
+	}
+	return txs, iter.Error()
+}
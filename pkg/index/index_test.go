@@ -0,0 +1,154 @@
+package index
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"xcoin/HayekTool/pkg/rpc"
+)
+
+const (
+	alice = "0x3eb41fc94f240242c9bbb8bf46b9feb356fd09e2"
+	bob   = "0x5205f45c6399c41e11e533926ca69a0aedfdbb8d"
+)
+
+// 内存中的测试链, fork 用于生成不同分支的区块哈希
+type testChain struct {
+	blocks []*rpc.GetBlockReply
+}
+
+func (c *testChain) build(fork string, n int, txs map[int][]rpc.Tx) {
+	parent := ""
+	if len(c.blocks) > 0 {
+		parent = c.blocks[len(c.blocks)-1].Hash
+	}
+	for i := 0; i < n; i++ {
+		h := len(c.blocks)
+		b := &rpc.GetBlockReply{
+			Number:       fmt.Sprintf("0x%x", h),
+			Hash:         fmt.Sprintf("0x%s%04x", fork, h),
+			ParentHash:   parent,
+			Transactions: txs[h],
+		}
+		for j := range b.Transactions {
+			b.Transactions[j].Hash = fmt.Sprintf("0x%s%04x%02x", fork, h, j)
+		}
+		c.blocks = append(c.blocks, b)
+		parent = b.Hash
+	}
+}
+
+func (c *testChain) GetLatestBlock(fullList bool) (*rpc.GetBlockReply, error) {
+	return c.blocks[len(c.blocks)-1], nil
+}
+
+func (c *testChain) GetBlockByHeight(height int64, fullList bool) (*rpc.GetBlockReply, error) {
+	if height >= int64(len(c.blocks)) {
+		return nil, nil
+	}
+	return c.blocks[height], nil
+}
+
+func (c *testChain) GetTxReceipt(hash string) (*rpc.TxReceipt, error) {
+	return &rpc.TxReceipt{TxHash: hash, Status: "0x1"}, nil
+}
+
+func TestSyncReorg(t *testing.T) {
+	dir, err := ioutil.TempDir("", "index")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	db, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	chain := new(testChain)
+	chain.build("aa", 3, map[int][]rpc.Tx{
+		1: {{From: alice, To: bob, Value: "0x1"}},
+		2: {{From: bob, To: alice, Value: "0x2"}, {From: alice, To: alice, Value: "0x3"}},
+	})
+	s := &Syncer{DB: db, Source: chain}
+
+	if height, err := s.Sync(nil); err != nil || height != 2 {
+		t.Fatalf("sync = %d, %v", height, err)
+	}
+	if n, _ := db.Count(alice); n != 3 {
+		t.Fatalf("count(alice) = %d", n)
+	}
+	txs, err := db.History(alice, 1, 10)
+	if err != nil || len(txs) != 2 || txs[0].Hash != "0xaa000200" || txs[1].Hash != "0xaa000100" {
+		t.Fatalf("history = %+v, %v", txs, err)
+	}
+
+	// 区块 2 被分叉替换
+	chain.blocks = chain.blocks[:2]
+	chain.build("bb", 2, nil)
+
+	var dropped []int64
+	s.Reorg = func(b *Block, depth int) { dropped = append(dropped, b.Number) }
+	if height, err := s.Sync(nil); err != nil || height != 3 {
+		t.Fatalf("sync = %d, %v", height, err)
+	}
+	if fmt.Sprint(dropped) != "[2]" {
+		t.Fatalf("dropped = %v", dropped)
+	}
+	if n, _ := db.Count(alice); n != 1 {
+		t.Fatalf("count(alice) = %d", n)
+	}
+	if tx, _ := db.Tx("0xaa000200"); tx != nil {
+		t.Fatalf("tx in dropped block: %+v", tx)
+	}
+	if b, _ := db.Head(); b == nil || b.Hash != "0xbb0003" {
+		t.Fatalf("head = %+v", b)
+	}
+}
+
+func TestOpenReadOnly(t *testing.T) {
+	dir, err := ioutil.TempDir("", "index")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	db, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	chain := new(testChain)
+	chain.build("aa", 5, map[int][]rpc.Tx{1: {{From: alice, To: bob, Value: "0x1"}}})
+
+	// 每次最多同步 2 个区块, 每次之间关闭数据库
+	s := &Syncer{DB: db, Source: chain, MaxBlocks: 2}
+	if height, err := s.Sync(nil); err != nil || height != 1 {
+		t.Fatalf("sync = %d, %v", height, err)
+	}
+	if _, err := OpenReadOnly(dir); err == nil {
+		t.Fatal("expect error while syncing")
+	}
+	db.Close()
+
+	// 多个只读的进程可以同时打开
+	r1, err := OpenReadOnly(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r1.Close()
+	r2, err := OpenReadOnly(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r2.Close()
+	if n, _ := r2.Count(bob); n != 1 {
+		t.Fatalf("count(bob) = %d", n)
+	}
+
+	if _, err := OpenReadOnly(dir + "-missing"); err == nil {
+		t.Fatal("expect error for missing index")
+	}
+}
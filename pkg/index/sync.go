@@ -0,0 +1,157 @@
+package index
+
+import (
+	"fmt"
+	"strings"
+
+	"xcoin/HayekTool/pkg/rpc"
+	"xcoin/HayekTool/pkg/util"
+)
+
+// 分叉回滚的最大深度
+const MaxReorgDepth = 1024
+
+// 区块数据来源, *rpc.RPCClient 实现了这个接口
+type Source interface {
+	GetLatestBlock(fullList bool) (*rpc.GetBlockReply, error)
+	GetBlockByHeight(height int64, fullList bool) (*rpc.GetBlockReply, error)
+	GetTxReceipt(hash string) (*rpc.TxReceipt, error)
+}
+
+// 从节点同步区块到数据库
+type Syncer struct {
+	DB            *DB
+	Source        Source
+	StartHeight   int64 // 数据库为空时开始的高度
+	Confirmations int64 // 只同步有足够确认数的区块
+	MaxBlocks     int64 // 每次最多同步的区块数, 0 表示不限制; 追赶时分多次同步, 期间让出数据库
+
+	Progress func(height, target int64)      // 每个区块保存后调用
+	Reorg    func(dropped *Block, depth int) // 每个回滚的区块调用
+}
+
+// 同步到最新区块(减去确认数), 返回同步后的高度; stop 关闭时提前返回
+func (s *Syncer) Sync(stop <-chan struct{}) (int64, error) {
+	latest, err := s.Source.GetLatestBlock(false)
+	if err != nil {
+		return 0, err
+	}
+	if latest == nil {
+		return 0, fmt.Errorf("index: no latest block")
+	}
+	target := util.String2Big(latest.Number).Int64() - s.Confirmations
+
+	head, err := s.DB.Head()
+	if err != nil {
+		return 0, err
+	}
+
+	depth, synced := 0, int64(0)
+	for {
+		next := s.StartHeight
+		if head != nil {
+			next = head.Number + 1
+		}
+		if next > target || s.MaxBlocks > 0 && synced >= s.MaxBlocks {
+			break
+		}
+
+		select {
+		case <-stop:
+			return next - 1, nil
+		default:
+		}
+
+		reply, err := s.Source.GetBlockByHeight(next, true)
+		if err != nil {
+			return next - 1, err
+		}
+		if reply == nil {
+			return next - 1, fmt.Errorf("index: block %d not found", next)
+		}
+
+		// 父区块不一致说明发生了分叉, 回滚后重新同步
+		if head != nil && !strings.EqualFold(reply.ParentHash, head.Hash) {
+			if depth++; depth > MaxReorgDepth {
+				return head.Number, fmt.Errorf("index: reorg deeper than %d blocks at height %d", MaxReorgDepth, next)
+			}
+			if _, err := s.DB.Rewind(); err != nil {
+				return head.Number, err
+			}
+			if s.Reorg != nil {
+				s.Reorg(head, depth)
+			}
+			if head, err = s.DB.Head(); err != nil {
+				return 0, err
+			}
+			continue
+		}
+		depth = 0
+
+		b, txs, err := s.convert(reply)
+		if err != nil {
+			return next - 1, err
+		}
+		if err := s.DB.Put(b, txs); err != nil {
+			return next - 1, err
+		}
+		head = b
+		synced++
+
+		if s.Progress != nil {
+			s.Progress(b.Number, target)
+		}
+	}
+
+	if head == nil {
+		return s.StartHeight - 1, nil
+	}
+	return head.Number, nil
+}
+
+// 转换为数据库中的格式, 同时查询交易收据
+func (s *Syncer) convert(reply *rpc.GetBlockReply) (*Block, []*Tx, error) {
+	b := &Block{
+		Number:     util.String2Big(reply.Number).Int64(),
+		Hash:       reply.Hash,
+		ParentHash: reply.ParentHash,
+		Timestamp:  util.String2Big(reply.Timestamp).Int64(),
+		Miner:      reply.Miner,
+		Difficulty: reply.Difficulty,
+		GasLimit:   reply.GasLimit,
+		GasUsed:    reply.GasUsed,
+		Uncles:     len(reply.Uncles),
+	}
+
+	var txs []*Tx
+	for i, x := range reply.Transactions {
+		receipt, err := s.Source.GetTxReceipt(x.Hash)
+		if err != nil {
+			return nil, nil, fmt.Errorf("index: receipt %s: %v", x.Hash, err)
+		}
+		if receipt == nil {
+			return nil, nil, fmt.Errorf("index: receipt %s not found", x.Hash)
+		}
+
+		b.Transactions = append(b.Transactions, x.Hash)
+		txs = append(txs, &Tx{
+			Hash:        x.Hash,
+			BlockNumber: b.Number,
+			BlockHash:   b.Hash,
+			Index:       i,
+			Timestamp:   b.Timestamp,
+			From:        x.From,
+			To:          x.To,
+			Value:       x.Value,
+			Gas:         x.Gas,
+			GasPrice:    x.GasPrice,
+			Nonce:       x.Nonce,
+			Input:       x.Input,
+
+			Status:          receipt.Status,
+			GasUsed:         receipt.GasUsed,
+			ContractAddress: receipt.ContractAddress,
+		})
+	}
+	return b, txs, nil
+}
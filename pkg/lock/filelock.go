@@ -0,0 +1,187 @@
+package lock
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"time"
+
+	"xcoin/HayekTool/pkg/util"
+)
+
+const DefaultTTL = time.Minute
+
+// 基于共享文件系统(NFS等)的锁文件
+//
+// 锁文件内容记录持有者和租约过期时间, 过期后其它持有者可以接管.
+// 各机器之间的时钟偏差需要远小于租约时长.
+type FileLock struct {
+	Path  string
+	Owner string
+	Lease time.Duration
+}
+
+type fileLockInfo struct {
+	Owner    string
+	ExpireAt time.Time
+}
+
+func NewFileLock(path string, lease time.Duration) *FileLock {
+	if lease <= 0 {
+		lease = DefaultTTL
+	}
+	return &FileLock{
+		Path:  path,
+		Owner: DefaultOwner(),
+		Lease: lease,
+	}
+}
+
+// 默认持有者标识: 主机名+进程号
+func DefaultOwner() string {
+	host, _ := os.Hostname()
+	return fmt.Sprintf("%s:%d", host, os.Getpid())
+}
+
+func (p *FileLock) TTL() time.Duration {
+	return p.Lease
+}
+
+func (p *FileLock) TryLock() error {
+	info, err := readLockFile(p.Path)
+	switch {
+	case os.IsNotExist(err):
+		return p.create()
+	case err != nil:
+		return err
+	}
+
+	if time.Now().Before(info.ExpireAt) {
+		if info.Owner != p.Owner {
+			return ErrLockHeld
+		}
+		return p.write()
+	}
+
+	// 租约已过期: 先把锁文件改为唯一的名字, 同一个文件只有一个接管者能改名成功
+	stale, err := p.rename()
+	if err != nil {
+		if os.IsNotExist(err) {
+			return ErrLockHeld
+		}
+		return err
+	}
+	// 读取和改名之间锁可能已经被别人接管, 这时放回去
+	if info, err := readLockFile(stale); err != nil || (info.Owner != p.Owner && time.Now().Before(info.ExpireAt)) {
+		p.restore(stale)
+		if err != nil {
+			return err
+		}
+		return ErrLockHeld
+	}
+	os.Remove(stale)
+	return p.create()
+}
+
+func (p *FileLock) Refresh() error {
+	info, err := readLockFile(p.Path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return ErrLockLost
+		}
+		return err
+	}
+	if info.Owner != p.Owner {
+		return ErrLockLost
+	}
+	return p.write()
+}
+
+// 先把锁文件改为唯一的名字再检查持有者, 避免删除刚被别人接管的锁
+func (p *FileLock) Unlock() error {
+	tmp, err := p.rename()
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	info, err := readLockFile(tmp)
+	if err != nil {
+		p.restore(tmp)
+		return err
+	}
+	if info.Owner != p.Owner {
+		p.restore(tmp)
+		return ErrLockLost
+	}
+	return os.Remove(tmp)
+}
+
+func readLockFile(path string) (*fileLockInfo, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var info fileLockInfo
+	if err := json.Unmarshal(data, &info); err != nil {
+		// 内容不完整的文件视为已过期
+		return &fileLockInfo{}, nil
+	}
+	return &info, nil
+}
+
+// 锁文件旁边的唯一文件名
+func (p *FileLock) tempName() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return fmt.Sprintf("%s.%x", p.Path, b)
+}
+
+// 把锁文件改为唯一的名字, 不存在时返回 os.IsNotExist 错误
+func (p *FileLock) rename() (string, error) {
+	tmp := p.tempName()
+	if err := os.Rename(p.Path, tmp); err != nil {
+		return "", err
+	}
+	return tmp, nil
+}
+
+// 放回改名的锁文件; 期间已经有人创建了新的锁时以新的为准
+func (p *FileLock) restore(tmp string) {
+	os.Link(tmp, p.Path)
+	os.Remove(tmp)
+}
+
+func (p *FileLock) encode() []byte {
+	data, _ := json.MarshalIndent(&fileLockInfo{
+		Owner:    p.Owner,
+		ExpireAt: time.Now().Add(p.Lease),
+	}, "", "\t")
+	return data
+}
+
+// 不存在时原子创建: 先写好唯一的临时文件再硬链接到锁文件, 已存在时链接失败,
+// 其它人不会读到内容不完整的锁文件
+func (p *FileLock) create() error {
+	tmp := p.tempName()
+	if err := ioutil.WriteFile(tmp, p.encode(), 0644); err != nil {
+		return err
+	}
+	defer os.Remove(tmp)
+
+	if err := os.Link(tmp, p.Path); err != nil {
+		if os.IsExist(err) {
+			return ErrLockHeld
+		}
+		return err
+	}
+	return nil
+}
+
+// 原子写入, 保证其它机器不会读到写入一半的内容
+func (p *FileLock) write() error {
+	return util.WriteFileAtomic(p.Path, p.encode(), 0644)
+}
@@ -0,0 +1,127 @@
+package lock
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestFileLock(t *testing.T) {
+	dir, err := ioutil.TempDir("", "hayek-lock")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "payouts.lock")
+	a := &FileLock{Path: path, Owner: "a", Lease: 200 * time.Millisecond}
+	b := &FileLock{Path: path, Owner: "b", Lease: 200 * time.Millisecond}
+
+	if err := a.TryLock(); err != nil {
+		t.Fatalf("a.TryLock: %v", err)
+	}
+	if err := b.TryLock(); err != ErrLockHeld {
+		t.Fatalf("b.TryLock: expect ErrLockHeld, got %v", err)
+	}
+	if err := a.Refresh(); err != nil {
+		t.Fatalf("a.Refresh: %v", err)
+	}
+
+	// a 崩溃, 租约过期后 b 接管
+	time.Sleep(250 * time.Millisecond)
+	if err := b.TryLock(); err != nil {
+		t.Fatalf("b.TryLock after expire: %v", err)
+	}
+	if err := a.Refresh(); err != ErrLockLost {
+		t.Fatalf("a.Refresh: expect ErrLockLost, got %v", err)
+	}
+	if err := a.Unlock(); err != ErrLockLost {
+		t.Fatalf("a.Unlock: expect ErrLockLost, got %v", err)
+	}
+	if err := b.Refresh(); err != nil {
+		t.Fatalf("b.Refresh after a.Unlock: %v", err)
+	}
+
+	if err := b.Unlock(); err != nil {
+		t.Fatalf("b.Unlock: %v", err)
+	}
+	if err := a.TryLock(); err != nil {
+		t.Fatalf("a.TryLock after unlock: %v", err)
+	}
+}
+
+func TestFileLockTakeover(t *testing.T) {
+	dir, err := ioutil.TempDir("", "hayek-lock")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "payouts.lock")
+	old := &FileLock{Path: path, Owner: "old", Lease: 10 * time.Millisecond}
+	if err := old.TryLock(); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(20 * time.Millisecond)
+
+	// 多个接管者同时抢过期的锁, 只能有一个成功
+	var (
+		wg  sync.WaitGroup
+		won int32
+	)
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			l := &FileLock{Path: path, Owner: fmt.Sprint(i), Lease: time.Minute}
+			if err := l.TryLock(); err == nil {
+				atomic.AddInt32(&won, 1)
+			} else if err != ErrLockHeld {
+				t.Error(err)
+			}
+		}(i)
+	}
+	wg.Wait()
+	if won != 1 {
+		t.Fatalf("%d owners acquired the lock", won)
+	}
+
+	files, _ := ioutil.ReadDir(dir)
+	if len(files) != 1 {
+		t.Fatalf("%d files left in lock dir", len(files))
+	}
+}
+
+func TestElector(t *testing.T) {
+	dir, err := ioutil.TempDir("", "hayek-lock")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "payouts.lock")
+	a := NewElector(&FileLock{Path: path, Owner: "a", Lease: 150 * time.Millisecond})
+	b := NewElector(&FileLock{Path: path, Owner: "b", Lease: 150 * time.Millisecond})
+
+	a.Start()
+	time.Sleep(20 * time.Millisecond)
+	b.Start()
+	time.Sleep(100 * time.Millisecond)
+
+	if !a.IsLeader() || b.IsLeader() {
+		t.Fatalf("expect a leader: a = %v, b = %v", a.IsLeader(), b.IsLeader())
+	}
+
+	// a 退出后释放锁, b 在下一次心跳时成为 leader
+	a.Close()
+	time.Sleep(150 * time.Millisecond)
+	if !b.Confirm() {
+		t.Fatalf("expect b leader")
+	}
+	b.Close()
+}
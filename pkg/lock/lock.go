@@ -0,0 +1,130 @@
+// 分布式锁/选主
+//
+// 多台机器同时运行 send-payouts 时, 只有持有锁的一台(leader)执行支付任务.
+// 锁带有租约, 持有者需要定期续约, 崩溃后租约过期, 其它机器可以接管.
+package lock
+
+import (
+	"errors"
+	"log"
+	"sync"
+	"time"
+)
+
+var (
+	ErrLockHeld = errors.New("lock: held by another owner")
+	ErrLockLost = errors.New("lock: lease lost")
+)
+
+// 带租约的锁, 不同后端(共享文件/etcd/redis等)实现该接口
+type Locker interface {
+	// 尝试获取锁, 锁被其它人持有且租约未过期时返回 ErrLockHeld
+	TryLock() error
+
+	// 续约, 锁已被别人接管时返回 ErrLockLost
+	Refresh() error
+
+	// 释放锁
+	Unlock() error
+
+	// 租约时长
+	TTL() time.Duration
+}
+
+// 基于 Locker 的选主
+type Elector struct {
+	locker Locker
+
+	mu        sync.Mutex
+	heldUntil time.Time
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+func NewElector(l Locker) *Elector {
+	return &Elector{
+		locker: l,
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+}
+
+// 后台循环: 不是 leader 时尝试获取锁, 是 leader 时续约
+func (e *Elector) Start() {
+	go func() {
+		defer close(e.done)
+
+		ticker := time.NewTicker(e.locker.TTL() / 3)
+		defer ticker.Stop()
+
+		for {
+			e.heartbeat()
+
+			select {
+			case <-ticker.C:
+			case <-e.stop:
+				e.release()
+				return
+			}
+		}
+	}()
+}
+
+// 停止后台循环并释放锁
+func (e *Elector) Close() {
+	close(e.stop)
+	<-e.done
+}
+
+// 当前是否为 leader(本地租约未过期)
+func (e *Elector) IsLeader() bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return time.Now().Before(e.heldUntil)
+}
+
+// 立即续约一次并返回是否为 leader, 用于执行关键任务之前的确认
+func (e *Elector) Confirm() bool {
+	e.heartbeat()
+	return e.IsLeader()
+}
+
+func (e *Elector) heartbeat() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	// 以发起请求之前的时间计算本地租约, 保证不晚于锁文件中的过期时间
+	now := time.Now()
+
+	var err error
+	if now.Before(e.heldUntil) {
+		if err = e.locker.Refresh(); err != nil {
+			log.Printf("lock: refresh failed: %v", err)
+		}
+	} else {
+		if err = e.locker.TryLock(); err == nil {
+			log.Printf("lock: acquired, now leader")
+		} else if err != ErrLockHeld {
+			log.Printf("lock: acquire failed: %v", err)
+		}
+	}
+
+	if err != nil {
+		e.heldUntil = time.Time{}
+		return
+	}
+	e.heldUntil = now.Add(e.locker.TTL())
+}
+
+func (e *Elector) release() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if time.Now().Before(e.heldUntil) {
+		if err := e.locker.Unlock(); err != nil {
+			log.Printf("lock: unlock failed: %v", err)
+		}
+	}
+	e.heldUntil = time.Time{}
+}
@@ -0,0 +1,170 @@
+package mainpkg
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"xcoin/HayekTool/pkg/addressbook"
+)
+
+// 地址簿文件, 没有配置时使用默认文件
+func (p *App) addressBookPath() string {
+	if p.cfg.AddressBookFile != "" {
+		return p.cfg.AddressBookFile
+	}
+	return addressbook.DefaultFile
+}
+
+// 把名字或者地址解析为地址, 同一个命令中只加载一次地址簿
+type addressResolver struct {
+	book  *addressbook.Book
+	extra map[string]string // 配置文件中的 XUserAddressBook
+}
+
+func (p *App) addressResolver() (*addressResolver, error) {
+	book, err := addressbook.Load(p.addressBookPath())
+	if err != nil {
+		return nil, err
+	}
+	return &addressResolver{book: book, extra: p.cfg.XUserAddressBook}, nil
+}
+
+// 名字先查找地址簿文件, 再查找 XUserAddressBook; 不认识的名字和无效的地址返回错误.
+func (r *addressResolver) resolve(id string) (string, error) {
+	if v, ok := r.extra[id]; ok && r.book.Get(id) == nil {
+		if err := addressbook.ValidateAddress(v); err != nil {
+			return "", fmt.Errorf("XUserAddressBook[%q]: %v", id, err)
+		}
+		return v, nil
+	}
+	return r.book.Resolve(id)
+}
+
+// 解析一个名字或者地址
+func (p *App) getAddress(id string) (string, error) {
+	r, err := p.addressResolver()
+	if err != nil {
+		return "", err
+	}
+	return r.resolve(id)
+}
+
+// 修改地址簿: 读取, 修改, 保存
+func (p *App) updateAddressBook(fn func(b *addressbook.Book) error) error {
+	path := p.addressBookPath()
+	b, err := addressbook.Load(path)
+	if err != nil {
+		return err
+	}
+	if err := fn(b); err != nil {
+		return err
+	}
+	return b.Save(path)
+}
+
+// 添加地址, replace 为 true 时覆盖同名的项
+func (p *App) CmdAddressBookAdd(e *addressbook.Entry, replace bool) error {
+	return p.updateAddressBook(func(b *addressbook.Book) error {
+		return b.Add(e, replace)
+	})
+}
+
+func (p *App) CmdAddressBookRemove(name string) error {
+	return p.updateAddressBook(func(b *addressbook.Book) error {
+		return b.Remove(name)
+	})
+}
+
+// 列出地址, format 为 table/json/csv
+func (p *App) CmdAddressBookList(tag, format string) error {
+	b, err := addressbook.Load(p.addressBookPath())
+	if err != nil {
+		return err
+	}
+	entries := b.Filter(tag)
+
+	if format != "table" && format != "" {
+		return addressbook.Export(os.Stdout, format, entries)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tADDRESS\tLABEL\tTAGS\tNOTE")
+	for _, e := range entries {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", e.Name, e.Address, e.Label, strings.Join(e.Tags, ","), e.Note)
+	}
+	return w.Flush()
+}
+
+// 从 json/csv 文件导入, fromConfig 为 true 时导入配置文件中的 XUserAddressBook
+func (p *App) CmdAddressBookImport(file string, fromConfig, replace bool) error {
+	var entries []*addressbook.Entry
+
+	if file != "" {
+		f, err := os.Open(file)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		list, err := addressbook.ReadEntries(f, addressbook.FormatOf(file))
+		if err != nil {
+			return fmt.Errorf("%s: %v", file, err)
+		}
+		entries = append(entries, list...)
+	}
+
+	if fromConfig {
+		var names []string
+		for name := range p.cfg.XUserAddressBook {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			entries = append(entries, &addressbook.Entry{
+				Name:    name,
+				Address: p.cfg.XUserAddressBook[name],
+				Note:    "imported from XUserAddressBook",
+			})
+		}
+	}
+
+	if len(entries) == 0 {
+		return fmt.Errorf("nothing to import")
+	}
+	if err := p.updateAddressBook(func(b *addressbook.Book) error {
+		return b.Import(entries, replace)
+	}); err != nil {
+		return err
+	}
+
+	fmt.Printf("imported %d address(es) into %s\n", len(entries), p.addressBookPath())
+	return nil
+}
+
+// 导出到文件, file 为空时输出到终端, format 为空时按文件扩展名判断
+func (p *App) CmdAddressBookExport(file, format, tag string) error {
+	b, err := addressbook.Load(p.addressBookPath())
+	if err != nil {
+		return err
+	}
+	if format == "" {
+		format = addressbook.FormatOf(file)
+	}
+
+	if file == "" {
+		return addressbook.Export(os.Stdout, format, b.Filter(tag))
+	}
+
+	f, err := os.Create(file)
+	if err != nil {
+		return err
+	}
+	if err := addressbook.Export(f, format, b.Filter(tag)); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -3,19 +3,29 @@ package mainpkg
 import (
 	"encoding/json"
 	"fmt"
-	"log"
 	"math/big"
 	"strconv"
 	"strings"
 	"time"
 
 	"xcoin/HayekTool/pkg/config"
+	"xcoin/HayekTool/pkg/lock"
 	"xcoin/HayekTool/pkg/rpc"
 	"xcoin/HayekTool/pkg/util"
 )
 
 type App struct {
 	cfg *config.Config
+
+	payoutsLocker lock.Locker
+
+	confirm    func(prompt string) bool                // 支出策略要求确认时调用, 为 nil 时拒绝
+	beforeSend func(txHash string, nonce uint64) error // 签名之后、发送之前调用, 返回错误时不发送
+
+	metrics  *ExporterOptions // 支付服务的指标配置, 为 nil 时不启动
+	exporter *exporter
+
+	client *rpc.RPCClient // 控制台中共享的节点连接, 为 nil 时每个命令新建连接
 }
 
 func NewApp(cfg *config.Config) *App {
@@ -24,83 +34,69 @@ func NewApp(cfg *config.Config) *App {
 	}
 }
 
-func (p *App) CmdGetWork() error {
+// 设置支付服务使用的分布式锁, 用于接入共享文件之外的后端
+func (p *App) SetPayoutsLocker(l lock.Locker) {
+	p.payoutsLocker = l
+}
 
-	var lastErr error
-	var lastWork []string
+// 支付服务同时启动 Prometheus exporter, 记录支付结果和节点状态
+func (p *App) SetPayoutsMetrics(opt ExporterOptions) {
+	p.metrics = &opt
+}
 
-	for {
-		client, err := rpc.NewRPCClient("HayekTool", p.cfg.Host, time.Second*3)
-		if err != nil && lastErr == nil {
-			log.Fatal(err)
-		}
-		if lastErr != nil && err == nil {
-			log.Printf("connect %s ok\n", p.cfg.Host)
-		}
+// 当前使用的配置
+func (p *App) Config() *config.Config {
+	return p.cfg
+}
 
-		lastErr = err
-
-		for {
-			work, err := client.GetWork()
-			if err != nil {
-				log.Printf("GetWork: err = %v", err)
-				break
-			}
-			if len(work) != 6 {
-				log.Printf("GetWork: invalid work, len != 6")
-				break
-			}
-
-			if strings.Join(work, ",") != strings.Join(lastWork, ",") {
-                lastWork = work
-                type GetWorkReply struct {
-                    Header    string // reply[0]
-                    Seed      string // reply[1]
-					Target    string // reply[2]
-					Height    string // reply[3]
-					StateRoot string // reply[4]
-					Timestamp string // reply[5]
-
-					XXXHeight    int
-					XXXTimestamp time.Time
-				}
-
-				data := GetWorkReply{
-					Header:    work[0],
-					Seed:      work[1],
-					Target:    work[2],
-					Height:    work[3],
-					StateRoot: work[4],
-					Timestamp: work[5],
-				}
-
-				height, _ := strconv.ParseUint(strings.Replace(work[3], "0x", "", -1), 16, 64)
-				timestamp, _ := strconv.ParseUint(strings.Replace(work[5], "0x", "", -1), 16, 64)
-
-				data.XXXHeight = int(height)
-				data.XXXTimestamp = time.Unix(int64(timestamp), 0)
-
-				s, _ := json.MarshalIndent(data, "", "\t")
-				fmt.Println(string(s))
-
-				time.Sleep(time.Second * 3)
-
-			} else {
-				time.Sleep(time.Second)
-			}
-		}
+// 使用新配置的副本
+func (p *App) withConfig(cfg *config.Config) *App {
+	q := *p
+	q.cfg = cfg
+	q.client = nil
+	return &q
+}
+
+// 使用另一个节点地址的副本, 不共享节点连接
+func (p *App) WithHost(host string) *App {
+	cfg := p.cfg.Clone()
+	cfg.Host = host
+	return p.withConfig(cfg)
+}
+
+// 使用另一个索引目录的副本
+func (p *App) WithIndexDir(dir string) *App {
+	cfg := p.cfg.Clone()
+	cfg.IndexDir = dir
+	q := *p
+	q.cfg = cfg
+	return &q
+}
+
+// 使用共享节点连接的副本
+func (p *App) withClient(client *rpc.RPCClient) *App {
+	q := *p
+	q.client = client
+	return &q
+}
+
+// 节点连接, 在控制台中使用共享的连接
+func (p *App) rpcClient(timeout time.Duration) (*rpc.RPCClient, error) {
+	if p.client != nil {
+		return p.client, nil
 	}
+	return rpc.NewRPCClient("HayekTool", p.cfg.Host, timeout)
 }
 
 func (p *App) CmdGetPendingBlock() error {
-	c, err := rpc.NewRPCClient("HayekTool", p.cfg.Host, time.Second*3)
+	c, err := p.rpcClient(time.Second * 3)
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
 
 	resp, err := c.GetPendingBlock(true)
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
 
 	s, _ := json.MarshalIndent(resp, "", "\t")
@@ -109,14 +105,14 @@ func (p *App) CmdGetPendingBlock() error {
 }
 
 func (p *App) CmdGetLatestBlock() error {
-	c, err := rpc.NewRPCClient("HayekTool", p.cfg.Host, time.Second*3)
+	c, err := p.rpcClient(time.Second * 3)
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
 
 	resp, err := c.GetLatestBlock(true)
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
 
 	s, _ := json.MarshalIndent(resp, "", "\t")
@@ -125,14 +121,14 @@ func (p *App) CmdGetLatestBlock() error {
 }
 
 func (p *App) CmdGetBlockByHeight(height int64) error {
-	c, err := rpc.NewRPCClient("HayekTool", p.cfg.Host, time.Second*3)
+	c, err := p.rpcClient(time.Second * 3)
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
 
 	resp, err := c.GetBlockByHeight(height, true)
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
 
 	s, _ := json.MarshalIndent(resp, "", "\t")
@@ -141,14 +137,14 @@ func (p *App) CmdGetBlockByHeight(height int64) error {
 }
 
 func (p *App) CmdGetBlockByHash(hash string) error {
-	c, err := rpc.NewRPCClient("HayekTool", p.cfg.Host, time.Second*3)
+	c, err := p.rpcClient(time.Second * 3)
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
 
 	resp, err := c.GetBlockByHash(hash, true)
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
 
 	s, _ := json.MarshalIndent(resp, "", "\t")
@@ -157,14 +153,14 @@ func (p *App) CmdGetBlockByHash(hash string) error {
 }
 
 func (p *App) CmdGetUncleByBlockNumberAndIndex(height int64, index int) error {
-	c, err := rpc.NewRPCClient("HayekTool", p.cfg.Host, time.Second*3)
+	c, err := p.rpcClient(time.Second * 3)
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
 
 	resp, err := c.GetUncleByBlockNumberAndIndex(height, index)
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
 
 	s, _ := json.MarshalIndent(resp, "", "\t")
@@ -173,14 +169,14 @@ func (p *App) CmdGetUncleByBlockNumberAndIndex(height int64, index int) error {
 }
 
 func (p *App) CmdGetTxReceipt(hash string) error {
-	c, err := rpc.NewRPCClient("HayekTool", p.cfg.Host, time.Second*3)
+	c, err := p.rpcClient(time.Second * 3)
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
 
 	resp, err := c.GetTxReceipt(hash)
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
 
 	s, _ := json.MarshalIndent(resp, "", "\t")
@@ -189,15 +185,18 @@ func (p *App) CmdGetTxReceipt(hash string) error {
 }
 
 func (p *App) CmdGetBalance(idOrAddress string) error {
-	c, err := rpc.NewRPCClient("HayekTool", p.cfg.Host, time.Second*3)
+	c, err := p.rpcClient(time.Second * 3)
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
 
-	address := p.cfg.GetAddress(idOrAddress)
+	address, err := p.getAddress(idOrAddress)
+	if err != nil {
+		return err
+	}
 	amountInWei, err := c.GetBalance(address)
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
 
 	fmt.Printf(
@@ -211,14 +210,14 @@ func (p *App) CmdGetBalance(idOrAddress string) error {
 }
 
 func (p *App) CmdGetPeerCount() error {
-	c, err := rpc.NewRPCClient("HayekTool", p.cfg.Host, time.Second*3)
+	c, err := p.rpcClient(time.Second * 3)
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
 
 	v, err := c.GetPeerCount()
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
 
 	fmt.Println(v)
@@ -226,14 +225,14 @@ func (p *App) CmdGetPeerCount() error {
 }
 
 func (p *App) CmdNetVersion() error {
-	c, err := rpc.NewRPCClient("HayekTool", p.cfg.Host, time.Second*3)
+	c, err := p.rpcClient(time.Second * 3)
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
 
 	v, err := c.NetVersion()
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
 
 	fmt.Println(v)
@@ -245,3 +244,36 @@ func (r *RPCClient) SubmitBlock(params []string) (bool, error) {
 func (r *RPCClient) Sign(from string, s string) (string, error) {
 func (r *RPCClient) SendTransaction(from, to, gas, gasPrice, value string, autoGas bool) (string, error) {
 */
+
+// 按 latest/pending/高度/哈希查询区块, 不存在时返回 nil
+func fetchBlock(c *rpc.RPCClient, id string, full bool) (*rpc.GetBlockReply, error) {
+	switch {
+	case id == "latest":
+		return c.GetLatestBlock(full)
+	case id == "pending":
+		return c.GetPendingBlock(full)
+	case strings.HasPrefix(id, "0x") && len(id) == 66:
+		return c.GetBlockByHash(id, full)
+	}
+	height, err := parseHeight(id)
+	if err != nil {
+		return nil, err
+	}
+	return c.GetBlockByHeight(height, full)
+}
+
+// 十进制或者 0x 开头的十六进制高度
+func parseHeight(s string) (int64, error) {
+	if strings.HasPrefix(s, "0x") {
+		v, err := strconv.ParseInt(s[2:], 16, 64)
+		if err != nil || v < 0 {
+			return 0, fmt.Errorf("invalid height %q", s)
+		}
+		return v, nil
+	}
+	v, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || v < 0 {
+		return 0, fmt.Errorf("invalid height %q", s)
+	}
+	return v, nil
+}
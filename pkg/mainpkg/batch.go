@@ -0,0 +1,400 @@
+package mainpkg
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"xcoin/HayekTool/pkg/rpc"
+	"xcoin/HayekTool/pkg/util"
+)
+
+// 批量转账结果的状态
+const (
+	batchStatusPending = "pending" // 还没有发送
+	batchStatusSending = "sending" // 已经签名, 正在发送, 重新运行时先查询交易
+	batchStatusSent    = "sent"    // 节点已经接受
+	batchStatusFailed  = "failed"  // 发送失败, 重新运行时会再次发送
+)
+
+// 批量转账中的一行
+type BatchTransfer struct {
+	Row     int    // 在 CSV 文件中的记录序号(从1开始, 包括表头, 不包括注释)
+	To      string // 文件中的地址或者地址簿中的名字
+	Address string
+	Value   *big.Int // 金额(wei)
+	Memo    string
+
+	Status string
+	TxHash string
+	Nonce  string // 交易的 nonce, 发送之前记录
+	Err    string
+}
+
+// 旧版本的结果文件没有最后的 nonce 列
+var batchResultHeader = []string{
+	"row", "to", "address", "value_wei", "value_hyk", "memo", "status", "tx_hash", "error", "nonce",
+}
+
+// 默认的结果文件: transfers.csv => transfers.result.csv
+func batchResultFile(file string) string {
+	ext := filepath.Ext(file)
+	return strings.TrimSuffix(file, ext) + ".result" + ext
+}
+
+// 读取转账文件, 每行: 地址或名字, 金额(可以带单位), 备注(可选)
+//
+// 第一行的金额不是数字时作为表头跳过, # 开头的行是注释.
+func (p *App) loadBatchFile(file string) ([]*BatchTransfer, FieldErrors, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer f.Close()
+
+	resolver, err := p.addressResolver()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	r := csv.NewReader(f)
+	r.Comment = '#'
+	r.FieldsPerRecord = -1
+	r.TrimLeadingSpace = true
+
+	var (
+		list []*BatchTransfer
+		errs FieldErrors
+		line int
+	)
+	for {
+		record, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("%s: %v", file, err)
+		}
+		line++
+		field := fmt.Sprintf("row %d", line)
+
+		if len(record) < 2 || len(record) > 3 {
+			errs.add(field, "expect to,amount[,memo], got %d column(s)", len(record))
+			continue
+		}
+
+		value, err := util.ParseAmount(record[1])
+		if err != nil {
+			if len(list) == 0 && len(errs) == 0 && isBatchHeader(record) {
+				continue
+			}
+			errs.add(field, "%v", err)
+			continue
+		}
+
+		t := &BatchTransfer{
+			Row:    line,
+			To:     strings.TrimSpace(record[0]),
+			Value:  value,
+			Status: batchStatusPending,
+		}
+		if len(record) == 3 {
+			t.Memo = strings.TrimSpace(record[2])
+		}
+
+		if t.Value.Sign() == 0 {
+			errs.add(field, "zero amount")
+		}
+		if t.Address, err = resolver.resolve(t.To); err != nil {
+			errs.add(field, "%v", err)
+		}
+		list = append(list, t)
+	}
+
+	if len(list) == 0 && len(errs) == 0 {
+		errs.add(file, "no transfers")
+	}
+	return list, errs, nil
+}
+
+func isBatchHeader(record []string) bool {
+	switch strings.ToLower(strings.TrimSpace(record[0])) {
+	case "to", "address", "name":
+		return true
+	}
+	return false
+}
+
+// 读取之前的结果, 已经发送的行在重新运行时跳过
+//
+// 结果文件和转账文件不一致(行被修改过)时返回错误, 避免重复或者漏发.
+func loadBatchResult(path string, list []*BatchTransfer) error {
+	f, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	defer f.Close()
+
+	records, err := csv.NewReader(f).ReadAll()
+	if err != nil {
+		return fmt.Errorf("%s: %v", path, err)
+	}
+
+	rows := make(map[int]*BatchTransfer)
+	for _, t := range list {
+		rows[t.Row] = t
+	}
+	for i, record := range records {
+		if i == 0 || len(record) < len(batchResultHeader)-1 || len(record) > len(batchResultHeader) {
+			continue
+		}
+		row, _ := strconv.Atoi(record[0])
+		status := record[6]
+		if status != batchStatusSent && status != batchStatusSending {
+			continue
+		}
+
+		t, ok := rows[row]
+		if !ok || !strings.EqualFold(t.Address, record[2]) || t.Value.String() != record[3] {
+			return fmt.Errorf("%s: row %d %s as %s %s wei, not match transfers file", path, row, status, record[2], record[3])
+		}
+		t.Status, t.TxHash = status, record[7]
+		if len(record) == len(batchResultHeader) {
+			t.Nonce = record[9]
+		}
+	}
+	return nil
+}
+
+// 写入全部结果, 中途退出时保留上一次的完整结果
+func saveBatchResult(path string, list []*BatchTransfer) error {
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	w.Write(batchResultHeader)
+	for _, t := range list {
+		w.Write([]string{
+			strconv.Itoa(t.Row), t.To, t.Address, t.Value.String(), formatWei(t.Value), t.Memo,
+			t.Status, t.TxHash, t.Err, t.Nonce,
+		})
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		return err
+	}
+	return util.WriteFileAtomic(path, buf.Bytes(), 0644)
+}
+
+// 查询上次发送中的交易: 节点已经知道的交易标记为已发送, 没有广播出去的重新发送
+//
+// 交易不存在但是 nonce 已经被使用时无法判断, 返回错误, 需要人工核对.
+func resolveBatchSending(c *rpc.RPCClient, from string, list []*BatchTransfer) error {
+	var nonce *uint64
+	for _, t := range list {
+		if t.Status != batchStatusSending {
+			continue
+		}
+		sent, err := strconv.ParseUint(t.Nonce, 10, 64)
+		if err != nil {
+			return fmt.Errorf("row %d: invalid nonce %q", t.Row, t.Nonce)
+		}
+
+		var tx json.RawMessage
+		receipt, err := c.GetTxReceipt(t.TxHash)
+		if err != nil {
+			return err
+		}
+		if receipt == nil {
+			if tx, err = c.Call(rpc.CoinId+"_getTransactionByHash", []interface{}{t.TxHash}); err != nil {
+				return err
+			}
+		}
+		if receipt != nil || tx != nil {
+			t.Status, t.Err = batchStatusSent, ""
+			fmt.Printf("row %d: txHash %s found, marked as sent\n", t.Row, t.TxHash)
+			continue
+		}
+
+		if nonce == nil {
+			n, err := c.GetTransactionCount(from, "pending")
+			if err != nil {
+				return err
+			}
+			nonce = &n
+		}
+		if *nonce > sent {
+			return fmt.Errorf("row %d: txHash %s not found but nonce %d already used, check the account before running again", t.Row, t.TxHash, sent)
+		}
+		fmt.Printf("row %d: txHash %s not found, send again\n", t.Row, t.TxHash)
+		t.Status, t.TxHash, t.Nonce, t.Err = batchStatusPending, "", "", ""
+	}
+	return nil
+}
+
+// 批量转账
+//
+// 先检查全部行(地址/金额/支出策略)和余额, 确认之后按顺序发送, 每发送一笔更新结果文件.
+// 中途退出后重新运行同样的命令, 已经发送的行会被跳过, 失败的行重新发送.
+// 每一行发送之前先记录交易 hash 和 nonce, 重新运行时先向节点查询发送中的行, 不会重复发送.
+func (p *App) CmdSendBatch(file, resultFile string, gasLimit, gasPrice int64, yes bool) error {
+	if resultFile == "" {
+		resultFile = batchResultFile(file)
+	}
+	if gasLimit == 0 {
+		gasLimit = DefaultGasLimit
+	}
+	if gasPrice == 0 {
+		gasPrice = DefaultGasPrice
+	}
+
+	list, errs, err := p.loadBatchFile(file)
+	if err != nil {
+		return err
+	}
+	if err := loadBatchResult(resultFile, list); err != nil {
+		return err
+	}
+
+	var todo []*BatchTransfer
+	for _, t := range list {
+		if t.Status != batchStatusSent {
+			todo = append(todo, t)
+		}
+	}
+	errs = append(errs, p.checkBatchPolicy(todo)...)
+	if len(errs) > 0 {
+		for _, e := range errs {
+			fmt.Printf("%s: %v\n", file, e)
+		}
+		return fmt.Errorf("%s: %d error(s)", file, len(errs))
+	}
+
+	w, err := NewWallet(p.cfg.UserAddress, p.cfg.UserKey)
+	if err != nil {
+		return fmt.Errorf("invalid UserKey")
+	}
+	c, err := p.rpcClient(time.Second * 3)
+	if err != nil {
+		return err
+	}
+
+	// 上次发送中的行查询之后再决定是否发送
+	if err := resolveBatchSending(c, w.Address, todo); err != nil {
+		return err
+	}
+	if err := saveBatchResult(resultFile, list); err != nil {
+		return err
+	}
+	unsent := todo[:0]
+	for _, t := range todo {
+		if t.Status != batchStatusSent {
+			unsent = append(unsent, t)
+		}
+	}
+	todo = unsent
+
+	var (
+		total   = new(big.Int)
+		gasCost = new(big.Int).Mul(big.NewInt(gasLimit*int64(len(todo))), big.NewInt(gasPrice))
+	)
+	for _, t := range todo {
+		total.Add(total, t.Value)
+	}
+	balance, err := c.GetBalance(w.Address)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("from:      %s\n", w.Address)
+	fmt.Printf("transfers: %d (%d already sent)\n", len(todo), len(list)-len(todo))
+	fmt.Printf("total:     %s HYK\n", formatWei(total))
+	fmt.Printf("max gas:   %s HYK\n", formatWei(gasCost))
+	fmt.Printf("balance:   %s HYK\n", formatWei(balance))
+
+	if len(todo) == 0 {
+		fmt.Println("nothing to send")
+		return nil
+	}
+	if need := new(big.Int).Add(total, gasCost); balance.Cmp(need) < 0 {
+		return fmt.Errorf("insufficient balance: need %s HYK, have %s HYK", formatWei(need), formatWei(balance))
+	}
+	if !yes && !confirmStdin(fmt.Sprintf("send %d transfer(s), total %s HYK?", len(todo), formatWei(total))) {
+		return fmt.Errorf("canceled")
+	}
+
+	// 超过策略中的确认金额时仍然逐笔确认, --yes 只确认整个批次
+	app := p.withConfirm(confirmStdin)
+
+	var failed int
+	for _, t := range todo {
+		// 先保存发送中的交易, 节点接受之后、保存结果之前退出时重新运行可以查到
+		sendApp := app.withBeforeSend(func(txHash string, nonce uint64) error {
+			t.Status, t.TxHash, t.Nonce, t.Err = batchStatusSending, txHash, strconv.FormatUint(nonce, 10), ""
+			return saveBatchResult(resultFile, list)
+		})
+		txHash, err := sendApp.sendRawTxFrom(c, w, t.Address, t.Value, uint64(gasLimit), big.NewInt(gasPrice))
+		if err != nil {
+			// 节点明确拒绝时才是失败, 网络错误时交易可能已经被接受, 保留发送中的状态
+			if _, ok := err.(*rpc.RPCError); ok || t.Status != batchStatusSending {
+				t.Status, t.TxHash, t.Nonce = batchStatusFailed, "", ""
+			}
+			t.Err = err.Error()
+			failed++
+			fmt.Printf("row %d: %s(%s) %s HYK failed: %v\n", t.Row, t.To, t.Address, formatWei(t.Value), err)
+		} else {
+			t.Status, t.TxHash, t.Err = batchStatusSent, txHash, ""
+			fmt.Printf("row %d: %s(%s) %s HYK, txHash: %s\n", t.Row, t.To, t.Address, formatWei(t.Value), txHash)
+		}
+
+		if err := saveBatchResult(resultFile, list); err != nil {
+			return err
+		}
+	}
+
+	fmt.Printf("result: %s\n", resultFile)
+	if failed > 0 {
+		return fmt.Errorf("%d of %d transfers failed, run again to retry", failed, len(todo))
+	}
+	return nil
+}
+
+// 按顺序模拟记账, 提前发现会被支出策略拒绝的行
+func (p *App) checkBatchPolicy(list []*BatchTransfer) FieldErrors {
+	var errs FieldErrors
+
+	policy, err := p.spendPolicy()
+	if err != nil {
+		errs.add("policy", "%v", err)
+		return errs
+	}
+	if policy == nil {
+		return nil
+	}
+
+	state, err := loadSpendState(policy.stateFile(), time.Now())
+	if err != nil {
+		errs.add("policy", "%v", err)
+		return errs
+	}
+	for _, t := range list {
+		if t.Value.Sign() == 0 || validateAddress(t.Address) != "" {
+			continue
+		}
+		if err := policy.check(state, t.Address, t.Value); err != nil {
+			errs.add(fmt.Sprintf("row %d", t.Row), "%v", err)
+			continue
+		}
+		state.add(t.Address, t.Value)
+	}
+	return errs
+}
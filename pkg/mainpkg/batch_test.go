@@ -0,0 +1,146 @@
+package mainpkg
+
+import (
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"xcoin/HayekTool/pkg/config"
+	"xcoin/HayekTool/pkg/rpc"
+)
+
+func TestBatchFileResume(t *testing.T) {
+	dir, err := ioutil.TempDir("", "batch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "transfers.csv")
+	data := "to,amount,memo\n" +
+		"# 注释\n" +
+		"alice, 1.5 HYK, bonus\n" +
+		"0x5205f45c6399c41e11e533926ca69a0aedfdbb8d, 100 gwei\n"
+	if err := ioutil.WriteFile(file, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	app := NewApp(&config.Config{
+		XUserAddressBook: map[string]string{"alice": "0x3eb41fc94f240242c9bbb8bf46b9feb356fd09e2"},
+	})
+	list, errs, err := app.loadBatchFile(file)
+	if err != nil || len(errs) > 0 {
+		t.Fatal(err, errs)
+	}
+	if len(list) != 2 || list[0].Row != 2 || list[0].Address != "0x3eb41fc94f240242c9bbb8bf46b9feb356fd09e2" ||
+		list[0].Value.String() != "1500000000000000000" || list[0].Memo != "bonus" ||
+		list[1].Value.String() != "100000000000" {
+		t.Fatalf("unexpected transfers: %+v %+v", list[0], list[1])
+	}
+
+	resultFile := batchResultFile(file)
+	if resultFile != filepath.Join(dir, "transfers.result.csv") {
+		t.Fatalf("result file = %s", resultFile)
+	}
+	list[0].Status, list[0].TxHash = batchStatusSent, "0x01"
+	list[1].Status, list[1].Err = batchStatusFailed, "boom"
+	if err := saveBatchResult(resultFile, list); err != nil {
+		t.Fatal(err)
+	}
+
+	resumed, _, _ := app.loadBatchFile(file)
+	if err := loadBatchResult(resultFile, resumed); err != nil {
+		t.Fatal(err)
+	}
+	if resumed[0].Status != batchStatusSent || resumed[0].TxHash != "0x01" || resumed[1].Status != batchStatusPending {
+		t.Fatalf("unexpected resume: %+v %+v", resumed[0], resumed[1])
+	}
+
+	// 已经发送的行被修改后不能继续
+	data = "to,amount,memo\n# 注释\nalice, 2 HYK\n"
+	ioutil.WriteFile(file, []byte(data), 0644)
+	changed, _, _ := app.loadBatchFile(file)
+	if err := loadBatchResult(resultFile, changed); err == nil {
+		t.Fatal("expect mismatch error")
+	}
+}
+
+func TestSendBatchCrashResume(t *testing.T) {
+	dir, err := ioutil.TempDir("", "batch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	const (
+		alice = "0x5205f45c6399c41e11e533926ca69a0aedfdbb8d"
+		bob   = "0x00000000000000000000000000000000000000bb"
+	)
+	user, key := newTestKey(t)
+	node, app := newTestNode(t, map[string]*big.Int{user: etherToWei(10)})
+	app.cfg.UserAddress, app.cfg.UserKey = user, key
+
+	file := filepath.Join(dir, "transfers.csv")
+	resultFile := batchResultFile(file)
+	data := alice + ",1\n" + bob + ",2\n"
+	if err := ioutil.WriteFile(file, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	list, _, err := app.loadBatchFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 第一行: 记录发送中之后节点接受了交易, 保存结果之前退出
+	client, _ := rpc.NewRPCClient("HayekTool", app.cfg.Host, time.Second)
+	wallet, _ := NewWallet(user, key)
+	crash := func(send bool, t0 *BatchTransfer) *App {
+		return app.withBeforeSend(func(txHash string, nonce uint64) error {
+			t0.Status, t0.TxHash, t0.Nonce = batchStatusSending, txHash, strconv.FormatUint(nonce, 10)
+			if err := saveBatchResult(resultFile, list); err != nil {
+				return err
+			}
+			if !send {
+				return fmt.Errorf("killed")
+			}
+			return nil
+		})
+	}
+	if _, err := crash(true, list[0]).sendRawTxFrom(client, wallet, alice, list[0].Value, DefaultGasLimit, big.NewInt(DefaultGasPrice)); err != nil {
+		t.Fatal(err)
+	}
+	// 第二行: 记录发送中之后还没有广播就退出
+	if _, err := crash(false, list[1]).sendRawTxFrom(client, wallet, bob, list[1].Value, DefaultGasLimit, big.NewInt(DefaultGasPrice)); err == nil {
+		t.Fatal("expect killed")
+	}
+	if node.Pending() != 1 {
+		t.Fatalf("pending = %d", node.Pending())
+	}
+
+	// 重新运行: 第一行查到交易不再发送, 第二行重新发送
+	if err := app.CmdSendBatch(file, "", 0, 0, true); err != nil {
+		t.Fatal(err)
+	}
+	node.Mine()
+	if n := node.Nonce(user); n != 2 {
+		t.Fatalf("nonce = %d, expect 2 transactions", n)
+	}
+	if a, b := node.Balance(alice), node.Balance(bob); a.Cmp(etherToWei(1)) != 0 || b.Cmp(etherToWei(2)) != 0 {
+		t.Fatalf("balances: alice %v, bob %v", a, b)
+	}
+
+	resumed, _, _ := app.loadBatchFile(file)
+	if err := loadBatchResult(resultFile, resumed); err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range resumed {
+		if r.Status != batchStatusSent || r.TxHash == "" || r.Nonce == "" {
+			t.Fatalf("unexpected result: %+v", r)
+		}
+	}
+}
@@ -0,0 +1,248 @@
+package mainpkg
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"xcoin/HayekTool/pkg/rpc"
+	"xcoin/HayekTool/pkg/util"
+)
+
+// 每个批量请求查询的区块数
+const chainStatsBatchSize = 100
+
+// 最近区块的统计
+type ChainStats struct {
+	Blocks     int
+	FromHeight int64
+	ToHeight   int64
+
+	// 出块时间(秒)
+	AvgBlockTime    float64
+	MedianBlockTime float64
+	MinBlockTime    int64
+	MaxBlockTime    int64
+
+	// 难度变化
+	FirstDifficulty   *big.Int
+	LastDifficulty    *big.Int
+	MinDifficulty     *big.Int
+	MaxDifficulty     *big.Int
+	AvgDifficulty     *big.Int
+	DifficultyChange  float64 // 最后一个区块相对第一个区块的变化(%)
+	EstimatedHashrate float64 // 平均难度 / 平均出块时间(H/s)
+
+	GasUsed     *big.Int
+	GasLimit    *big.Int
+	GasUsage    float64 // 使用的 gas 占 gas 上限的比例(%)
+	Txs         int
+	TxsPerBlock float64
+	Uncles      int
+	UncleRate   float64 // 叔块数 / 区块数(%)
+
+	TopMiners []*MinerStats
+}
+
+type MinerStats struct {
+	Address string
+	Blocks  int
+	Share   float64 // 出块占比(%)
+}
+
+// 统计最近 n 个区块, 输出表格或者 JSON
+func (p *App) CmdChainStats(n int, top int, format string) error {
+	if n < 2 {
+		return fmt.Errorf("chain-stats: need at least 2 blocks")
+	}
+
+	c, err := p.rpcClient(time.Second * 10)
+	if err != nil {
+		return err
+	}
+
+	latest, err := c.GetLatestBlock(false)
+	if err != nil {
+		return err
+	}
+	if latest == nil {
+		return fmt.Errorf("chain-stats: no latest block")
+	}
+	to := util.String2Big(latest.Number).Int64()
+	from := to - int64(n) + 1
+	if from < 0 {
+		from = 0
+	}
+
+	var blocks []*rpc.GetBlockReply
+	for start := from; start <= to; start += chainStatsBatchSize {
+		var heights []int64
+		for h := start; h <= to && h < start+chainStatsBatchSize; h++ {
+			heights = append(heights, h)
+		}
+		list, err := c.GetBlocksByHeight(heights, false)
+		if err != nil {
+			return err
+		}
+		for i, b := range list {
+			if b == nil {
+				return fmt.Errorf("chain-stats: block %d not found", heights[i])
+			}
+		}
+		blocks = append(blocks, list...)
+	}
+
+	stats, err := computeChainStats(blocks, top)
+	if err != nil {
+		return err
+	}
+
+	if format == "json" {
+		s, _ := json.MarshalIndent(stats, "", "\t")
+		fmt.Println(string(s))
+		return nil
+	}
+	return printChainStats(stats)
+}
+
+func computeChainStats(blocks []*rpc.GetBlockReply, top int) (*ChainStats, error) {
+	if len(blocks) < 2 {
+		return nil, fmt.Errorf("chain-stats: need at least 2 blocks")
+	}
+
+	stats := &ChainStats{
+		Blocks:     len(blocks),
+		FromHeight: util.String2Big(blocks[0].Number).Int64(),
+		ToHeight:   util.String2Big(blocks[len(blocks)-1].Number).Int64(),
+
+		FirstDifficulty: util.String2Big(blocks[0].Difficulty),
+		LastDifficulty:  util.String2Big(blocks[len(blocks)-1].Difficulty),
+		AvgDifficulty:   new(big.Int),
+		GasUsed:         new(big.Int),
+		GasLimit:        new(big.Int),
+	}
+
+	var (
+		times  []int64
+		miners = make(map[string]*MinerStats)
+	)
+	for i, b := range blocks {
+		if i > 0 {
+			if b.ParentHash != blocks[i-1].Hash {
+				return nil, fmt.Errorf("chain-stats: block %s is not a child of %s, chain changed while fetching", b.Number, blocks[i-1].Number)
+			}
+			times = append(times, util.String2Big(b.Timestamp).Int64()-util.String2Big(blocks[i-1].Timestamp).Int64())
+		}
+
+		diff := util.String2Big(b.Difficulty)
+		stats.AvgDifficulty.Add(stats.AvgDifficulty, diff)
+		if stats.MinDifficulty == nil || diff.Cmp(stats.MinDifficulty) < 0 {
+			stats.MinDifficulty = diff
+		}
+		if stats.MaxDifficulty == nil || diff.Cmp(stats.MaxDifficulty) > 0 {
+			stats.MaxDifficulty = diff
+		}
+
+		stats.GasUsed.Add(stats.GasUsed, util.String2Big(b.GasUsed))
+		stats.GasLimit.Add(stats.GasLimit, util.String2Big(b.GasLimit))
+		stats.Txs += len(b.Transactions)
+		stats.Uncles += len(b.Uncles)
+
+		miner := strings.ToLower(b.Miner)
+		if miners[miner] == nil {
+			miners[miner] = &MinerStats{Address: miner}
+		}
+		miners[miner].Blocks++
+	}
+	stats.AvgDifficulty.Div(stats.AvgDifficulty, big.NewInt(int64(len(blocks))))
+
+	var total int64
+	for _, t := range times {
+		total += t
+	}
+	sort.Slice(times, func(i, j int) bool { return times[i] < times[j] })
+	stats.AvgBlockTime = float64(total) / float64(len(times))
+	stats.MinBlockTime = times[0]
+	stats.MaxBlockTime = times[len(times)-1]
+	if k := len(times); k%2 == 1 {
+		stats.MedianBlockTime = float64(times[k/2])
+	} else {
+		stats.MedianBlockTime = float64(times[k/2-1]+times[k/2]) / 2
+	}
+
+	if stats.FirstDifficulty.Sign() > 0 {
+		change := new(big.Float).SetInt(new(big.Int).Sub(stats.LastDifficulty, stats.FirstDifficulty))
+		change.Quo(change, new(big.Float).SetInt(stats.FirstDifficulty))
+		stats.DifficultyChange, _ = change.Float64()
+		stats.DifficultyChange *= 100
+	}
+	if stats.AvgBlockTime > 0 {
+		diff, _ := new(big.Float).SetInt(stats.AvgDifficulty).Float64()
+		stats.EstimatedHashrate = diff / stats.AvgBlockTime
+	}
+	if stats.GasLimit.Sign() > 0 {
+		usage := new(big.Float).Quo(new(big.Float).SetInt(stats.GasUsed), new(big.Float).SetInt(stats.GasLimit))
+		stats.GasUsage, _ = usage.Float64()
+		stats.GasUsage *= 100
+	}
+	stats.TxsPerBlock = float64(stats.Txs) / float64(len(blocks))
+	stats.UncleRate = 100 * float64(stats.Uncles) / float64(len(blocks))
+
+	for _, m := range miners {
+		m.Share = 100 * float64(m.Blocks) / float64(len(blocks))
+		stats.TopMiners = append(stats.TopMiners, m)
+	}
+	sort.Slice(stats.TopMiners, func(i, j int) bool {
+		a, b := stats.TopMiners[i], stats.TopMiners[j]
+		if a.Blocks != b.Blocks {
+			return a.Blocks > b.Blocks
+		}
+		return a.Address < b.Address
+	})
+	if top > 0 && len(stats.TopMiners) > top {
+		stats.TopMiners = stats.TopMiners[:top]
+	}
+
+	return stats, nil
+}
+
+func printChainStats(stats *ChainStats) error {
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(w, "blocks:\t%d (%d - %d)\n", stats.Blocks, stats.FromHeight, stats.ToHeight)
+	fmt.Fprintf(w, "block time:\tavg %.2fs, median %.1fs, min %ds, max %ds\n",
+		stats.AvgBlockTime, stats.MedianBlockTime, stats.MinBlockTime, stats.MaxBlockTime,
+	)
+	fmt.Fprintf(w, "difficulty:\t%s -> %s (%+.2f%%), min %s, max %s\n",
+		stats.FirstDifficulty, stats.LastDifficulty, stats.DifficultyChange, stats.MinDifficulty, stats.MaxDifficulty,
+	)
+	fmt.Fprintf(w, "hashrate:\t%s (estimated)\n", formatHashrate(stats.EstimatedHashrate))
+	fmt.Fprintf(w, "gas usage:\t%.2f%% (%s / %s)\n", stats.GasUsage, stats.GasUsed, stats.GasLimit)
+	fmt.Fprintf(w, "txs:\t%d (%.2f per block)\n", stats.Txs, stats.TxsPerBlock)
+	fmt.Fprintf(w, "uncles:\t%d (%.2f%%)\n", stats.Uncles, stats.UncleRate)
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	fmt.Println()
+	w = tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "MINER\tBLOCKS\tSHARE")
+	for _, m := range stats.TopMiners {
+		fmt.Fprintf(w, "%s\t%d\t%.2f%%\n", m.Address, m.Blocks, m.Share)
+	}
+	return w.Flush()
+}
+
+func formatHashrate(h float64) string {
+	units := []string{"H/s", "KH/s", "MH/s", "GH/s", "TH/s", "PH/s"}
+	i := 0
+	for h >= 1000 && i < len(units)-1 {
+		h /= 1000
+		i++
+	}
+	return fmt.Sprintf("%.2f %s", h, units[i])
+}
@@ -0,0 +1,60 @@
+package mainpkg
+
+import (
+	"fmt"
+	"testing"
+
+	"xcoin/HayekTool/pkg/rpc"
+)
+
+func TestComputeChainStats(t *testing.T) {
+	const (
+		alice = "0x3eb41fc94f240242c9bbb8bf46b9feb356fd09e2"
+		bob   = "0x5205f45c6399c41e11e533926ca69a0aedfdbb8d"
+	)
+	var blocks []*rpc.GetBlockReply
+	for i, x := range []struct {
+		time, diff int
+		miner      string
+		txs        int
+		uncles     int
+	}{
+		{1000, 1000, alice, 0, 0},
+		{1010, 1100, bob, 2, 1},
+		{1040, 1200, alice, 1, 0},
+		{1050, 1300, alice, 3, 0},
+	} {
+		b := &rpc.GetBlockReply{
+			Number:       fmt.Sprintf("0x%x", 100+i),
+			Hash:         fmt.Sprintf("0x%02x", i+1),
+			ParentHash:   fmt.Sprintf("0x%02x", i),
+			Timestamp:    fmt.Sprintf("0x%x", x.time),
+			Difficulty:   fmt.Sprintf("0x%x", x.diff),
+			Miner:        x.miner,
+			GasUsed:      fmt.Sprintf("0x%x", 21000*x.txs),
+			GasLimit:     "0x15f90", // 90000
+			Transactions: make([]rpc.Tx, x.txs),
+			Uncles:       make([]string, x.uncles),
+		}
+		blocks = append(blocks, b)
+	}
+
+	stats, err := computeChainStats(blocks, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := fmt.Sprintf("%d-%d avg=%.2f median=%.1f min=%d max=%d diff=%s/%.0f%% hashrate=%.0f gas=%.2f txs=%.1f uncles=%.0f top=%s:%d",
+		stats.FromHeight, stats.ToHeight, stats.AvgBlockTime, stats.MedianBlockTime, stats.MinBlockTime, stats.MaxBlockTime,
+		stats.AvgDifficulty, stats.DifficultyChange, stats.EstimatedHashrate, stats.GasUsage, stats.TxsPerBlock, stats.UncleRate,
+		stats.TopMiners[0].Address, stats.TopMiners[0].Blocks,
+	)
+	want := "100-103 avg=16.67 median=10.0 min=10 max=30 diff=1150/30% hashrate=69 gas=35.00 txs=1.5 uncles=25 top=" + alice + ":3"
+	if got != want {
+		t.Fatalf("stats = %s\nexpect  %s", got, want)
+	}
+
+	blocks[2].ParentHash = "0xff"
+	if _, err := computeChainStats(blocks, 0); err == nil {
+		t.Fatal("expect error for broken chain")
+	}
+}
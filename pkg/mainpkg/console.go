@@ -0,0 +1,599 @@
+package mainpkg
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh/terminal"
+
+	"xcoin/HayekTool/pkg/addressbook"
+	"xcoin/HayekTool/pkg/rpc"
+	"xcoin/HayekTool/pkg/util"
+)
+
+const DefaultConsoleHistoryFile = "console-history"
+
+type ConsoleOptions struct {
+	HistoryFile string                              // 命令历史文件
+	Commands    []string                            // 可以在控制台中执行的 CLI 命令
+	Run         func(app *App, args []string) error // 执行 CLI 命令, app 共享控制台的配置和节点连接
+}
+
+// 控制台内置命令
+type consoleCommand struct {
+	name  string
+	usage string
+	run   func(c *console, args []string) (interface{}, error)
+}
+
+// help 需要列出所有命令, 在 init 中初始化避免循环引用
+var consoleCommands []*consoleCommand
+
+func init() {
+	consoleCommands = []*consoleCommand{
+		{"block", "block <latest|pending|height|hash> [full]", (*console).cmdBlock},
+		{"uncle", "uncle <height> <index>", (*console).cmdUncle},
+		{"tx", "tx <hash>", (*console).cmdTx},
+		{"receipt", "receipt <hash>", (*console).cmdReceipt},
+		{"balance", "balance <address|name>", (*console).cmdBalance},
+		{"nonce", "nonce <address|name> [latest|pending]", (*console).cmdNonce},
+		{"height", "height", (*console).cmdHeight},
+		{"peers", "peers", (*console).cmdPeers},
+		{"work", "work", (*console).cmdWork},
+		{"call", "call <method> [params...]   (numbers are hex encoded, JSON values as is)", (*console).cmdCall},
+		{"history", "history [n]   (!n runs history entry n)", (*console).cmdHistory},
+		{"help", "help", (*console).cmdHelp},
+		{"exit", "exit", nil},
+	}
+}
+
+type console struct {
+	app     *App
+	opt     ConsoleOptions
+	client  *rpc.RPCClient
+	chainId string
+	names   []string // 地址簿中的名字, 用于补全
+	history []string
+	out     io.Writer
+	term    *terminal.Terminal
+}
+
+var errConsoleExit = errors.New("exit")
+
+// 交互式控制台, 使用同一个 RPC 客户端执行查询
+//
+// 支持 Tab 补全命令和地址簿名字, 命令历史, 以及用管道过滤结果, 比如
+// block latest | .transactions[0].hash. 标准输入不是终端时逐行执行.
+func (p *App) CmdConsole(opt ConsoleOptions) error {
+	if opt.HistoryFile == "" {
+		opt.HistoryFile = DefaultConsoleHistoryFile
+	}
+
+	client, err := rpc.NewRPCClient("HayekTool", p.cfg.Host, time.Second*10)
+	if err != nil {
+		return err
+	}
+	c := &console{app: p, opt: opt, client: client, chainId: "?", out: os.Stdout}
+	if id, err := client.NetVersion(); err == nil {
+		c.chainId = strconv.Itoa(id)
+	}
+	c.loadNames()
+	c.loadHistory()
+
+	fd := int(os.Stdin.Fd())
+	if !terminal.IsTerminal(fd) {
+		return c.runScript(os.Stdin)
+	}
+
+	state, err := terminal.MakeRaw(fd)
+	if err != nil {
+		return err
+	}
+	defer terminal.Restore(fd, state)
+
+	c.term = terminal.NewTerminal(struct {
+		io.Reader
+		io.Writer
+	}{os.Stdin, os.Stdout}, "")
+	c.term.AutoCompleteCallback = c.complete
+	if w, h, err := terminal.GetSize(fd); err == nil {
+		c.term.SetSize(w, h)
+	}
+	c.out = c.term
+
+	fmt.Fprintf(c.out, "HayekTool console, %s. Type help for commands, Ctrl-D to exit.\n", p.cfg.Host)
+	for {
+		c.term.SetPrompt(c.prompt())
+		line, err := c.term.ReadLine()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		// CLI 命令在普通模式下执行, 可以用 Ctrl-C 中断
+		err = c.exec(line, func(args []string) error {
+			terminal.Restore(fd, state)
+			defer terminal.MakeRaw(fd)
+			return c.runCLI(args)
+		})
+		if err == errConsoleExit {
+			return nil
+		}
+		if err != nil {
+			fmt.Fprintf(c.out, "error: %v\n", err)
+		}
+	}
+}
+
+// 逐行执行, 出错时继续
+func (c *console) runScript(r io.Reader) error {
+	var run func(args []string) error
+	if c.opt.Run != nil {
+		run = c.runCLI
+	}
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		err := c.exec(scanner.Text(), run)
+		if err == errConsoleExit {
+			return nil
+		}
+		if err != nil {
+			fmt.Fprintf(c.out, "error: %v\n", err)
+		}
+	}
+	return scanner.Err()
+}
+
+// 执行 CLI 命令, 共享控制台的配置和节点连接
+func (c *console) runCLI(args []string) error {
+	return c.opt.Run(c.app.withClient(c.client), args)
+}
+
+func (c *console) prompt() string {
+	height := "?"
+	if data, err := c.client.Call(rpc.CoinId+"_blockNumber", nil); err == nil {
+		var s string
+		if json.Unmarshal(data, &s) == nil {
+			height = util.String2Big(s).String()
+		}
+	}
+	host := strings.TrimPrefix(strings.TrimPrefix(c.app.cfg.Host, "http://"), "https://")
+	return fmt.Sprintf("%s[%s]@%s #%s> ", rpc.CoinId, c.chainId, host, height)
+}
+
+func (c *console) loadNames() {
+	book, err := addressbook.Load(c.app.addressBookPath())
+	if err == nil {
+		for _, e := range book.Entries {
+			c.names = append(c.names, e.Name)
+		}
+	}
+	for name := range c.app.cfg.XUserAddressBook {
+		c.names = append(c.names, name)
+	}
+	sort.Strings(c.names)
+}
+
+func (c *console) loadHistory() {
+	data, err := ioutil.ReadFile(c.opt.HistoryFile)
+	if err != nil {
+		return
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			c.history = append(c.history, line)
+		}
+	}
+}
+
+func (c *console) addHistory(line string) {
+	if n := len(c.history); n > 0 && c.history[n-1] == line {
+		return
+	}
+	c.history = append(c.history, line)
+
+	f, err := os.OpenFile(c.opt.HistoryFile, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return
+	}
+	fmt.Fprintln(f, line)
+	f.Close()
+}
+
+// 执行一行命令, 结果经过管道中的过滤器后输出
+func (c *console) exec(line string, run func(args []string) error) error {
+	line = strings.TrimSpace(line)
+	if line == "" || strings.HasPrefix(line, "#") {
+		return nil
+	}
+	if strings.HasPrefix(line, "!") {
+		n, err := strconv.Atoi(line[1:])
+		if err != nil || n < 1 || n > len(c.history) {
+			return fmt.Errorf("%s: no such history entry", line)
+		}
+		line = c.history[n-1]
+		fmt.Fprintln(c.out, line)
+	}
+	c.addHistory(line)
+
+	stages, err := splitPipes(line)
+	if err != nil {
+		return err
+	}
+	args, err := splitArgs(stages[0])
+	if err != nil {
+		return err
+	}
+	if len(args) == 0 {
+		return fmt.Errorf("empty command")
+	}
+
+	cmd := findConsoleCommand(args[0])
+	if cmd == nil {
+		if !c.isCLICommand(args[0]) || run == nil {
+			return fmt.Errorf("unknown command %q, type help for commands", args[0])
+		}
+		if len(stages) > 1 {
+			return fmt.Errorf("%s: pipe only works with console commands", args[0])
+		}
+		return run(args)
+	}
+	if cmd.run == nil {
+		return errConsoleExit
+	}
+
+	result, err := cmd.run(c, args[1:])
+	if err != nil {
+		return err
+	}
+	if len(stages) > 1 {
+		v, err := toJSONValue(result)
+		if err != nil {
+			return err
+		}
+		for _, expr := range stages[1:] {
+			if v, err = queryJSON(v, expr); err != nil {
+				return err
+			}
+		}
+		result = v
+	}
+	return c.print(result)
+}
+
+func (c *console) print(v interface{}) error {
+	switch x := v.(type) {
+	case nil:
+		fmt.Fprintln(c.out, "null")
+	case string:
+		fmt.Fprintln(c.out, x)
+	case json.Number:
+		fmt.Fprintln(c.out, x.String())
+	case int:
+		fmt.Fprintln(c.out, x)
+	case json.RawMessage:
+		if len(x) == 0 {
+			fmt.Fprintln(c.out, "null")
+			return nil
+		}
+		var buf bytes.Buffer
+		if err := json.Indent(&buf, x, "", "\t"); err != nil {
+			return err
+		}
+		fmt.Fprintln(c.out, buf.String())
+	default:
+		s, err := json.MarshalIndent(v, "", "\t")
+		if err != nil {
+			return err
+		}
+		fmt.Fprintln(c.out, string(s))
+	}
+	return nil
+}
+
+func findConsoleCommand(name string) *consoleCommand {
+	if name == "quit" {
+		name = "exit"
+	}
+	for _, cmd := range consoleCommands {
+		if cmd.name == name {
+			return cmd
+		}
+	}
+	return nil
+}
+
+func (c *console) isCLICommand(name string) bool {
+	for _, s := range c.opt.Commands {
+		if s == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Tab 补全: 第一个词补全命令, 管道之后补全过滤器, 其它位置补全地址簿名字和区块标签
+func (c *console) complete(line string, pos int, key rune) (string, int, bool) {
+	if key != '\t' {
+		return "", 0, false
+	}
+
+	head := line[:pos]
+	start := strings.LastIndexAny(head, " |") + 1
+	word := head[start:]
+	before := strings.TrimSpace(head[:start])
+
+	var candidates []string
+	switch {
+	case before == "":
+		for _, cmd := range consoleCommands {
+			candidates = append(candidates, cmd.name)
+		}
+		candidates = append(candidates, c.opt.Commands...)
+	case strings.HasSuffix(before, "|"):
+		candidates = consoleFilters
+	default:
+		candidates = append([]string{"latest", "pending", "full"}, c.names...)
+	}
+
+	var matches []string
+	seen := make(map[string]bool)
+	for _, s := range candidates {
+		if strings.HasPrefix(s, word) && !seen[s] {
+			matches = append(matches, s)
+			seen[s] = true
+		}
+	}
+	if len(matches) == 0 {
+		return line, pos, true
+	}
+	sort.Strings(matches)
+
+	prefix := matches[0]
+	for _, s := range matches[1:] {
+		for !strings.HasPrefix(s, prefix) {
+			prefix = prefix[:len(prefix)-1]
+		}
+	}
+	if len(matches) == 1 {
+		prefix += " "
+	} else if prefix == word && c.term != nil {
+		fmt.Fprintf(c.term, "%s\n", strings.Join(matches, "  "))
+	}
+	return head[:start] + prefix + line[pos:], start + len(prefix), true
+}
+
+// 按 | 分割管道, 忽略引号中的 |
+func splitPipes(line string) ([]string, error) {
+	var (
+		stages []string
+		quote  rune
+		last   int
+	)
+	for i, r := range line {
+		switch {
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			}
+		case r == '"' || r == '\'':
+			quote = r
+		case r == '|':
+			stages = append(stages, line[last:i])
+			last = i + 1
+		}
+	}
+	if quote != 0 {
+		return nil, fmt.Errorf("unterminated quote")
+	}
+	stages = append(stages, line[last:])
+	for i, s := range stages {
+		if stages[i] = strings.TrimSpace(s); stages[i] == "" {
+			return nil, fmt.Errorf("empty pipe stage")
+		}
+	}
+	return stages, nil
+}
+
+// 按空白分割参数, 支持单引号和双引号
+func splitArgs(s string) ([]string, error) {
+	var (
+		args  []string
+		cur   strings.Builder
+		quote rune
+		inArg bool
+	)
+	for _, r := range s {
+		switch {
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			} else {
+				cur.WriteRune(r)
+			}
+		case r == '"' || r == '\'':
+			quote, inArg = r, true
+		case r == ' ' || r == '\t':
+			if inArg {
+				args = append(args, cur.String())
+				cur.Reset()
+				inArg = false
+			}
+		default:
+			cur.WriteRune(r)
+			inArg = true
+		}
+	}
+	if quote != 0 {
+		return nil, fmt.Errorf("unterminated quote")
+	}
+	if inArg {
+		args = append(args, cur.String())
+	}
+	return args, nil
+}
+
+func (c *console) cmdBlock(args []string) (interface{}, error) {
+	if len(args) < 1 || len(args) > 2 || len(args) == 2 && args[1] != "full" {
+		return nil, fmt.Errorf("usage: block <latest|pending|height|hash> [full]")
+	}
+	block, err := fetchBlock(c.client, args[0], len(args) == 2)
+	if err != nil || block == nil {
+		return nil, err
+	}
+	return block, nil
+}
+
+func (c *console) cmdUncle(args []string) (interface{}, error) {
+	if len(args) != 2 {
+		return nil, fmt.Errorf("usage: uncle <height> <index>")
+	}
+	height, err := parseHeight(args[0])
+	if err != nil {
+		return nil, err
+	}
+	index, err := strconv.Atoi(args[1])
+	if err != nil {
+		return nil, fmt.Errorf("invalid index %q", args[1])
+	}
+	block, err := c.client.GetUncleByBlockNumberAndIndex(height, index)
+	if err != nil || block == nil {
+		return nil, err
+	}
+	return block, nil
+}
+
+func (c *console) cmdTx(args []string) (interface{}, error) {
+	if len(args) != 1 {
+		return nil, fmt.Errorf("usage: tx <hash>")
+	}
+	return c.client.Call(rpc.CoinId+"_getTransactionByHash", []interface{}{args[0]})
+}
+
+func (c *console) cmdReceipt(args []string) (interface{}, error) {
+	if len(args) != 1 {
+		return nil, fmt.Errorf("usage: receipt <hash>")
+	}
+	return c.client.Call(rpc.CoinId+"_getTransactionReceipt", []interface{}{args[0]})
+}
+
+// 地址的余额, 控制台和 HTTP API 使用
+type addressBalance struct {
+	Address string `json:"address"`
+	Wei     string `json:"wei"`
+	HYK     string `json:"hyk"`
+}
+
+func (c *console) cmdBalance(args []string) (interface{}, error) {
+	if len(args) != 1 {
+		return nil, fmt.Errorf("usage: balance <address|name>")
+	}
+	address, err := c.app.getAddress(args[0])
+	if err != nil {
+		return nil, err
+	}
+	wei, err := c.client.GetBalance(address)
+	if err != nil {
+		return nil, err
+	}
+	return &addressBalance{Address: address, Wei: wei.String(), HYK: formatWei(wei)}, nil
+}
+
+func (c *console) cmdNonce(args []string) (interface{}, error) {
+	if len(args) < 1 || len(args) > 2 {
+		return nil, fmt.Errorf("usage: nonce <address|name> [latest|pending]")
+	}
+	address, err := c.app.getAddress(args[0])
+	if err != nil {
+		return nil, err
+	}
+	block := "latest"
+	if len(args) == 2 {
+		block = args[1]
+	}
+	nonce, err := c.client.GetTransactionCount(address, block)
+	if err != nil {
+		return nil, err
+	}
+	return json.Number(strconv.FormatUint(nonce, 10)), nil
+}
+
+func (c *console) cmdHeight(args []string) (interface{}, error) {
+	block, err := c.client.GetLatestBlock(false)
+	if err != nil {
+		return nil, err
+	}
+	if block == nil {
+		return nil, fmt.Errorf("no latest block")
+	}
+	return json.Number(util.String2Big(block.Number).String()), nil
+}
+
+func (c *console) cmdPeers(args []string) (interface{}, error) {
+	n, err := c.client.GetPeerCount()
+	if err != nil {
+		return nil, err
+	}
+	return json.Number(strconv.FormatInt(n, 10)), nil
+}
+
+func (c *console) cmdWork(args []string) (interface{}, error) {
+	return c.client.GetWork()
+}
+
+func (c *console) cmdCall(args []string) (interface{}, error) {
+	if len(args) < 1 {
+		return nil, fmt.Errorf("usage: call <method> [params...]")
+	}
+	result, err := c.client.Call(args[0], parseRPCArgs(args[1:]))
+	if rpcErr, ok := err.(*rpc.RPCError); ok {
+		return nil, fmt.Errorf("%s (code %d)", rpcErr.Message, rpcErr.Code)
+	}
+	return result, err
+}
+
+func (c *console) cmdHistory(args []string) (interface{}, error) {
+	n := 20
+	if len(args) > 0 {
+		v, err := strconv.Atoi(args[0])
+		if err != nil || v <= 0 {
+			return nil, fmt.Errorf("usage: history [n]")
+		}
+		n = v
+	}
+	first := len(c.history) - n
+	if first < 0 {
+		first = 0
+	}
+	var lines []string
+	for i := first; i < len(c.history); i++ {
+		lines = append(lines, fmt.Sprintf("%5d  %s", i+1, c.history[i]))
+	}
+	return strings.Join(lines, "\n"), nil
+}
+
+func (c *console) cmdHelp(args []string) (interface{}, error) {
+	var lines []string
+	for _, cmd := range consoleCommands {
+		lines = append(lines, "  "+cmd.usage)
+	}
+	lines = append(lines,
+		"",
+		"Filters: cmd | .path[0].field | length | keys | dec",
+		"CLI commands: "+strings.Join(c.opt.Commands, " "),
+	)
+	return strings.Join(lines, "\n"), nil
+}
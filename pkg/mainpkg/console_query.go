@@ -0,0 +1,152 @@
+package mainpkg
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"xcoin/HayekTool/pkg/util"
+)
+
+// 控制台管道中的过滤器
+var consoleFilters = []string{"length", "keys", "dec"}
+
+// 转为 JSON 的通用结构(map/slice/string/json.Number/bool/nil)
+func toJSONValue(v interface{}) (interface{}, error) {
+	var data []byte
+	switch x := v.(type) {
+	case json.RawMessage:
+		data = x
+	default:
+		var err error
+		if data, err = json.Marshal(v); err != nil {
+			return nil, err
+		}
+	}
+	if len(data) == 0 {
+		return nil, nil
+	}
+
+	var out interface{}
+	dec := json.NewDecoder(strings.NewReader(string(data)))
+	dec.UseNumber()
+	if err := dec.Decode(&out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// 对结果执行一个过滤器: 路径(.a.b[0]), length, keys 或者 dec(十六进制转十进制)
+func queryJSON(v interface{}, expr string) (interface{}, error) {
+	expr = strings.TrimSpace(expr)
+	switch expr {
+	case "length":
+		switch x := v.(type) {
+		case []interface{}:
+			return len(x), nil
+		case map[string]interface{}:
+			return len(x), nil
+		case string:
+			return len(x), nil
+		case nil:
+			return 0, nil
+		}
+		return nil, fmt.Errorf("length: %T has no length", v)
+
+	case "keys":
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("keys: %T is not an object", v)
+		}
+		keys := make([]string, 0, len(m))
+		for k := range m {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		return keys, nil
+
+	case "dec":
+		s, ok := v.(string)
+		if !ok || !strings.HasPrefix(s, "0x") {
+			return nil, fmt.Errorf("dec: %v is not a hex number", v)
+		}
+		return util.String2Big(s).String(), nil
+	}
+
+	if !strings.HasPrefix(expr, ".") {
+		return nil, fmt.Errorf("invalid filter %q: expect .path, %s", expr, strings.Join(consoleFilters, ", "))
+	}
+	return queryPath(v, expr)
+}
+
+// 按路径取值, 比如 .transactions[0].hash, 下标可以是负数(从末尾算)
+func queryPath(v interface{}, path string) (interface{}, error) {
+	rest := path
+	for rest != "" {
+		switch rest[0] {
+		case '.':
+			rest = rest[1:]
+			n := strings.IndexAny(rest, ".[")
+			if n < 0 {
+				n = len(rest)
+			}
+			name := rest[:n]
+			rest = rest[n:]
+			if name == "" {
+				continue
+			}
+			m, ok := v.(map[string]interface{})
+			if !ok {
+				return nil, fmt.Errorf("%s: .%s on %s", path, name, jsonKind(v))
+			}
+			v = m[name]
+
+		case '[':
+			end := strings.IndexByte(rest, ']')
+			if end < 0 {
+				return nil, fmt.Errorf("%s: missing ]", path)
+			}
+			index, err := strconv.Atoi(strings.TrimSpace(rest[1:end]))
+			if err != nil {
+				return nil, fmt.Errorf("%s: invalid index %q", path, rest[1:end])
+			}
+			rest = rest[end+1:]
+			list, ok := v.([]interface{})
+			if !ok {
+				return nil, fmt.Errorf("%s: [%d] on %s", path, index, jsonKind(v))
+			}
+			if index < 0 {
+				index += len(list)
+			}
+			if index < 0 || index >= len(list) {
+				v = nil
+				continue
+			}
+			v = list[index]
+
+		default:
+			return nil, fmt.Errorf("%s: unexpected %q", path, rest)
+		}
+	}
+	return v, nil
+}
+
+func jsonKind(v interface{}) string {
+	switch v.(type) {
+	case nil:
+		return "null"
+	case map[string]interface{}:
+		return "object"
+	case []interface{}:
+		return "array"
+	case string:
+		return "string"
+	case json.Number:
+		return "number"
+	case bool:
+		return "boolean"
+	}
+	return fmt.Sprintf("%T", v)
+}
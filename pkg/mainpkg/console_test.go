@@ -0,0 +1,142 @@
+package mainpkg
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"xcoin/HayekTool/pkg/rpc"
+)
+
+func TestConsoleScript(t *testing.T) {
+	dir, err := ioutil.TempDir("", "console")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	payer, payerKey := newTestKey(t)
+	node, app := newTestNode(t, map[string]*big.Int{payer: etherToWei(10)})
+	app.cfg.AddressBookFile = filepath.Join(dir, "addressbook.json")
+	app.cfg.XUserAddressBook = map[string]string{"payer": payer}
+
+	client, _ := rpc.NewRPCClient("HayekTool", app.cfg.Host, time.Second)
+	w, _ := NewWallet(payer, payerKey)
+	hash, err := app.sendRawTxFrom(client, w, "0x00000000000000000000000000000000000000aa", etherToWei(1), DefaultGasLimit, big.NewInt(DefaultGasPrice))
+	if err != nil {
+		t.Fatal(err)
+	}
+	node.Mine()
+
+	var (
+		out bytes.Buffer
+		ran []string
+	)
+	c := &console{
+		app:    app,
+		client: client,
+		out:    &out,
+		opt: ConsoleOptions{
+			HistoryFile: filepath.Join(dir, "history"),
+			Commands:    []string{"get-balance"},
+			Run: func(a *App, args []string) error {
+				if a.client != client {
+					t.Error("command not run with the console client")
+				}
+				ran = append(ran, strings.Join(args, " "))
+				return nil
+			},
+		},
+	}
+	c.loadNames()
+
+	script := strings.Join([]string{
+		"height",
+		"block latest full | .transactions[0].hash",
+		"block 1 | .transactions | length",
+		"nonce payer",
+		"call hyk_getBalance 0x00000000000000000000000000000000000000aa latest | dec",
+		"receipt " + hash + " | .status",
+		"get-balance --address payer",
+		"get-balance | .x",
+		"!1",
+		"exit",
+		"height",
+	}, "\n")
+	if err := c.runScript(strings.NewReader(script)); err != nil {
+		t.Fatal(err)
+	}
+
+	want := strings.Join([]string{
+		"1",
+		hash,
+		"1",
+		"1",
+		"1000000000000000000",
+		"0x1",
+		"error: get-balance: pipe only works with console commands",
+		"height",
+		"1",
+	}, "\n") + "\n"
+	if got := out.String(); got != want {
+		t.Fatalf("output:\n%s\nexpect:\n%s", got, want)
+	}
+	if len(ran) != 1 || ran[0] != "get-balance --address payer" {
+		t.Fatalf("cli commands = %q", ran)
+	}
+
+	// 历史文件中不重复记录连续相同的命令
+	data, _ := ioutil.ReadFile(c.opt.HistoryFile)
+	if n := strings.Count(string(data), "\n"); n != 10 {
+		t.Fatalf("history lines = %d", n)
+	}
+
+	for _, tt := range []struct {
+		line, want string
+	}{
+		{"blo", "block "},
+		{"ge", "get-balance "},
+		{"balance pa", "balance payer "},
+		{"block latest | le", "block latest | length "},
+		{"h", "h"}, // height/help/history
+	} {
+		if got, _, _ := c.complete(tt.line, len(tt.line), '\t'); got != tt.want {
+			t.Errorf("complete(%q) = %q, expect %q", tt.line, got, tt.want)
+		}
+	}
+}
+
+func TestQueryJSON(t *testing.T) {
+	v, _ := toJSONValue(map[string]interface{}{
+		"a": []interface{}{map[string]interface{}{"b": "0x10"}, 2},
+	})
+	for _, tt := range []struct {
+		expr, want string
+		err        bool
+	}{
+		{".a[0].b", "0x10", false},
+		{".a[-1]", "2", false},
+		{".a[5]", "<nil>", false},
+		{".a.b", "", true},
+		{"length", "1", false},
+		{"keys", "[a]", false},
+		{"a", "", true},
+	} {
+		got, err := queryJSON(v, tt.expr)
+		if tt.err != (err != nil) {
+			t.Fatalf("%s: err = %v", tt.expr, err)
+		}
+		if !tt.err && fmt.Sprint(got) != tt.want {
+			t.Fatalf("%s = %v, expect %s", tt.expr, got, tt.want)
+		}
+	}
+	if got, _ := queryJSON("0x10", "dec"); got != "16" {
+		t.Fatalf("dec = %v", got)
+	}
+}
@@ -0,0 +1,438 @@
+package mainpkg
+
+import (
+	"log"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"xcoin/HayekTool/pkg/metrics"
+	"xcoin/HayekTool/pkg/rpc"
+	"xcoin/HayekTool/pkg/util"
+)
+
+const DefaultExporterListen = "127.0.0.1:9101"
+
+type ExporterOptions struct {
+	Listen    string        // /metrics 的监听地址
+	Nodes     []string      // 节点地址, 默认为配置文件中的 Host
+	Addresses []string      // 监控余额的地址(或者地址簿中的名字)
+	Interval  time.Duration // 采集周期, 默认 15 秒
+}
+
+// 每个节点的状态
+type nodeMetrics struct {
+	up         bool
+	height     int64
+	peers      int64
+	blockTime  int64 // 最新区块的时间戳
+	pendingTxs int
+	scrapedAt  time.Time
+}
+
+// 每个节点每个方法的调用统计
+type rpcMetrics struct {
+	requests int64
+	errors   int64
+	seconds  float64
+}
+
+type rpcMetricsKey struct {
+	node, method string
+}
+
+// 每个分组的支付结果
+type payoutsMetrics struct {
+	runs        map[string]int64 // success/error
+	transfers   map[string]int64 // sent/failed/carried/planned
+	sentWei     *big.Int
+	lastRun     time.Time
+	lastSuccess time.Time
+}
+
+// Prometheus 指标采集, 定期通过 rpc 查询节点和钱包, 支付服务中同时记录支付结果
+type exporter struct {
+	app       *App
+	opt       ExporterOptions
+	clients   []*rpc.RPCClient
+	addresses map[string]string // 地址 => 名字
+	now       func() time.Time
+
+	mu        sync.Mutex
+	nodes     map[string]*nodeMetrics
+	rpc       map[rpcMetricsKey]*rpcMetrics
+	balances  map[string]*big.Int  // 最近一次查询成功的余额, 查询失败时删除
+	balanceAt map[string]time.Time // 最近一次查询余额成功的时间
+	payouts   map[string]*payoutsMetrics
+}
+
+func (p *App) newExporter(opt ExporterOptions) (*exporter, error) {
+	if opt.Listen == "" {
+		opt.Listen = DefaultExporterListen
+	}
+	if len(opt.Nodes) == 0 {
+		opt.Nodes = []string{p.cfg.Host}
+	}
+	if opt.Interval <= 0 {
+		opt.Interval = time.Second * 15
+	}
+
+	e := &exporter{
+		app:       p,
+		opt:       opt,
+		addresses: make(map[string]string),
+		now:       time.Now,
+		nodes:     make(map[string]*nodeMetrics),
+		rpc:       make(map[rpcMetricsKey]*rpcMetrics),
+		balances:  make(map[string]*big.Int),
+		balanceAt: make(map[string]time.Time),
+		payouts:   make(map[string]*payoutsMetrics),
+	}
+	for _, url := range opt.Nodes {
+		c, err := rpc.NewRPCClient("HayekTool", url, time.Second*5)
+		if err != nil {
+			return nil, err
+		}
+		e.clients = append(e.clients, c)
+	}
+	resolver, err := p.addressResolver()
+	if err != nil {
+		return nil, err
+	}
+	for _, id := range opt.Addresses {
+		address, err := resolver.resolve(id)
+		if err != nil {
+			return nil, err
+		}
+		name := ""
+		if !strings.EqualFold(id, address) {
+			name = id
+		}
+		e.addresses[address] = name
+	}
+	return e, nil
+}
+
+// 运行 Prometheus exporter, 收到 SIGINT/SIGTERM 时退出
+func (p *App) CmdExporter(opt ExporterOptions) error {
+	e, err := p.newExporter(opt)
+	if err != nil {
+		return err
+	}
+	stop, err := e.start()
+	if err != nil {
+		return err
+	}
+	defer stop()
+
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(sigCh)
+
+	sig := <-sigCh
+	log.Printf("exporter: %v, exit", sig)
+	return nil
+}
+
+// 启动 HTTP 服务和定期采集, 返回停止函数
+func (e *exporter) start() (stop func(), err error) {
+	ln, err := net.Listen("tcp", e.opt.Listen)
+	if err != nil {
+		return nil, err
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", e)
+	server := &http.Server{Handler: mux}
+	go server.Serve(ln)
+	log.Printf("exporter: listen on http://%s/metrics, nodes %s", ln.Addr(), strings.Join(e.opt.Nodes, ", "))
+
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(e.opt.Interval)
+		defer ticker.Stop()
+		for {
+			e.scrape()
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+
+	return func() {
+		close(done)
+		server.Close()
+	}, nil
+}
+
+// 调用节点并记录耗时和错误
+func (e *exporter) call(node, method string, f func() error) error {
+	start := time.Now()
+	err := f()
+	elapsed := time.Since(start).Seconds()
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	m := e.rpc[rpcMetricsKey{node, method}]
+	if m == nil {
+		m = new(rpcMetrics)
+		e.rpc[rpcMetricsKey{node, method}] = m
+	}
+	m.requests++
+	m.seconds += elapsed
+	if err != nil {
+		m.errors++
+	}
+	return err
+}
+
+// 采集一次所有节点, 余额从第一个可用的节点查询
+//
+// 查询不到的余额不再导出, 告警可以根据最近一次成功的时间发现.
+func (e *exporter) scrape() {
+	var balanceClient *rpc.RPCClient
+	for i, c := range e.clients {
+		node := e.opt.Nodes[i]
+		m := e.scrapeNode(node, c)
+
+		e.mu.Lock()
+		e.nodes[node] = m
+		e.mu.Unlock()
+
+		if m.up && balanceClient == nil {
+			balanceClient = c
+		}
+	}
+	if balanceClient == nil {
+		e.mu.Lock()
+		for address := range e.balances {
+			delete(e.balances, address)
+		}
+		e.mu.Unlock()
+		return
+	}
+
+	node := balanceClient.Url
+	for address := range e.addresses {
+		var balance *big.Int
+		err := e.call(node, rpc.CoinId+"_getBalance", func() (err error) {
+			balance, err = balanceClient.GetBalance(address)
+			return err
+		})
+		e.mu.Lock()
+		if err != nil {
+			log.Printf("exporter: %s: balance of %s: %v", node, address, err)
+			delete(e.balances, address)
+		} else {
+			e.balances[address] = balance
+			e.balanceAt[address] = e.now()
+		}
+		e.mu.Unlock()
+	}
+}
+
+func (e *exporter) scrapeNode(node string, c *rpc.RPCClient) *nodeMetrics {
+	m := &nodeMetrics{scrapedAt: e.now()}
+
+	var latest, pending *rpc.GetBlockReply
+	err := e.call(node, rpc.CoinId+"_getBlockByNumber", func() (err error) {
+		latest, err = c.GetLatestBlock(false)
+		return err
+	})
+	if err != nil || latest == nil {
+		if err != nil {
+			log.Printf("exporter: %s: %v", node, err)
+		}
+		return m
+	}
+	m.up = true
+	m.height = util.String2Big(latest.Number).Int64()
+	m.blockTime = util.String2Big(latest.Timestamp).Int64()
+
+	if err := e.call(node, "net_peerCount", func() (err error) {
+		m.peers, err = c.GetPeerCount()
+		return err
+	}); err != nil {
+		log.Printf("exporter: %s: %v", node, err)
+	}
+
+	if err := e.call(node, rpc.CoinId+"_getBlockByNumber", func() (err error) {
+		pending, err = c.GetPendingBlock(false)
+		return err
+	}); err == nil && pending != nil {
+		m.pendingTxs = len(pending.Transactions)
+	}
+	return m
+}
+
+// 记录一次支付任务的结果
+func (e *exporter) recordPayoutsRun(run *PayoutsRun) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	m := e.payouts[run.Group]
+	if m == nil {
+		m = &payoutsMetrics{runs: make(map[string]int64), transfers: make(map[string]int64), sentWei: new(big.Int)}
+		e.payouts[run.Group] = m
+	}
+
+	m.lastRun = e.now()
+	if run.Err != "" {
+		m.runs["error"]++
+	} else {
+		m.runs["success"]++
+		m.lastSuccess = m.lastRun
+	}
+
+	for _, t := range run.Transfers {
+		switch {
+		case t.TxHash != "":
+			m.transfers["sent"]++
+			if t.Value != nil {
+				m.sentWei.Add(m.sentWei, t.Value)
+			}
+		case t.Err != "":
+			m.transfers["failed"]++
+		case run.Plan != "" && t.Value != nil && t.Value.Sign() > 0:
+			m.transfers["planned"]++
+		default:
+			m.transfers["carried"]++
+		}
+	}
+}
+
+// 支付任务结束时调用, 没有启用指标时什么都不做
+func (p *App) recordPayoutsRun(run *PayoutsRun) {
+	if p.exporter != nil {
+		p.exporter.recordPayoutsRun(run)
+	}
+}
+
+func (e *exporter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", metrics.ContentType)
+	if err := metrics.Write(w, e.collect()); err != nil {
+		log.Printf("exporter: write metrics: %v", err)
+	}
+}
+
+func weiToFloat(wei *big.Int) float64 {
+	v, _ := new(big.Float).Quo(new(big.Float).SetInt(wei), new(big.Float).SetInt(util.Ether)).Float64()
+	return v
+}
+
+func boolFloat(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+func (e *exporter) collect() []*metrics.Family {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	var (
+		up         = metrics.NewFamily("hayek_node_up", metrics.Gauge, "Whether the last scrape of the node succeeded.")
+		height     = metrics.NewFamily("hayek_block_height", metrics.Gauge, "Latest block height.")
+		peers      = metrics.NewFamily("hayek_peer_count", metrics.Gauge, "Number of connected peers.")
+		blockAge   = metrics.NewFamily("hayek_last_block_age_seconds", metrics.Gauge, "Seconds since the timestamp of the latest block.")
+		pending    = metrics.NewFamily("hayek_pending_block_transactions", metrics.Gauge, "Number of transactions in the pending block.")
+		scrapeTime = metrics.NewFamily("hayek_last_scrape_timestamp_seconds", metrics.Gauge, "Unix time of the last scrape.")
+		requests   = metrics.NewFamily("hayek_rpc_requests_total", metrics.Counter, "RPC requests by node and method.")
+		errors     = metrics.NewFamily("hayek_rpc_errors_total", metrics.Counter, "Failed RPC requests by node and method.")
+		latency    = metrics.NewFamily("hayek_rpc_duration_seconds", metrics.Summary, "RPC request latency by node and method.")
+		balance    = metrics.NewFamily("hayek_wallet_balance_hyk", metrics.Gauge, "Wallet balance in HYK.")
+		balanceAt  = metrics.NewFamily("hayek_wallet_balance_last_success_timestamp_seconds", metrics.Gauge, "Unix time of the last successful balance query.")
+	)
+
+	now := e.now()
+	for _, node := range e.opt.Nodes {
+		m := e.nodes[node]
+		if m == nil {
+			continue
+		}
+		up.Add(boolFloat(m.up), "node", node)
+		scrapeTime.Add(float64(m.scrapedAt.Unix()), "node", node)
+		if !m.up {
+			continue
+		}
+		height.Add(float64(m.height), "node", node)
+		peers.Add(float64(m.peers), "node", node)
+		blockAge.Add(now.Sub(time.Unix(m.blockTime, 0)).Seconds(), "node", node)
+		pending.Add(float64(m.pendingTxs), "node", node)
+	}
+
+	var keys []rpcMetricsKey
+	for k := range e.rpc {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].node != keys[j].node {
+			return keys[i].node < keys[j].node
+		}
+		return keys[i].method < keys[j].method
+	})
+	for _, k := range keys {
+		m := e.rpc[k]
+		requests.Add(float64(m.requests), "node", k.node, "method", k.method)
+		errors.Add(float64(m.errors), "node", k.node, "method", k.method)
+		latency.AddSuffix("_sum", m.seconds, "node", k.node, "method", k.method)
+		latency.AddSuffix("_count", float64(m.requests), "node", k.node, "method", k.method)
+	}
+
+	var addresses []string
+	for address := range e.balanceAt {
+		addresses = append(addresses, address)
+	}
+	sort.Strings(addresses)
+	for _, address := range addresses {
+		if v, ok := e.balances[address]; ok {
+			balance.Add(weiToFloat(v), "address", address, "name", e.addresses[address])
+		}
+		balanceAt.Add(float64(e.balanceAt[address].Unix()), "address", address, "name", e.addresses[address])
+	}
+
+	families := []*metrics.Family{up, height, peers, blockAge, pending, scrapeTime, requests, errors, latency, balance, balanceAt}
+	return append(families, e.collectPayouts()...)
+}
+
+// 需要持有 e.mu
+func (e *exporter) collectPayouts() []*metrics.Family {
+	var (
+		runs        = metrics.NewFamily("hayek_payouts_runs_total", metrics.Counter, "Payout runs by group and result.")
+		transfers   = metrics.NewFamily("hayek_payouts_transfers_total", metrics.Counter, "Payout transfers by group and status.")
+		sent        = metrics.NewFamily("hayek_payouts_sent_hyk_total", metrics.Counter, "HYK sent by payouts.")
+		lastRun     = metrics.NewFamily("hayek_payouts_last_run_timestamp_seconds", metrics.Gauge, "Unix time of the last payout run.")
+		lastSuccess = metrics.NewFamily("hayek_payouts_last_success_timestamp_seconds", metrics.Gauge, "Unix time of the last successful payout run.")
+	)
+
+	var groups []string
+	for g := range e.payouts {
+		groups = append(groups, g)
+	}
+	sort.Strings(groups)
+	for _, g := range groups {
+		m := e.payouts[g]
+		for _, result := range []string{"success", "error"} {
+			runs.Add(float64(m.runs[result]), "group", g, "result", result)
+		}
+		for _, status := range []string{"sent", "failed", "carried", "planned"} {
+			transfers.Add(float64(m.transfers[status]), "group", g, "status", status)
+		}
+		sent.Add(weiToFloat(m.sentWei), "group", g)
+		lastRun.Add(float64(m.lastRun.Unix()), "group", g)
+		if !m.lastSuccess.IsZero() {
+			lastSuccess.Add(float64(m.lastSuccess.Unix()), "group", g)
+		}
+	}
+	return []*metrics.Family{runs, transfers, sent, lastRun, lastSuccess}
+}
@@ -0,0 +1,78 @@
+package mainpkg
+
+import (
+	"math/big"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestExporter(t *testing.T) {
+	const bob = "0x5205f45c6399c41e11e533926ca69a0aedfdbb8d"
+	node, app := newTestNode(t, map[string]*big.Int{bob: etherToWei(2.5)})
+	app.cfg.XUserAddressBook = map[string]string{"bob": bob}
+	node.Mine()
+	node.Mine()
+
+	const down = "http://127.0.0.1:1"
+	e, err := app.newExporter(ExporterOptions{
+		Nodes:     []string{app.cfg.Host, down},
+		Addresses: []string{"bob"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	e.scrape()
+
+	app.exporter = e
+	app.recordPayoutsRun(&PayoutsRun{
+		Group: "pool",
+		Transfers: []PayoutTransfer{
+			{Value: etherToWei(1), TxHash: "0x01"},
+			{Value: etherToWei(2), Err: "nonce too low"},
+		},
+	})
+	app.recordPayoutsRun(&PayoutsRun{Group: "pool", Err: "rpc down"})
+
+	w := httptest.NewRecorder()
+	e.ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	out := w.Body.String()
+
+	host := app.cfg.Host
+	for _, want := range []string{
+		`hayek_node_up{node="` + host + `"} 1`,
+		`hayek_node_up{node="` + down + `"} 0`,
+		`hayek_block_height{node="` + host + `"} 2`,
+		`hayek_pending_block_transactions{node="` + host + `"} 0`,
+		`hayek_last_block_age_seconds{node="` + host + `"}`,
+		`hayek_rpc_requests_total{node="` + host + `",method="hyk_getBalance"} 1`,
+		`hayek_rpc_errors_total{node="` + down + `",method="hyk_getBlockByNumber"} 1`,
+		`hayek_rpc_duration_seconds_count{node="` + host + `",method="hyk_getBlockByNumber"} 2`,
+		`hayek_wallet_balance_hyk{address="` + bob + `",name="bob"} 2.5`,
+		`hayek_payouts_runs_total{group="pool",result="success"} 1`,
+		`hayek_payouts_runs_total{group="pool",result="error"} 1`,
+		`hayek_payouts_transfers_total{group="pool",status="sent"} 1`,
+		`hayek_payouts_transfers_total{group="pool",status="failed"} 1`,
+		`hayek_payouts_sent_hyk_total{group="pool"} 1`,
+	} {
+		if !strings.Contains(out, want+"\n") && !strings.Contains(out, want+" ") {
+			t.Errorf("missing %q in:\n%s", want, out)
+		}
+	}
+	if strings.Contains(out, `hayek_block_height{node="`+down+`"}`) {
+		t.Errorf("height reported for down node:\n%s", out)
+	}
+
+	// 没有可用的节点时不再导出旧的余额, 保留最近一次成功的时间
+	e.clients, e.opt.Nodes = e.clients[1:], e.opt.Nodes[1:]
+	e.scrape()
+	w = httptest.NewRecorder()
+	e.ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	out = w.Body.String()
+	if strings.Contains(out, `hayek_wallet_balance_hyk{`) {
+		t.Errorf("stale balance reported:\n%s", out)
+	}
+	if want := `hayek_wallet_balance_last_success_timestamp_seconds{address="` + bob + `",name="bob"} `; !strings.Contains(out, want) {
+		t.Errorf("missing %q in:\n%s", want, out)
+	}
+}
@@ -0,0 +1,237 @@
+package mainpkg
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"xcoin/HayekTool/pkg/index"
+	"xcoin/HayekTool/pkg/rpc"
+	"xcoin/HayekTool/pkg/util"
+)
+
+// 索引选项
+type IndexOptions struct {
+	FromHeight    int64         // 数据库为空时开始的高度
+	Confirmations int64         // 只索引有足够确认数的区块
+	Follow        bool          // 同步到最新后继续跟踪新区块
+	Interval      time.Duration // 跟踪新区块的间隔
+}
+
+func (p *App) indexDir() string {
+	if p.cfg.IndexDir != "" {
+		return p.cfg.IndexDir
+	}
+	return index.DefaultDir
+}
+
+// 跟踪模式下每轮最多同步的区块数, 追赶时每轮之间短暂地关闭数据库
+const (
+	indexFollowBatch = 1000
+	indexFollowYield = time.Millisecond * 500
+)
+
+// history 命令等待同步进程关闭数据库的时间
+const historyLockWait = time.Second * 30
+
+// 同步区块到本地索引, 可以中断后重新运行
+//
+// 跟踪模式下每轮同步结束后关闭数据库, 空闲时 history 命令可以查询.
+// 追赶时每同步 indexFollowBatch 个区块关闭一次数据库, history 命令不需要等到追赶结束.
+func (p *App) CmdIndex(opt *IndexOptions) error {
+	if opt.Interval <= 0 {
+		opt.Interval = time.Second * 5
+	}
+
+	c, err := p.rpcClient(time.Second * 3)
+	if err != nil {
+		return err
+	}
+
+	stop := make(chan struct{})
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(sigCh)
+	go func() {
+		if sig, ok := <-sigCh; ok {
+			log.Printf("index: %v, stopping", sig)
+			close(stop)
+		}
+	}()
+
+	for {
+		height, behind, err := p.syncIndex(c, opt, stop)
+		if err != nil {
+			if !opt.Follow {
+				return err
+			}
+			log.Printf("index: %v", err)
+		}
+
+		select {
+		case <-stop:
+			log.Printf("index: stopped at height %d", height)
+			return nil
+		default:
+		}
+		if !opt.Follow {
+			log.Printf("index: synced to height %d", height)
+			return nil
+		}
+
+		// 还在追赶时只短暂地让出数据库
+		wait := opt.Interval
+		if err == nil && behind {
+			wait = indexFollowYield
+		}
+
+		select {
+		case <-stop:
+			log.Printf("index: stopped at height %d", height)
+			return nil
+		case <-time.After(wait):
+		}
+	}
+}
+
+// 同步一轮, behind 为 true 表示因为 MaxBlocks 提前结束, 还没有追上
+func (p *App) syncIndex(c *rpc.RPCClient, opt *IndexOptions, stop <-chan struct{}) (height int64, behind bool, err error) {
+	db, err := index.Open(p.indexDir())
+	if err != nil {
+		return 0, false, err
+	}
+	defer db.Close()
+
+	var (
+		startTime   = time.Now()
+		startHeight = int64(-1)
+		lastReport  time.Time
+	)
+	s := &index.Syncer{
+		DB:            db,
+		Source:        c,
+		StartHeight:   opt.FromHeight,
+		Confirmations: opt.Confirmations,
+
+		Progress: func(height, target int64) {
+			behind = height < target
+			if startHeight < 0 {
+				startHeight = height
+			}
+			// 追赶时每 5 秒报告一次进度, 跟踪时每个区块报告一次
+			if height < target && time.Since(lastReport) < time.Second*5 {
+				return
+			}
+			lastReport = time.Now()
+
+			percent := 100.0
+			if target > 0 {
+				percent = 100 * float64(height) / float64(target)
+			}
+			rate := float64(height-startHeight+1) / time.Since(startTime).Seconds()
+			log.Printf("index: height %d/%d (%.1f%%), %.1f blocks/s", height, target, percent, rate)
+		},
+		Reorg: func(b *index.Block, depth int) {
+			log.Printf("index: reorg, drop block %d %s (depth %d)", b.Number, b.Hash, depth)
+		},
+	}
+	if opt.Follow {
+		s.MaxBlocks = indexFollowBatch
+	}
+	height, err = s.Sync(stop)
+	return height, behind, err
+}
+
+// 只读打开索引, 同步进程正在写入时等待它关闭数据库
+func (p *App) openIndexReadOnly() (*index.DB, error) {
+	deadline := time.Now().Add(historyLockWait)
+	for {
+		db, err := index.OpenReadOnly(p.indexDir())
+		if err == nil {
+			return db, nil
+		}
+		if _, statErr := os.Stat(p.indexDir()); statErr != nil || time.Now().After(deadline) {
+			return nil, err
+		}
+		time.Sleep(time.Millisecond * 100)
+	}
+}
+
+// 从本地索引查询地址的交易历史, 按时间倒序分页
+func (p *App) CmdHistory(address string, page, pageSize int, format string) error {
+	address, err := p.getAddress(address)
+	if err != nil {
+		return err
+	}
+	if page < 1 {
+		page = 1
+	}
+	if pageSize <= 0 {
+		pageSize = 20
+	}
+
+	db, err := p.openIndexReadOnly()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	total, err := db.Count(address)
+	if err != nil {
+		return err
+	}
+	txs, err := db.History(address, (page-1)*pageSize, pageSize)
+	if err != nil {
+		return err
+	}
+
+	if format == "json" {
+		s, _ := json.MarshalIndent(map[string]interface{}{
+			"Address":  address,
+			"Total":    total,
+			"Page":     page,
+			"PageSize": pageSize,
+			"Txs":      txs,
+		}, "", "\t")
+		fmt.Println(string(s))
+		return nil
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "HEIGHT\tTIME\tDIR\tHASH\tCOUNTERPARTY\tVALUE(HYK)\tSTATUS")
+	for _, tx := range txs {
+		dir, counterparty := "out", tx.To
+		switch {
+		case strings.EqualFold(tx.From, tx.To):
+			dir = "self"
+		case !strings.EqualFold(tx.From, address):
+			dir, counterparty = "in", tx.From
+		case tx.To == "":
+			counterparty = "create " + tx.ContractAddress
+		}
+		status := "ok"
+		if tx.Status == "0x0" {
+			status = "failed"
+		}
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\t%s\t%s\n",
+			tx.BlockNumber, time.Unix(tx.Timestamp, 0).Format("2006-01-02 15:04:05"),
+			dir, tx.Hash, counterparty, formatWei(util.String2Big(tx.Value)), status,
+		)
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	pages := (total + pageSize - 1) / pageSize
+	if pages == 0 {
+		pages = 1
+	}
+	fmt.Printf("page %d/%d, %d tx(s)\n", page, pages, total)
+	return nil
+}
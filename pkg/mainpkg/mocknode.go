@@ -0,0 +1,111 @@
+package mainpkg
+
+import (
+	"fmt"
+	"log"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/ethereum/go-ethereum/core/types"
+
+	"xcoin/HayekTool/pkg/mocknode"
+	"xcoin/HayekTool/pkg/rpc"
+)
+
+const DefaultMockNodeListen = "127.0.0.1:8585"
+
+type MockNodeOptions struct {
+	Listen     string   // 监听地址
+	Balances   []string // 初始余额: 地址(或者地址簿中的名字)=金额(HYK)
+	Coinbase   string   // 出块地址
+	Reward     float64  // 区块奖励(HYK)
+	Difficulty int64    // 区块难度, 用于测试 getWork/submitWork
+	BlockTime  time.Duration
+}
+
+// 运行模拟节点, 收到 SIGINT/SIGTERM 时退出
+//
+// BlockTime 为 0 时每收到一笔交易立即出块, 否则按间隔出块(没有交易时不出块).
+func (p *App) CmdMockNode(opt MockNodeOptions) error {
+	if opt.Listen == "" {
+		opt.Listen = DefaultMockNodeListen
+	}
+
+	resolver, err := p.addressResolver()
+	if err != nil {
+		return err
+	}
+	balances := make(map[string]*big.Int)
+	for _, s := range opt.Balances {
+		i := strings.LastIndex(s, "=")
+		if i < 0 {
+			return fmt.Errorf("invalid balance %q: expect address=amount", s)
+		}
+		address, err := resolver.resolve(strings.TrimSpace(s[:i]))
+		if err != nil {
+			return fmt.Errorf("invalid balance %q: %v", s, err)
+		}
+		amount, err := strconv.ParseFloat(strings.TrimSpace(s[i+1:]), 64)
+		if err != nil || amount < 0 {
+			return fmt.Errorf("invalid balance %q: bad amount", s)
+		}
+		balances[address] = etherToWei(amount)
+	}
+
+	cfg := mocknode.Config{
+		Coinbase:    opt.Coinbase,
+		BlockReward: etherToWei(opt.Reward),
+		AutoMine:    opt.BlockTime <= 0,
+		Balances:    balances,
+		OnBlock: func(b *types.Block) {
+			log.Printf("mock-node: block %d %s, %d txs", b.NumberU64(), b.Hash().Hex(), len(b.Transactions()))
+		},
+	}
+	if opt.Difficulty > 0 {
+		cfg.Difficulty = big.NewInt(opt.Difficulty)
+	}
+	node := mocknode.New(cfg)
+
+	ln, err := net.Listen("tcp", opt.Listen)
+	if err != nil {
+		return err
+	}
+	server := &http.Server{Handler: node}
+	go server.Serve(ln)
+	defer server.Close()
+
+	log.Printf("mock-node: listen on http://%s, chain id %s", ln.Addr(), rpc.ChainID)
+	for address, wei := range balances {
+		log.Printf("mock-node: %s balance %s HYK", address, formatWei(wei))
+	}
+
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(sigCh)
+
+	var tick <-chan time.Time
+	if opt.BlockTime > 0 {
+		ticker := time.NewTicker(opt.BlockTime)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	for {
+		select {
+		case sig := <-sigCh:
+			log.Printf("mock-node: %v, exit at height %d", sig, node.Height())
+			return nil
+		case <-tick:
+			if node.Pending() > 0 {
+				node.Mine()
+			}
+		}
+	}
+}
@@ -0,0 +1,24 @@
+package mainpkg
+
+import (
+	"math/big"
+	"net/http/httptest"
+	"testing"
+
+	"xcoin/HayekTool/pkg/config"
+	"xcoin/HayekTool/pkg/mocknode"
+)
+
+// 启动模拟节点, 返回节点和连接该节点的 App; 测试结束时关闭
+func newTestNode(t *testing.T, balances map[string]*big.Int) (*mocknode.Node, *App) {
+	node := mocknode.New(mocknode.Config{
+		Coinbase:   "0x00000000000000000000000000000000000000cc",
+		Difficulty: big.NewInt(1),
+		Balances:   balances,
+	})
+	server := httptest.NewServer(node)
+	t.Cleanup(server.Close)
+
+	cfg := &config.Config{Host: server.URL}
+	return node, NewApp(cfg)
+}
@@ -4,126 +4,214 @@ import (
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
-	"log"
 	"math/big"
+	"reflect"
 	"strings"
 	"time"
 
-	"xcoin/HayekTool/pkg/clockwork"
-	"xcoin/HayekTool/pkg/rpc"
+	"xcoin/HayekTool/pkg/lock"
+	"xcoin/HayekTool/pkg/notify"
 	"xcoin/HayekTool/pkg/util"
 )
 
 // 用于定时给多个客户按比例分红文件
 type PayoutsFile struct {
+	Name   string `json:",omitempty"` // 分组名字
+	From   string `json:",omitempty"` // 付款地址(或者地址簿中的名字), 默认为配置文件中的 UserAddress
+	KeyRef string `json:",omitempty"` // 付款私钥: env:变量名 或者 file:文件路径, 默认为配置文件中的 UserKey
+
 	Threshold     int64        // CoinBase 最小余额
 	FeePercentage float64      // 消费(保留的比例)
 	EveryDatAt    []string     // 每天定时触发的时间, 时间格式 hour:min, 比如 18:30 或 10:30 等
 	GasLimit      int64        // Gas限制
 	GasPrice      int64        // Gas价格
 	Payouts       []PayoutElem // 支付列表
+
+	LockFile  string // 锁文件, 多机部署时放在共享文件系统上, 只有持有锁的机器执行支付
+	LockLease int64  // 锁的租约(秒), 默认60秒
+
+	HistoryFile string // 支付历史记录文件, 默认 payouts-history.jsonl
+
+	MinPayout   float64 // 最小支付金额(HYK), 不足的部分累计到下次支付
+	BalanceFile string  // 累计未付金额的文件, 默认 payouts-balances.json
+
+	Pool *PoolConfig `json:",omitempty"` // 矿池模式(PROP/PPLNS), 设置后不使用 Payouts 列表
+
+	Groups []*PayoutsFile `json:",omitempty"` // 多个付款钱包时的独立分组, 每组有自己的钱包/时间表/收款列表
+
+	Notify *notify.Config `json:",omitempty"` // 支付结果通知(webhook/邮件), 分组没有配置时使用顶层的
+
+	ApprovalLimit float64  `json:",omitempty"` // 一次支付总额(HYK)超过该值时需要审批, 0 表示不需要
+	Approvers     []string `json:",omitempty"` // 审批人地址, 不能是付款地址
+	PlanDir       string   `json:",omitempty"` // 待审批计划的目录, 默认 payouts-plans
+	PlanTTL       int64    `json:",omitempty"` // 计划的有效期(秒), 默认86400秒
 }
 
 // 每个支付的地址和比例
 type PayoutElem struct {
 	Name            string  // 客户名字
-	Address         string  // 客户地址
+	Address         string  // 客户地址(或者地址簿中的名字)
 	ValuePercentage float64 // 支付比例(0.01～1.0)
+	MinPayout       float64 // 最小支付金额(HYK), 为0时使用 PayoutsFile.MinPayout
+
+	Strategy    string       `json:",omitempty"` // 分配策略: percentage(默认)/fixed/weighted/tiered
+	FixedAmount float64      `json:",omitempty"` // fixed: 每次支付的金额(HYK)
+	Weight      float64      `json:",omitempty"` // weighted: 权重
+	Tiers       []PayoutTier `json:",omitempty"` // tiered: 阶梯比例
 }
 
-func (p *App) CmdRunPayoutsService(payoutsFile string) {
+// 运行定时支付服务
+//
+// 服务运行期间修改支付文件或配置文件(或者发送 SIGHUP)会自动重新加载.
+func (p *App) CmdRunPayoutsService(payoutsFile, configFile string) error {
 	payoutsInfo, err := p.loadPayoutsFile(payoutsFile)
 	if err != nil {
 		p.genPayoutsFileTemplate(strings.TrimSuffix(payoutsFile, ".json") + ".example.json")
-		log.Fatal(err)
+		return err
 	}
 
 	if err := p.checkPayoutsFile(payoutsInfo); err != nil {
-		log.Fatal(err)
+		return err
 	}
 
-	for _, time := range payoutsInfo.EveryDatAt {
-		clockwork.Every(1).Day().At(time).Do(func() {
-			if err := p.doPayoutsTask(payoutsInfo); err != nil {
-				log.Println(err)
-			}
-		})
+	if p.metrics != nil {
+		// 默认监控各分组的付款钱包
+		opt := *p.metrics
+		if len(opt.Addresses) == 0 {
+			opt.Addresses = p.payoutsFromAddresses(payoutsInfo)
+		}
+		e, err := p.newExporter(opt)
+		if err != nil {
+			return err
+		}
+		stop, err := e.start()
+		if err != nil {
+			return err
+		}
+		defer stop()
+		p.exporter = e
 	}
 
-	// 启动定时任务
-	<-clockwork.Start()
+	s := newPayoutsService(p, payoutsInfo, payoutsFile, configFile)
+	s.elector = p.newPayoutsElector(payoutsInfo)
+	s.run()
+	return nil
+}
+
+// 创建选主对象, 没有配置锁时返回 nil(单机模式)
+func (p *App) newPayoutsElector(info *PayoutsFile) *lock.Elector {
+	if p.payoutsLocker != nil {
+		return lock.NewElector(p.payoutsLocker)
+	}
+	if info.LockFile != "" {
+		lease := time.Duration(info.LockLease) * time.Second
+		return lock.NewElector(lock.NewFileLock(info.LockFile, lease))
+	}
+	return nil
 }
 
 // 执行一次支付任务
-func (p *App) doPayoutsTask(info *PayoutsFile) error {
-	c, err := rpc.NewRPCClient("HayekTool", p.cfg.Host, time.Second*3)
+func (p *App) doPayoutsTask(info *PayoutsFile) (err error) {
+	run := &PayoutsRun{
+		Id:      time.Now().Format("20060102-150405"),
+		Group:   info.groupName(),
+		StartAt: time.Now(),
+		From:    info.From,
+	}
+	defer func() {
+		if err != nil {
+			run.Err = err.Error()
+		}
+		if errSave := p.savePayoutsRun(info, run); errSave != nil {
+			info.logf("save history failed: %v", errSave)
+		}
+		p.notifyPayoutsRun(info, run)
+		p.recordPayoutsRun(run)
+	}()
+
+	w, err := p.payoutsWallet(info)
 	if err != nil {
 		return err
 	}
+	run.From = w.Address
 
-	amountInWei, err := c.GetBalance(p.cfg.UserAddress)
+	c, err := p.rpcClient(time.Second * 3)
 	if err != nil {
-		return nil
+		return err
 	}
 
+	amountInWei, err := c.GetBalance(w.Address)
+	if err != nil {
+		return err
+	}
+	run.Balance = amountInWei
+
 	if info.Threshold > 0 {
 		vThresholdWei := new(big.Int).Mul(big.NewInt(info.Threshold), util.Ether)
 		if amountInWei.Cmp(vThresholdWei) < 0 {
-			return fmt.Errorf("balance limit: threshold = %v", info.Threshold)
+			return fmt.Errorf("payouts[%s]: balance limit: threshold = %v", info.groupName(), info.Threshold)
 		}
 	}
 
-	var (
-		gasLimit = info.GasLimit
-		gasPrice = info.GasPrice
-	)
-	if gasLimit == 0 {
-		gasLimit = DefaultGasLimit
-	}
-	if gasPrice == 0 {
-		gasPrice = DefaultGasPrice
+	if info.Pool != nil {
+		return p.doPoolPayoutsTask(c, w, info, run)
 	}
 
-	for _, to := range info.Payouts {
-		xRate := to.ValuePercentage
-
-		xReward := new(big.Float).Mul(new(big.Float).SetInt(amountInWei), big.NewFloat(xRate))
-
-		xRewardWei := xReward.Mul(xReward, new(big.Float).SetInt(util.Ether))
-
-		valueWei, _ := xRewardWei.Int(nil)
+	// 累计未付的部分已经属于客户, 不参与本次分配
+	balances, err := loadPayoutsBalances(payoutsBalanceFile(info))
+	if err != nil {
+		return err
+	}
+	available := new(big.Int).Sub(amountInWei, balances.total())
+	if available.Sign() < 0 {
+		return fmt.Errorf("payouts[%s]: balance %v less than owed %v", info.groupName(), amountInWei, balances.total())
+	}
 
-		txHash, err := p.sendRawTx(c, to.Address, valueWei, uint64(gasLimit), big.NewInt(gasPrice))
-		if err != nil {
-			return err
-		}
+	feeWei, amounts, err := distributePayouts(info, available)
+	if err != nil {
+		return err
+	}
+	run.Fee = feeWei
 
-		fmt.Println("txHash:", txHash)
+	// 本次分配先记入余额, 再统一支付达到最小金额的部分
+	minPayout := make(map[string]*big.Int)
+	for i := range info.Payouts {
+		to := &info.Payouts[i]
+		owed := balances.owed(to.Address)
+		balances.set(to.Name, to.Address, owed.Add(owed, amounts[i]))
+		minPayout[strings.ToLower(to.Address)] = info.minPayoutWei(to)
+	}
+	if err := balances.save(payoutsBalanceFile(info)); err != nil {
+		return err
 	}
 
-	return nil
+	return p.payOwedBalances(c, w, info, balances, run, func(v *PayoutBalance) *big.Int {
+		if m, ok := minPayout[strings.ToLower(v.Address)]; ok {
+			return m
+		}
+		return etherToWei(info.MinPayout)
+	})
 }
 
-func (p *App) checkPayoutsFile(info *PayoutsFile) error {
-	if len(info.EveryDatAt) == 0 {
-		return fmt.Errorf("empty EveryDatAt")
+func (info *PayoutsFile) gasLimitPrice() (gasLimit, gasPrice int64) {
+	gasLimit, gasPrice = info.GasLimit, info.GasPrice
+	if gasLimit == 0 {
+		gasLimit = DefaultGasLimit
 	}
-	if len(info.Payouts) == 0 {
-		return fmt.Errorf("empty Payouts")
+	if gasPrice == 0 {
+		gasPrice = DefaultGasPrice
 	}
+	return
+}
 
-	// 验证是否大于 100%
-	var totalValuePercentage = info.FeePercentage
-	for _, v := range info.Payouts {
-		totalValuePercentage += v.ValuePercentage
-	}
-	if totalValuePercentage > 1 {
-		return fmt.Errorf("ValuePercentage overflow(%v)", totalValuePercentage)
+func (p *App) checkPayoutsFile(info *PayoutsFile) error {
+	if errs := ValidatePayoutsFile(info, p.cfg.UserAddress); len(errs) > 0 {
+		return errs
 	}
-
 	return nil
 }
 
+// 读取支付文件, 包含不认识的字段时返回 FieldErrors
 func (p *App) loadPayoutsFile(payoutsFile string) (*PayoutsFile, error) {
 	data, err := ioutil.ReadFile(payoutsFile)
 	if err != nil {
@@ -136,9 +224,99 @@ func (p *App) loadPayoutsFile(payoutsFile string) (*PayoutsFile, error) {
 		return nil, err
 	}
 
+	if errs := checkUnknownFields(data, reflect.TypeOf(info), ""); len(errs) > 0 {
+		return nil, errs
+	}
+	if errs := p.resolvePayoutsAddresses(&info); len(errs) > 0 {
+		return nil, errs
+	}
+
 	return &info, nil
 }
 
+// 把支付文件中的地址簿名字(From/Payouts[i].Address/Approvers[i])替换为地址
+//
+// 0x 开头的值不处理, 由 ValidatePayoutsFile 检查.
+func (p *App) resolvePayoutsAddresses(info *PayoutsFile) FieldErrors {
+	var errs FieldErrors
+
+	resolver, err := p.addressResolver()
+	if err != nil {
+		errs.add("AddressBookFile", "%v", err)
+		return errs
+	}
+	resolve := func(field string, s *string) {
+		if *s == "" || strings.HasPrefix(*s, "0x") || strings.HasPrefix(*s, "0X") {
+			return
+		}
+		address, err := resolver.resolve(*s)
+		if err != nil {
+			errs.add(field, "%v", err)
+			return
+		}
+		*s = address
+	}
+	resolveGroup := func(prefix string, g *PayoutsFile) {
+		resolve(prefix+"From", &g.From)
+		for i := range g.Payouts {
+			resolve(fmt.Sprintf("%sPayouts[%d].Address", prefix, i), &g.Payouts[i].Address)
+		}
+		for i := range g.Approvers {
+			resolve(fmt.Sprintf("%sApprovers[%d]", prefix, i), &g.Approvers[i])
+		}
+	}
+
+	resolveGroup("", info)
+	for i, g := range info.Groups {
+		resolveGroup(fmt.Sprintf("Groups[%d].", i), g)
+	}
+	return errs
+}
+
+// 生成支付报表, historyFile 为空时使用支付文件中的配置
+func (p *App) CmdPayoutsReportFile(payoutsFile, historyFile string, from, to time.Time, format string) error {
+	if historyFile == "" {
+		info, err := p.loadPayoutsFile(payoutsFile)
+		if err != nil {
+			return err
+		}
+		historyFile = payoutsHistoryFile(info)
+	}
+	return p.CmdPayoutsReport(historyFile, from, to, format)
+}
+
+// 检查支付文件, 打印全部错误
+func (p *App) CmdPayoutsValidate(payoutsFile string) error {
+	data, err := ioutil.ReadFile(payoutsFile)
+	if err != nil {
+		return err
+	}
+
+	var info PayoutsFile
+	if err := json.Unmarshal(data, &info); err != nil {
+		return fmt.Errorf("%s: %v", payoutsFile, err)
+	}
+
+	errs := checkUnknownFields(data, reflect.TypeOf(info), "")
+	resolveErrs := p.resolvePayoutsAddresses(&info)
+	errs = append(errs, resolveErrs...)
+	for _, e := range ValidatePayoutsFile(&info, p.cfg.UserAddress) {
+		// 没有解析的名字不再重复报告地址格式错误
+		if !resolveErrs.has(e.Field) {
+			errs = append(errs, e)
+		}
+	}
+	if len(errs) == 0 {
+		fmt.Printf("%s: ok\n", payoutsFile)
+		return nil
+	}
+
+	for _, e := range errs {
+		fmt.Printf("%s: %v\n", payoutsFile, e)
+	}
+	return fmt.Errorf("%s: %d error(s)", payoutsFile, len(errs))
+}
+
 func (p *App) genPayoutsFileTemplate(payoutsFile string) error {
 	x := &PayoutsFile{
 		Threshold:     1,
@@ -151,8 +329,14 @@ func (p *App) genPayoutsFileTemplate(payoutsFile string) error {
 				Name:            "user0",
 				Address:         "0x3eb41fc94f240242c9bbb8bf46b9feb356fd09e2",
 				ValuePercentage: 0.1,
+				MinPayout:       0,
 			},
 		},
+		LockFile:    "",
+		LockLease:   60,
+		HistoryFile: DefaultPayoutsHistoryFile,
+		MinPayout:   0,
+		BalanceFile: DefaultPayoutsBalanceFile,
 	}
 
 	data, _ := json.MarshalIndent(x, "", "\t")
@@ -0,0 +1,509 @@
+package mainpkg
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ethereum/go-ethereum/accounts"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+
+	"xcoin/HayekTool/pkg/rpc"
+	"xcoin/HayekTool/pkg/util"
+)
+
+const (
+	DefaultPayoutsPlanDir = "payouts-plans"
+	DefaultPayoutsPlanTTL = 86400
+
+	// 计划结束后的文件后缀
+	payoutsPlanDone    = ".done"
+	payoutsPlanExpired = ".expired"
+)
+
+// 待审批的支付计划
+//
+// 一次支付的总额超过 ApprovalLimit 时不直接发送, 而是写入计划文件,
+// 由 Approvers 中的地址签名(payouts approve)之后才广播.
+type PayoutsPlan struct {
+	Id        string
+	Group     string
+	From      string
+	CreatedAt time.Time
+	ExpiresAt time.Time        // 过期后计划作废, 下次任务重新生成
+	Total     *big.Int         // 总金额(wei)
+	Transfers []PayoutTransfer // 计划中的转账
+	Hash      string           // 计划内容的 keccak256, 审批人对它签名
+	Approvals []PayoutsApproval
+
+	file string
+}
+
+// 一个审批签名
+type PayoutsApproval struct {
+	Approver  string // 审批人地址
+	Signature string // 对 Hash 的签名(EIP-191 personal message)
+	At        time.Time
+}
+
+// 参与 hash 的内容, 修改其中任何一项都会使签名失效
+type payoutsPlanBody struct {
+	Id        string
+	Group     string
+	From      string
+	ExpiresAt int64
+	Total     string
+	Transfers [][2]string
+}
+
+func (info *PayoutsFile) planDir() string {
+	if info.PlanDir != "" {
+		return info.PlanDir
+	}
+	return DefaultPayoutsPlanDir
+}
+
+func (info *PayoutsFile) planTTL() time.Duration {
+	if info.PlanTTL > 0 {
+		return time.Duration(info.PlanTTL) * time.Second
+	}
+	return DefaultPayoutsPlanTTL * time.Second
+}
+
+// 计算计划内容的 hash
+func (plan *PayoutsPlan) hash() string {
+	body := payoutsPlanBody{
+		Id:        plan.Id,
+		Group:     plan.Group,
+		From:      strings.ToLower(plan.From),
+		ExpiresAt: plan.ExpiresAt.Unix(),
+		Total:     plan.Total.String(),
+	}
+	for _, t := range plan.Transfers {
+		body.Transfers = append(body.Transfers, [2]string{strings.ToLower(t.Address), t.Value.String()})
+	}
+	data, _ := json.Marshal(body)
+	return crypto.Keccak256Hash(data).Hex()
+}
+
+func (plan *PayoutsPlan) expired(now time.Time) bool {
+	return !now.Before(plan.ExpiresAt)
+}
+
+// 检查签名, 返回有效的审批人地址
+func (plan *PayoutsPlan) approvers(allowed []string) ([]string, error) {
+	if plan.Hash != plan.hash() {
+		return nil, fmt.Errorf("plan %s: hash mismatch, file modified", plan.Id)
+	}
+
+	var list []string
+	seen := make(map[string]bool)
+	for _, a := range plan.Approvals {
+		addr, err := recoverPlanApprover(plan.Hash, a.Signature)
+		if err != nil {
+			return nil, fmt.Errorf("plan %s: approval of %s: %v", plan.Id, a.Approver, err)
+		}
+		if !strings.EqualFold(addr, a.Approver) {
+			return nil, fmt.Errorf("plan %s: approval of %s signed by %s", plan.Id, a.Approver, addr)
+		}
+		// 付款人自己的签名不算审批
+		if !containsAddress(allowed, addr) || strings.EqualFold(addr, plan.From) || seen[strings.ToLower(addr)] {
+			continue
+		}
+		seen[strings.ToLower(addr)] = true
+		list = append(list, addr)
+	}
+	return list, nil
+}
+
+func signPlanHash(hash string, key string) (string, error) {
+	privateKey, err := crypto.HexToECDSA(strings.TrimPrefix(key, "0x"))
+	if err != nil {
+		return "", err
+	}
+	sig, err := crypto.Sign(accounts.TextHash(common.HexToHash(hash).Bytes()), privateKey)
+	if err != nil {
+		return "", err
+	}
+	return "0x" + hex.EncodeToString(sig), nil
+}
+
+func recoverPlanApprover(hash, signature string) (string, error) {
+	sig, err := hex.DecodeString(strings.TrimPrefix(signature, "0x"))
+	if err != nil || len(sig) != crypto.SignatureLength {
+		return "", fmt.Errorf("invalid signature")
+	}
+	pub, err := crypto.SigToPub(accounts.TextHash(common.HexToHash(hash).Bytes()), sig)
+	if err != nil {
+		return "", err
+	}
+	return crypto.PubkeyToAddress(*pub).Hex(), nil
+}
+
+func containsAddress(list []string, address string) bool {
+	for _, v := range list {
+		if strings.EqualFold(v, address) {
+			return true
+		}
+	}
+	return false
+}
+
+func loadPayoutsPlan(path string) (*PayoutsPlan, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var plan PayoutsPlan
+	if err := json.Unmarshal(data, &plan); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	plan.file = path
+	return &plan, nil
+}
+
+// 原子地写入, 避免审批和执行同时读写时看到一半的内容
+func (plan *PayoutsPlan) save() error {
+	data, err := json.MarshalIndent(plan, "", "\t")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(plan.file), 0755); err != nil {
+		return err
+	}
+	return util.WriteFileAtomic(plan.file, data, 0644)
+}
+
+// 结束计划(执行或者过期), 改名后不再被加载
+func (plan *PayoutsPlan) finish(suffix string) error {
+	return os.Rename(plan.file, plan.file+suffix)
+}
+
+// 分组当前未结束的计划, 没有时返回 nil
+func loadPendingPayoutsPlan(info *PayoutsFile) (*PayoutsPlan, error) {
+	files, err := filepath.Glob(filepath.Join(info.planDir(), info.groupName(), "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	if len(files) == 0 {
+		return nil, nil
+	}
+	sort.Strings(files)
+	return loadPayoutsPlan(files[0])
+}
+
+func newPayoutsPlan(info *PayoutsFile, w *Wallet, run *PayoutsRun, transfers []PayoutTransfer) *PayoutsPlan {
+	plan := &PayoutsPlan{
+		Id:        run.Id,
+		Group:     info.groupName(),
+		From:      w.Address,
+		CreatedAt: time.Now(),
+		ExpiresAt: time.Now().Add(info.planTTL()),
+		Total:     new(big.Int),
+		Transfers: transfers,
+		file:      filepath.Join(info.planDir(), info.groupName(), run.Id+".json"),
+	}
+	for _, t := range transfers {
+		plan.Total.Add(plan.Total, t.Value)
+	}
+	plan.Hash = plan.hash()
+	return plan
+}
+
+// 检查未结束的计划
+//
+// 返回 ready 表示计划已经审批, 需要执行; 返回 wait 表示还在等待审批, 本次不支付.
+// 过期的计划直接作废.
+func (p *App) checkPendingPayoutsPlan(info *PayoutsFile, run *PayoutsRun) (plan *PayoutsPlan, ready, wait bool, err error) {
+	plan, err = loadPendingPayoutsPlan(info)
+	if err != nil || plan == nil {
+		return nil, false, false, err
+	}
+
+	if plan.expired(time.Now()) {
+		info.logf("plan %s expired at %s without approval", plan.Id, plan.ExpiresAt.Format(time.RFC3339))
+		return nil, false, false, plan.finish(payoutsPlanExpired)
+	}
+
+	approvers, err := plan.approvers(info.Approvers)
+	if err != nil {
+		return nil, false, false, err
+	}
+	run.Plan = plan.file
+	if len(approvers) == 0 {
+		info.logf("plan %s waiting for approval: %s", plan.Id, plan.file)
+		return plan, false, true, nil
+	}
+	info.logf("plan %s approved by %s", plan.Id, strings.Join(approvers, ", "))
+	return plan, true, false, nil
+}
+
+// 支付余额中达到最小金额的部分
+//
+// 配置了 ApprovalLimit 时, 总额超过限制的支付先生成计划, 审批之后才发送.
+func (p *App) payOwedBalances(c *rpc.RPCClient, w *Wallet, info *PayoutsFile, balances PayoutsBalances, run *PayoutsRun, minPayout func(v *PayoutBalance) *big.Int) error {
+	if info.ApprovalLimit > 0 {
+		plan, ready, wait, err := p.checkPendingPayoutsPlan(info, run)
+		if err != nil {
+			return err
+		}
+		if ready {
+			return p.executePayoutsPlan(c, w, info, balances, run, plan)
+		}
+		if wait {
+			run.Transfers = append(run.Transfers, carriedTransfers(balances, nil)...)
+			return nil
+		}
+	}
+
+	_, gasPrice := info.gasLimitPrice()
+
+	var (
+		transfers []PayoutTransfer
+		total     = new(big.Int)
+		paying    = make(map[string]bool)
+	)
+	for _, k := range balances.keys() {
+		v := balances.Balances[k]
+		if v.Owed == nil || v.Owed.Sign() == 0 || v.Owed.Cmp(minPayout(v)) < 0 {
+			continue
+		}
+		transfers = append(transfers, PayoutTransfer{
+			Name:     v.Name,
+			Address:  v.Address,
+			Value:    new(big.Int).Set(v.Owed),
+			GasPrice: big.NewInt(gasPrice),
+		})
+		total.Add(total, v.Owed)
+		paying[k] = true
+	}
+	run.Transfers = append(run.Transfers, carriedTransfers(balances, paying)...)
+
+	if info.ApprovalLimit > 0 && total.Cmp(etherToWei(info.ApprovalLimit)) > 0 {
+		plan := newPayoutsPlan(info, w, run, transfers)
+		if err := plan.save(); err != nil {
+			return err
+		}
+		info.logf("total %s HYK over approval limit %v, plan %s waiting for approval: %s",
+			formatWei(total), info.ApprovalLimit, plan.Id, plan.file,
+		)
+		run.Plan = plan.file
+		for _, t := range transfers {
+			t.Carried = t.Value
+			run.Transfers = append(run.Transfers, t)
+		}
+		return nil
+	}
+
+	return p.sendPayoutTransfers(c, w, info, balances, run, transfers)
+}
+
+// 余额中本次不支付的部分, 记录到历史
+func carriedTransfers(balances PayoutsBalances, paying map[string]bool) []PayoutTransfer {
+	var list []PayoutTransfer
+	for _, k := range balances.keys() {
+		v := balances.Balances[k]
+		if paying[k] || v.Owed == nil || v.Owed.Sign() == 0 {
+			continue
+		}
+		list = append(list, PayoutTransfer{
+			Name:    v.Name,
+			Address: v.Address,
+			Value:   new(big.Int),
+			Carried: new(big.Int).Set(v.Owed),
+		})
+	}
+	return list
+}
+
+// 执行已审批的计划, 金额以计划为准
+//
+// 计划先标记为结束再发送, 中途退出时不会重复支付, 失败的部分留在余额中下次支付.
+func (p *App) executePayoutsPlan(c *rpc.RPCClient, w *Wallet, info *PayoutsFile, balances PayoutsBalances, run *PayoutsRun, plan *PayoutsPlan) error {
+	if !strings.EqualFold(plan.From, w.Address) {
+		return fmt.Errorf("payouts[%s]: plan %s from %s, wallet is %s", info.groupName(), plan.Id, plan.From, w.Address)
+	}
+	if err := plan.finish(payoutsPlanDone); err != nil {
+		return err
+	}
+	run.Plan = plan.file + payoutsPlanDone
+
+	_, gasPrice := info.gasLimitPrice()
+	transfers := make([]PayoutTransfer, len(plan.Transfers))
+	for i, t := range plan.Transfers {
+		// 计划之后余额可能已经减少(比如 flush), 只支付仍然欠的部分
+		if owed := balances.owed(t.Address); owed.Cmp(t.Value) < 0 {
+			t.Value = owed
+		}
+		t.GasPrice = big.NewInt(gasPrice)
+		transfers[i] = t
+	}
+	// 审批过的计划不再需要交互确认, 其它的策略限制仍然有效
+	return p.withConfirm(confirmApproved).sendPayoutTransfers(c, w, info, balances, run, transfers)
+}
+
+// 发送转账, 成功的部分从余额中扣除
+func (p *App) sendPayoutTransfers(c *rpc.RPCClient, w *Wallet, info *PayoutsFile, balances PayoutsBalances, run *PayoutsRun, transfers []PayoutTransfer) error {
+	gasLimit, gasPrice := info.gasLimitPrice()
+
+	var failed int
+	for _, t := range transfers {
+		if t.Value.Sign() == 0 {
+			continue
+		}
+
+		txHash, err := p.sendRawTxFrom(c, w, t.Address, t.Value, uint64(gasLimit), big.NewInt(gasPrice))
+		owed := balances.owed(t.Address)
+		if err != nil {
+			info.logf("send to %s(%s) failed: %v", t.Name, t.Address, err)
+			t.Err = err.Error()
+			failed++
+		} else {
+			info.logf("send %s HYK to %s(%s), txHash: %s", formatWei(t.Value), t.Name, t.Address, txHash)
+			t.TxHash = txHash
+			owed.Sub(owed, t.Value)
+			balances.set(t.Name, t.Address, owed)
+		}
+		t.Carried = owed
+		run.Transfers = append(run.Transfers, t)
+
+		// 每笔之后保存, 避免中途退出后重复支付
+		if err := balances.save(payoutsBalanceFile(info)); err != nil {
+			return err
+		}
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("payouts[%s]: %d of %d transfers failed", info.groupName(), failed, len(transfers))
+	}
+	return nil
+}
+
+// 执行已审批的计划(不等下一次定时任务), 没有已审批的计划时什么都不做
+func (p *App) doPayoutsPlanTask(info *PayoutsFile) (err error) {
+	if info.ApprovalLimit <= 0 {
+		return nil
+	}
+	run := &PayoutsRun{
+		Id:      time.Now().Format("20060102-150405"),
+		Group:   info.groupName(),
+		StartAt: time.Now(),
+		From:    info.From,
+		Fee:     new(big.Int),
+	}
+
+	plan, ready, _, err := p.checkPendingPayoutsPlan(info, run)
+	if err != nil || !ready {
+		return err
+	}
+
+	defer func() {
+		if err != nil {
+			run.Err = err.Error()
+		}
+		if errSave := p.savePayoutsRun(info, run); errSave != nil {
+			info.logf("save history failed: %v", errSave)
+		}
+		p.notifyPayoutsRun(info, run)
+		p.recordPayoutsRun(run)
+	}()
+
+	w, err := p.payoutsWallet(info)
+	if err != nil {
+		return err
+	}
+	run.From = w.Address
+
+	c, err := p.rpcClient(time.Second * 3)
+	if err != nil {
+		return err
+	}
+	if run.Balance, err = c.GetBalance(w.Address); err != nil {
+		return err
+	}
+
+	balances, err := loadPayoutsBalances(payoutsBalanceFile(info))
+	if err != nil {
+		return err
+	}
+	return p.executePayoutsPlan(c, w, info, balances, run, plan)
+}
+
+// 审批支付计划: 用审批人的私钥对计划 hash 签名
+func (p *App) CmdPayoutsApprove(payoutsFile, planFile, keyRef string) error {
+	plan, err := loadPayoutsPlan(planFile)
+	if err != nil {
+		return err
+	}
+	if plan.Hash != plan.hash() {
+		return fmt.Errorf("plan %s: hash mismatch, file modified", plan.Id)
+	}
+	if plan.expired(time.Now()) {
+		return fmt.Errorf("plan %s: expired at %s", plan.Id, plan.ExpiresAt.Format(time.RFC3339))
+	}
+
+	info, err := p.loadPayoutsFile(payoutsFile)
+	if err != nil {
+		return err
+	}
+	group := info.payoutsGroup(plan.Group)
+	if group == nil {
+		return fmt.Errorf("plan %s: group %q not found in %s", plan.Id, plan.Group, payoutsFile)
+	}
+
+	key, err := ResolveKeyRef(keyRef)
+	if err != nil {
+		return err
+	}
+	privateKey, err := crypto.HexToECDSA(strings.TrimPrefix(key, "0x"))
+	if err != nil {
+		return err
+	}
+	approver := crypto.PubkeyToAddress(privateKey.PublicKey).Hex()
+
+	if !containsAddress(group.Approvers, approver) {
+		return fmt.Errorf("plan %s: %s is not an approver of group %s", plan.Id, approver, plan.Group)
+	}
+	if strings.EqualFold(approver, plan.From) {
+		return fmt.Errorf("plan %s: payer %s cannot approve its own plan", plan.Id, approver)
+	}
+	for _, a := range plan.Approvals {
+		if strings.EqualFold(a.Approver, approver) {
+			return fmt.Errorf("plan %s: already approved by %s", plan.Id, approver)
+		}
+	}
+
+	fmt.Printf("plan:    %s\n", plan.Id)
+	fmt.Printf("group:   %s\n", plan.Group)
+	fmt.Printf("from:    %s\n", plan.From)
+	fmt.Printf("expires: %s\n", plan.ExpiresAt.Format(time.RFC3339))
+	fmt.Printf("hash:    %s\n", plan.Hash)
+	for _, t := range plan.Transfers {
+		fmt.Printf("  %s(%s) %s HYK\n", t.Name, t.Address, formatWei(t.Value))
+	}
+	fmt.Printf("total:   %s HYK\n", formatWei(plan.Total))
+
+	sig, err := signPlanHash(plan.Hash, key)
+	if err != nil {
+		return err
+	}
+	plan.Approvals = append(plan.Approvals, PayoutsApproval{
+		Approver:  approver,
+		Signature: sig,
+		At:        time.Now(),
+	})
+	if err := plan.save(); err != nil {
+		return err
+	}
+
+	fmt.Printf("approved by %s\n", approver)
+	return nil
+}
@@ -0,0 +1,108 @@
+package mainpkg
+
+import (
+	"encoding/hex"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+func newTestKey(t *testing.T) (address, key string) {
+	k, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return crypto.PubkeyToAddress(k.PublicKey).Hex(), hex.EncodeToString(crypto.FromECDSA(k))
+}
+
+func TestPayoutsPlanApproval(t *testing.T) {
+	dir, err := ioutil.TempDir("", "payouts-plans")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	payer, _ := newTestKey(t)
+	approver, approverKey := newTestKey(t)
+	other, otherKey := newTestKey(t)
+
+	info := &PayoutsFile{
+		ApprovalLimit: 10,
+		Approvers:     []string{approver},
+		PlanDir:       dir,
+	}
+	run := &PayoutsRun{Id: "20200701-103000"}
+	plan := newPayoutsPlan(info, &Wallet{Address: payer}, run, []PayoutTransfer{
+		{Name: "user0", Address: other, Value: etherToWei(12)},
+	})
+	if err := plan.save(); err != nil {
+		t.Fatal(err)
+	}
+
+	check := func() (ready, wait bool) {
+		_, ready, wait, err := (&App{}).checkPendingPayoutsPlan(info, &PayoutsRun{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return ready, wait
+	}
+	approve := func(addr, key string) {
+		plan, err := loadPayoutsPlan(plan.file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sig, err := signPlanHash(plan.Hash, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		plan.Approvals = append(plan.Approvals, PayoutsApproval{Approver: addr, Signature: sig, At: time.Now()})
+		if err := plan.save(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if ready, wait := check(); ready || !wait {
+		t.Fatalf("new plan: ready %v, wait %v", ready, wait)
+	}
+
+	// 不在审批人列表中的签名不算
+	approve(other, otherKey)
+	if ready, _ := check(); ready {
+		t.Fatal("approved by non-approver")
+	}
+
+	approve(approver, approverKey)
+	if ready, _ := check(); !ready {
+		t.Fatal("approved plan not ready")
+	}
+
+	// 修改金额后签名失效
+	tampered, _ := loadPayoutsPlan(plan.file)
+	tampered.Transfers[0].Value = etherToWei(120)
+	tampered.Total = new(big.Int).Set(tampered.Transfers[0].Value)
+	tampered.save()
+	if _, _, _, err := (&App{}).checkPendingPayoutsPlan(info, &PayoutsRun{}); err == nil {
+		t.Fatal("tampered plan accepted")
+	}
+
+	// 过期的计划作废
+	expired, _ := loadPayoutsPlan(plan.file)
+	expired.Transfers[0].Value = etherToWei(12)
+	expired.ExpiresAt = time.Now().Add(-time.Minute)
+	expired.Hash = expired.hash()
+	expired.save()
+	if ready, wait := check(); ready || wait {
+		t.Fatalf("expired plan: ready %v, wait %v", ready, wait)
+	}
+	if _, err := os.Stat(plan.file + payoutsPlanExpired); err != nil {
+		t.Fatal(err)
+	}
+	if files, _ := filepath.Glob(filepath.Join(dir, "*", "*.json")); len(files) != 0 {
+		t.Fatalf("pending plans left: %v", files)
+	}
+}
@@ -0,0 +1,231 @@
+package mainpkg
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"math/big"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"xcoin/HayekTool/pkg/lock"
+	"xcoin/HayekTool/pkg/util"
+)
+
+const DefaultPayoutsBalanceFile = "payouts-balances.json"
+
+// 未达到最小支付金额时累计的余额
+//
+// 矿池模式记入余额的奖励和余额保存在同一个文件中, 中途退出后重新扫描时不会重复记账.
+type PayoutsBalances struct {
+	Balances map[string]*PayoutBalance // 按地址(小写)索引
+	Credited map[string]int64          `json:",omitempty"` // 矿池模式已记账的区块/叔块 hash(小写) => 所在区块高度
+}
+
+type PayoutBalance struct {
+	Name      string
+	Address   string
+	Owed      *big.Int // 累计未付金额(wei)
+	UpdatedAt time.Time
+}
+
+func payoutsBalanceFile(info *PayoutsFile) string {
+	if info != nil && info.BalanceFile != "" {
+		return info.BalanceFile
+	}
+	return DefaultPayoutsBalanceFile
+}
+
+func newPayoutsBalances() PayoutsBalances {
+	return PayoutsBalances{
+		Balances: make(map[string]*PayoutBalance),
+		Credited: make(map[string]int64),
+	}
+}
+
+// 兼容旧格式(只有按地址索引的余额)
+func loadPayoutsBalances(path string) (PayoutsBalances, error) {
+	balances := newPayoutsBalances()
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return balances, nil
+		}
+		return balances, err
+	}
+
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return balances, fmt.Errorf("%s: %v", path, err)
+	}
+	if _, ok := fields["Balances"]; ok {
+		err = json.Unmarshal(data, &balances)
+	} else {
+		err = json.Unmarshal(data, &balances.Balances)
+	}
+	if err != nil {
+		return balances, fmt.Errorf("%s: %v", path, err)
+	}
+	if balances.Balances == nil {
+		balances.Balances = make(map[string]*PayoutBalance)
+	}
+	if balances.Credited == nil {
+		balances.Credited = make(map[string]int64)
+	}
+	return balances, nil
+}
+
+func (m PayoutsBalances) save(path string) error {
+	data, err := json.MarshalIndent(m, "", "\t")
+	if err != nil {
+		return err
+	}
+	return util.WriteFileAtomic(path, data, 0644)
+}
+
+// 按地址排序的索引
+func (m PayoutsBalances) keys() []string {
+	keys := make([]string, 0, len(m.Balances))
+	for k := range m.Balances {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// 某个地址累计未付的金额
+func (m PayoutsBalances) owed(address string) *big.Int {
+	if v, ok := m.Balances[strings.ToLower(address)]; ok && v.Owed != nil {
+		return new(big.Int).Set(v.Owed)
+	}
+	return new(big.Int)
+}
+
+func (m PayoutsBalances) set(name, address string, owed *big.Int) {
+	key := strings.ToLower(address)
+	if owed.Sign() == 0 {
+		delete(m.Balances, key)
+		return
+	}
+	m.Balances[key] = &PayoutBalance{
+		Name:      name,
+		Address:   address,
+		Owed:      new(big.Int).Set(owed),
+		UpdatedAt: time.Now(),
+	}
+}
+
+// 全部未付金额
+func (m PayoutsBalances) total() *big.Int {
+	sum := new(big.Int)
+	for _, v := range m.Balances {
+		if v.Owed != nil {
+			sum.Add(sum, v.Owed)
+		}
+	}
+	return sum
+}
+
+// 最小支付金额(wei), 客户的配置优先
+func (info *PayoutsFile) minPayoutWei(to *PayoutElem) *big.Int {
+	if to.MinPayout > 0 {
+		return etherToWei(to.MinPayout)
+	}
+	return etherToWei(info.MinPayout)
+}
+
+// HYK 转换为 wei, 按十进制字面值精确转换
+func etherToWei(v float64) *big.Int {
+	return mulRat(util.Ether, floatRat(v))
+}
+
+// 立即支付全部累计未付的金额, group 为空时处理全部分组
+func (p *App) CmdPayoutsFlush(payoutsFile, group string) error {
+	info, err := p.loadPayoutsFile(payoutsFile)
+	if err != nil {
+		return err
+	}
+	if err := p.checkPayoutsFile(info); err != nil {
+		return err
+	}
+
+	// 和支付服务使用同一个锁, 避免同时修改余额文件
+	if info.LockFile != "" {
+		l := lock.NewFileLock(info.LockFile, time.Duration(info.LockLease)*time.Second)
+		if err := l.TryLock(); err != nil {
+			return fmt.Errorf("payouts flush: %s: %v", info.LockFile, err)
+		}
+		defer l.Unlock()
+	}
+
+	var failed, found int
+	for _, g := range info.payoutsGroups() {
+		if group != "" && g.groupName() != group {
+			continue
+		}
+		found++
+		if err := p.doPayoutsFlush(g); err != nil {
+			log.Println(err)
+			failed++
+		}
+	}
+	if found == 0 {
+		return fmt.Errorf("payouts flush: group %q not found", group)
+	}
+	if failed > 0 {
+		return fmt.Errorf("payouts flush: %d group(s) failed", failed)
+	}
+	return nil
+}
+
+func (p *App) doPayoutsFlush(info *PayoutsFile) (err error) {
+	run := &PayoutsRun{
+		Id:      "flush-" + time.Now().Format("20060102-150405"),
+		Group:   info.groupName(),
+		StartAt: time.Now(),
+		Fee:     new(big.Int),
+	}
+
+	balances, err := loadPayoutsBalances(payoutsBalanceFile(info))
+	if err != nil {
+		return err
+	}
+	if len(balances.Balances) == 0 {
+		info.logf("nothing to flush")
+		return nil
+	}
+
+	defer func() {
+		if err != nil {
+			run.Err = err.Error()
+		}
+		if errSave := p.savePayoutsRun(info, run); errSave != nil {
+			info.logf("save history failed: %v", errSave)
+		}
+		p.notifyPayoutsRun(info, run)
+		p.recordPayoutsRun(run)
+	}()
+
+	w, err := p.payoutsWallet(info)
+	if err != nil {
+		return err
+	}
+	run.From = w.Address
+
+	c, err := p.rpcClient(time.Second * 3)
+	if err != nil {
+		return err
+	}
+	if run.Balance, err = c.GetBalance(w.Address); err != nil {
+		return err
+	}
+
+	// 全部支付, 超过审批限制时同样需要审批
+	return p.payOwedBalances(c, w, info, balances, run, func(*PayoutBalance) *big.Int {
+		return new(big.Int)
+	})
+}
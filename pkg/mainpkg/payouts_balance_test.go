@@ -0,0 +1,125 @@
+package mainpkg
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/rlp"
+
+	"xcoin/HayekTool/pkg/config"
+)
+
+// 只支持支付任务用到的方法的节点, fail 为 true 时拒绝所有交易
+type fakePayoutsNode struct {
+	balance *big.Int
+	fail    bool
+	nonce   uint64
+	sent    map[string]*big.Int // 地址(小写) => 金额
+}
+
+func (n *fakePayoutsNode) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Method string
+		Params []string
+	}
+	json.NewDecoder(r.Body).Decode(&req)
+
+	var result interface{}
+	var err error
+	switch req.Method {
+	case "hyk_getBalance":
+		result = hexutil.EncodeBig(n.balance)
+	case "hyk_getTransactionCount":
+		result = hexutil.EncodeUint64(n.nonce)
+	case "hyk_sendRawTransaction":
+		tx := new(types.Transaction)
+		data, _ := hexutil.Decode(req.Params[0])
+		if err = rlp.DecodeBytes(data, tx); err != nil {
+			break
+		}
+		if n.fail {
+			err = fmt.Errorf("insufficient funds for gas * price + value")
+			break
+		}
+		n.nonce++
+		n.balance.Sub(n.balance, tx.Value())
+		n.sent[strings.ToLower(tx.To().Hex())] = tx.Value()
+		result = tx.Hash().Hex()
+	default:
+		err = fmt.Errorf("method %s not supported", req.Method)
+	}
+
+	resp := map[string]interface{}{"jsonrpc": "2.0", "id": 0, "result": result}
+	if err != nil {
+		resp = map[string]interface{}{"jsonrpc": "2.0", "id": 0, "error": map[string]interface{}{"code": -32000, "message": err.Error()}}
+	}
+	json.NewEncoder(w).Encode(resp)
+}
+
+func TestPayoutsCarryFailedShare(t *testing.T) {
+	dir, err := ioutil.TempDir("", "payouts")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	key, _ := crypto.GenerateKey()
+	node := &fakePayoutsNode{balance: etherToWei(10), fail: true, sent: make(map[string]*big.Int)}
+	server := httptest.NewServer(node)
+	defer server.Close()
+
+	app := NewApp(&config.Config{
+		Host:        server.URL,
+		UserKey:     hex.EncodeToString(crypto.FromECDSA(key)),
+		UserAddress: crypto.PubkeyToAddress(key.PublicKey).Hex(),
+	})
+
+	const (
+		alice = "0x5205f45c6399c41e11e533926ca69a0aedfdbb8d"
+		bob   = "0x3eb41fc94f240242c9bbb8bf46b9feb356fd09e2"
+	)
+	info := &PayoutsFile{
+		Payouts: []PayoutElem{
+			{Name: "alice", Address: alice, ValuePercentage: 0.5},
+			{Name: "bob", Address: bob, ValuePercentage: 0.25},
+		},
+		HistoryFile: filepath.Join(dir, "history.jsonl"),
+		BalanceFile: filepath.Join(dir, "balances.json"),
+	}
+
+	// 发送失败时份额累计到余额中
+	if err := app.doPayoutsTask(info); err == nil {
+		t.Fatal("expect error for failed transfers")
+	}
+	balances, err := loadPayoutsBalances(info.BalanceFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a, b := balances.owed(alice), balances.owed(bob); a.Cmp(etherToWei(5)) != 0 || b.Cmp(etherToWei(2.5)) != 0 {
+		t.Fatalf("owed after failure: alice %v, bob %v", a, b)
+	}
+
+	// 下次支付时累计的余额和新的份额一起支付
+	node.fail = false
+	if err := app.doPayoutsTask(info); err != nil {
+		t.Fatal(err)
+	}
+	if a, b := node.sent[alice], node.sent[bob]; a.Cmp(etherToWei(6.25)) != 0 || b.Cmp(etherToWei(3.125)) != 0 {
+		t.Fatalf("sent: alice %v, bob %v", a, b)
+	}
+	if balances, _ = loadPayoutsBalances(info.BalanceFile); len(balances.Balances) != 0 {
+		t.Fatalf("balances after payout: %v", balances)
+	}
+}
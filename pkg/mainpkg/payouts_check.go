@@ -0,0 +1,401 @@
+package mainpkg
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+
+	"xcoin/HayekTool/pkg/addressbook"
+)
+
+var dayTimePattern = regexp.MustCompile(`^([01][0-9]|2[0-3]):[0-5][0-9]$`)
+
+// 字段错误, Field 是字段路径, 比如 Payouts[1].Address
+type FieldError struct {
+	Field string
+	Err   string
+}
+
+func (e *FieldError) Error() string {
+	return e.Field + ": " + e.Err
+}
+
+// 多个字段错误
+type FieldErrors []*FieldError
+
+func (errs FieldErrors) Error() string {
+	var lines []string
+	for _, e := range errs {
+		lines = append(lines, e.Error())
+	}
+	return strings.Join(lines, "\n")
+}
+
+func (errs FieldErrors) has(field string) bool {
+	for _, e := range errs {
+		if e.Field == field {
+			return true
+		}
+	}
+	return false
+}
+
+func (errs *FieldErrors) add(field, format string, a ...interface{}) {
+	*errs = append(*errs, &FieldError{Field: field, Err: fmt.Sprintf(format, a...)})
+}
+
+// 检查支付文件, 返回全部错误; defaultFrom 为没有配置 From 时使用的付款地址(配置文件中的 UserAddress)
+func ValidatePayoutsFile(info *PayoutsFile, defaultFrom string) FieldErrors {
+	var errs FieldErrors
+
+	if info.LockLease < 0 {
+		errs.add("LockLease", "negative value %d", info.LockLease)
+	}
+
+	// 只有 Groups 时顶层不需要支付配置
+	if len(info.Groups) == 0 || len(info.Payouts) > 0 || info.Pool != nil {
+		errs = append(errs, validatePayoutsGroup(info)...)
+	} else {
+		// 分组会继承顶层的审批设置
+		validatePayoutsApproval(&errs, info)
+	}
+
+	var (
+		seenGroup = map[string]string{defaultPayoutsGroup: "default group"}
+		seenFrom  = make(map[string]string)
+	)
+	// 顶层有支付配置时按实际使用的付款地址检查重复
+	if from := info.From; from != "" || len(info.Payouts) > 0 || info.Pool != nil {
+		if from == "" {
+			from = defaultFrom
+		}
+		seenFrom[strings.ToLower(from)] = "default group"
+	}
+	for i, g := range info.Groups {
+		prefix := fmt.Sprintf("Groups[%d]", i)
+
+		for _, e := range validatePayoutsGroup(g) {
+			errs.add(prefix+"."+e.Field, "%s", e.Err)
+		}
+
+		if g.Name == "" {
+			errs.add(prefix+".Name", "empty")
+		} else if x, ok := seenGroup[g.Name]; ok {
+			errs.add(prefix+".Name", "duplicate of %s", x)
+		} else {
+			seenGroup[g.Name] = prefix
+		}
+
+		// 同一个钱包不能在多个分组中并发使用 nonce
+		if g.From == "" {
+			errs.add(prefix+".From", "empty")
+		} else if x, ok := seenFrom[strings.ToLower(g.From)]; ok {
+			errs.add(prefix+".From", "duplicate of %s", x)
+		} else {
+			seenFrom[strings.ToLower(g.From)] = prefix
+		}
+
+		if len(g.Groups) > 0 {
+			errs.add(prefix+".Groups", "nested groups not supported")
+		}
+		if g.LockFile != "" || g.LockLease != 0 {
+			errs.add(prefix+".LockFile", "only supported at top level")
+		}
+	}
+
+	return errs
+}
+
+// 检查一个支付分组
+func validatePayoutsGroup(info *PayoutsFile) FieldErrors {
+	var errs FieldErrors
+
+	if info.From != "" {
+		if err := validateAddress(info.From); err != "" {
+			errs.add("From", "%s", err)
+		}
+	}
+	if info.Notify != nil {
+		if field, err := info.Notify.Validate(); err != nil {
+			errs.add("Notify."+field, "%v", err)
+		}
+	}
+	if info.KeyRef != "" && !strings.HasPrefix(info.KeyRef, "env:") && !strings.HasPrefix(info.KeyRef, "file:") {
+		errs.add("KeyRef", "expect env:NAME or file:PATH")
+	}
+
+	if info.Threshold < 0 {
+		errs.add("Threshold", "negative value %d", info.Threshold)
+	}
+	validatePercentage(&errs, "FeePercentage", info.FeePercentage)
+
+	if len(info.EveryDatAt) == 0 {
+		errs.add("EveryDatAt", "empty")
+	}
+	seenAt := make(map[string]int)
+	for i, at := range info.EveryDatAt {
+		field := fmt.Sprintf("EveryDatAt[%d]", i)
+		if !dayTimePattern.MatchString(at) {
+			errs.add(field, "invalid time %q, expect hour:min like 18:30", at)
+			continue
+		}
+		if j, ok := seenAt[at]; ok {
+			errs.add(field, "duplicate of EveryDatAt[%d]", j)
+		}
+		seenAt[at] = i
+	}
+
+	if info.GasLimit < 0 {
+		errs.add("GasLimit", "negative value %d", info.GasLimit)
+	}
+	if info.GasPrice < 0 {
+		errs.add("GasPrice", "negative value %d", info.GasPrice)
+	}
+	validateAmount(&errs, "MinPayout", info.MinPayout)
+	validatePayoutsApproval(&errs, info)
+
+	if info.Pool != nil {
+		validatePoolConfig(&errs, info.Pool)
+		if len(info.Payouts) > 0 {
+			errs.add("Payouts", "not used in pool mode")
+		}
+	} else if len(info.Payouts) == 0 {
+		errs.add("Payouts", "empty")
+	}
+
+	var (
+		seenName    = make(map[string]int)
+		seenAddress = make(map[string]int)
+		total       = info.FeePercentage
+	)
+	for i, v := range info.Payouts {
+		field := fmt.Sprintf("Payouts[%d]", i)
+
+		if v.Name == "" {
+			errs.add(field+".Name", "empty")
+		} else if j, ok := seenName[v.Name]; ok {
+			errs.add(field+".Name", "duplicate of Payouts[%d]", j)
+		} else {
+			seenName[v.Name] = i
+		}
+
+		if err := validateAddress(v.Address); err != "" {
+			errs.add(field+".Address", "%s", err)
+		} else if j, ok := seenAddress[strings.ToLower(v.Address)]; ok {
+			errs.add(field+".Address", "duplicate of Payouts[%d]", j)
+		} else {
+			seenAddress[strings.ToLower(v.Address)] = i
+		}
+
+		validateAmount(&errs, field+".MinPayout", v.MinPayout)
+
+		switch v.strategyName() {
+		case PayoutStrategyPercentage:
+			if validatePercentage(&errs, field+".ValuePercentage", v.ValuePercentage) {
+				total += v.ValuePercentage
+			}
+		case PayoutStrategyFixed:
+			if validateAmount(&errs, field+".FixedAmount", v.FixedAmount) && v.FixedAmount == 0 {
+				errs.add(field+".FixedAmount", "required by %s strategy", v.Strategy)
+			}
+		case PayoutStrategyWeighted:
+			if validateAmount(&errs, field+".Weight", v.Weight) && v.Weight == 0 {
+				errs.add(field+".Weight", "required by %s strategy", v.Strategy)
+			}
+		case PayoutStrategyTiered:
+			if len(v.Tiers) == 0 {
+				errs.add(field+".Tiers", "required by %s strategy", v.Strategy)
+			}
+			seenAbove := make(map[float64]int)
+			for k, t := range v.Tiers {
+				tierField := fmt.Sprintf("%s.Tiers[%d]", field, k)
+				validateAmount(&errs, tierField+".Above", t.Above)
+				validatePercentage(&errs, tierField+".Percentage", t.Percentage)
+				if j, ok := seenAbove[t.Above]; ok {
+					errs.add(tierField+".Above", "duplicate of Tiers[%d]", j)
+				}
+				seenAbove[t.Above] = k
+			}
+		default:
+			if _, ok := payoutStrategies[v.Strategy]; !ok {
+				errs.add(field+".Strategy", "unknown strategy %q", v.Strategy)
+			}
+		}
+	}
+
+	// 验证是否大于 100%
+	if total > 1 {
+		errs.add("Payouts", "FeePercentage + ValuePercentage overflow(%v)", total)
+	}
+
+	return errs
+}
+
+func validatePayoutsApproval(errs *FieldErrors, info *PayoutsFile) {
+	if validateAmount(errs, "ApprovalLimit", info.ApprovalLimit) && info.ApprovalLimit > 0 && len(info.Approvers) == 0 {
+		errs.add("Approvers", "required by ApprovalLimit")
+	}
+	if info.PlanTTL < 0 {
+		errs.add("PlanTTL", "negative value %d", info.PlanTTL)
+	}
+
+	seen := make(map[string]int)
+	for i, v := range info.Approvers {
+		field := fmt.Sprintf("Approvers[%d]", i)
+		if err := validateAddress(v); err != "" {
+			errs.add(field, "%s", err)
+		} else if j, ok := seen[strings.ToLower(v)]; ok {
+			errs.add(field, "duplicate of Approvers[%d]", j)
+		} else if info.From != "" && strings.EqualFold(v, info.From) {
+			errs.add(field, "payer %s cannot approve its own payouts", v)
+		} else {
+			seen[strings.ToLower(v)] = i
+		}
+	}
+}
+
+func validatePoolConfig(errs *FieldErrors, pool *PoolConfig) {
+	switch pool.Scheme {
+	case PoolSchemePROP:
+	case PoolSchemePPLNS:
+		if pool.PPLNSWindow <= 0 {
+			errs.add("Pool.PPLNSWindow", "required by PPLNS")
+		}
+	default:
+		errs.add("Pool.Scheme", "unknown scheme %q, expect PROP or PPLNS", pool.Scheme)
+	}
+	if pool.ShareLogFile == "" {
+		errs.add("Pool.ShareLogFile", "empty")
+	}
+	if validateAmount(errs, "Pool.BlockReward", pool.BlockReward) && pool.BlockReward == 0 {
+		errs.add("Pool.BlockReward", "empty")
+	}
+	if pool.Maturity < 0 {
+		errs.add("Pool.Maturity", "negative value %d", pool.Maturity)
+	}
+	if pool.StartHeight < 0 {
+		errs.add("Pool.StartHeight", "negative value %d", pool.StartHeight)
+	}
+}
+
+// 检查比例是否在 [0, 1] 范围内
+func validatePercentage(errs *FieldErrors, field string, v float64) bool {
+	switch {
+	case math.IsNaN(v) || math.IsInf(v, 0):
+		errs.add(field, "invalid value %v", v)
+	case v < 0:
+		errs.add(field, "negative value %v", v)
+	case v > 1:
+		errs.add(field, "value %v greater than 1", v)
+	default:
+		return true
+	}
+	return false
+}
+
+// 检查金额(HYK)不为负数
+func validateAmount(errs *FieldErrors, field string, v float64) bool {
+	switch {
+	case math.IsNaN(v) || math.IsInf(v, 0):
+		errs.add(field, "invalid value %v", v)
+	case v < 0:
+		errs.add(field, "negative value %v", v)
+	default:
+		return true
+	}
+	return false
+}
+
+// 检查地址格式, 返回错误说明, 规则和地址簿相同
+func validateAddress(s string) string {
+	if err := addressbook.ValidateAddress(s); err != nil {
+		return err.Error()
+	}
+	return ""
+}
+
+// 检查 JSON 中不认识的字段
+func checkUnknownFields(data []byte, typ reflect.Type, path string) FieldErrors {
+	var errs FieldErrors
+
+	for typ.Kind() == reflect.Ptr {
+		typ = typ.Elem()
+	}
+
+	switch typ.Kind() {
+	case reflect.Struct:
+		var m map[string]json.RawMessage
+		if json.Unmarshal(data, &m) != nil {
+			return nil
+		}
+		for _, key := range sortedKeys(m) {
+			raw := m[key]
+			field, ok := lookupJSONField(typ, key)
+			if !ok {
+				errs.add(joinFieldPath(path, key), "unknown field")
+				continue
+			}
+			errs = append(errs, checkUnknownFields(raw, field.Type, joinFieldPath(path, field.Name))...)
+		}
+
+	case reflect.Slice, reflect.Array:
+		var list []json.RawMessage
+		if json.Unmarshal(data, &list) != nil {
+			return nil
+		}
+		for i, raw := range list {
+			errs = append(errs, checkUnknownFields(raw, typ.Elem(), fmt.Sprintf("%s[%d]", path, i))...)
+		}
+
+	case reflect.Map:
+		var m map[string]json.RawMessage
+		if json.Unmarshal(data, &m) != nil {
+			return nil
+		}
+		for _, key := range sortedKeys(m) {
+			errs = append(errs, checkUnknownFields(m[key], typ.Elem(), fmt.Sprintf("%s[%q]", path, key))...)
+		}
+	}
+
+	return errs
+}
+
+// 按 encoding/json 的规则查找字段(忽略大小写)
+func lookupJSONField(typ reflect.Type, key string) (reflect.StructField, bool) {
+	for i := 0; i < typ.NumField(); i++ {
+		f := typ.Field(i)
+		if f.PkgPath != "" {
+			continue
+		}
+		name := f.Name
+		if tag := strings.Split(f.Tag.Get("json"), ",")[0]; tag == "-" {
+			continue
+		} else if tag != "" {
+			name = tag
+		}
+		if strings.EqualFold(name, key) {
+			return f, true
+		}
+	}
+	return reflect.StructField{}, false
+}
+
+func sortedKeys(m map[string]json.RawMessage) []string {
+	var keys []string
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func joinFieldPath(path, name string) string {
+	if path == "" {
+		return name
+	}
+	return path + "." + name
+}
@@ -0,0 +1,98 @@
+package mainpkg
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"xcoin/HayekTool/pkg/config"
+)
+
+func TestValidatePayoutsFile(t *testing.T) {
+	info := &PayoutsFile{
+		FeePercentage: 0.1,
+		EveryDatAt:    []string{"10:30", "24:00"},
+		Payouts: []PayoutElem{
+			{Name: "a", Address: "0x3eb41fc94f240242c9bbb8bf46b9feb356fd09e2", ValuePercentage: 0.5},
+			{Name: "b", Address: "0x3eB41Fc94f240242c9BBb8bf46b9feb356Fd09E2", ValuePercentage: 0.1},
+			{Name: "c", Address: "0x3eB41Fc94f240242c9BBb8bf46b9feb356Fd09e2", ValuePercentage: 0.6},
+		},
+	}
+
+	var fields []string
+	for _, e := range ValidatePayoutsFile(info, "") {
+		fields = append(fields, e.Field)
+	}
+
+	expect := []string{
+		"EveryDatAt[1]",
+		"Payouts[1].Address", // 重复
+		"Payouts[2].Address", // EIP-55 校验错误
+		"Payouts",            // 超过 100%
+	}
+	if !reflect.DeepEqual(fields, expect) {
+		t.Fatalf("expect = %v, got = %v", expect, fields)
+	}
+}
+
+func TestValidatePayoutsFileDefaultFrom(t *testing.T) {
+	const user = "0x3eb41fc94f240242c9bbb8bf46b9feb356fd09e2"
+	info := &PayoutsFile{
+		EveryDatAt: []string{"10:30"},
+		Payouts: []PayoutElem{
+			{Name: "a", Address: "0x5205f45c6399c41e11e533926ca69a0aedfdbb8d", ValuePercentage: 0.5},
+		},
+		Groups: []*PayoutsFile{{
+			Name:       "g",
+			EveryDatAt: []string{"10:30"},
+			From:       "0x3EB41FC94F240242C9BBB8BF46B9FEB356FD09E2",
+			Payouts: []PayoutElem{
+				{Name: "b", Address: "0x5205f45c6399c41e11e533926ca69a0aedfdbb8d", ValuePercentage: 0.5},
+			},
+		}},
+	}
+
+	// 顶层没有 From 时使用配置文件中的地址, 与分组重复
+	var fields []string
+	for _, e := range ValidatePayoutsFile(info, user) {
+		fields = append(fields, e.Field)
+	}
+	if expect := []string{"Groups[0].From"}; !reflect.DeepEqual(fields, expect) {
+		t.Fatalf("expect = %v, got = %v", expect, fields)
+	}
+}
+
+func TestCheckUnknownFields(t *testing.T) {
+	data := []byte(`{"Threshold": 1, "threshold2": 2, "Payouts": [{"Name": "a"}, {"name": "b", "Rate": 1}]}`)
+
+	var fields []string
+	for _, e := range checkUnknownFields(data, reflect.TypeOf(PayoutsFile{}), "") {
+		fields = append(fields, e.Field)
+	}
+
+	expect := []string{"Payouts[1].Rate", "threshold2"}
+	if !reflect.DeepEqual(fields, expect) {
+		t.Fatalf("expect = %v, got = %v", expect, fields)
+	}
+}
+
+func TestPayoutsFromAddresses(t *testing.T) {
+	const (
+		user = "0x3eb41fc94f240242c9bbb8bf46b9feb356fd09e2"
+		hot  = "0x5205f45c6399c41e11e533926ca69a0aedfdbb8d"
+	)
+	app := NewApp(&config.Config{UserAddress: user})
+	info := &PayoutsFile{
+		Payouts: []PayoutElem{{Name: "a", Address: hot, ValuePercentage: 0.5}},
+		Groups: []*PayoutsFile{
+			{Name: "hot", From: hot},
+			{Name: "user", From: strings.ToUpper(user[:2]) + user[2:]},
+		},
+	}
+
+	// 顶层默认使用 UserAddress, 重复的地址只导出一次
+	got := app.payoutsFromAddresses(info)
+	if expect := []string{user, hot}; !reflect.DeepEqual(got, expect) {
+		t.Fatalf("expect = %v, got = %v", expect, got)
+	}
+}
@@ -0,0 +1,126 @@
+package mainpkg
+
+import (
+	"fmt"
+	"log"
+	"strings"
+)
+
+// 没有名字的分组(顶层配置)
+const defaultPayoutsGroup = "default"
+
+// 返回全部支付分组
+//
+// 顶层配置了 Payouts 或 Pool 时作为默认分组, Groups 中的每一项是独立的分组,
+// 分组没有配置的 HistoryFile/Notify/审批设置使用顶层的, BalanceFile/Pool.StateFile 默认按分组名字区分.
+func (info *PayoutsFile) payoutsGroups() []*PayoutsFile {
+	var groups []*PayoutsFile
+
+	if len(info.Payouts) > 0 || info.Pool != nil {
+		top := *info
+		top.Groups = nil
+		groups = append(groups, &top)
+	}
+
+	for _, g := range info.Groups {
+		x := *g
+		if x.HistoryFile == "" {
+			x.HistoryFile = payoutsHistoryFile(info)
+		}
+		if x.Notify == nil {
+			x.Notify = info.Notify
+		}
+		if x.ApprovalLimit == 0 && len(x.Approvers) == 0 {
+			x.ApprovalLimit, x.Approvers = info.ApprovalLimit, info.Approvers
+		}
+		if x.PlanDir == "" {
+			x.PlanDir = info.PlanDir
+		}
+		if x.PlanTTL == 0 {
+			x.PlanTTL = info.PlanTTL
+		}
+		if x.BalanceFile == "" {
+			x.BalanceFile = groupFileName(DefaultPayoutsBalanceFile, x.Name)
+		}
+		if x.Pool != nil {
+			pool := *x.Pool
+			if pool.StateFile == "" {
+				pool.StateFile = groupFileName(DefaultPoolStateFile, x.Name)
+			}
+			x.Pool = &pool
+		}
+		groups = append(groups, &x)
+	}
+
+	return groups
+}
+
+// 全部分组的付款地址, 没有配置 From 时使用配置文件中的 UserAddress
+func (p *App) payoutsFromAddresses(info *PayoutsFile) []string {
+	var (
+		addresses []string
+		seen      = make(map[string]bool)
+	)
+	for _, g := range info.payoutsGroups() {
+		from := g.From
+		if from == "" {
+			from = p.cfg.UserAddress
+		}
+		if from != "" && !seen[strings.ToLower(from)] {
+			seen[strings.ToLower(from)] = true
+			addresses = append(addresses, from)
+		}
+	}
+	return addresses
+}
+
+// 按名字查找分组
+func (info *PayoutsFile) payoutsGroup(name string) *PayoutsFile {
+	for _, g := range info.payoutsGroups() {
+		if g.groupName() == name {
+			return g
+		}
+	}
+	return nil
+}
+
+func (info *PayoutsFile) groupName() string {
+	if info.Name == "" {
+		return defaultPayoutsGroup
+	}
+	return info.Name
+}
+
+// 带分组名字的日志
+func (info *PayoutsFile) logf(format string, a ...interface{}) {
+	log.Printf("payouts[%s]: %s", info.groupName(), fmt.Sprintf(format, a...))
+}
+
+// payouts-balances.json => payouts-balances.name.json
+func groupFileName(path, name string) string {
+	if i := strings.LastIndex(path, "."); i > 0 {
+		return path[:i] + "." + name + path[i:]
+	}
+	return path + "." + name
+}
+
+// 分组的付款钱包
+func (p *App) payoutsWallet(info *PayoutsFile) (*Wallet, error) {
+	from := info.From
+	if from == "" {
+		from = p.cfg.UserAddress
+	}
+
+	if info.KeyRef == "" {
+		if !strings.EqualFold(from, p.cfg.UserAddress) {
+			return nil, fmt.Errorf("payouts[%s]: KeyRef required for %s", info.groupName(), from)
+		}
+		return NewWallet(from, p.cfg.UserKey)
+	}
+
+	key, err := ResolveKeyRef(info.KeyRef)
+	if err != nil {
+		return nil, fmt.Errorf("payouts[%s]: %v", info.groupName(), err)
+	}
+	return NewWallet(from, key)
+}
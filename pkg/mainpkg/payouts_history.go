@@ -0,0 +1,374 @@
+package mainpkg
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/big"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"xcoin/HayekTool/pkg/rpc"
+	"xcoin/HayekTool/pkg/util"
+)
+
+const DefaultPayoutsHistoryFile = "payouts-history.jsonl"
+
+// 一次支付任务的记录(历史文件中每行一个)
+type PayoutsRun struct {
+	Id        string           // 任务编号
+	Group     string           // 分组名字
+	StartAt   time.Time        // 开始时间
+	From      string           // 支付地址
+	Balance   *big.Int         // 支付前余额(wei)
+	Fee       *big.Int         // 保留的费用(wei)
+	Transfers []PayoutTransfer // 转账列表
+	Plan      string           `json:",omitempty"` // 需要审批时的计划文件
+	Err       string           // 任务错误
+}
+
+// 一笔转账
+type PayoutTransfer struct {
+	Name     string
+	Address  string
+	Value    *big.Int // 金额(wei)
+	GasPrice *big.Int
+	TxHash   string
+	Err      string   // 发送失败的原因
+	Carried  *big.Int // 本次之后累计未付的金额(wei)
+}
+
+func payoutsHistoryFile(info *PayoutsFile) string {
+	if info != nil && info.HistoryFile != "" {
+		return info.HistoryFile
+	}
+	return DefaultPayoutsHistoryFile
+}
+
+// 追加一条任务记录
+func (p *App) savePayoutsRun(info *PayoutsFile, run *PayoutsRun) error {
+	data, err := json.Marshal(run)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(payoutsHistoryFile(info), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err = f.Write(append(data, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// 读取 [from, to) 时间范围内的任务记录
+func loadPayoutsRuns(historyFile string, from, to time.Time) ([]*PayoutsRun, error) {
+	f, err := os.Open(historyFile)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var runs []*PayoutsRun
+
+	r := bufio.NewReader(f)
+	for lineno := 1; ; lineno++ {
+		line, err := r.ReadBytes('\n')
+		if len(strings.TrimSpace(string(line))) > 0 {
+			var run PayoutsRun
+			if err := json.Unmarshal(line, &run); err != nil {
+				return nil, fmt.Errorf("%s:%d: %v", historyFile, lineno, err)
+			}
+			if !run.StartAt.Before(from) && (to.IsZero() || run.StartAt.Before(to)) {
+				runs = append(runs, &run)
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return runs, nil
+}
+
+// 支付报表
+type PayoutsReport struct {
+	From        time.Time
+	To          time.Time
+	Runs        int
+	FeeHeldBack *big.Int // 保留的费用合计(wei)
+	GasSpent    *big.Int // 已上链交易的 Gas 费用合计(wei)
+	Recipients  []*PayoutsRecipientTotal
+	Transfers   []*PayoutsReportTransfer
+}
+
+// 每个客户的合计
+type PayoutsRecipientTotal struct {
+	Name      string
+	Address   string
+	Total     *big.Int // 已确认的金额合计(wei)
+	GasSpent  *big.Int
+	Carried   *big.Int // 截止报表结束时累计未付的金额(wei)
+	Transfers int
+	Pending   int
+	Failed    int
+}
+
+// 报表中的一笔转账, 状态以链上数据为准
+type PayoutsReportTransfer struct {
+	RunId       string
+	Group       string
+	RunAt       time.Time
+	Name        string
+	Address     string
+	Value       *big.Int
+	TxHash      string
+	Status      string // confirmed/reverted/pending/failed/mismatch/carried/planned
+	BlockNumber int64
+	BlockHash   string
+	BlockTime   time.Time
+	GasUsed     int64
+	GasCost     *big.Int
+	Carried     *big.Int
+	Err         string
+}
+
+const (
+	payoutStatusConfirmed = "confirmed" // 上链成功
+	payoutStatusReverted  = "reverted"  // 上链但执行失败
+	payoutStatusPending   = "pending"   // 没有查到回执
+	payoutStatusFailed    = "failed"    // 发送失败
+	payoutStatusMismatch  = "mismatch"  // 回执的区块和链上区块不一致
+	payoutStatusCarried   = "carried"   // 不足最小支付金额, 累计到下次
+	payoutStatusPlanned   = "planned"   // 超过审批限制, 等待审批
+)
+
+// 生成 [from, to) 时间范围内的支付报表
+func (p *App) CmdPayoutsReport(historyFile string, from, to time.Time, format string) error {
+	runs, err := loadPayoutsRuns(historyFile, from, to)
+	if err != nil {
+		return err
+	}
+
+	c, err := p.rpcClient(time.Second * 3)
+	if err != nil {
+		return err
+	}
+
+	report, err := p.buildPayoutsReport(c, runs)
+	if err != nil {
+		return err
+	}
+	report.From, report.To = from, to
+
+	switch format {
+	case "json", "":
+		s, _ := json.MarshalIndent(report, "", "\t")
+		fmt.Println(string(s))
+		return nil
+	case "csv":
+		return report.writeCSV(os.Stdout)
+	default:
+		return fmt.Errorf("unknown format %q", format)
+	}
+}
+
+func (p *App) buildPayoutsReport(c *rpc.RPCClient, runs []*PayoutsRun) (*PayoutsReport, error) {
+	report := &PayoutsReport{
+		Runs:        len(runs),
+		FeeHeldBack: new(big.Int),
+		GasSpent:    new(big.Int),
+	}
+
+	var (
+		recipients = make(map[string]*PayoutsRecipientTotal)
+		blocks     = make(map[int64]*rpc.GetBlockReply)
+	)
+
+	for _, run := range runs {
+		if run.Fee != nil {
+			report.FeeHeldBack.Add(report.FeeHeldBack, run.Fee)
+		}
+
+		for _, t := range run.Transfers {
+			x := &PayoutsReportTransfer{
+				RunId:   run.Id,
+				Group:   run.Group,
+				RunAt:   run.StartAt,
+				Name:    t.Name,
+				Address: t.Address,
+				Value:   t.Value,
+				TxHash:  t.TxHash,
+				GasCost: new(big.Int),
+				Carried: t.Carried,
+				Err:     t.Err,
+			}
+			if x.Value == nil {
+				x.Value = new(big.Int)
+			}
+			if x.Carried == nil {
+				x.Carried = new(big.Int)
+			}
+			if err := p.verifyPayoutTransfer(c, blocks, t, x); err != nil {
+				return nil, err
+			}
+			report.Transfers = append(report.Transfers, x)
+
+			key := strings.ToLower(t.Address)
+			total, ok := recipients[key]
+			if !ok {
+				total = &PayoutsRecipientTotal{
+					Name:     t.Name,
+					Address:  t.Address,
+					Total:    new(big.Int),
+					GasSpent: new(big.Int),
+					Carried:  new(big.Int),
+				}
+				recipients[key] = total
+				report.Recipients = append(report.Recipients, total)
+			}
+
+			total.Carried = x.Carried
+			if x.Status == payoutStatusCarried || x.Status == payoutStatusPlanned {
+				continue
+			}
+
+			total.Transfers++
+			total.GasSpent.Add(total.GasSpent, x.GasCost)
+			report.GasSpent.Add(report.GasSpent, x.GasCost)
+
+			switch x.Status {
+			case payoutStatusConfirmed:
+				total.Total.Add(total.Total, x.Value)
+			case payoutStatusPending:
+				total.Pending++
+			default:
+				total.Failed++
+			}
+		}
+	}
+
+	sort.SliceStable(report.Recipients, func(i, j int) bool {
+		return report.Recipients[i].Name < report.Recipients[j].Name
+	})
+
+	return report, nil
+}
+
+// 通过回执和区块核对转账状态
+func (p *App) verifyPayoutTransfer(
+	c *rpc.RPCClient, blocks map[int64]*rpc.GetBlockReply,
+	t PayoutTransfer, x *PayoutsReportTransfer,
+) error {
+	if t.TxHash == "" {
+		switch {
+		case t.Err != "":
+			x.Status = payoutStatusFailed
+		case t.Value != nil && t.Value.Sign() > 0:
+			x.Status = payoutStatusPlanned
+		default:
+			x.Status = payoutStatusCarried
+		}
+		return nil
+	}
+
+	receipt, err := c.GetTxReceipt(t.TxHash)
+	if err != nil {
+		return fmt.Errorf("GetTxReceipt(%s): %v", t.TxHash, err)
+	}
+	if receipt == nil || !receipt.Confirmed() {
+		x.Status = payoutStatusPending
+		return nil
+	}
+
+	x.BlockNumber = util.String2Big(receipt.BlockNumber).Int64()
+	x.BlockHash = receipt.BlockHash
+	x.GasUsed = util.String2Big(receipt.GasUsed).Int64()
+	if t.GasPrice != nil {
+		x.GasCost = new(big.Int).Mul(big.NewInt(x.GasUsed), t.GasPrice)
+	}
+
+	block, ok := blocks[x.BlockNumber]
+	if !ok {
+		if block, err = c.GetBlockByHeight(x.BlockNumber, false); err != nil {
+			return fmt.Errorf("GetBlockByHeight(%d): %v", x.BlockNumber, err)
+		}
+		blocks[x.BlockNumber] = block
+	}
+
+	switch {
+	case block == nil || !strings.EqualFold(block.Hash, receipt.BlockHash):
+		// 交易所在的区块已经不在主链上
+		x.Status = payoutStatusMismatch
+	case !receipt.Successful():
+		x.Status = payoutStatusReverted
+	default:
+		x.Status = payoutStatusConfirmed
+	}
+	if block != nil {
+		x.BlockTime = time.Unix(util.String2Big(block.Timestamp).Int64(), 0)
+	}
+
+	return nil
+}
+
+func (r *PayoutsReport) writeCSV(w io.Writer) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{
+		"type", "run_id", "group", "run_at", "name", "address",
+		"value_wei", "value_hyk", "tx_hash", "status",
+		"block_number", "block_hash", "block_time",
+		"gas_used", "gas_cost_wei", "carried_wei", "transfers", "pending", "failed", "error",
+	})
+
+	for _, x := range r.Transfers {
+		var blockNumber, blockTime string
+		if x.BlockHash != "" {
+			blockNumber = fmt.Sprint(x.BlockNumber)
+			blockTime = x.BlockTime.Format(time.RFC3339)
+		}
+		cw.Write([]string{
+			"transfer", x.RunId, x.Group, x.RunAt.Format(time.RFC3339), x.Name, x.Address,
+			x.Value.String(), formatWei(x.Value), x.TxHash, x.Status,
+			blockNumber, x.BlockHash, blockTime,
+			fmt.Sprint(x.GasUsed), x.GasCost.String(), x.Carried.String(), "", "", "", x.Err,
+		})
+	}
+	for _, x := range r.Recipients {
+		cw.Write([]string{
+			"recipient", "", "", "", x.Name, x.Address,
+			x.Total.String(), formatWei(x.Total), "", "",
+			"", "", "",
+			"", x.GasSpent.String(), x.Carried.String(), fmt.Sprint(x.Transfers), fmt.Sprint(x.Pending), fmt.Sprint(x.Failed), "",
+		})
+	}
+	cw.Write([]string{
+		"fee", "", "", "", "", "",
+		r.FeeHeldBack.String(), formatWei(r.FeeHeldBack), "", "",
+		"", "", "",
+		"", "", "", "", "", "", "",
+	})
+	cw.Write([]string{
+		"gas", "", "", "", "", "",
+		"", "", "", "",
+		"", "", "",
+		"", r.GasSpent.String(), "", "", "", "", "",
+	})
+
+	cw.Flush()
+	return cw.Error()
+}
+
+// wei 转换为 HYK 字符串
+func formatWei(v *big.Int) string {
+	return util.FormatRatReward(new(big.Rat).SetInt(v))
+}
@@ -0,0 +1,76 @@
+package mainpkg
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"xcoin/HayekTool/pkg/notify"
+)
+
+// 发送支付任务的结果通知
+func (p *App) notifyPayoutsRun(info *PayoutsFile, run *PayoutsRun) {
+	if info.Notify == nil {
+		return
+	}
+	if err := notify.NewDispatcher(info.Notify).Notify(newPayoutsEvent(run)); err != nil {
+		info.logf("notify failed: %v", err)
+	}
+}
+
+func newPayoutsEvent(run *PayoutsRun) *notify.Event {
+	e := &notify.Event{
+		Type:   notify.EventPayoutsSuccess,
+		Source: "HayekTool",
+		Time:   time.Now(),
+		Data:   run,
+	}
+
+	var (
+		buf  strings.Builder
+		sent int
+	)
+	fmt.Fprintf(&buf, "group: %s\n", run.Group)
+	fmt.Fprintf(&buf, "run: %s\n", run.Id)
+	fmt.Fprintf(&buf, "from: %s\n", run.From)
+	if run.Balance != nil {
+		fmt.Fprintf(&buf, "balance: %s HYK\n", formatWei(run.Balance))
+	}
+	if run.Fee != nil {
+		fmt.Fprintf(&buf, "fee: %s HYK\n", formatWei(run.Fee))
+	}
+	if run.Err != "" {
+		fmt.Fprintf(&buf, "error: %s\n", run.Err)
+	}
+	buf.WriteString("\n")
+
+	for _, t := range run.Transfers {
+		switch {
+		case t.Err != "":
+			fmt.Fprintf(&buf, "FAILED  %s(%s) %s HYK: %s\n", t.Name, t.Address, formatWei(t.Value), t.Err)
+		case t.TxHash == "" && t.Value != nil && t.Value.Sign() > 0:
+			fmt.Fprintf(&buf, "PLANNED %s(%s) %s HYK\n", t.Name, t.Address, formatWei(t.Value))
+		case t.TxHash == "":
+			fmt.Fprintf(&buf, "CARRIED %s(%s) %s HYK\n", t.Name, t.Address, formatWei(t.Carried))
+		default:
+			fmt.Fprintf(&buf, "SENT    %s(%s) %s HYK, txHash: %s\n", t.Name, t.Address, formatWei(t.Value), t.TxHash)
+			sent++
+		}
+	}
+	if run.Plan != "" {
+		fmt.Fprintf(&buf, "\nplan: %s\n", run.Plan)
+	}
+	e.Text = buf.String()
+
+	switch {
+	case run.Err != "":
+		e.Type = notify.EventPayoutsFailure
+		e.Subject = fmt.Sprintf("[HayekTool] payouts[%s] %s failed: %s", run.Group, run.Id, run.Err)
+	case run.Plan != "" && sent == 0:
+		e.Type = notify.EventPayoutsApproval
+		e.Subject = fmt.Sprintf("[HayekTool] payouts[%s] %s waiting for approval: %s", run.Group, run.Id, run.Plan)
+	default:
+		e.Subject = fmt.Sprintf("[HayekTool] payouts[%s] %s ok: %d transfer(s) sent", run.Group, run.Id, sent)
+	}
+	return e
+}
@@ -0,0 +1,396 @@
+package mainpkg
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"xcoin/HayekTool/pkg/rpc"
+	"xcoin/HayekTool/pkg/util"
+)
+
+// 矿池分配方式
+const (
+	PoolSchemePROP  = "PROP"  // 按本轮(两次出块之间)的份额比例分配
+	PoolSchemePPLNS = "PPLNS" // 按出块之前最近N个份额的比例分配
+)
+
+const (
+	DefaultPoolMaturity  = 60
+	DefaultPoolStateFile = "payouts-pool.json"
+
+	poolStateCredits = 1000 // 进度文件中保留的最近奖励记录数
+)
+
+// 矿池模式: 根据付款地址挖出的区块和份额日志计算每个矿工的收益
+//
+// 计算出的收益记入累计余额(BalanceFile), 达到最小支付金额后支付.
+type PoolConfig struct {
+	Scheme       string  // PROP 或 PPLNS
+	ShareLogFile string  // 份额日志, 每行: unix时间戳,矿工地址,份额难度
+	PPLNSWindow  int64   // PPLNS 使用的最近份额数目
+	BlockReward  float64 // 区块奖励(HYK), 叔块和引用叔块的奖励按以太坊规则计算
+	Maturity     int64   // 成熟需要的确认数, 默认60
+	StartHeight  int64   // 第一次扫描的起始高度, 为 0 时从当前成熟的高度开始, 不补算历史区块
+	StateFile    string  // 扫描进度文件, 默认 payouts-pool.json
+}
+
+// 一个份额
+type PoolShare struct {
+	Time       int64
+	Miner      string
+	Difficulty *big.Int
+}
+
+// 矿池获得的一笔奖励(区块或者叔块)
+type PoolCredit struct {
+	Height int64    // 包含该奖励的区块高度
+	Hash   string   // 区块或叔块的 hash
+	Uncle  bool     // 是否为叔块
+	Time   int64    // 出块时间
+	Reward *big.Int // 奖励(wei)
+}
+
+// 扫描进度
+type poolState struct {
+	LastHeight    int64         // 已处理的最高区块, 小于 0 表示还没有开始扫描
+	LastRoundTime int64         // PROP: 上一轮结束的时间
+	Credits       []*PoolCredit // 最近的奖励记录, 仅供查看
+}
+
+func (c *PoolConfig) maturity() int64 {
+	if c.Maturity > 0 {
+		return c.Maturity
+	}
+	return DefaultPoolMaturity
+}
+
+func (c *PoolConfig) stateFile() string {
+	if c.StateFile != "" {
+		return c.StateFile
+	}
+	return DefaultPoolStateFile
+}
+
+func loadPoolState(path string, startHeight int64) (*poolState, error) {
+	state := &poolState{LastHeight: startHeight - 1}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return state, nil
+		}
+		return nil, err
+	}
+	if err := json.Unmarshal(data, state); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return state, nil
+}
+
+func (s *poolState) save(path string) error {
+	data, err := json.MarshalIndent(s, "", "\t")
+	if err != nil {
+		return err
+	}
+	return util.WriteFileAtomic(path, data, 0644)
+}
+
+// 读取份额日志
+func loadPoolShares(path string) ([]*PoolShare, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var shares []*PoolShare
+
+	scanner := bufio.NewScanner(f)
+	for lineno := 1; scanner.Scan(); lineno++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.Split(line, ",")
+		if len(fields) != 3 {
+			return nil, fmt.Errorf("%s:%d: expect timestamp,address,difficulty", path, lineno)
+		}
+
+		t, err := strconv.ParseInt(strings.TrimSpace(fields[0]), 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: invalid timestamp: %v", path, lineno, err)
+		}
+		miner := strings.TrimSpace(fields[1])
+		if !util.IsValidHexAddress(miner) {
+			return nil, fmt.Errorf("%s:%d: invalid address %q", path, lineno, miner)
+		}
+		diff, ok := new(big.Int).SetString(strings.TrimSpace(fields[2]), 0)
+		if !ok || diff.Sign() <= 0 {
+			return nil, fmt.Errorf("%s:%d: invalid difficulty %q", path, lineno, fields[2])
+		}
+
+		shares = append(shares, &PoolShare{Time: t, Miner: strings.ToLower(miner), Difficulty: diff})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	sort.SliceStable(shares, func(i, j int) bool {
+		return shares[i].Time < shares[j].Time
+	})
+	return shares, nil
+}
+
+// 扫描 (state.LastHeight, latest-Maturity] 范围内 miner 获得的奖励
+func scanPoolCredits(c *rpc.RPCClient, cfg *PoolConfig, miner string, state *poolState) ([]*PoolCredit, int64, error) {
+	latest, err := c.GetLatestBlock(false)
+	if err != nil {
+		return nil, 0, err
+	}
+	if latest == nil {
+		return nil, 0, fmt.Errorf("no latest block")
+	}
+
+	var (
+		matureHeight = util.String2Big(latest.Number).Int64() - cfg.maturity()
+		blockReward  = etherToWei(cfg.BlockReward)
+		credits      []*PoolCredit
+	)
+
+	// 没有配置起始高度时第一次只记录进度, 不从创世区块开始扫描
+	if state.LastHeight < 0 && cfg.StartHeight <= 0 {
+		return nil, matureHeight, nil
+	}
+
+	for h := state.LastHeight + 1; h <= matureHeight; h++ {
+		block, err := c.GetBlockByHeight(h, true)
+		if err != nil {
+			return nil, 0, fmt.Errorf("GetBlockByHeight(%d): %v", h, err)
+		}
+		if block == nil {
+			return nil, 0, fmt.Errorf("GetBlockByHeight(%d): not found", h)
+		}
+
+		if strings.EqualFold(block.Miner, miner) {
+			reward, err := poolBlockReward(c, block, blockReward)
+			if err != nil {
+				return nil, 0, err
+			}
+			credits = append(credits, &PoolCredit{
+				Height: h,
+				Hash:   block.Hash,
+				Time:   util.String2Big(block.Timestamp).Int64(),
+				Reward: reward,
+			})
+		}
+
+		for i := range block.Uncles {
+			uncle, err := c.GetUncleByBlockNumberAndIndex(h, i)
+			if err != nil {
+				return nil, 0, fmt.Errorf("GetUncleByBlockNumberAndIndex(%d, %d): %v", h, i, err)
+			}
+			if uncle == nil || !strings.EqualFold(uncle.Miner, miner) {
+				continue
+			}
+
+			// 叔块奖励: (叔块高度 + 8 - 区块高度) * 区块奖励 / 8
+			depth := util.String2Big(uncle.Number).Int64() + 8 - h
+			if depth <= 0 {
+				continue
+			}
+			reward := new(big.Int).Mul(blockReward, big.NewInt(depth))
+			reward.Div(reward, big.NewInt(8))
+
+			credits = append(credits, &PoolCredit{
+				Height: h,
+				Hash:   uncle.Hash,
+				Uncle:  true,
+				Time:   util.String2Big(uncle.Timestamp).Int64(),
+				Reward: reward,
+			})
+		}
+	}
+
+	return credits, matureHeight, nil
+}
+
+// 区块奖励 + 引用叔块的奖励 + 交易手续费
+func poolBlockReward(c *rpc.RPCClient, block *rpc.GetBlockReply, blockReward *big.Int) (*big.Int, error) {
+	reward := new(big.Int).Set(blockReward)
+
+	nephew := new(big.Int).Div(blockReward, big.NewInt(32))
+	reward.Add(reward, nephew.Mul(nephew, big.NewInt(int64(len(block.Uncles)))))
+
+	for _, tx := range block.Transactions {
+		receipt, err := c.GetTxReceipt(tx.Hash)
+		if err != nil {
+			return nil, fmt.Errorf("GetTxReceipt(%s): %v", tx.Hash, err)
+		}
+		if receipt == nil {
+			continue
+		}
+		fee := new(big.Int).Mul(util.String2Big(receipt.GasUsed), util.String2Big(tx.GasPrice))
+		reward.Add(reward, fee)
+	}
+
+	return reward, nil
+}
+
+// 按份额计算每个矿工的收益, 返回 map[矿工地址]wei
+//
+// roundStart 是 PROP 本轮开始的时间(不包含), 矿池费用按 feePercentage 保留.
+func allocatePoolCredit(
+	scheme string, window int64, feePercentage float64,
+	shares []*PoolShare, credit *PoolCredit, roundStart int64,
+) map[string]*big.Int {
+	var selected []*PoolShare
+
+	switch scheme {
+	case PoolSchemePROP:
+		for _, s := range shares {
+			if s.Time > roundStart && s.Time <= credit.Time {
+				selected = append(selected, s)
+			}
+		}
+	case PoolSchemePPLNS:
+		// shares 已按时间排序, 取 credit.Time 之前最近的 window 个
+		end := sort.Search(len(shares), func(i int) bool {
+			return shares[i].Time > credit.Time
+		})
+		start := end - int(window)
+		if start < 0 {
+			start = 0
+		}
+		selected = shares[start:end]
+	}
+
+	var (
+		total  = new(big.Int)
+		miners = make(map[string]*big.Int)
+	)
+	for _, s := range selected {
+		if _, ok := miners[s.Miner]; !ok {
+			miners[s.Miner] = new(big.Int)
+		}
+		miners[s.Miner].Add(miners[s.Miner], s.Difficulty)
+		total.Add(total, s.Difficulty)
+	}
+	if total.Sign() == 0 {
+		return nil
+	}
+
+	reward := new(big.Int).Sub(credit.Reward, mulRat(credit.Reward, floatRat(feePercentage)))
+
+	amounts := make(map[string]*big.Int)
+	for miner, diff := range miners {
+		v := new(big.Int).Mul(reward, diff)
+		amounts[miner] = v.Div(v, total)
+	}
+	return amounts
+}
+
+// 把奖励按份额记入矿工余额, 返回保留的矿池费用
+//
+// PROP 中同一高度的区块和叔块属于同一轮, 叔块的时间一般早于引用它的区块,
+// 所以按该高度之前的本轮开始时间分配, 处理完区块之后才开始新的一轮.
+func creditPoolRewards(info *PayoutsFile, state *poolState, shares []*PoolShare, balances PayoutsBalances, credits []*PoolCredit) *big.Int {
+	var (
+		pool        = info.Pool
+		fee         = new(big.Int)
+		roundHeight = int64(-1)
+		roundStart  int64
+	)
+	for _, credit := range credits {
+		if credit.Height != roundHeight {
+			roundHeight, roundStart = credit.Height, state.LastRoundTime
+		}
+		if !credit.Uncle {
+			state.LastRoundTime = credit.Time
+		}
+
+		// 上次记入余额后没有保存进度就退出了, 不能重复记账
+		if _, ok := balances.Credited[strings.ToLower(credit.Hash)]; ok {
+			info.logf("pool: height %d, hash %s already credited", credit.Height, credit.Hash)
+			continue
+		}
+		balances.Credited[strings.ToLower(credit.Hash)] = credit.Height
+
+		amounts := allocatePoolCredit(pool.Scheme, pool.PPLNSWindow, info.FeePercentage, shares, credit, roundStart)
+		if amounts == nil {
+			info.logf("pool: no shares for block %d(%s), reward kept", credit.Height, credit.Hash)
+		}
+
+		distributed := new(big.Int)
+		for miner, v := range amounts {
+			owed := balances.owed(miner)
+			balances.set(miner, miner, owed.Add(owed, v))
+			distributed.Add(distributed, v)
+		}
+		fee.Add(fee, new(big.Int).Sub(credit.Reward, distributed))
+
+		info.logf("pool: height %d, hash %s, uncle %v, reward %s HYK",
+			credit.Height, credit.Hash, credit.Uncle, formatWei(credit.Reward),
+		)
+	}
+	return fee
+}
+
+// 矿池模式的支付任务: 扫描新成熟的奖励, 记入矿工余额, 然后支付达到最小金额的余额
+func (p *App) doPoolPayoutsTask(c *rpc.RPCClient, w *Wallet, info *PayoutsFile, run *PayoutsRun) error {
+	pool := info.Pool
+
+	state, err := loadPoolState(pool.stateFile(), pool.StartHeight)
+	if err != nil {
+		return err
+	}
+	shares, err := loadPoolShares(pool.ShareLogFile)
+	if err != nil {
+		return err
+	}
+	balances, err := loadPayoutsBalances(payoutsBalanceFile(info))
+	if err != nil {
+		return err
+	}
+
+	credits, matureHeight, err := scanPoolCredits(c, pool, w.Address, state)
+	if err != nil {
+		return err
+	}
+
+	// 进度已经保存, 之前的记账记录不会再被扫描到
+	for hash, height := range balances.Credited {
+		if height <= state.LastHeight {
+			delete(balances.Credited, hash)
+		}
+	}
+
+	run.Fee = creditPoolRewards(info, state, shares, balances, credits)
+	if matureHeight > state.LastHeight {
+		state.LastHeight = matureHeight
+	}
+	state.Credits = append(state.Credits, credits...)
+	if n := len(state.Credits); n > poolStateCredits {
+		state.Credits = state.Credits[n-poolStateCredits:]
+	}
+
+	// 先保存余额和记账记录再保存进度, 中途退出时重新扫描到的奖励按 hash 跳过
+	if err := balances.save(payoutsBalanceFile(info)); err != nil {
+		return err
+	}
+	if err := state.save(pool.stateFile()); err != nil {
+		return err
+	}
+
+	minPayout := etherToWei(info.MinPayout)
+	return p.payOwedBalances(c, w, info, balances, run, func(*PayoutBalance) *big.Int {
+		return minPayout
+	})
+}
@@ -0,0 +1,174 @@
+package mainpkg
+
+import (
+	"io/ioutil"
+	"math/big"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"xcoin/HayekTool/pkg/config"
+	"xcoin/HayekTool/pkg/mocknode"
+	"xcoin/HayekTool/pkg/rpc"
+)
+
+func TestAllocatePoolCredit(t *testing.T) {
+	const (
+		a = "0x00000000000000000000000000000000000000aa"
+		b = "0x00000000000000000000000000000000000000bb"
+	)
+	shares := []*PoolShare{
+		{Time: 100, Miner: a, Difficulty: big.NewInt(1)},
+		{Time: 110, Miner: b, Difficulty: big.NewInt(1)},
+		{Time: 120, Miner: a, Difficulty: big.NewInt(2)},
+		{Time: 130, Miner: b, Difficulty: big.NewInt(4)},
+		{Time: 140, Miner: a, Difficulty: big.NewInt(8)},
+	}
+	credit := &PoolCredit{Time: 130, Reward: big.NewInt(1000)}
+
+	tests := []struct {
+		name       string
+		scheme     string
+		window     int64
+		fee        float64
+		roundStart int64
+		expect     map[string]int64
+	}{
+		// 本轮(105, 130]: a=2, b=5
+		{"PROP", PoolSchemePROP, 0, 0, 105, map[string]int64{a: 285, b: 714}},
+		// 最近3个: a=2, b=5, 保留10%
+		{"PPLNS", PoolSchemePPLNS, 3, 0.1, 0, map[string]int64{a: 257, b: 642}},
+		// 窗口大于份额数目: a=3, b=5
+		{"PPLNS all", PoolSchemePPLNS, 100, 0, 0, map[string]int64{a: 375, b: 625}},
+		// 本轮没有份额
+		{"PROP empty", PoolSchemePROP, 0, 0, 130, nil},
+	}
+
+	for _, tt := range tests {
+		got := allocatePoolCredit(tt.scheme, tt.window, tt.fee, shares, credit, tt.roundStart)
+		if len(got) != len(tt.expect) {
+			t.Errorf("%s: expect = %v, got = %v", tt.name, tt.expect, got)
+			continue
+		}
+		for miner, v := range tt.expect {
+			if got[miner] == nil || got[miner].Int64() != v {
+				t.Errorf("%s: %s: expect = %d, got = %v", tt.name, miner, v, got[miner])
+			}
+		}
+	}
+}
+
+func TestPoolPayoutsCreditOnce(t *testing.T) {
+	dir, err := ioutil.TempDir("", "payouts-pool")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	const miner = "0x00000000000000000000000000000000000000aa"
+	addr, key := newTestKey(t)
+	node := mocknode.New(mocknode.Config{Coinbase: addr, Difficulty: big.NewInt(1)})
+	server := httptest.NewServer(node)
+	defer server.Close()
+	for i := 0; i < 3; i++ {
+		node.Mine()
+	}
+
+	app := NewApp(&config.Config{Host: server.URL})
+	client, _ := rpc.NewRPCClient("HayekTool", server.URL, time.Second)
+	wallet, _ := NewWallet(addr, key)
+
+	shareLog := filepath.Join(dir, "shares.csv")
+	if err := ioutil.WriteFile(shareLog, []byte("0,"+miner+",1\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	info := &PayoutsFile{
+		MinPayout:   100,
+		BalanceFile: filepath.Join(dir, "balances.json"),
+		Pool: &PoolConfig{
+			Scheme:       PoolSchemePPLNS,
+			ShareLogFile: shareLog,
+			PPLNSWindow:  10,
+			BlockReward:  1,
+			Maturity:     1,
+		},
+	}
+	owed := func() *big.Int {
+		balances, err := loadPayoutsBalances(info.BalanceFile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return balances.owed(miner)
+	}
+
+	// 没有配置起始高度时第一次只记录进度
+	info.Pool.StateFile = filepath.Join(dir, "first.json")
+	if err := app.doPoolPayoutsTask(client, wallet, info, &PayoutsRun{}); err != nil {
+		t.Fatal(err)
+	}
+	if v := owed(); v.Sign() != 0 {
+		t.Fatalf("owed after first run without start height: %v", v)
+	}
+
+	// 余额已经保存但进度没有保存, 重新扫描时不能重复记账
+	info.Pool.StartHeight = 1
+	info.Pool.StateFile = filepath.Join(dir, "missing", "state.json")
+	if err := app.doPoolPayoutsTask(client, wallet, info, &PayoutsRun{}); err == nil {
+		t.Fatal("expect error saving state")
+	}
+	if v := owed(); v.Cmp(etherToWei(2)) != 0 {
+		t.Fatalf("owed after interrupted run: %v", v)
+	}
+
+	info.Pool.StateFile = filepath.Join(dir, "state.json")
+	for i := 0; i < 2; i++ {
+		if err := app.doPoolPayoutsTask(client, wallet, info, &PayoutsRun{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if v := owed(); v.Cmp(etherToWei(2)) != 0 {
+		t.Fatalf("owed after rerun: %v", v)
+	}
+
+	// 进度保存后过期的记账记录被清理
+	node.Mine()
+	if err := app.doPoolPayoutsTask(client, wallet, info, &PayoutsRun{}); err != nil {
+		t.Fatal(err)
+	}
+	balances, _ := loadPayoutsBalances(info.BalanceFile)
+	if v := balances.owed(miner); v.Cmp(etherToWei(3)) != 0 || len(balances.Credited) != 1 {
+		t.Fatalf("owed %v, credited %v", v, balances.Credited)
+	}
+}
+
+func TestCreditPoolRewardsUncle(t *testing.T) {
+	const (
+		a = "0x00000000000000000000000000000000000000aa"
+		b = "0x00000000000000000000000000000000000000bb"
+	)
+	shares := []*PoolShare{
+		{Time: 100, Miner: a, Difficulty: big.NewInt(1)},
+		{Time: 110, Miner: b, Difficulty: big.NewInt(1)},
+		{Time: 130, Miner: a, Difficulty: big.NewInt(2)},
+	}
+	// 区块 10 引用了时间更早的叔块, 区块 11 属于下一轮
+	credits := []*PoolCredit{
+		{Height: 10, Hash: "0x10", Time: 120, Reward: big.NewInt(1000)},
+		{Height: 10, Hash: "0x0f", Uncle: true, Time: 115, Reward: big.NewInt(800)},
+		{Height: 11, Hash: "0x11", Time: 140, Reward: big.NewInt(1000)},
+	}
+	info := &PayoutsFile{Pool: &PoolConfig{Scheme: PoolSchemePROP}}
+	state := &poolState{LastRoundTime: 90}
+	balances := PayoutsBalances{Balances: make(map[string]*PayoutBalance), Credited: make(map[string]int64)}
+
+	// 区块和叔块按 (90, 120] 和 (90, 115] 分给 a 和 b, 区块 11 按 (120, 140] 全部给 a
+	fee := creditPoolRewards(info, state, shares, balances, credits)
+	if fee.Sign() != 0 || state.LastRoundTime != 140 {
+		t.Fatalf("fee = %v, last round = %d", fee, state.LastRoundTime)
+	}
+	if va, vb := balances.owed(a), balances.owed(b); va.Int64() != 1900 || vb.Int64() != 900 {
+		t.Fatalf("a = %v, b = %v", va, vb)
+	}
+}
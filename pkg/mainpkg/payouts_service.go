@@ -0,0 +1,335 @@
+package mainpkg
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"xcoin/HayekTool/pkg/clockwork"
+	"xcoin/HayekTool/pkg/config"
+	"xcoin/HayekTool/pkg/lock"
+)
+
+// 检查配置文件是否变化的周期
+const payoutsWatchInterval = time.Second * 2
+
+// 检查已审批计划的周期, 审批后不需要等到下一次定时任务
+const payoutsPlanInterval = time.Second * 30
+
+// 支付服务, 支持在不重启的情况下重新加载支付文件和配置文件
+//
+// 文件修改(轮询修改时间)或者收到 SIGHUP 时重新加载, 新文件必须通过检查,
+// 否则继续使用之前的版本.
+type payoutsService struct {
+	app         *App
+	payoutsFile string
+	configFile  string
+	elector     *lock.Elector
+
+	mu          sync.Mutex
+	groupMu     map[string]*sync.Mutex // 分组名字 => 锁, 同一分组的支付任务和计划执行不能同时修改余额文件
+	cfg         *config.Config
+	info        *PayoutsFile
+	schedStop   chan bool
+	payoutsStat os.FileInfo
+	configStat  os.FileInfo
+}
+
+func newPayoutsService(app *App, info *PayoutsFile, payoutsFile, configFile string) *payoutsService {
+	s := &payoutsService{
+		app:         app,
+		payoutsFile: payoutsFile,
+		configFile:  configFile,
+		cfg:         app.cfg,
+		info:        info,
+		groupMu:     make(map[string]*sync.Mutex),
+	}
+	s.payoutsStat, _ = os.Stat(payoutsFile)
+	if configFile != "" {
+		s.configStat, _ = os.Stat(configFile)
+	}
+	return s
+}
+
+// 运行服务, 直到收到 SIGINT/SIGTERM
+func (s *payoutsService) run() {
+	if s.elector != nil {
+		s.elector.Start()
+		defer s.elector.Close()
+	}
+
+	s.mu.Lock()
+	s.reschedule(s.info)
+	s.mu.Unlock()
+
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGHUP, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(sigCh)
+
+	ticker := time.NewTicker(payoutsWatchInterval)
+	defer ticker.Stop()
+
+	planTicker := time.NewTicker(payoutsPlanInterval)
+	defer planTicker.Stop()
+
+	for {
+		select {
+		case sig := <-sigCh:
+			if sig == syscall.SIGHUP {
+				log.Println("payouts: SIGHUP, reload")
+				s.reload(true)
+				continue
+			}
+			log.Printf("payouts: %v, exit", sig)
+			s.mu.Lock()
+			s.schedStop <- true
+			s.mu.Unlock()
+			return
+
+		case <-ticker.C:
+			s.reload(false)
+
+		case <-planTicker.C:
+			go s.runPlans()
+		}
+	}
+}
+
+// 定时任务回调, 每次执行时使用当时最新的配置
+//
+// 每个分组是独立的定时任务, 一个分组失败不影响其它分组.
+func (s *payoutsService) runTask(group string) {
+	// 多机部署时只有 leader 执行
+	if s.elector != nil && !s.elector.Confirm() {
+		log.Printf("payouts[%s]: not leader, skip", group)
+		return
+	}
+
+	s.mu.Lock()
+	app, info := s.app.withConfig(s.cfg), s.info.payoutsGroup(group)
+	s.mu.Unlock()
+
+	if info == nil {
+		log.Printf("payouts[%s]: group removed, skip", group)
+		return
+	}
+
+	mu := s.groupLock(group)
+	mu.Lock()
+	defer mu.Unlock()
+	if err := app.doPayoutsTask(info); err != nil {
+		log.Println(err)
+	}
+}
+
+// 分组的锁, 不同分组使用不同的钱包和余额文件, 可以同时执行
+func (s *payoutsService) groupLock(group string) *sync.Mutex {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	mu, ok := s.groupMu[group]
+	if !ok {
+		mu = new(sync.Mutex)
+		s.groupMu[group] = mu
+	}
+	return mu
+}
+
+// 执行已审批的支付计划
+func (s *payoutsService) runPlans() {
+	if s.elector != nil && !s.elector.Confirm() {
+		return
+	}
+
+	s.mu.Lock()
+	app, groups := s.app.withConfig(s.cfg), s.info.payoutsGroups()
+	s.mu.Unlock()
+
+	for _, g := range groups {
+		mu := s.groupLock(g.groupName())
+		mu.Lock()
+		if err := app.doPayoutsPlanTask(g); err != nil {
+			log.Println(err)
+		}
+		mu.Unlock()
+	}
+}
+
+// 用新的时间表替换定时任务, 需要持有 s.mu
+func (s *payoutsService) reschedule(info *PayoutsFile) {
+	sched := clockwork.NewScheduler()
+	for _, g := range info.payoutsGroups() {
+		for _, at := range g.EveryDatAt {
+			sched.Every(1).Day().At(at).Do(s.runTask, g.groupName())
+		}
+		log.Printf("payouts[%s]: scheduled every day at %s", g.groupName(), strings.Join(g.EveryDatAt, ", "))
+	}
+
+	// 先停止旧的再启动新的, 两个同时运行时可能在同一分钟重复支付; 不到一秒的空档没有影响
+	if s.schedStop != nil {
+		s.schedStop <- true
+	}
+	s.schedStop = sched.Start()
+}
+
+// 时间表, 用于判断是否需要重新安排定时任务
+func payoutsSchedule(info *PayoutsFile) []string {
+	var list []string
+	for _, g := range info.payoutsGroups() {
+		for _, at := range g.EveryDatAt {
+			list = append(list, g.groupName()+"@"+at)
+		}
+	}
+	return list
+}
+
+// 重新加载变化的文件, force 表示忽略修改时间
+func (s *payoutsService) reload(force bool) {
+	if st, changed := fileChanged(s.payoutsFile, s.payoutsStat); force || changed {
+		s.payoutsStat = st
+		s.reloadPayoutsFile()
+	}
+	if s.configFile == "" {
+		return
+	}
+	if st, changed := fileChanged(s.configFile, s.configStat); force || changed {
+		s.configStat = st
+		s.reloadConfigFile()
+	}
+}
+
+func (s *payoutsService) reloadPayoutsFile() {
+	info, err := s.app.loadPayoutsFile(s.payoutsFile)
+	if err == nil {
+		err = s.app.checkPayoutsFile(info)
+	}
+	if err != nil {
+		log.Printf("payouts: reload %s failed, keep previous: %v", s.payoutsFile, err)
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	diff := diffJSON(s.info, info, nil)
+	if len(diff) == 0 {
+		return
+	}
+	log.Printf("payouts: reload %s:\n\t%s", s.payoutsFile, strings.Join(diff, "\n\t"))
+
+	if info.LockFile != s.info.LockFile || info.LockLease != s.info.LockLease {
+		log.Println("payouts: LockFile/LockLease changes take effect after restart")
+	}
+	if !reflect.DeepEqual(payoutsSchedule(info), payoutsSchedule(s.info)) {
+		s.reschedule(info)
+	}
+	s.info = info
+}
+
+func (s *payoutsService) reloadConfigFile() {
+	cfg, err := config.Load(s.configFile)
+	if err != nil {
+		log.Printf("payouts: reload %s failed, keep previous: %v", s.configFile, err)
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	diff := diffJSON(s.cfg, cfg, []string{"UserKey"})
+	if len(diff) == 0 {
+		return
+	}
+	log.Printf("payouts: reload %s:\n\t%s", s.configFile, strings.Join(diff, "\n\t"))
+	s.cfg = cfg
+}
+
+func fileChanged(path string, last os.FileInfo) (os.FileInfo, bool) {
+	st, err := os.Stat(path)
+	if err != nil {
+		return last, false
+	}
+	if last == nil {
+		return st, true
+	}
+	return st, !st.ModTime().Equal(last.ModTime()) || st.Size() != last.Size()
+}
+
+// 比较两个对象的 JSON 表示, 返回变化的字段列表
+// secret 中的字段只提示变化, 不打印内容
+func diffJSON(a, b interface{}, secret []string) []string {
+	var fa, fb = make(map[string]string), make(map[string]string)
+	flattenJSON(a, "", fa)
+	flattenJSON(b, "", fb)
+
+	var keys []string
+	for k := range fa {
+		keys = append(keys, k)
+	}
+	for k := range fb {
+		if _, ok := fa[k]; !ok {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	var lines []string
+	for _, k := range keys {
+		va, oka := fa[k]
+		vb, okb := fb[k]
+		if oka && okb && va == vb {
+			continue
+		}
+		for _, x := range secret {
+			if k == x {
+				va, vb = "***", "***"
+			}
+		}
+		switch {
+		case !oka:
+			lines = append(lines, fmt.Sprintf("+ %s: %s", k, vb))
+		case !okb:
+			lines = append(lines, fmt.Sprintf("- %s: %s", k, va))
+		default:
+			lines = append(lines, fmt.Sprintf("~ %s: %s -> %s", k, va, vb))
+		}
+	}
+	return lines
+}
+
+func flattenJSON(v interface{}, prefix string, out map[string]string) {
+	data, _ := json.Marshal(v)
+
+	var x interface{}
+	json.Unmarshal(data, &x)
+
+	var walk func(x interface{}, path string)
+	walk = func(x interface{}, path string) {
+		switch x := x.(type) {
+		case map[string]interface{}:
+			for k, v := range x {
+				if path == "" {
+					walk(v, k)
+				} else {
+					walk(v, path+"."+k)
+				}
+			}
+		case []interface{}:
+			for i, v := range x {
+				walk(v, fmt.Sprintf("%s[%d]", path, i))
+			}
+		default:
+			s, _ := json.Marshal(x)
+			out[path] = string(s)
+		}
+	}
+	walk(x, prefix)
+}
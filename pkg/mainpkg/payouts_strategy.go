@@ -0,0 +1,203 @@
+package mainpkg
+
+import (
+	"fmt"
+	"math/big"
+	"sort"
+	"strconv"
+)
+
+// 分配策略名字
+const (
+	PayoutStrategyPercentage = "percentage" // 按余额比例(默认)
+	PayoutStrategyFixed      = "fixed"      // 每次固定金额, 比如工资/房租
+	PayoutStrategyWeighted   = "weighted"   // 按权重分配其它策略分配后剩余的金额
+	PayoutStrategyTiered     = "tiered"     // 阶梯比例, 比如超过1000HYK的部分按10%
+)
+
+// 分配策略
+type PayoutStrategy interface {
+	// 是否只分配手续费和其它策略分配后剩余的金额
+	Residual() bool
+
+	// 计算使用该策略的每个客户的金额(wei), total 是可分配的总金额
+	Allocate(total *big.Int, payouts []*PayoutElem) ([]*big.Int, error)
+}
+
+var payoutStrategies = map[string]PayoutStrategy{
+	PayoutStrategyPercentage: percentageStrategy{},
+	PayoutStrategyFixed:      fixedStrategy{},
+	PayoutStrategyWeighted:   weightedStrategy{},
+	PayoutStrategyTiered:     tieredStrategy{},
+}
+
+// 注册新的分配策略
+func RegisterPayoutStrategy(name string, s PayoutStrategy) {
+	payoutStrategies[name] = s
+}
+
+// 阶梯比例: 余额中超过 Above 的部分(直到下一个阶梯)按 Percentage 分配
+type PayoutTier struct {
+	Above      float64 // 起始金额(HYK)
+	Percentage float64 // 比例(0～1.0)
+}
+
+func (to *PayoutElem) strategyName() string {
+	if to.Strategy == "" {
+		return PayoutStrategyPercentage
+	}
+	return to.Strategy
+}
+
+// 按策略计算每个客户的金额, 返回保留的费用和每个客户的金额
+func distributePayouts(info *PayoutsFile, available *big.Int) (fee *big.Int, amounts []*big.Int, err error) {
+	fee = mulRat(available, floatRat(info.FeePercentage))
+	amounts = make([]*big.Int, len(info.Payouts))
+
+	// 按策略分组, 保持配置文件中的顺序
+	groups := make(map[string][]int)
+	var names []string
+	for i := range info.Payouts {
+		name := info.Payouts[i].strategyName()
+		if _, ok := payoutStrategies[name]; !ok {
+			return nil, nil, fmt.Errorf("Payouts[%d]: unknown strategy %q", i, name)
+		}
+		if _, ok := groups[name]; !ok {
+			names = append(names, name)
+		}
+		groups[name] = append(groups[name], i)
+	}
+
+	// 先计算非剩余类的策略, 再分配剩余金额
+	sort.SliceStable(names, func(i, j int) bool {
+		return !payoutStrategies[names[i]].Residual() && payoutStrategies[names[j]].Residual()
+	})
+
+	remaining := new(big.Int).Sub(available, fee)
+	for _, name := range names {
+		s := payoutStrategies[name]
+
+		var list []*PayoutElem
+		for _, i := range groups[name] {
+			list = append(list, &info.Payouts[i])
+		}
+
+		total := available
+		if s.Residual() {
+			total = new(big.Int).Set(remaining)
+		}
+
+		values, err := s.Allocate(total, list)
+		if err != nil {
+			return nil, nil, err
+		}
+		for k, i := range groups[name] {
+			amounts[i] = values[k]
+			remaining.Sub(remaining, values[k])
+		}
+	}
+
+	if remaining.Sign() < 0 {
+		return nil, nil, fmt.Errorf("payouts overflow: need %v more wei", new(big.Int).Neg(remaining))
+	}
+	return fee, amounts, nil
+}
+
+type percentageStrategy struct{}
+
+func (percentageStrategy) Residual() bool { return false }
+
+func (percentageStrategy) Allocate(total *big.Int, payouts []*PayoutElem) ([]*big.Int, error) {
+	var values []*big.Int
+	for _, to := range payouts {
+		values = append(values, mulRat(total, floatRat(to.ValuePercentage)))
+	}
+	return values, nil
+}
+
+type fixedStrategy struct{}
+
+func (fixedStrategy) Residual() bool { return false }
+
+func (fixedStrategy) Allocate(total *big.Int, payouts []*PayoutElem) ([]*big.Int, error) {
+	var values []*big.Int
+	for _, to := range payouts {
+		values = append(values, etherToWei(to.FixedAmount))
+	}
+	return values, nil
+}
+
+type weightedStrategy struct{}
+
+func (weightedStrategy) Residual() bool { return true }
+
+func (weightedStrategy) Allocate(total *big.Int, payouts []*PayoutElem) ([]*big.Int, error) {
+	var values = make([]*big.Int, len(payouts))
+	if total.Sign() <= 0 {
+		for i := range values {
+			values[i] = new(big.Int)
+		}
+		return values, nil
+	}
+
+	var sum = new(big.Rat)
+	for _, to := range payouts {
+		sum.Add(sum, floatRat(to.Weight))
+	}
+	if sum.Sign() == 0 {
+		return nil, fmt.Errorf("weighted: total weight is zero")
+	}
+
+	for i, to := range payouts {
+		share := new(big.Rat).Quo(floatRat(to.Weight), sum)
+		values[i] = mulRat(total, share)
+	}
+	return values, nil
+}
+
+type tieredStrategy struct{}
+
+func (tieredStrategy) Residual() bool { return false }
+
+func (tieredStrategy) Allocate(total *big.Int, payouts []*PayoutElem) ([]*big.Int, error) {
+	var values []*big.Int
+	for _, to := range payouts {
+		tiers := append([]PayoutTier(nil), to.Tiers...)
+		sort.SliceStable(tiers, func(i, j int) bool {
+			return tiers[i].Above < tiers[j].Above
+		})
+
+		var value = new(big.Rat)
+		for i, t := range tiers {
+			lo := etherToWei(t.Above)
+			if total.Cmp(lo) <= 0 {
+				break
+			}
+			hi := total
+			if i+1 < len(tiers) {
+				if next := etherToWei(tiers[i+1].Above); next.Cmp(total) < 0 {
+					hi = next
+				}
+			}
+			band := new(big.Rat).SetInt(new(big.Int).Sub(hi, lo))
+			value.Add(value, band.Mul(band, floatRat(t.Percentage)))
+		}
+		values = append(values, new(big.Int).Quo(value.Num(), value.Denom()))
+	}
+	return values, nil
+}
+
+// 按十进制字面值精确转换, 0.1 就是 1/10
+func floatRat(v float64) *big.Rat {
+	r, ok := new(big.Rat).SetString(strconv.FormatFloat(v, 'f', -1, 64))
+	if !ok {
+		return new(big.Rat)
+	}
+	return r
+}
+
+// x * r, 向下取整
+func mulRat(x *big.Int, r *big.Rat) *big.Int {
+	v := new(big.Rat).Mul(new(big.Rat).SetInt(x), r)
+	return new(big.Int).Quo(v.Num(), v.Denom())
+}
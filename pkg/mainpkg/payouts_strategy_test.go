@@ -0,0 +1,193 @@
+package mainpkg
+
+import (
+	"math/big"
+	"testing"
+)
+
+func TestDistributePayouts(t *testing.T) {
+	hyk := func(s string) *big.Int {
+		v, _ := new(big.Int).SetString(s, 10)
+		return v.Mul(v, big.NewInt(1e18))
+	}
+	wei := func(s string) *big.Int {
+		v, _ := new(big.Int).SetString(s, 10)
+		return v
+	}
+
+	tests := []struct {
+		name      string
+		info      PayoutsFile
+		available *big.Int
+		fee       *big.Int
+		amounts   []*big.Int
+		err       bool
+	}{
+		{
+			name: "percentage",
+			info: PayoutsFile{
+				FeePercentage: 0.1,
+				Payouts: []PayoutElem{
+					{Name: "a", ValuePercentage: 0.3},
+					{Name: "b", ValuePercentage: 0.25},
+				},
+			},
+			available: hyk("10"),
+			fee:       hyk("1"),
+			amounts:   []*big.Int{hyk("3"), wei("2500000000000000000")},
+		},
+		{
+			name: "percentage rounding down",
+			info: PayoutsFile{
+				FeePercentage: 0.1,
+				Payouts: []PayoutElem{
+					{Name: "a", ValuePercentage: 0.33},
+				},
+			},
+			available: wei("15"),
+			fee:       wei("1"),
+			amounts:   []*big.Int{wei("4")},
+		},
+		{
+			name: "fixed and weighted",
+			info: PayoutsFile{
+				FeePercentage: 0.05,
+				Payouts: []PayoutElem{
+					{Name: "a", Strategy: PayoutStrategyWeighted, Weight: 1},
+					{Name: "rent", Strategy: PayoutStrategyFixed, FixedAmount: 20},
+					{Name: "b", Strategy: PayoutStrategyWeighted, Weight: 2},
+				},
+			},
+			available: hyk("100"),
+			fee:       hyk("5"),
+			amounts:   []*big.Int{hyk("25"), hyk("20"), hyk("50")},
+		},
+		{
+			name: "weighted rounding down",
+			info: PayoutsFile{
+				Payouts: []PayoutElem{
+					{Name: "a", Strategy: PayoutStrategyWeighted, Weight: 1},
+					{Name: "b", Strategy: PayoutStrategyWeighted, Weight: 1},
+					{Name: "c", Strategy: PayoutStrategyWeighted, Weight: 1},
+				},
+			},
+			available: wei("100"),
+			fee:       wei("0"),
+			amounts:   []*big.Int{wei("33"), wei("33"), wei("33")},
+		},
+		{
+			name: "percentage and weighted remainder",
+			info: PayoutsFile{
+				FeePercentage: 0.1,
+				Payouts: []PayoutElem{
+					{Name: "a", Strategy: PayoutStrategyWeighted, Weight: 0.5},
+					{Name: "b", ValuePercentage: 0.4},
+				},
+			},
+			available: hyk("10"),
+			fee:       hyk("1"),
+			amounts:   []*big.Int{hyk("5"), hyk("4")},
+		},
+		{
+			name: "tiered above 1000",
+			info: PayoutsFile{
+				Payouts: []PayoutElem{
+					{Name: "b", Strategy: PayoutStrategyTiered, Tiers: []PayoutTier{{Above: 1000, Percentage: 0.1}}},
+				},
+			},
+			available: hyk("1500"),
+			fee:       wei("0"),
+			amounts:   []*big.Int{hyk("50")},
+		},
+		{
+			name: "tiered below first tier",
+			info: PayoutsFile{
+				Payouts: []PayoutElem{
+					{Name: "b", Strategy: PayoutStrategyTiered, Tiers: []PayoutTier{{Above: 1000, Percentage: 0.1}}},
+				},
+			},
+			available: hyk("999"),
+			fee:       wei("0"),
+			amounts:   []*big.Int{wei("0")},
+		},
+		{
+			name: "tiered bands",
+			info: PayoutsFile{
+				Payouts: []PayoutElem{
+					{Name: "b", Strategy: PayoutStrategyTiered, Tiers: []PayoutTier{
+						{Above: 1000, Percentage: 0.1},
+						{Above: 0, Percentage: 0.01},
+						{Above: 2000, Percentage: 0.2},
+					}},
+				},
+			},
+			available: hyk("1500"),
+			fee:       wei("0"),
+			amounts:   []*big.Int{hyk("60")}, // 1000*1% + 500*10%
+		},
+		{
+			name: "fixed overflow",
+			info: PayoutsFile{
+				Payouts: []PayoutElem{
+					{Name: "rent", Strategy: PayoutStrategyFixed, FixedAmount: 20},
+				},
+			},
+			available: hyk("10"),
+			err:       true,
+		},
+		{
+			name: "unknown strategy",
+			info: PayoutsFile{
+				Payouts: []PayoutElem{
+					{Name: "a", Strategy: "lottery"},
+				},
+			},
+			available: hyk("10"),
+			err:       true,
+		},
+	}
+
+	for _, tt := range tests {
+		fee, amounts, err := distributePayouts(&tt.info, tt.available)
+		if tt.err {
+			if err == nil {
+				t.Errorf("%s: expect error", tt.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if fee.Cmp(tt.fee) != 0 {
+			t.Errorf("%s: fee: expect = %v, got = %v", tt.name, tt.fee, fee)
+		}
+		if len(amounts) != len(tt.amounts) {
+			t.Errorf("%s: expect %d amounts, got %d", tt.name, len(tt.amounts), len(amounts))
+			continue
+		}
+		for i := range amounts {
+			if amounts[i].Cmp(tt.amounts[i]) != 0 {
+				t.Errorf("%s: Payouts[%d]: expect = %v, got = %v", tt.name, i, tt.amounts[i], amounts[i])
+			}
+		}
+	}
+}
+
+func TestEtherToWei(t *testing.T) {
+	tests := []struct {
+		v   float64
+		wei string
+	}{
+		{0, "0"},
+		{1, "1000000000000000000"},
+		{0.1, "100000000000000000"},
+		{1.23456789, "1234567890000000000"},
+		{0.000000000000000001, "1"},
+	}
+	for _, tt := range tests {
+		if got := etherToWei(tt.v).String(); got != tt.wei {
+			t.Errorf("etherToWei(%v): expect = %s, got = %s", tt.v, tt.wei, got)
+		}
+	}
+}
@@ -0,0 +1,109 @@
+package mainpkg
+
+import (
+	"errors"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/core/types"
+
+	"xcoin/HayekTool/pkg/rpc"
+)
+
+// 支付任务端到端测试: 发送失败的部分累计到下次, 报表和链上余额一致
+func TestPayoutsTaskEndToEnd(t *testing.T) {
+	dir, err := ioutil.TempDir("", "payouts")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	payer, payerKey := newTestKey(t)
+	alice, _ := newTestKey(t)
+	bob, _ := newTestKey(t)
+
+	node, app := newTestNode(t, map[string]*big.Int{payer: etherToWei(100)})
+	app.cfg.UserAddress, app.cfg.UserKey = payer, payerKey
+
+	info := &PayoutsFile{
+		FeePercentage: 0.1,
+		Payouts: []PayoutElem{
+			{Name: "alice", Address: alice, ValuePercentage: 0.54},
+			{Name: "bob", Address: bob, ValuePercentage: 0.36},
+		},
+		HistoryFile: filepath.Join(dir, "history.jsonl"),
+		BalanceFile: filepath.Join(dir, "balances.json"),
+	}
+
+	// 第一次支付时节点拒绝给 bob 的交易
+	node.SetReject(func(tx *types.Transaction) error {
+		if strings.EqualFold(tx.To().Hex(), bob) {
+			return errors.New("txpool is full")
+		}
+		return nil
+	})
+	if err := app.doPayoutsTask(info); err == nil || !strings.Contains(err.Error(), "1 of 2 transfers failed") {
+		t.Fatalf("first run: %v", err)
+	}
+	node.Mine()
+
+	if b := node.Balance(alice); b.Cmp(etherToWei(54)) != 0 {
+		t.Fatalf("alice balance = %s", formatWei(b))
+	}
+	balances, err := loadPayoutsBalances(info.BalanceFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if owed := balances.owed(bob); owed.Cmp(etherToWei(36)) != 0 {
+		t.Fatalf("bob owed = %s", formatWei(owed))
+	}
+
+	// 第二次支付补上 bob 的欠款
+	node.SetReject(nil)
+	if err := app.doPayoutsTask(info); err != nil {
+		t.Fatal(err)
+	}
+	node.Mine()
+
+	if n := node.Nonce(payer); n != 3 {
+		t.Fatalf("payer nonce = %d", n)
+	}
+	balances, _ = loadPayoutsBalances(info.BalanceFile)
+	if total := balances.total(); total.Sign() != 0 {
+		t.Fatalf("owed after second run = %s", formatWei(total))
+	}
+
+	runs, err := loadPayoutsRuns(info.HistoryFile, time.Time{}, time.Time{})
+	if err != nil || len(runs) != 2 {
+		t.Fatalf("runs = %d, %v", len(runs), err)
+	}
+	c, _ := rpc.NewRPCClient("HayekTool", app.cfg.Host, time.Second)
+	report, err := app.buildPayoutsReport(c, runs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 同一次任务内按地址顺序发送, 比较前排序
+	var status []string
+	for _, x := range report.Transfers {
+		status = append(status, x.Name+":"+x.Status)
+	}
+	sort.Strings(status)
+	if got := strings.Join(status, " "); got != "alice:confirmed alice:confirmed bob:confirmed bob:failed" {
+		t.Fatalf("transfers = %s", got)
+	}
+	for _, r := range report.Recipients {
+		if b := node.Balance(r.Address); b.Cmp(r.Total) != 0 {
+			t.Fatalf("%s: balance %s, report %s", r.Name, formatWei(b), formatWei(r.Total))
+		}
+	}
+	if gas := new(big.Int).Mul(big.NewInt(3*21000), big.NewInt(DefaultGasPrice)); report.GasSpent.Cmp(gas) != 0 {
+		t.Fatalf("gas spent = %v", report.GasSpent)
+	}
+}
@@ -0,0 +1,324 @@
+package mainpkg
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	"xcoin/HayekTool/pkg/lock"
+	"xcoin/HayekTool/pkg/util"
+)
+
+const DefaultSpendStateFile = "spend-state.json"
+
+// 支出记录的文件锁, 多个进程(serve/send-payouts/batch)的检查和记账串行执行
+const (
+	spendLockLease = time.Minute
+	spendLockWait  = spendLockLease + time.Second*10 // 持有者崩溃时等待租约过期
+	spendLockRetry = time.Millisecond * 50
+)
+
+// 支出策略, 所有转账(send-tx 和定时支付)发送前都要经过检查
+//
+// 金额单位为 HYK, 为 0 表示不限制. 每日限额按本地时间的自然日计算,
+// 已经支出的金额记录在 StateFile 中, 重启后继续有效.
+type SpendPolicy struct {
+	MaxPerTx        float64            // 单笔上限
+	MaxPerDay       float64            // 每天总额上限
+	MaxPerRecipient float64            // 每个收款地址每天的上限
+	RecipientLimits map[string]float64 `json:",omitempty"` // 指定收款地址每天的上限, 优先于 MaxPerRecipient
+
+	Allowlist []string `json:",omitempty"` // 收款地址白名单, 非空时只允许名单中的地址
+	Denylist  []string `json:",omitempty"` // 收款地址黑名单
+
+	ConfirmAbove float64 // 单笔超过该金额时需要交互确认, 非交互的调用(定时支付)直接拒绝
+	StateFile    string  // 支出记录文件, 默认 spend-state.json
+
+	APITransfers bool `json:",omitempty"` // 允许 serve 命令的 HTTP 转账接口, 默认关闭
+}
+
+// 当天的支出记录
+type spendState struct {
+	Day        string              // 2006-01-02
+	Total      *big.Int            // 当天总支出(wei)
+	Recipients map[string]*big.Int // 按收款地址(小写)统计
+}
+
+// 同一个进程内的转账串行检查和记账, 避免并发的转账一起超过限额
+var spendMu sync.Mutex
+
+func loadSpendPolicy(path string) (*SpendPolicy, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var policy SpendPolicy
+	if err := json.Unmarshal(data, &policy); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+
+	errs := checkUnknownFields(data, reflect.TypeOf(policy), "")
+	errs = append(errs, ValidateSpendPolicy(&policy)...)
+	if len(errs) > 0 {
+		return nil, fmt.Errorf("%s: %v", path, errs)
+	}
+	return &policy, nil
+}
+
+// 检查支出策略, 返回全部错误
+func ValidateSpendPolicy(policy *SpendPolicy) FieldErrors {
+	var errs FieldErrors
+
+	validateAmount(&errs, "MaxPerTx", policy.MaxPerTx)
+	validateAmount(&errs, "MaxPerDay", policy.MaxPerDay)
+	validateAmount(&errs, "MaxPerRecipient", policy.MaxPerRecipient)
+	validateAmount(&errs, "ConfirmAbove", policy.ConfirmAbove)
+
+	for addr, v := range policy.RecipientLimits {
+		field := fmt.Sprintf("RecipientLimits[%q]", addr)
+		if err := validateAddress(addr); err != "" {
+			errs.add(field, "%s", err)
+		}
+		validateAmount(&errs, field, v)
+	}
+	for i, addr := range policy.Allowlist {
+		if err := validateAddress(addr); err != "" {
+			errs.add(fmt.Sprintf("Allowlist[%d]", i), "%s", err)
+		}
+	}
+	for i, addr := range policy.Denylist {
+		if err := validateAddress(addr); err != "" {
+			errs.add(fmt.Sprintf("Denylist[%d]", i), "%s", err)
+		} else if containsAddress(policy.Allowlist, addr) {
+			errs.add(fmt.Sprintf("Denylist[%d]", i), "%s also in Allowlist", addr)
+		}
+	}
+
+	return errs
+}
+
+func (policy *SpendPolicy) stateFile() string {
+	if policy.StateFile != "" {
+		return policy.StateFile
+	}
+	return DefaultSpendStateFile
+}
+
+// 收款地址每天的上限(wei), 没有限制时返回 nil
+func (policy *SpendPolicy) recipientLimit(to string) *big.Int {
+	for addr, v := range policy.RecipientLimits {
+		if strings.EqualFold(addr, to) {
+			return etherToWei(v)
+		}
+	}
+	if policy.MaxPerRecipient > 0 {
+		return etherToWei(policy.MaxPerRecipient)
+	}
+	return nil
+}
+
+// 检查一笔转账是否符合策略, 不包括交互确认
+func (policy *SpendPolicy) check(state *spendState, to string, value *big.Int) error {
+	if containsAddress(policy.Denylist, to) {
+		return fmt.Errorf("policy: recipient %s is denied", to)
+	}
+	if len(policy.Allowlist) > 0 && !containsAddress(policy.Allowlist, to) {
+		return fmt.Errorf("policy: recipient %s not in allowlist", to)
+	}
+
+	if policy.MaxPerTx > 0 && value.Cmp(etherToWei(policy.MaxPerTx)) > 0 {
+		return fmt.Errorf("policy: %s HYK over per-tx limit %v", formatWei(value), policy.MaxPerTx)
+	}
+	if policy.MaxPerDay > 0 {
+		total := new(big.Int).Add(state.Total, value)
+		if total.Cmp(etherToWei(policy.MaxPerDay)) > 0 {
+			return fmt.Errorf("policy: %s HYK over per-day limit %v (spent %s today)",
+				formatWei(value), policy.MaxPerDay, formatWei(state.Total),
+			)
+		}
+	}
+	if limit := policy.recipientLimit(to); limit != nil {
+		spent := state.spent(to)
+		if new(big.Int).Add(spent, value).Cmp(limit) > 0 {
+			return fmt.Errorf("policy: %s HYK over per-recipient limit %s for %s (sent %s today)",
+				formatWei(value), formatWei(limit), to, formatWei(spent),
+			)
+		}
+	}
+	return nil
+}
+
+func loadSpendState(path string, now time.Time) (*spendState, error) {
+	state := &spendState{
+		Day:        now.Format("2006-01-02"),
+		Total:      new(big.Int),
+		Recipients: make(map[string]*big.Int),
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return state, nil
+		}
+		return nil, err
+	}
+
+	var saved spendState
+	if err := json.Unmarshal(data, &saved); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	// 之前的记录不影响当天的限额
+	if saved.Day != state.Day {
+		return state, nil
+	}
+	if saved.Total != nil {
+		state.Total = saved.Total
+	}
+	for k, v := range saved.Recipients {
+		state.Recipients[k] = v
+	}
+	return state, nil
+}
+
+// 原子地写入, 避免中途退出时损坏
+func (state *spendState) save(path string) error {
+	data, err := json.MarshalIndent(state, "", "\t")
+	if err != nil {
+		return err
+	}
+	return util.WriteFileAtomic(path, data, 0644)
+}
+
+func (state *spendState) spent(to string) *big.Int {
+	if v, ok := state.Recipients[strings.ToLower(to)]; ok {
+		return new(big.Int).Set(v)
+	}
+	return new(big.Int)
+}
+
+func (state *spendState) add(to string, value *big.Int) {
+	state.Total = new(big.Int).Add(state.Total, value)
+	state.Recipients[strings.ToLower(to)] = new(big.Int).Add(state.spent(to), value)
+}
+
+// 当前配置的支出策略, 没有配置时返回 nil
+//
+// 每次转账时重新读取, 修改策略文件后不需要重启支付服务.
+func (p *App) spendPolicy() (*SpendPolicy, error) {
+	if p.cfg.PolicyFile == "" {
+		return nil, nil
+	}
+	return loadSpendPolicy(p.cfg.PolicyFile)
+}
+
+// 转账前检查支出策略, 通过后返回转账结束时调用的函数, sent 为 true 时记账
+//
+// 从检查到记账持有支出记录的文件锁, 多个进程一起转账也不会超过限额;
+// 同一个进程内调用者还需要持有 spendMu.
+func (p *App) authorizeSpend(to string, value *big.Int) (done func(sent bool) error, err error) {
+	policy, err := p.spendPolicy()
+	if err != nil {
+		return nil, err
+	}
+	if policy == nil {
+		return func(bool) error { return nil }, nil
+	}
+
+	path := policy.stateFile()
+	state, err := loadSpendState(path, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	if err := policy.check(state, to, value); err != nil {
+		return nil, err
+	}
+
+	if policy.ConfirmAbove > 0 && value.Cmp(etherToWei(policy.ConfirmAbove)) > 0 {
+		prompt := fmt.Sprintf("send %s HYK to %s?", formatWei(value), to)
+		if p.confirm == nil {
+			return nil, fmt.Errorf("policy: %s HYK over %v needs confirmation", formatWei(value), policy.ConfirmAbove)
+		}
+		if !p.confirm(prompt) {
+			return nil, fmt.Errorf("policy: %s HYK to %s not confirmed", formatWei(value), to)
+		}
+	}
+
+	// 确认之后再加锁, 交互确认不占用锁; 加锁后重新检查其它进程在此期间的支出
+	l, err := lockSpendState(path)
+	if err != nil {
+		return nil, err
+	}
+	if state, err = loadSpendState(path, time.Now()); err == nil {
+		err = policy.check(state, to, value)
+	}
+	if err != nil {
+		l.Unlock()
+		return nil, err
+	}
+
+	return func(sent bool) error {
+		defer l.Unlock()
+		if !sent {
+			return nil
+		}
+		state.add(to, value)
+		return state.save(path)
+	}, nil
+}
+
+// 获取支出记录的文件锁, 被其它进程持有时等待
+func lockSpendState(path string) (*lock.FileLock, error) {
+	l := lock.NewFileLock(path+".lock", spendLockLease)
+	deadline := time.Now().Add(spendLockWait)
+	for {
+		err := l.TryLock()
+		if err == nil {
+			return l, nil
+		}
+		if err != lock.ErrLockHeld {
+			return nil, fmt.Errorf("policy: lock %s: %v", l.Path, err)
+		}
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("policy: %s held by another process", l.Path)
+		}
+		time.Sleep(spendLockRetry)
+	}
+}
+
+// 使用指定确认方式的副本
+func (p *App) withConfirm(confirm func(prompt string) bool) *App {
+	q := *p
+	q.confirm = confirm
+	return &q
+}
+
+// 使用指定发送前回调的副本
+func (p *App) withBeforeSend(beforeSend func(txHash string, nonce uint64) error) *App {
+	q := *p
+	q.beforeSend = beforeSend
+	return &q
+}
+
+// 在终端上确认, 只有输入 y/yes 时通过
+func confirmStdin(prompt string) bool {
+	fmt.Printf("%s [y/N]: ", prompt)
+	line, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	switch strings.ToLower(strings.TrimSpace(line)) {
+	case "y", "yes":
+		return true
+	}
+	return false
+}
+
+// 已经通过其它方式确认(比如审批过的支付计划)
+func confirmApproved(string) bool {
+	return true
+}
@@ -0,0 +1,159 @@
+package mainpkg
+
+import (
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"xcoin/HayekTool/pkg/config"
+	"xcoin/HayekTool/pkg/lock"
+)
+
+func TestSpendPolicyCheck(t *testing.T) {
+	const (
+		alice = "0x3eb41fc94f240242c9bbb8bf46b9feb356fd09e2"
+		bob   = "0x5205f45c6399c41e11e533926ca69a0aedfdbb8d"
+		eve   = "0x0000000000000000000000000000000000000bad"
+	)
+	policy := &SpendPolicy{
+		MaxPerTx:        10,
+		MaxPerDay:       25,
+		MaxPerRecipient: 15,
+		RecipientLimits: map[string]float64{bob: 20},
+		Denylist:        []string{eve},
+	}
+	state := &spendState{Total: etherToWei(12), Recipients: map[string]*big.Int{alice: etherToWei(8)}}
+
+	for _, tt := range []struct {
+		to    string
+		value float64
+		err   string
+	}{
+		{alice, 5, ""},
+		{alice, 10.5, "per-tx"},
+		{alice, 7.5, "per-recipient"},
+		{bob, 10, ""},
+		{bob, 10.5, "per-tx"},
+		{eve, 1, "denied"},
+	} {
+		err := policy.check(state, tt.to, etherToWei(tt.value))
+		if tt.err == "" && err != nil || tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)) {
+			t.Errorf("check(%s, %v) = %v, expect %q", tt.to, tt.value, err, tt.err)
+		}
+	}
+
+	state.Total = etherToWei(20)
+	if err := policy.check(state, bob, etherToWei(6)); err == nil || !strings.Contains(err.Error(), "per-day") {
+		t.Errorf("per-day: %v", err)
+	}
+
+	policy.Allowlist = []string{alice}
+	if err := policy.check(state, bob, etherToWei(1)); err == nil || !strings.Contains(err.Error(), "allowlist") {
+		t.Errorf("allowlist: %v", err)
+	}
+}
+
+func TestAuthorizeSpend(t *testing.T) {
+	dir, err := ioutil.TempDir("", "policy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	policyFile := filepath.Join(dir, "policy.json")
+	stateFile := filepath.Join(dir, "state.json")
+	data := `{"MaxPerDay": 10, "ConfirmAbove": 5, "StateFile": "` + stateFile + `"}`
+	if err := ioutil.WriteFile(policyFile, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	const to = "0x3eb41fc94f240242c9bbb8bf46b9feb356fd09e2"
+	app := NewApp(&config.Config{PolicyFile: policyFile})
+
+	// 非交互调用不能超过确认金额
+	if _, err := app.authorizeSpend(to, etherToWei(6)); err == nil {
+		t.Fatal("expect confirmation error")
+	}
+	if _, err := app.withConfirm(func(string) bool { return false }).authorizeSpend(to, etherToWei(6)); err == nil {
+		t.Fatal("expect not confirmed")
+	}
+
+	done, err := app.withConfirm(confirmApproved).authorizeSpend(to, etherToWei(6))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := done(true); err != nil {
+		t.Fatal(err)
+	}
+
+	// 记录保存在文件中, 新的进程也受当天限额约束
+	if _, err := NewApp(app.cfg).authorizeSpend(to, etherToWei(5)); err == nil || !strings.Contains(err.Error(), "per-day") {
+		t.Fatalf("per-day after restart: %v", err)
+	}
+
+	// 第二天重新计算
+	state, err := loadSpendState(stateFile, time.Now().AddDate(0, 0, 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state.Total.Sign() != 0 {
+		t.Fatalf("next day total = %v", state.Total)
+	}
+}
+
+func TestAuthorizeSpendLock(t *testing.T) {
+	dir, err := ioutil.TempDir("", "policy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	policyFile := filepath.Join(dir, "policy.json")
+	stateFile := filepath.Join(dir, "state.json")
+	data := `{"MaxPerDay": 10, "StateFile": "` + stateFile + `"}`
+	if err := ioutil.WriteFile(policyFile, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	const to = "0x3eb41fc94f240242c9bbb8bf46b9feb356fd09e2"
+	app := NewApp(&config.Config{PolicyFile: policyFile})
+
+	// 另一个进程持有锁, 释放前记账 8 HYK
+	other := &lock.FileLock{Path: stateFile + ".lock", Owner: "other", Lease: time.Minute}
+	if err := other.TryLock(); err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		time.Sleep(time.Millisecond * 200)
+		state, _ := loadSpendState(stateFile, time.Now())
+		state.add(to, etherToWei(8))
+		state.save(stateFile)
+		other.Unlock()
+	}()
+
+	// 等到锁释放后按最新的记录检查
+	if _, err := app.authorizeSpend(to, etherToWei(5)); err == nil || !strings.Contains(err.Error(), "per-day") {
+		t.Fatalf("expect per-day error after waiting for lock: %v", err)
+	}
+
+	done, err := app.authorizeSpend(to, etherToWei(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := other.TryLock(); err != lock.ErrLockHeld {
+		t.Fatalf("lock not held until done: %v", err)
+	}
+	if err := done(false); err != nil {
+		t.Fatal(err)
+	}
+	if state, _ := loadSpendState(stateFile, time.Now()); state.Total.Cmp(etherToWei(8)) != 0 {
+		t.Fatalf("total = %v, expect 8 HYK", state.Total)
+	}
+	if err := other.TryLock(); err != nil {
+		t.Fatalf("lock not released: %v", err)
+	}
+}
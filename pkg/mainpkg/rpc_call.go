@@ -0,0 +1,96 @@
+package mainpkg
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+
+	"xcoin/HayekTool/pkg/rpc"
+)
+
+// 调用任意 RPC 方法, 打印原始结果; 节点返回错误时返回包含错误码的错误
+//
+// 参数可以是 JSON 数组(paramsJSON), 或者命令行参数(args), 两者不能同时使用.
+func (p *App) CmdRPCCall(method, paramsJSON string, args []string) error {
+	if method == "" {
+		return fmt.Errorf("method required")
+	}
+
+	var params []interface{}
+	switch {
+	case paramsJSON != "" && len(args) > 0:
+		return fmt.Errorf("use either --params or arguments, not both")
+	case paramsJSON != "":
+		d := json.NewDecoder(strings.NewReader(paramsJSON))
+		d.UseNumber()
+		if err := d.Decode(&params); err != nil {
+			return fmt.Errorf("invalid --params: expect JSON array: %v", err)
+		}
+	default:
+		params = parseRPCArgs(args)
+	}
+
+	c, err := p.rpcClient(time.Second * 10)
+	if err != nil {
+		return err
+	}
+
+	result, err := c.Call(method, params)
+	if rpcErr, ok := err.(*rpc.RPCError); ok {
+		return fmt.Errorf("%s: error %d: %s", method, rpcErr.Code, rpcErr.Message)
+	}
+	if err != nil {
+		return err
+	}
+
+	if result == nil {
+		fmt.Println("null")
+		return nil
+	}
+	var buf bytes.Buffer
+	if err := json.Indent(&buf, result, "", "\t"); err != nil {
+		return err
+	}
+	fmt.Println(buf.String())
+	return nil
+}
+
+// 把命令行参数转为 RPC 参数
+//
+// 十进制整数转为十六进制数量(0x..), true/false/null 和 JSON 对象/数组/带引号的字符串按 JSON 解析,
+// 其它参数(包括 0x 开头的地址和哈希)作为字符串.
+func parseRPCArgs(args []string) []interface{} {
+	params := make([]interface{}, 0, len(args))
+	for _, s := range args {
+		params = append(params, parseRPCArg(s))
+	}
+	return params
+}
+
+func parseRPCArg(s string) interface{} {
+	switch s {
+	case "true":
+		return true
+	case "false":
+		return false
+	case "null":
+		return nil
+	}
+
+	if n, ok := new(big.Int).SetString(s, 10); ok && n.Sign() >= 0 {
+		return fmt.Sprintf("0x%x", n)
+	}
+
+	if strings.HasPrefix(s, "{") || strings.HasPrefix(s, "[") || strings.HasPrefix(s, `"`) {
+		var v interface{}
+		d := json.NewDecoder(strings.NewReader(s))
+		d.UseNumber()
+		if err := d.Decode(&v); err == nil {
+			return v
+		}
+	}
+	return s
+}
@@ -0,0 +1,38 @@
+package mainpkg
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"xcoin/HayekTool/pkg/rpc"
+)
+
+func TestParseRPCArgs(t *testing.T) {
+	params := parseRPCArgs([]string{
+		"1234", "0x10", "true", "null", "latest", `"42"`, `{"to":"0xaa"}`, "[1,2]", "-1", "{bad",
+	})
+	data, _ := json.Marshal(params)
+	want := `["0x4d2","0x10",true,null,"latest","42",{"to":"0xaa"},[1,2],"-1","{bad"]`
+	if string(data) != want {
+		t.Fatalf("params = %s, expect %s", data, want)
+	}
+}
+
+func TestRPCCallError(t *testing.T) {
+	_, app := newTestNode(t, nil)
+	c, _ := rpc.NewRPCClient("HayekTool", app.cfg.Host, time.Second)
+
+	result, err := c.Call("hyk_getBlockByNumber", parseRPCArgs([]string{"0", "false"}))
+	if err != nil || len(result) == 0 {
+		t.Fatalf("result = %s, %v", result, err)
+	}
+	if result, err := c.Call("hyk_getBlockByNumber", parseRPCArgs([]string{"100", "false"})); err != nil || result != nil {
+		t.Fatalf("missing block = %s, %v", result, err)
+	}
+
+	_, err = c.Call("txpool_status", nil)
+	if e, ok := err.(*rpc.RPCError); !ok || e.Code != -32601 {
+		t.Fatalf("err = %#v", err)
+	}
+}
@@ -0,0 +1,453 @@
+package mainpkg
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"xcoin/HayekTool/pkg/addressbook"
+	"xcoin/HayekTool/pkg/rpc"
+)
+
+const DefaultServeListen = "127.0.0.1:8600"
+
+type ServeOptions struct {
+	Listen      string  // 监听地址
+	Token       string  // 访问令牌(Authorization: Bearer <token>), 为空时不检查
+	RateLimit   float64 // 每个客户端每秒的请求数, 0 表示不限制
+	Burst       int     // 突发请求数, 默认 RateLimit 的两倍
+	HistoryFile string  // 支付历史文件, 默认 payouts-history.jsonl
+}
+
+// HTTP JSON API, 和命令行使用同样的查询和转账逻辑
+//
+//	GET  /api/status
+//	GET  /api/balance/{address|name}
+//	GET  /api/block/{latest|pending|height|hash}?full=true
+//	GET  /api/tx/{hash}
+//	GET  /api/addressbook?tag=
+//	GET  /api/addressbook/{name}
+//	GET  /api/payouts/runs?from=2006-01-02&to=2006-01-02
+//	GET  /api/payouts/report?from=2006-01-02&to=2006-01-02
+//	POST /api/transfer {"to":..., "value": HYK, "gasLimit":..., "gasPrice":...}
+//
+// 转账接口需要设置访问令牌和支出策略中的 APITransfers, 并且受策略的限额约束.
+type apiServer struct {
+	app    *App
+	opt    ServeOptions
+	client *rpc.RPCClient
+	limit  *rateLimiter
+}
+
+// 出错时的响应
+type apiError struct {
+	status int
+	msg    string
+}
+
+func (e *apiError) Error() string {
+	return e.msg
+}
+
+func apiErrorf(status int, format string, a ...interface{}) error {
+	return &apiError{status: status, msg: fmt.Sprintf(format, a...)}
+}
+
+func (p *App) NewAPIHandler(opt ServeOptions) (http.Handler, error) {
+	if opt.HistoryFile == "" {
+		opt.HistoryFile = DefaultPayoutsHistoryFile
+	}
+
+	client, err := p.rpcClient(time.Second * 10)
+	if err != nil {
+		return nil, err
+	}
+	s := &apiServer{app: p, opt: opt, client: client}
+	if opt.RateLimit > 0 {
+		s.limit = newRateLimiter(opt.RateLimit, opt.Burst, time.Now)
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/api/status", s.handle("GET", s.status))
+	mux.Handle("/api/balance/", s.handle("GET", s.balance))
+	mux.Handle("/api/block/", s.handle("GET", s.block))
+	mux.Handle("/api/tx/", s.handle("GET", s.tx))
+	mux.Handle("/api/addressbook", s.handle("GET", s.addressBook))
+	mux.Handle("/api/addressbook/", s.handle("GET", s.addressBookEntry))
+	mux.Handle("/api/payouts/runs", s.handle("GET", s.payoutsRuns))
+	mux.Handle("/api/payouts/report", s.handle("GET", s.payoutsReport))
+	mux.Handle("/api/transfer", s.handle("POST", s.transfer))
+	return mux, nil
+}
+
+// 运行 HTTP API 服务, 收到 SIGINT/SIGTERM 时退出
+func (p *App) CmdServe(opt ServeOptions) error {
+	if opt.Listen == "" {
+		opt.Listen = DefaultServeListen
+	}
+	handler, err := p.NewAPIHandler(opt)
+	if err != nil {
+		return err
+	}
+
+	ln, err := net.Listen("tcp", opt.Listen)
+	if err != nil {
+		return err
+	}
+	server := &http.Server{Handler: handler, ReadTimeout: time.Second * 30, WriteTimeout: time.Minute}
+	go server.Serve(ln)
+	defer server.Close()
+
+	if opt.Token == "" {
+		log.Printf("serve: no token, API is open to anyone who can reach %s, transfers disabled", ln.Addr())
+	}
+	log.Printf("serve: listen on http://%s, node %s", ln.Addr(), p.cfg.Host)
+
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(sigCh)
+
+	sig := <-sigCh
+	log.Printf("serve: %v, exit", sig)
+	return nil
+}
+
+// 检查限流/令牌/方法, 把结果或者错误写成 JSON
+func (s *apiServer) handle(method string, f func(r *http.Request) (interface{}, error)) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		result, err := s.serve(method, f, r)
+		if err != nil {
+			status := http.StatusInternalServerError
+			if e, ok := err.(*apiError); ok {
+				status = e.status
+			} else if _, ok := err.(*rpc.RPCError); ok {
+				status = http.StatusBadGateway
+			}
+			if status == http.StatusTooManyRequests {
+				w.Header().Set("Retry-After", "1")
+			}
+			writeAPIJSON(w, status, map[string]string{"error": err.Error()})
+			return
+		}
+		writeAPIJSON(w, http.StatusOK, result)
+	})
+}
+
+func (s *apiServer) serve(method string, f func(r *http.Request) (interface{}, error), r *http.Request) (interface{}, error) {
+	// 先限流, 避免无限制地猜测令牌
+	if s.limit != nil && !s.limit.allow(clientIP(r)) {
+		return nil, apiErrorf(http.StatusTooManyRequests, "rate limit exceeded")
+	}
+	if s.opt.Token != "" {
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(token), []byte(s.opt.Token)) != 1 {
+			return nil, apiErrorf(http.StatusUnauthorized, "invalid token")
+		}
+	}
+	if r.Method != method {
+		return nil, apiErrorf(http.StatusMethodNotAllowed, "method %s not allowed", r.Method)
+	}
+	return f(r)
+}
+
+func writeAPIJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	s, _ := json.MarshalIndent(v, "", "\t")
+	w.Write(append(s, '\n'))
+}
+
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// 路径中前缀之后的参数, 不能为空或者包含 /
+func pathArg(r *http.Request, prefix string) (string, error) {
+	arg := strings.TrimPrefix(r.URL.Path, prefix)
+	if arg == "" || strings.Contains(arg, "/") {
+		return "", apiErrorf(http.StatusNotFound, "not found: %s", r.URL.Path)
+	}
+	return arg, nil
+}
+
+func (s *apiServer) status(r *http.Request) (interface{}, error) {
+	block, err := s.client.GetLatestBlock(false)
+	if err != nil {
+		return nil, err
+	}
+	if block == nil {
+		return nil, fmt.Errorf("no latest block")
+	}
+	peers, err := s.client.GetPeerCount()
+	if err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{
+		"height":    block.Number,
+		"hash":      block.Hash,
+		"timestamp": block.Timestamp,
+		"peers":     peers,
+	}, nil
+}
+
+func (s *apiServer) balance(r *http.Request) (interface{}, error) {
+	id, err := pathArg(r, "/api/balance/")
+	if err != nil {
+		return nil, err
+	}
+	address, err := s.app.getAddress(id)
+	if err != nil {
+		return nil, apiErrorf(http.StatusBadRequest, "%v", err)
+	}
+	wei, err := s.client.GetBalance(address)
+	if err != nil {
+		return nil, err
+	}
+	return &addressBalance{Address: address, Wei: wei.String(), HYK: formatWei(wei)}, nil
+}
+
+func (s *apiServer) block(r *http.Request) (interface{}, error) {
+	id, err := pathArg(r, "/api/block/")
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case id == "latest", id == "pending", strings.HasPrefix(id, "0x") && len(id) == 66:
+	default:
+		if _, err := parseHeight(id); err != nil {
+			return nil, apiErrorf(http.StatusBadRequest, "%v", err)
+		}
+	}
+	full, _ := strconv.ParseBool(r.URL.Query().Get("full"))
+
+	block, err := fetchBlock(s.client, id, full)
+	if err != nil {
+		return nil, err
+	}
+	if block == nil {
+		return nil, apiErrorf(http.StatusNotFound, "block %s not found", id)
+	}
+	return block, nil
+}
+
+func (s *apiServer) tx(r *http.Request) (interface{}, error) {
+	hash, err := pathArg(r, "/api/tx/")
+	if err != nil {
+		return nil, err
+	}
+	tx, err := s.client.Call(rpc.CoinId+"_getTransactionByHash", []interface{}{hash})
+	if err != nil {
+		return nil, err
+	}
+	if tx == nil {
+		return nil, apiErrorf(http.StatusNotFound, "transaction %s not found", hash)
+	}
+	receipt, err := s.client.GetTxReceipt(hash)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{"transaction": tx, "receipt": receipt}, nil
+}
+
+func (s *apiServer) addressBook(r *http.Request) (interface{}, error) {
+	book, err := addressbook.Load(s.app.addressBookPath())
+	if err != nil {
+		return nil, err
+	}
+	entries := book.Filter(r.URL.Query().Get("tag"))
+	if entries == nil {
+		entries = []*addressbook.Entry{}
+	}
+	return entries, nil
+}
+
+func (s *apiServer) addressBookEntry(r *http.Request) (interface{}, error) {
+	name, err := pathArg(r, "/api/addressbook/")
+	if err != nil {
+		return nil, err
+	}
+	book, err := addressbook.Load(s.app.addressBookPath())
+	if err != nil {
+		return nil, err
+	}
+	e := book.Get(name)
+	if e == nil {
+		return nil, apiErrorf(http.StatusNotFound, "address book entry %q not found", name)
+	}
+	return e, nil
+}
+
+// 查询参数中的日期范围 [from, to], to 包含当天
+func dateRange(r *http.Request) (from, to time.Time, err error) {
+	if s := r.URL.Query().Get("from"); s != "" {
+		if from, err = time.ParseInLocation("2006-01-02", s, time.Local); err != nil {
+			return from, to, apiErrorf(http.StatusBadRequest, "invalid from %q", s)
+		}
+	}
+	if s := r.URL.Query().Get("to"); s != "" {
+		if to, err = time.ParseInLocation("2006-01-02", s, time.Local); err != nil {
+			return from, to, apiErrorf(http.StatusBadRequest, "invalid to %q", s)
+		}
+		to = to.AddDate(0, 0, 1)
+	}
+	return from, to, nil
+}
+
+func (s *apiServer) loadRuns(r *http.Request) ([]*PayoutsRun, error) {
+	from, to, err := dateRange(r)
+	if err != nil {
+		return nil, err
+	}
+	runs, err := loadPayoutsRuns(s.opt.HistoryFile, from, to)
+	if os.IsNotExist(err) {
+		return []*PayoutsRun{}, nil
+	}
+	return runs, err
+}
+
+func (s *apiServer) payoutsRuns(r *http.Request) (interface{}, error) {
+	runs, err := s.loadRuns(r)
+	if err != nil {
+		return nil, err
+	}
+	if runs == nil {
+		runs = []*PayoutsRun{}
+	}
+	return runs, nil
+}
+
+func (s *apiServer) payoutsReport(r *http.Request) (interface{}, error) {
+	runs, err := s.loadRuns(r)
+	if err != nil {
+		return nil, err
+	}
+	report, err := s.app.buildPayoutsReport(s.client, runs)
+	if err != nil {
+		return nil, err
+	}
+	report.From, report.To, _ = dateRange(r)
+	return report, nil
+}
+
+type apiTransferRequest struct {
+	To       string
+	Value    float64 // HYK
+	GasLimit int64   `json:",omitempty"`
+	GasPrice int64   `json:",omitempty"`
+}
+
+func (s *apiServer) transfer(r *http.Request) (interface{}, error) {
+	policy, err := s.app.spendPolicy()
+	if err != nil {
+		return nil, err
+	}
+	if policy == nil || !policy.APITransfers {
+		return nil, apiErrorf(http.StatusForbidden, "transfers disabled: set APITransfers in the spending policy")
+	}
+	if s.opt.Token == "" {
+		return nil, apiErrorf(http.StatusForbidden, "transfers disabled: set --token to allow transfers")
+	}
+
+	var req apiTransferRequest
+	d := json.NewDecoder(io.LimitReader(r.Body, 1<<16))
+	d.DisallowUnknownFields()
+	if err := d.Decode(&req); err != nil {
+		return nil, apiErrorf(http.StatusBadRequest, "invalid request: %v", err)
+	}
+	if req.Value <= 0 || math.IsInf(req.Value, 0) || math.IsNaN(req.Value) {
+		return nil, apiErrorf(http.StatusBadRequest, "invalid value %v", req.Value)
+	}
+	if req.GasLimit < 0 || req.GasPrice < 0 {
+		return nil, apiErrorf(http.StatusBadRequest, "invalid gas")
+	}
+	if req.GasLimit == 0 {
+		req.GasLimit = DefaultGasLimit
+	}
+	if req.GasPrice == 0 {
+		req.GasPrice = DefaultGasPrice
+	}
+
+	to, err := s.app.getAddress(req.To)
+	if err != nil {
+		return nil, apiErrorf(http.StatusBadRequest, "%v", err)
+	}
+	value := etherToWei(req.Value)
+
+	// 没有交互确认, 超过 ConfirmAbove 的转账被拒绝
+	txHash, err := s.app.sendRawTx(s.client, to, value, uint64(req.GasLimit), big.NewInt(req.GasPrice))
+	if err != nil {
+		if strings.HasPrefix(err.Error(), "policy:") {
+			return nil, apiErrorf(http.StatusForbidden, "%v", err)
+		}
+		return nil, err
+	}
+	log.Printf("serve: transfer %s HYK to %s from %s, txHash: %s", formatWei(value), to, clientIP(r), txHash)
+	return map[string]string{"txHash": txHash, "to": to, "value": value.String()}, nil
+}
+
+// 按客户端的令牌桶限流
+type rateLimiter struct {
+	rate  float64
+	burst float64
+	now   func() time.Time
+
+	mu      sync.Mutex
+	buckets map[string]*rateBucket
+}
+
+type rateBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+func newRateLimiter(rate float64, burst int, now func() time.Time) *rateLimiter {
+	if burst <= 0 {
+		burst = int(math.Ceil(rate * 2))
+	}
+	return &rateLimiter{rate: rate, burst: float64(burst), now: now, buckets: make(map[string]*rateBucket)}
+}
+
+func (l *rateLimiter) allow(key string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	b := l.buckets[key]
+	if b == nil {
+		// 清理已经回满的桶, 避免客户端很多时占用内存
+		if len(l.buckets) > 10000 {
+			for k, x := range l.buckets {
+				if now.Sub(x.last).Seconds()*l.rate >= l.burst {
+					delete(l.buckets, k)
+				}
+			}
+		}
+		b = &rateBucket{tokens: l.burst, last: now}
+		l.buckets[key] = b
+	}
+
+	b.tokens = math.Min(l.burst, b.tokens+now.Sub(b.last).Seconds()*l.rate)
+	b.last = now
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
@@ -0,0 +1,197 @@
+package mainpkg
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAPIServer(t *testing.T) {
+	dir, err := ioutil.TempDir("", "serve")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	const bob = "0x5205f45c6399c41e11e533926ca69a0aedfdbb8d"
+	payer, payerKey := newTestKey(t)
+	node, app := newTestNode(t, map[string]*big.Int{payer: etherToWei(10)})
+	app.cfg.UserAddress, app.cfg.UserKey = payer, payerKey
+	app.cfg.AddressBookFile = filepath.Join(dir, "addressbook.json")
+	app.cfg.XUserAddressBook = map[string]string{"bob": bob}
+
+	history := filepath.Join(dir, "history.jsonl")
+	app.savePayoutsRun(&PayoutsFile{HistoryFile: history}, &PayoutsRun{Id: "run1", StartAt: time.Now()})
+
+	handler, err := app.NewAPIHandler(ServeOptions{Token: "secret", HistoryFile: history})
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	do := func(method, path, token, body string) (int, map[string]interface{}) {
+		req, _ := http.NewRequest(method, server.URL+path, strings.NewReader(body))
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+
+		data, _ := ioutil.ReadAll(resp.Body)
+		var v map[string]interface{}
+		if bytes.HasPrefix(data, []byte("{")) {
+			json.Unmarshal(data, &v)
+		}
+		return resp.StatusCode, v
+	}
+	get := func(path string) (int, map[string]interface{}) {
+		return do("GET", path, "secret", "")
+	}
+
+	if code, _ := do("GET", "/api/status", "", ""); code != http.StatusUnauthorized {
+		t.Fatalf("no token: %d", code)
+	}
+	if code, _ := do("GET", "/api/status", "wrong", ""); code != http.StatusUnauthorized {
+		t.Fatalf("wrong token: %d", code)
+	}
+	if code, v := get("/api/status"); code != http.StatusOK || v["height"] != "0x0" {
+		t.Fatalf("status: %d %v", code, v)
+	}
+	if code, v := get("/api/balance/" + payer); code != http.StatusOK || v["wei"] != etherToWei(10).String() {
+		t.Fatalf("balance: %d %v", code, v)
+	}
+	if code, v := get("/api/balance/bob"); code != http.StatusOK || v["address"] != bob {
+		t.Fatalf("balance by name: %d %v", code, v)
+	}
+	if code, _ := get("/api/balance/nobody"); code != http.StatusBadRequest {
+		t.Fatalf("unknown name: %d", code)
+	}
+	if code, _ := get("/api/block/abc"); code != http.StatusBadRequest {
+		t.Fatalf("invalid block: %d", code)
+	}
+	if code, _ := get("/api/block/100"); code != http.StatusNotFound {
+		t.Fatalf("missing block: %d", code)
+	}
+	if code, _ := do("POST", "/api/block/latest", "secret", ""); code != http.StatusMethodNotAllowed {
+		t.Fatalf("POST block: %d", code)
+	}
+
+	// 没有策略或者策略没有开启时不能转账
+	transfer := `{"To": "bob", "Value": 1}`
+	if code, _ := do("POST", "/api/transfer", "secret", transfer); code != http.StatusForbidden {
+		t.Fatalf("transfer without policy: %d", code)
+	}
+	policy := filepath.Join(dir, "policy.json")
+	app.cfg.PolicyFile = policy
+	writePolicy := func(p *SpendPolicy) {
+		p.StateFile = filepath.Join(dir, "spend-state.json")
+		data, _ := json.Marshal(p)
+		if err := ioutil.WriteFile(policy, data, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writePolicy(&SpendPolicy{MaxPerTx: 5})
+	if code, v := do("POST", "/api/transfer", "secret", transfer); code != http.StatusForbidden || !strings.Contains(v["error"].(string), "APITransfers") {
+		t.Fatalf("transfer not enabled: %d %v", code, v)
+	}
+
+	writePolicy(&SpendPolicy{MaxPerTx: 5, APITransfers: true})
+	// 没有令牌时不能转账
+	noToken, err := app.NewAPIHandler(ServeOptions{HistoryFile: history})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec := httptest.NewRecorder()
+	noToken.ServeHTTP(rec, httptest.NewRequest("POST", "/api/transfer", strings.NewReader(transfer)))
+	if rec.Code != http.StatusForbidden || !strings.Contains(rec.Body.String(), "token") {
+		t.Fatalf("transfer without token: %d %s", rec.Code, rec.Body)
+	}
+	if code, v := do("POST", "/api/transfer", "secret", `{"To": "bob", "Value": 6}`); code != http.StatusForbidden || !strings.Contains(v["error"].(string), "per-tx") {
+		t.Fatalf("transfer over limit: %d %v", code, v)
+	}
+	if code, _ := do("POST", "/api/transfer", "secret", `{"To": "bob", "Amount": 1}`); code != http.StatusBadRequest {
+		t.Fatalf("bad request: %d", code)
+	}
+	code, v := do("POST", "/api/transfer", "secret", transfer)
+	if code != http.StatusOK {
+		t.Fatalf("transfer: %d %v", code, v)
+	}
+	node.Mine()
+
+	txHash := v["txHash"].(string)
+	code, v = get("/api/tx/" + txHash)
+	if code != http.StatusOK || v["receipt"].(map[string]interface{})["status"] != "0x1" {
+		t.Fatalf("tx: %d %v", code, v)
+	}
+	if code, v := get("/api/balance/bob"); code != http.StatusOK || v["wei"] != etherToWei(1).String() {
+		t.Fatalf("bob balance: %d %v", code, v)
+	}
+
+	req, _ := http.NewRequest("GET", server.URL+"/api/payouts/runs", nil)
+	req.Header.Set("Authorization", "Bearer secret")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var runs []*PayoutsRun
+	json.NewDecoder(resp.Body).Decode(&runs)
+	resp.Body.Close()
+	if len(runs) != 1 || runs[0].Id != "run1" {
+		t.Fatalf("runs = %+v", runs)
+	}
+}
+
+func TestAPIRateLimitBeforeToken(t *testing.T) {
+	_, app := newTestNode(t, nil)
+	handler, err := app.NewAPIHandler(ServeOptions{Token: "secret", RateLimit: 1, Burst: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 猜错令牌的请求也受限流约束
+	var codes []int
+	for i := 0; i < 2; i++ {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", "/api/status", nil)
+		req.Header.Set("Authorization", "Bearer wrong")
+		handler.ServeHTTP(rec, req)
+		codes = append(codes, rec.Code)
+	}
+	if codes[0] != http.StatusUnauthorized || codes[1] != http.StatusTooManyRequests {
+		t.Fatalf("codes = %v", codes)
+	}
+}
+
+func TestRateLimiter(t *testing.T) {
+	now := time.Unix(1600000000, 0)
+	l := newRateLimiter(2, 3, func() time.Time { return now })
+
+	for i := 0; i < 3; i++ {
+		if !l.allow("a") {
+			t.Fatalf("request %d denied", i)
+		}
+	}
+	if l.allow("a") {
+		t.Fatal("burst exceeded")
+	}
+	if !l.allow("b") {
+		t.Fatal("other client denied")
+	}
+
+	now = now.Add(time.Millisecond * 500)
+	if !l.allow("a") || l.allow("a") {
+		t.Fatal("refill after 0.5s")
+	}
+}
@@ -0,0 +1,57 @@
+package mainpkg
+
+import (
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"xcoin/HayekTool/pkg/ethash"
+	"xcoin/HayekTool/pkg/stratum"
+)
+
+// 启动 Stratum 挖矿代理, 定期打印矿工统计, 收到 SIGINT/SIGTERM 时退出
+func (p *App) CmdStratumProxy(cfg stratum.Config, statsInterval time.Duration) error {
+	if statsInterval <= 0 {
+		statsInterval = time.Minute
+	}
+
+	c, err := p.rpcClient(time.Second * 3)
+	if err != nil {
+		return err
+	}
+
+	proxy := stratum.New(cfg, c, ethash.NewLight(2))
+	if err := proxy.Start(); err != nil {
+		return err
+	}
+
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(sigCh)
+
+	ticker := time.NewTicker(statsInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case sig := <-sigCh:
+			log.Printf("stratum: %v, exit", sig)
+			proxy.Stop()
+			logStratumStats(proxy.Stats())
+			return nil
+		case <-ticker.C:
+			logStratumStats(proxy.Stats())
+		}
+	}
+}
+
+func logStratumStats(stats []stratum.WorkerStats) {
+	for _, w := range stats {
+		log.Printf("stratum: worker %s: conns=%d valid=%d stale=%d invalid=%d dup=%d blocks=%d rejected=%d hashrate=%s",
+			w.Name, w.Connections, w.ValidShares, w.StaleShares, w.InvalidShares, w.DuplicateShares,
+			w.Blocks, w.RejectedBlocks, formatHashrate(w.Hashrate),
+		)
+	}
+}
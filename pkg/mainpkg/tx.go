@@ -10,7 +10,6 @@ import (
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/common/hexutil"
 	"github.com/ethereum/go-ethereum/core/types"
-	"github.com/ethereum/go-ethereum/crypto"
 	"github.com/ethereum/go-ethereum/rlp"
 
 	"xcoin/HayekTool/pkg/rpc"
@@ -33,16 +32,20 @@ func (p *App) CmdSendTx(to string, value, gasLimit, gasPrice int64) error {
 		gasPrice = DefaultGasPrice
 	}
 
-	c, err := rpc.NewRPCClient("HayekTool", p.cfg.Host, time.Second*3)
+	c, err := p.rpcClient(time.Second * 3)
 	if err != nil {
 		return err
 	}
 
 	valueWei := new(big.Int).Mul(big.NewInt(value), util.Ether)
 
-	to = p.cfg.GetAddress(to)
+	to, err = p.getAddress(to)
+	if err != nil {
+		return err
+	}
 
-	txHash, err := p.sendRawTx(c, to, valueWei, uint64(gasLimit), big.NewInt(gasPrice))
+	// 超过策略中的确认金额时在终端上确认
+	txHash, err := p.withConfirm(confirmStdin).sendRawTx(c, to, valueWei, uint64(gasLimit), big.NewInt(gasPrice))
 	if err != nil {
 		return err
 	}
@@ -51,30 +54,57 @@ func (p *App) CmdSendTx(to string, value, gasLimit, gasPrice int64) error {
 	return nil
 }
 
+// 使用配置文件中的 UserAddress/UserKey 发送交易
 func (p *App) sendRawTx(
 	client *rpc.RPCClient, to string, value *big.Int,
 	gasLimit uint64, gasPrice *big.Int,
 ) (
 	txHash string, err error,
 ) {
-	var (
-		privateKey, _ = crypto.HexToECDSA(p.cfg.UserKey)
-		toAddress     = common.HexToAddress(to)
-	)
-
 	if p.cfg.DebugMode {
 		s, _ := json.MarshalIndent(p.cfg, "", "\t")
 		fmt.Printf("App.sendRawTx: p.cfg = %s\n", s)
 	}
 
-	if privateKey == nil {
+	w, err := NewWallet(p.cfg.UserAddress, p.cfg.UserKey)
+	if err != nil {
 		if p.cfg.DebugMode {
-			log.Println("err")
+			log.Println(err)
 		}
 		return "", fmt.Errorf("invalid UserKey")
 	}
 
-	nonce, err := client.GetTransactionCount(p.cfg.UserAddress, "pending")
+	return p.sendRawTxFrom(client, w, to, value, gasLimit, gasPrice)
+}
+
+// 从钱包 w 发送交易, 发送前检查支出策略
+func (p *App) sendRawTxFrom(
+	client *rpc.RPCClient, w *Wallet, to string, value *big.Int,
+	gasLimit uint64, gasPrice *big.Int,
+) (
+	txHash string, err error,
+) {
+	var toAddress = common.HexToAddress(to)
+
+	spendMu.Lock()
+	defer spendMu.Unlock()
+
+	done, err := p.authorizeSpend(to, value)
+	if err != nil {
+		return "", err
+	}
+	sent := false
+	defer func() {
+		// 节点已经接受交易, 记账失败不能当作发送失败, 否则会重复支付
+		if errRecord := done(sent); errRecord != nil {
+			log.Printf("policy: record spending failed: %v", errRecord)
+		}
+	}()
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	nonce, err := w.nextNonce(client)
 	if err != nil {
 		if p.cfg.DebugMode {
 			log.Println("err")
@@ -84,25 +114,16 @@ func (p *App) sendRawTx(
 	if p.cfg.DebugMode {
 		log.Println("nonce:", nonce)
 	}
+	defer func() { w.doneNonce(err == nil) }()
 
-	// nonce = 1
 	tx := types.NewTransaction(nonce, toAddress, value, gasLimit, gasPrice, nil)
 
 	if p.cfg.DebugMode {
 		s, _ := json.MarshalIndent(tx, "", "\t")
 		log.Printf("App.sendRawTx: tx = %s\n", s)
-
-		addr, err2 := types.NewEIP155Signer(rpc.ChainID).Sender(tx)
-		log.Printf("App.sendRawTx111: %v, %x, %v", rpc.ChainID, addr, err2)
-		if err2 != nil {
-			if p.cfg.DebugMode {
-				log.Println(err2)
-			}
-			return "", err2
-		}
 	}
 
-	signedTx, err := types.SignTx(tx, types.NewEIP155Signer(rpc.ChainID), privateKey)
+	signedTx, err := types.SignTx(tx, types.NewEIP155Signer(rpc.ChainID), w.key)
 	if err != nil {
 		if p.cfg.DebugMode {
 			log.Println(err)
@@ -114,6 +135,13 @@ func (p *App) sendRawTx(
 		log.Println("signedTx:", string(s))
 	}
 
+	// 发送之前记录交易, 发送后没来得及保存结果就退出时可以查询
+	if p.beforeSend != nil {
+		if err = p.beforeSend(signedTx.Hash().Hex(), nonce); err != nil {
+			return "", err
+		}
+	}
+
 	data, err := rlp.EncodeToBytes(signedTx)
 	if err != nil {
 		if p.cfg.DebugMode {
@@ -132,6 +160,7 @@ func (p *App) sendRawTx(
 		}
 		return "", err
 	}
+	sent = true
 
 	if s := signedTx.Hash().Hex(); txHash != s {
 		if p.cfg.DebugMode {
@@ -0,0 +1,348 @@
+package mainpkg
+
+import (
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/rlp"
+
+	"xcoin/HayekTool/pkg/rpc"
+	"xcoin/HayekTool/pkg/util"
+)
+
+// 解码节点返回的十六进制字段, 只记录第一个错误
+type hexDecoder struct {
+	err error
+}
+
+func (d *hexDecoder) fail(field, s string, err error) {
+	if d.err == nil {
+		d.err = fmt.Errorf("invalid %s %q: %v", field, s, err)
+	}
+}
+
+func (d *hexDecoder) bytes(field, s string) []byte {
+	b, err := hexutil.Decode(s)
+	if err != nil {
+		d.fail(field, s, err)
+	}
+	return b
+}
+
+func (d *hexDecoder) fixed(field, s string, n int) []byte {
+	b := d.bytes(field, s)
+	if b != nil && len(b) != n {
+		d.fail(field, s, fmt.Errorf("want %d bytes, got %d", n, len(b)))
+		return make([]byte, n)
+	}
+	if b == nil {
+		return make([]byte, n)
+	}
+	return b
+}
+
+func (d *hexDecoder) hash(field, s string) common.Hash {
+	return common.BytesToHash(d.fixed(field, s, common.HashLength))
+}
+
+func (d *hexDecoder) address(field, s string) common.Address {
+	return common.BytesToAddress(d.fixed(field, s, common.AddressLength))
+}
+
+func (d *hexDecoder) big(field, s string) *big.Int {
+	v, err := hexutil.DecodeBig(s)
+	if err != nil {
+		d.fail(field, s, err)
+		return new(big.Int)
+	}
+	return v
+}
+
+func (d *hexDecoder) uint64(field, s string) uint64 {
+	v, err := hexutil.DecodeUint64(s)
+	if err != nil {
+		d.fail(field, s, err)
+	}
+	return v
+}
+
+// 用节点返回的字段重建区块头
+func blockHeader(b *rpc.GetBlockReply) (*types.Header, error) {
+	var d hexDecoder
+	head := &types.Header{
+		ParentHash:  d.hash("parentHash", b.ParentHash),
+		UncleHash:   d.hash("sha3Uncles", b.Sha3Uncles),
+		Coinbase:    d.address("miner", b.Miner),
+		Root:        d.hash("stateRoot", b.StateRoot),
+		TxHash:      d.hash("transactionsRoot", b.TransactionsRoot),
+		ReceiptHash: d.hash("receiptsRoot", b.ReceiptsRoot),
+		Bloom:       types.BytesToBloom(d.fixed("logsBloom", b.LogsBloom, types.BloomByteLength)),
+		Difficulty:  d.big("difficulty", b.Difficulty),
+		Number:      d.big("number", b.Number),
+		GasLimit:    d.uint64("gasLimit", b.GasLimit),
+		GasUsed:     d.uint64("gasUsed", b.GasUsed),
+		Time:        d.uint64("timestamp", b.Timestamp),
+		Extra:       d.bytes("extraData", b.ExtraData),
+		MixDigest:   d.hash("mixHash", b.MixHash),
+	}
+	copy(head.Nonce[:], d.fixed("nonce", b.Nonce, len(head.Nonce)))
+	if d.err != nil {
+		return nil, d.err
+	}
+	return head, nil
+}
+
+// 与 types.Transaction 的 RLP 编码相同
+type txRLP struct {
+	AccountNonce uint64
+	Price        *big.Int
+	GasLimit     uint64
+	Recipient    *common.Address `rlp:"nil"`
+	Amount       *big.Int
+	Payload      []byte
+	V, R, S      *big.Int
+}
+
+// 用节点返回的完整交易(包括签名)重建交易
+func blockTransaction(tx *rpc.Tx) (*types.Transaction, error) {
+	var d hexDecoder
+	data := txRLP{
+		AccountNonce: d.uint64("nonce", tx.Nonce),
+		Price:        d.big("gasPrice", tx.GasPrice),
+		GasLimit:     d.uint64("gas", tx.Gas),
+		Amount:       d.big("value", tx.Value),
+		Payload:      d.bytes("input", tx.Input),
+		V:            d.big("v", tx.V),
+		R:            d.big("r", tx.R),
+		S:            d.big("s", tx.S),
+	}
+	if tx.To != "" {
+		to := d.address("to", tx.To)
+		data.Recipient = &to
+	}
+	if d.err != nil {
+		return nil, fmt.Errorf("tx %s: %v", tx.Hash, d.err)
+	}
+
+	enc, err := rlp.EncodeToBytes(&data)
+	if err != nil {
+		return nil, err
+	}
+	t := new(types.Transaction)
+	if err := rlp.DecodeBytes(enc, t); err != nil {
+		return nil, fmt.Errorf("tx %s: %v", tx.Hash, err)
+	}
+	return t, nil
+}
+
+// 用节点返回的回执重建共识编码需要的字段
+func blockReceipt(r *rpc.TxReceipt) (*types.Receipt, error) {
+	var d hexDecoder
+	receipt := &types.Receipt{
+		CumulativeGasUsed: d.uint64("cumulativeGasUsed", r.CumulativeGasUsed),
+		Bloom:             types.BytesToBloom(d.fixed("logsBloom", r.LogsBloom, types.BloomByteLength)),
+		Logs:              []*types.Log{},
+	}
+	if r.Root != "" {
+		receipt.PostState = d.bytes("root", r.Root)
+	} else {
+		receipt.Status = d.uint64("status", r.Status)
+	}
+	for _, l := range r.Logs {
+		log := &types.Log{
+			Address: d.address("log address", l.Address),
+			Data:    d.bytes("log data", l.Data),
+		}
+		for _, topic := range l.Topics {
+			log.Topics = append(log.Topics, d.hash("log topic", topic))
+		}
+		receipt.Logs = append(receipt.Logs, log)
+	}
+	if d.err != nil {
+		return nil, fmt.Errorf("receipt %s: %v", r.TxHash, d.err)
+	}
+	return receipt, nil
+}
+
+// 一处不一致
+type BlockMismatch struct {
+	Field  string
+	Expect string // 区块中记录的值
+	Actual string // 重新计算的值
+}
+
+// 一个区块的验证结果
+type BlockVerification struct {
+	Height     int64
+	Hash       string
+	Mismatches []BlockMismatch
+	Err        string `json:",omitempty"` // 无法验证的原因
+}
+
+func (v *BlockVerification) OK() bool {
+	return len(v.Mismatches) == 0 && v.Err == ""
+}
+
+func (v *BlockVerification) mismatch(field string, expect, actual interface{}) {
+	e, a := fmt.Sprint(expect), fmt.Sprint(actual)
+	if e != a {
+		v.Mismatches = append(v.Mismatches, BlockMismatch{field, e, a})
+	}
+}
+
+// 验证区块哈希, 交易根, 回执根, 日志布隆和叔块哈希; parent 不为空时检查父哈希
+func verifyBlock(c *rpc.RPCClient, b, parent *rpc.GetBlockReply) *BlockVerification {
+	v := &BlockVerification{
+		Height: util.String2Big(b.Number).Int64(),
+		Hash:   b.Hash,
+	}
+	if err := checkBlock(c, b, parent, v); err != nil {
+		v.Err = err.Error()
+	}
+	return v
+}
+
+func checkBlock(c *rpc.RPCClient, b, parent *rpc.GetBlockReply, v *BlockVerification) error {
+	head, err := blockHeader(b)
+	if err != nil {
+		return err
+	}
+	v.mismatch("hash", common.HexToHash(b.Hash).Hex(), head.Hash().Hex())
+
+	if parent != nil {
+		v.mismatch("parentHash", common.HexToHash(parent.Hash).Hex(), head.ParentHash.Hex())
+		v.mismatch("number", new(big.Int).Add(util.String2Big(parent.Number), big.NewInt(1)), head.Number)
+	}
+
+	txs := make(types.Transactions, len(b.Transactions))
+	for i := range b.Transactions {
+		tx := &b.Transactions[i]
+		if txs[i], err = blockTransaction(tx); err != nil {
+			return err
+		}
+		v.mismatch(fmt.Sprintf("transactions[%d].hash", i), common.HexToHash(tx.Hash).Hex(), txs[i].Hash().Hex())
+	}
+	v.mismatch("transactionsRoot", head.TxHash.Hex(), types.DeriveSha(txs).Hex())
+
+	// 按节点返回的交易哈希查询回执, 交易被篡改时也能检查回执根
+	receipts := make(types.Receipts, len(txs))
+	for i := range b.Transactions {
+		hash := b.Transactions[i].Hash
+		reply, err := c.GetTxReceipt(hash)
+		if err != nil {
+			return err
+		}
+		if reply == nil {
+			return fmt.Errorf("receipt of tx %s not found", hash)
+		}
+		v.mismatch(fmt.Sprintf("receipts[%d].blockHash", i), common.HexToHash(b.Hash).Hex(), common.HexToHash(reply.BlockHash).Hex())
+		if receipts[i], err = blockReceipt(reply); err != nil {
+			return err
+		}
+	}
+	v.mismatch("receiptsRoot", head.ReceiptHash.Hex(), types.DeriveSha(receipts).Hex())
+	v.mismatch("logsBloom", hexutil.Encode(head.Bloom[:]), hexutil.Encode(types.CreateBloom(receipts).Bytes()))
+	if len(receipts) > 0 {
+		v.mismatch("gasUsed", head.GasUsed, receipts[len(receipts)-1].CumulativeGasUsed)
+	}
+
+	uncles := make([]*types.Header, len(b.Uncles))
+	for i := range b.Uncles {
+		reply, err := c.GetUncleByBlockNumberAndIndex(v.Height, i)
+		if err != nil {
+			return err
+		}
+		if reply == nil {
+			return fmt.Errorf("uncle %d not found", i)
+		}
+		if uncles[i], err = blockHeader(reply); err != nil {
+			return fmt.Errorf("uncle %d: %v", i, err)
+		}
+		v.mismatch(fmt.Sprintf("uncles[%d]", i), common.HexToHash(b.Uncles[i]).Hex(), uncles[i].Hash().Hex())
+	}
+	v.mismatch("sha3Uncles", head.UncleHash.Hex(), types.CalcUncleHash(uncles).Hex())
+	return nil
+}
+
+// 验证 from 到 to(包括)的区块, 检查相邻区块的父哈希; to 为空时只验证一个区块
+func (p *App) CmdVerifyBlock(from, to string) error {
+	c, err := p.rpcClient(time.Second * 10)
+	if err != nil {
+		return err
+	}
+
+	start, end, err := blockRange(c, from, to)
+	if err != nil {
+		return fmt.Errorf("verify-block: %v", err)
+	}
+
+	failed := 0
+	var parent *rpc.GetBlockReply
+	for height := start; height <= end; height++ {
+		b, err := c.GetBlockByHeight(height, true)
+		if err != nil {
+			return err
+		}
+		if b == nil {
+			return fmt.Errorf("verify-block: block %d not found", height)
+		}
+
+		v := verifyBlock(c, b, parent)
+		printBlockVerification(v)
+		if !v.OK() {
+			failed++
+		}
+		parent = b
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("verify-block: %d of %d blocks failed", failed, end-start+1)
+	}
+	return nil
+}
+
+// 区块范围 from 到 to(包括)的高度, to 为空时只有 from 一个区块
+func blockRange(c *rpc.RPCClient, from, to string) (start, end int64, err error) {
+	first, err := fetchBlock(c, from, false)
+	if err != nil {
+		return 0, 0, err
+	}
+	if first == nil {
+		return 0, 0, fmt.Errorf("block %s not found", from)
+	}
+	start = util.String2Big(first.Number).Int64()
+	if to == "" {
+		return start, start, nil
+	}
+
+	last, err := fetchBlock(c, to, false)
+	if err != nil {
+		return 0, 0, err
+	}
+	if last == nil {
+		return 0, 0, fmt.Errorf("block %s not found", to)
+	}
+	end = util.String2Big(last.Number).Int64()
+	if end < start {
+		return 0, 0, fmt.Errorf("end %d is before start %d", end, start)
+	}
+	return start, end, nil
+}
+
+func printBlockVerification(v *BlockVerification) {
+	switch {
+	case v.Err != "":
+		fmt.Printf("#%d %s ERROR %s\n", v.Height, v.Hash, v.Err)
+	case len(v.Mismatches) > 0:
+		fmt.Printf("#%d %s MISMATCH\n", v.Height, v.Hash)
+	default:
+		fmt.Printf("#%d %s ok\n", v.Height, v.Hash)
+	}
+	for _, m := range v.Mismatches {
+		fmt.Printf("\t%s: block %s, computed %s\n", m.Field, m.Expect, m.Actual)
+	}
+}
@@ -0,0 +1,96 @@
+package mainpkg
+
+import (
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+
+	"xcoin/HayekTool/pkg/ethash"
+	"xcoin/HayekTool/pkg/rpc"
+	"xcoin/HayekTool/pkg/util"
+)
+
+// verify-pow 中批量查询区块头的数量
+const verifyPowBatchSize = 100
+
+// 用 ethash 验证缓存重新计算 mix digest, 检查 nonce 和 mix digest 是否满足难度, 创世区块没有工作量证明
+func verifyPow(light *ethash.Light, b *rpc.GetBlockReply) *BlockVerification {
+	v := &BlockVerification{
+		Height: util.String2Big(b.Number).Int64(),
+		Hash:   b.Hash,
+	}
+	head, err := blockHeader(b)
+	if err != nil {
+		v.Err = err.Error()
+		return v
+	}
+	if head.Number.Sign() == 0 {
+		return v
+	}
+	if head.Difficulty.Sign() <= 0 {
+		v.Err = "invalid difficulty " + b.Difficulty
+		return v
+	}
+
+	sealHash := ethash.SealHash(head)
+	nonce := head.Nonce.Uint64()
+
+	mix, result := light.Compute(head.Number.Uint64(), sealHash.Bytes(), nonce)
+	v.mismatch("mixHash", head.MixDigest.Hex(), common.BytesToHash(mix).Hex())
+
+	target := util.DiffToTarget(head.Difficulty)
+	if new(big.Int).SetBytes(result).Cmp(target) > 0 {
+		v.Mismatches = append(v.Mismatches, BlockMismatch{
+			Field:  "result",
+			Expect: "<= " + hexutil.EncodeBig(target),
+			Actual: hexutil.Encode(result),
+		})
+	}
+	return v
+}
+
+// 验证 from 到 to(包括)的区块的工作量证明, to 为空时只验证一个区块
+//
+// 每个 epoch 第一次使用时需要生成 16MB 以上的缓存, caches 为保留的缓存数.
+func (p *App) CmdVerifyPow(from, to string, caches int) error {
+	c, err := p.rpcClient(time.Second * 10)
+	if err != nil {
+		return err
+	}
+
+	start, end, err := blockRange(c, from, to)
+	if err != nil {
+		return fmt.Errorf("verify-pow: %v", err)
+	}
+
+	light := ethash.NewLight(caches)
+	failed := 0
+	for batch := start; batch <= end; batch += verifyPowBatchSize {
+		var heights []int64
+		for h := batch; h <= end && h < batch+verifyPowBatchSize; h++ {
+			heights = append(heights, h)
+		}
+		blocks, err := c.GetBlocksByHeight(heights, false)
+		if err != nil {
+			return err
+		}
+		for i, b := range blocks {
+			if b == nil {
+				return fmt.Errorf("verify-pow: block %d not found", heights[i])
+			}
+			v := verifyPow(light, b)
+			printBlockVerification(v)
+			if !v.OK() {
+				failed++
+			}
+		}
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("verify-pow: %d of %d blocks failed", failed, end-start+1)
+	}
+	return nil
+}
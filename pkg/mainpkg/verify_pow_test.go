@@ -0,0 +1,75 @@
+package mainpkg
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+
+	"xcoin/HayekTool/pkg/ethash"
+	"xcoin/HayekTool/pkg/rpc"
+)
+
+func TestVerifyPow(t *testing.T) {
+	node, app := newTestNode(t, nil)
+	light := ethash.NewLight(1)
+
+	// 区块 1 通过 submitWork 封装, 区块 2 没有工作量证明
+	header, _, _ := node.Work()
+	sealHash := ethash.SealHash(header)
+	mix, _ := light.Compute(header.Number.Uint64(), sealHash.Bytes(), 7)
+	if !node.SubmitWork(types.EncodeNonce(7), sealHash, common.BytesToHash(mix)) {
+		t.Fatal("submit work rejected")
+	}
+	node.Mine()
+
+	client, _ := rpc.NewRPCClient("HayekTool", app.cfg.Host, time.Second)
+	block := func(height int64) *rpc.GetBlockReply {
+		b, err := client.GetBlockByHeight(height, false)
+		if err != nil || b == nil {
+			t.Fatalf("block %d: %v", height, err)
+		}
+		return b
+	}
+	fields := func(v *BlockVerification) string {
+		if v.Err != "" {
+			t.Fatal(v.Err)
+		}
+		var list []string
+		for _, m := range v.Mismatches {
+			list = append(list, m.Field)
+		}
+		return strings.Join(list, " ")
+	}
+
+	for _, height := range []int64{0, 1} {
+		if got := fields(verifyPow(light, block(height))); got != "" {
+			t.Fatalf("block %d: %s", height, got)
+		}
+	}
+
+	// 没有封装的区块 mix digest 不正确
+	if got := fields(verifyPow(light, block(2))); got != "mixHash" {
+		t.Fatalf("unsealed block: %s", got)
+	}
+
+	b := block(1)
+	b.Nonce = "0x0000000000000008"
+	if got := fields(verifyPow(light, b)); got != "mixHash" {
+		t.Fatalf("wrong nonce: %s", got)
+	}
+	b = block(1)
+	b.Difficulty = "0xffffffffffffffff"
+	if got := fields(verifyPow(light, b)); got != "mixHash result" {
+		t.Fatalf("raised difficulty: %s", got)
+	}
+
+	if err := app.CmdVerifyPow("0", "1", 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := app.CmdVerifyPow("0", "latest", 1); err == nil || !strings.Contains(err.Error(), "1 of 3") {
+		t.Fatalf("range with unsealed block: %v", err)
+	}
+}
@@ -0,0 +1,88 @@
+package mainpkg
+
+import (
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+
+	"xcoin/HayekTool/pkg/rpc"
+)
+
+func TestVerifyBlock(t *testing.T) {
+	const alice = "0x5205f45c6399c41e11e533926ca69a0aedfdbb8d"
+	bob, bobKey := newTestKey(t)
+	node, app := newTestNode(t, map[string]*big.Int{bob: etherToWei(10)})
+
+	wallet, _ := NewWallet(bob, bobKey)
+	client, _ := rpc.NewRPCClient("HayekTool", app.cfg.Host, time.Second)
+	for i := 1; i <= 2; i++ {
+		if _, err := app.sendRawTxFrom(client, wallet, alice, etherToWei(float64(i)), DefaultGasLimit, big.NewInt(DefaultGasPrice)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	node.Mine()
+	node.Mine()
+
+	if err := app.CmdVerifyBlock("0", "latest"); err != nil {
+		t.Fatal(err)
+	}
+
+	parent, _ := client.GetBlockByHeight(0, true)
+	fields := func(v *BlockVerification) string {
+		if v.Err != "" {
+			t.Fatal(v.Err)
+		}
+		var list []string
+		for _, m := range v.Mismatches {
+			list = append(list, m.Field)
+		}
+		return strings.Join(list, " ")
+	}
+	fetch := func() *rpc.GetBlockReply {
+		b, err := client.GetBlockByHeight(1, true)
+		if err != nil || len(b.Transactions) != 2 {
+			t.Fatalf("block 1: %v %+v", err, b)
+		}
+		return b
+	}
+
+	b := fetch()
+	if got := fields(verifyBlock(client, b, parent)); got != "" {
+		t.Fatalf("valid block: %s", got)
+	}
+
+	// 篡改交易金额, 交易哈希和交易根都不一致
+	b.Transactions[1].Value = "0x1"
+	if got := fields(verifyBlock(client, b, parent)); got != "transactions[1].hash transactionsRoot" {
+		t.Fatalf("tampered tx: %s", got)
+	}
+
+	// 交换交易顺序, 交易根, 回执根和累计 gas 都不一致
+	b = fetch()
+	b.Transactions[0], b.Transactions[1] = b.Transactions[1], b.Transactions[0]
+	if got := fields(verifyBlock(client, b, parent)); got != "transactionsRoot receiptsRoot gasUsed" {
+		t.Fatalf("reordered txs: %s", got)
+	}
+
+	// 修改区块头字段后区块哈希不一致, 与下一个区块的父哈希也不一致
+	b = fetch()
+	b.Timestamp = "0x1"
+	if got := fields(verifyBlock(client, b, parent)); got != "hash" {
+		t.Fatalf("tampered header: %s", got)
+	}
+	next, _ := client.GetBlockByHeight(2, true)
+	parent.Hash = "0x" + strings.Repeat("00", 32)
+	if got := fields(verifyBlock(client, fetch(), parent)); got != "parentHash" {
+		t.Fatalf("broken link: %s", got)
+	}
+	if got := fields(verifyBlock(client, next, parent)); got != "parentHash number" {
+		t.Fatalf("wrong parent: %s", got)
+	}
+
+	b = fetch()
+	b.Transactions[0].R = ""
+	if v := verifyBlock(client, b, nil); v.OK() || !strings.Contains(v.Err, "invalid r") {
+		t.Fatalf("missing signature: %+v", v)
+	}
+}
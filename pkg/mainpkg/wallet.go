@@ -0,0 +1,89 @@
+package mainpkg
+
+import (
+	"crypto/ecdsa"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/ethereum/go-ethereum/crypto"
+
+	"xcoin/HayekTool/pkg/rpc"
+)
+
+// 付款钱包, 管理私钥和 nonce
+//
+// 同一个钱包连续发送多笔交易时在本地递增 nonce, 发送失败后重新从节点获取.
+type Wallet struct {
+	Address string
+
+	key *ecdsa.PrivateKey
+
+	mu         sync.Mutex
+	nonce      uint64
+	nonceValid bool
+}
+
+// 创建钱包, 检查私钥和地址是否匹配
+func NewWallet(address, keyHex string) (*Wallet, error) {
+	key, err := crypto.HexToECDSA(strings.TrimPrefix(strings.TrimSpace(keyHex), "0x"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid key for %s", address)
+	}
+
+	keyAddress := crypto.PubkeyToAddress(key.PublicKey).Hex()
+	if address == "" {
+		address = strings.ToLower(keyAddress)
+	}
+	if !strings.EqualFold(keyAddress, address) {
+		return nil, fmt.Errorf("key does not match address %s", address)
+	}
+
+	return &Wallet{Address: address, key: key}, nil
+}
+
+// 读取私钥引用: env:变量名 或者 file:文件路径
+func ResolveKeyRef(ref string) (string, error) {
+	switch {
+	case strings.HasPrefix(ref, "env:"):
+		name := strings.TrimPrefix(ref, "env:")
+		if v := os.Getenv(name); v != "" {
+			return v, nil
+		}
+		return "", fmt.Errorf("key ref %q: environment variable not set", ref)
+
+	case strings.HasPrefix(ref, "file:"):
+		data, err := ioutil.ReadFile(strings.TrimPrefix(ref, "file:"))
+		if err != nil {
+			return "", fmt.Errorf("key ref %q: %v", ref, err)
+		}
+		return strings.TrimSpace(string(data)), nil
+
+	default:
+		return "", fmt.Errorf("key ref %q: expect env:NAME or file:PATH", ref)
+	}
+}
+
+// 下一个 nonce, 需要持有 w.mu
+func (w *Wallet) nextNonce(client *rpc.RPCClient) (uint64, error) {
+	if w.nonceValid {
+		return w.nonce, nil
+	}
+	nonce, err := client.GetTransactionCount(w.Address, "pending")
+	if err != nil {
+		return 0, err
+	}
+	w.nonce, w.nonceValid = nonce, true
+	return nonce, nil
+}
+
+// 发送结束后更新 nonce, 需要持有 w.mu
+func (w *Wallet) doneNonce(ok bool) {
+	if ok {
+		w.nonce++
+	} else {
+		w.nonceValid = false
+	}
+}
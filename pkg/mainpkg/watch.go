@@ -0,0 +1,434 @@
+package mainpkg
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"math/big"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+
+	"xcoin/HayekTool/pkg/notify"
+	"xcoin/HayekTool/pkg/rpc"
+	"xcoin/HayekTool/pkg/util"
+)
+
+const DefaultWatchStateFile = "watch-state.json"
+
+// 保留最近的区块用于检测分叉, 超过这个深度的分叉无法回滚
+const watchReorgDepth = 128
+
+// 监控事件类型
+const (
+	WatchTransferIn      = "transfer.in"      // 转入
+	WatchTransferOut     = "transfer.out"     // 转出
+	WatchTransferRemoved = "transfer.removed" // 转账所在的区块因为分叉被丢弃
+	WatchReorg           = "reorg"            // 分叉
+	WatchBalance         = "balance"          // 余额变化
+	WatchBalanceDrop     = "balance.drop"     // 余额减少, 但是没有看到转出交易
+	WatchBalanceLow      = "balance.low"      // 余额低于下限
+)
+
+// 监控事件, 每个事件输出一行 JSON
+type WatchEvent struct {
+	Type      string
+	Time      time.Time
+	Address   string   `json:",omitempty"` // 监控的地址
+	Height    int64    `json:",omitempty"`
+	BlockHash string   `json:",omitempty"`
+	TxHash    string   `json:",omitempty"`
+	From      string   `json:",omitempty"`
+	To        string   `json:",omitempty"`
+	Value     *big.Int `json:",omitempty"` // 转账金额(wei)
+	Balance   *big.Int `json:",omitempty"` // 当前余额(wei)
+	Delta     *big.Int `json:",omitempty"` // 余额变化(wei)
+	Depth     int      `json:",omitempty"` // 分叉深度
+}
+
+// 监控选项
+type WatchOptions struct {
+	Addresses     []string      // 地址或者地址簿中的名字
+	Tag           string        // 同时监控地址簿中有这个分类的地址
+	StateFile     string        // 进度文件, 重启后从上次的区块继续
+	FromHeight    int64         // 没有进度文件时的起始高度, 0 表示从最新区块开始
+	Confirmations int64         // 只处理有足够确认数的区块
+	Interval      time.Duration // 轮询间隔
+	MinBalance    float64       // 余额低于该值(HYK)时报警, 0 表示不检查
+	Webhook       string        // 事件同时发送到 webhook
+	WebhookSecret string
+	Output        io.Writer
+}
+
+// 已处理的区块
+type watchBlock struct {
+	Height int64
+	Hash   string
+	Events []*WatchEvent `json:",omitempty"` // 区块中的转账, 分叉时用于撤销
+}
+
+// 监控进度
+type watchState struct {
+	Initialized   bool                // 已经确定起始高度
+	Height        int64               // 最后处理的区块
+	Recent        []*watchBlock       // 最近的区块, 按高度排序
+	Balances      map[string]*big.Int // 按地址(小写)记录的余额
+	BalanceHeight int64               // 记录余额时已经处理的区块, 分叉时撤销之后被丢弃的转账
+}
+
+func loadWatchState(path string) (*watchState, error) {
+	state := &watchState{Balances: make(map[string]*big.Int)}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return state, nil
+		}
+		return nil, err
+	}
+	if err := json.Unmarshal(data, state); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	if state.Balances == nil {
+		state.Balances = make(map[string]*big.Int)
+	}
+	// 旧版本的进度文件没有 Initialized
+	if !state.Initialized && state.Height > 0 {
+		state.Initialized = true
+	}
+	return state, nil
+}
+
+// 原子地写入, 避免中途退出时损坏
+func (s *watchState) save(path string) error {
+	data, err := json.MarshalIndent(s, "", "\t")
+	if err != nil {
+		return err
+	}
+	return util.WriteFileAtomic(path, data, 0644)
+}
+
+func (s *watchState) recent(height int64) *watchBlock {
+	for i := len(s.Recent) - 1; i >= 0; i-- {
+		if s.Recent[i].Height == height {
+			return s.Recent[i]
+		}
+	}
+	return nil
+}
+
+// 地址监控
+type watcher struct {
+	app      *App
+	opt      *WatchOptions
+	client   *rpc.RPCClient
+	state    *watchState
+	watched  map[string]string // 小写地址 => 地址
+	notifier *notify.Dispatcher
+}
+
+// 监控地址的余额和转账, 直到收到 SIGINT/SIGTERM
+func (p *App) CmdWatch(opt *WatchOptions) error {
+	if opt.StateFile == "" {
+		opt.StateFile = DefaultWatchStateFile
+	}
+	if opt.Interval <= 0 {
+		opt.Interval = time.Second * 5
+	}
+	if opt.Output == nil {
+		opt.Output = os.Stdout
+	}
+
+	w := &watcher{app: p, opt: opt, watched: make(map[string]string)}
+	if err := w.resolveAddresses(); err != nil {
+		return err
+	}
+	if opt.Webhook != "" {
+		w.notifier = notify.NewDispatcher(&notify.Config{
+			Webhooks: []notify.WebhookConfig{{URL: opt.Webhook, Secret: opt.WebhookSecret}},
+		})
+	}
+
+	var err error
+	if w.client, err = p.rpcClient(time.Second * 3); err != nil {
+		return err
+	}
+	if w.state, err = loadWatchState(opt.StateFile); err != nil {
+		return err
+	}
+
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(sigCh)
+
+	ticker := time.NewTicker(opt.Interval)
+	defer ticker.Stop()
+
+	for {
+		if err := w.poll(); err != nil {
+			log.Printf("watch: %v", err)
+		}
+
+		select {
+		case sig := <-sigCh:
+			log.Printf("watch: %v, exit at height %d", sig, w.state.Height)
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+func (w *watcher) resolveAddresses() error {
+	resolver, err := w.app.addressResolver()
+	if err != nil {
+		return err
+	}
+	for _, s := range w.opt.Addresses {
+		address, err := resolver.resolve(s)
+		if err != nil {
+			return err
+		}
+		w.watched[strings.ToLower(address)] = address
+	}
+	if w.opt.Tag != "" {
+		for _, e := range resolver.book.Filter(w.opt.Tag) {
+			w.watched[strings.ToLower(e.Address)] = e.Address
+		}
+	}
+	if len(w.watched) == 0 {
+		return fmt.Errorf("watch: no address")
+	}
+
+	var list []string
+	for _, v := range w.watched {
+		list = append(list, v)
+	}
+	sort.Strings(list)
+	log.Printf("watch: %d address(es): %s", len(list), strings.Join(list, ", "))
+	return nil
+}
+
+// 处理新的区块, 然后检查余额
+func (w *watcher) poll() error {
+	latest, err := w.client.GetLatestBlock(false)
+	if err != nil {
+		return err
+	}
+	if latest == nil {
+		return fmt.Errorf("no latest block")
+	}
+	target := util.String2Big(latest.Number).Int64() - w.opt.Confirmations
+
+	if !w.state.Initialized {
+		w.state.Initialized = true
+		w.state.Height = target
+		if w.opt.FromHeight > 0 {
+			w.state.Height = w.opt.FromHeight - 1
+		}
+		w.state.BalanceHeight = w.state.Height
+		log.Printf("watch: start after height %d", w.state.Height)
+	}
+
+	outgoing := make(map[string]int) // 本次处理的区块中每个地址的转出笔数
+	for w.state.Height < target {
+		block, err := w.client.GetBlockByHeight(w.state.Height+1, true)
+		if err != nil {
+			return err
+		}
+		if block == nil {
+			return fmt.Errorf("block %d not found", w.state.Height+1)
+		}
+
+		// 父区块和记录的不一致时回滚, 然后重新处理
+		if prev := w.state.recent(w.state.Height); prev != nil && !strings.EqualFold(prev.Hash, block.ParentHash) {
+			if err := w.rollback(outgoing); err != nil {
+				return err
+			}
+			continue
+		}
+
+		w.processBlock(block, outgoing)
+		if err := w.state.save(w.opt.StateFile); err != nil {
+			return err
+		}
+	}
+
+	if err := w.checkBalances(outgoing); err != nil {
+		return err
+	}
+	w.state.BalanceHeight = w.state.Height
+	return w.state.save(w.opt.StateFile)
+}
+
+func (w *watcher) processBlock(block *rpc.GetBlockReply, outgoing map[string]int) {
+	b := &watchBlock{
+		Height: util.String2Big(block.Number).Int64(),
+		Hash:   block.Hash,
+	}
+
+	for _, tx := range block.Transactions {
+		from, to := strings.ToLower(tx.From), strings.ToLower(tx.To)
+		value := util.String2Big(tx.Value)
+
+		for _, x := range []struct {
+			typ, address string
+		}{
+			{WatchTransferOut, from},
+			{WatchTransferIn, to},
+		} {
+			address, ok := w.watched[x.address]
+			if !ok {
+				continue
+			}
+			if x.typ == WatchTransferOut {
+				outgoing[x.address]++
+			}
+			e := &WatchEvent{
+				Type:      x.typ,
+				Address:   address,
+				Height:    b.Height,
+				BlockHash: b.Hash,
+				TxHash:    tx.Hash,
+				From:      tx.From,
+				To:        tx.To,
+				Value:     value,
+			}
+			b.Events = append(b.Events, e)
+			w.emit(e)
+		}
+	}
+
+	w.state.Height = b.Height
+	w.state.Recent = append(w.state.Recent, b)
+	if n := len(w.state.Recent); n > watchReorgDepth {
+		w.state.Recent = w.state.Recent[n-watchReorgDepth:]
+	}
+}
+
+// 从最新的区块往回找到和链上一致的区块, 丢弃之后的区块并撤销其中的转账
+//
+// 记录的余额和本次的转出笔数中也撤销被丢弃的转账, 避免误报余额变化.
+func (w *watcher) rollback(outgoing map[string]int) error {
+	var dropped []*watchBlock
+	for len(w.state.Recent) > 0 {
+		last := w.state.Recent[len(w.state.Recent)-1]
+		block, err := w.client.GetBlockByHeight(last.Height, false)
+		if err != nil {
+			return err
+		}
+		if block != nil && strings.EqualFold(block.Hash, last.Hash) {
+			break
+		}
+		dropped = append(dropped, last)
+		w.state.Recent = w.state.Recent[:len(w.state.Recent)-1]
+	}
+	if len(w.state.Recent) == 0 {
+		return fmt.Errorf("reorg deeper than %d blocks at height %d", watchReorgDepth, w.state.Height)
+	}
+
+	fork := w.state.Recent[len(w.state.Recent)-1]
+	w.emit(&WatchEvent{
+		Type:      WatchReorg,
+		Height:    fork.Height,
+		BlockHash: fork.Hash,
+		Depth:     len(dropped),
+	})
+	for _, b := range dropped {
+		for _, e := range b.Events {
+			x := *e
+			x.Type = WatchTransferRemoved
+			w.emit(&x)
+
+			k := strings.ToLower(e.Address)
+			if e.Type == WatchTransferOut && outgoing[k] > 0 {
+				outgoing[k]--
+			}
+			if old, ok := w.state.Balances[k]; ok && b.Height <= w.state.BalanceHeight && e.Value != nil {
+				if e.Type == WatchTransferIn {
+					w.state.Balances[k] = new(big.Int).Sub(old, e.Value)
+				} else {
+					w.state.Balances[k] = new(big.Int).Add(old, e.Value)
+				}
+			}
+		}
+	}
+
+	w.state.Height = fork.Height
+	if w.state.BalanceHeight > fork.Height {
+		w.state.BalanceHeight = fork.Height
+	}
+	return nil
+}
+
+// 检查已处理高度的余额变化; 余额减少但是没有看到转出交易时额外报警
+//
+// 使用已处理的高度而不是 latest, 还没有确认的区块中的转出不会被误报.
+func (w *watcher) checkBalances(outgoing map[string]int) error {
+	if w.state.Height < 0 {
+		return nil
+	}
+
+	var keys []string
+	for k := range w.watched {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	minBalance := etherToWei(w.opt.MinBalance)
+	for _, k := range keys {
+		address := w.watched[k]
+		balance, err := w.client.GetBalanceAt(address, w.state.Height)
+		if err != nil {
+			return err
+		}
+
+		old, ok := w.state.Balances[k]
+		w.state.Balances[k] = balance
+		if !ok || old.Cmp(balance) == 0 {
+			continue
+		}
+
+		delta := new(big.Int).Sub(balance, old)
+		w.emit(&WatchEvent{Type: WatchBalance, Address: address, Height: w.state.Height, Balance: balance, Delta: delta})
+
+		if delta.Sign() < 0 && outgoing[k] == 0 {
+			w.emit(&WatchEvent{Type: WatchBalanceDrop, Address: address, Height: w.state.Height, Balance: balance, Delta: delta})
+		}
+		if w.opt.MinBalance > 0 && balance.Cmp(minBalance) < 0 && old.Cmp(minBalance) >= 0 {
+			w.emit(&WatchEvent{Type: WatchBalanceLow, Address: address, Height: w.state.Height, Balance: balance})
+		}
+	}
+	return nil
+}
+
+// 输出事件, 配置了 webhook 时同时发送
+func (w *watcher) emit(e *WatchEvent) {
+	e.Time = time.Now()
+
+	data, _ := json.Marshal(e)
+	fmt.Fprintln(w.opt.Output, string(data))
+
+	if w.notifier == nil {
+		return
+	}
+	subject := fmt.Sprintf("[HayekTool] watch %s %s", e.Type, e.Address)
+	if e.Value != nil {
+		subject += fmt.Sprintf(" %s HYK", formatWei(e.Value))
+	}
+	err := w.notifier.Notify(&notify.Event{
+		Type:    "watch." + e.Type,
+		Source:  "HayekTool",
+		Time:    e.Time,
+		Subject: subject,
+		Text:    string(data),
+		Data:    e,
+	})
+	if err != nil {
+		log.Printf("watch: webhook: %v", err)
+	}
+}
@@ -0,0 +1,181 @@
+package mainpkg
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"xcoin/HayekTool/pkg/rpc"
+)
+
+func TestWatchReorg(t *testing.T) {
+	const alice = "0x3eb41fc94f240242c9bbb8bf46b9feb356fd09e2"
+	dir, err := ioutil.TempDir("", "watch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	bob, bobKey := newTestKey(t)
+	node, app := newTestNode(t, map[string]*big.Int{bob: etherToWei(10)})
+
+	// 区块 2 中 bob 转给 alice 1 HYK
+	node.Mine()
+	wallet, _ := NewWallet(bob, bobKey)
+	client, _ := rpc.NewRPCClient("HayekTool", app.cfg.Host, time.Second)
+	if _, err := app.sendRawTxFrom(client, wallet, alice, etherToWei(1), DefaultGasLimit, big.NewInt(DefaultGasPrice)); err != nil {
+		t.Fatal(err)
+	}
+	node.Mine()
+	node.Mine()
+
+	var out bytes.Buffer
+	opt := &WatchOptions{StateFile: filepath.Join(dir, DefaultWatchStateFile), FromHeight: 1, Output: &out}
+	newWatcher := func() *watcher {
+		client, err := rpc.NewRPCClient("HayekTool", app.cfg.Host, time.Second)
+		if err != nil {
+			t.Fatal(err)
+		}
+		state, err := loadWatchState(opt.StateFile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return &watcher{opt: opt, client: client, state: state, watched: map[string]string{alice: alice}}
+	}
+	events := func() []string {
+		var types []string
+		for _, line := range strings.Split(strings.TrimSpace(out.String()), "\n") {
+			var e WatchEvent
+			if err := json.Unmarshal([]byte(line), &e); err != nil {
+				t.Fatal(err)
+			}
+			types = append(types, fmt.Sprintf("%s@%d", e.Type, e.Height))
+		}
+		out.Reset()
+		return types
+	}
+
+	w := newWatcher()
+	if err := w.poll(); err != nil {
+		t.Fatal(err)
+	}
+	if got := events(); fmt.Sprint(got) != "[transfer.in@2]" || w.state.Height != 3 {
+		t.Fatalf("events = %v, height = %d", got, w.state.Height)
+	}
+
+	// 重启后从进度文件继续, 区块 2 和 3 被分叉替换, 转账丢失
+	if err := node.Rewind(2); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		node.Mine()
+	}
+
+	w = newWatcher()
+	if err := w.poll(); err != nil {
+		t.Fatal(err)
+	}
+	// 记录的余额撤销了丢弃的转账, 不会误报余额减少
+	want := "[reorg@1 transfer.removed@2]"
+	if got := events(); fmt.Sprint(got) != want || w.state.Height != 4 {
+		t.Fatalf("events = %v, height = %d, expect %s", got, w.state.Height, want)
+	}
+	if v := w.state.Balances[alice]; v == nil || v.Sign() != 0 {
+		t.Fatalf("balance after reorg = %v", v)
+	}
+}
+
+func TestWatchFromGenesis(t *testing.T) {
+	const alice = "0x3eb41fc94f240242c9bbb8bf46b9feb356fd09e2"
+	dir, err := ioutil.TempDir("", "watch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	bob, bobKey := newTestKey(t)
+	node, app := newTestNode(t, map[string]*big.Int{bob: etherToWei(10)})
+	client, _ := rpc.NewRPCClient("HayekTool", app.cfg.Host, time.Second)
+
+	var out bytes.Buffer
+	opt := &WatchOptions{StateFile: filepath.Join(dir, DefaultWatchStateFile), Output: &out}
+	state, _ := loadWatchState(opt.StateFile)
+	w := &watcher{opt: opt, client: client, state: state, watched: map[string]string{alice: alice}}
+
+	// 从高度 0 开始, 之后的区块不能被跳过
+	if err := w.poll(); err != nil {
+		t.Fatal(err)
+	}
+	if !w.state.Initialized || w.state.Height != 0 {
+		t.Fatalf("state = %+v", w.state)
+	}
+
+	wallet, _ := NewWallet(bob, bobKey)
+	if _, err := app.sendRawTxFrom(client, wallet, alice, etherToWei(1), DefaultGasLimit, big.NewInt(DefaultGasPrice)); err != nil {
+		t.Fatal(err)
+	}
+	node.Mine()
+	out.Reset()
+	if err := w.poll(); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out.String(), `"Type":"transfer.in"`) || w.state.Height != 1 {
+		t.Fatalf("height = %d, events:\n%s", w.state.Height, out.String())
+	}
+}
+
+func TestWatchConfirmations(t *testing.T) {
+	const alice = "0x3eb41fc94f240242c9bbb8bf46b9feb356fd09e2"
+	dir, err := ioutil.TempDir("", "watch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	bob, bobKey := newTestKey(t)
+	node, app := newTestNode(t, map[string]*big.Int{bob: etherToWei(10)})
+	client, _ := rpc.NewRPCClient("HayekTool", app.cfg.Host, time.Second)
+	node.Mine()
+
+	var out bytes.Buffer
+	opt := &WatchOptions{StateFile: filepath.Join(dir, DefaultWatchStateFile), Confirmations: 1, Output: &out}
+	state, _ := loadWatchState(opt.StateFile)
+	w := &watcher{opt: opt, client: client, state: state, watched: map[string]string{strings.ToLower(bob): bob}}
+	poll := func() string {
+		out.Reset()
+		if err := w.poll(); err != nil {
+			t.Fatal(err)
+		}
+		var types []string
+		for _, line := range strings.Split(strings.TrimSpace(out.String()), "\n") {
+			var e WatchEvent
+			if line != "" && json.Unmarshal([]byte(line), &e) == nil {
+				types = append(types, fmt.Sprintf("%s@%d", e.Type, e.Height))
+			}
+		}
+		return fmt.Sprint(types)
+	}
+	poll()
+
+	// 转出交易在还没有确认的区块 2 中, 余额按已处理的高度检查, 不报警
+	wallet, _ := NewWallet(bob, bobKey)
+	if _, err := app.sendRawTxFrom(client, wallet, alice, etherToWei(1), DefaultGasLimit, big.NewInt(DefaultGasPrice)); err != nil {
+		t.Fatal(err)
+	}
+	node.Mine()
+	if got := poll(); got != "[]" || w.state.Height != 1 {
+		t.Fatalf("unconfirmed send: events = %s, height = %d", got, w.state.Height)
+	}
+
+	node.Mine()
+	if got, want := poll(), "[transfer.out@2 balance@2]"; got != want {
+		t.Fatalf("events = %s, expect %s", got, want)
+	}
+}
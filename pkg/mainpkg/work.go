@@ -0,0 +1,317 @@
+package mainpkg
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"math/big"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common/hexutil"
+
+	"xcoin/HayekTool/pkg/rpc"
+	"xcoin/HayekTool/pkg/util"
+)
+
+// 节点返回的挖矿工作
+type Work struct {
+	Header    string // reply[0]
+	Seed      string // reply[1]
+	Target    string // reply[2]
+	Height    string // reply[3]
+	StateRoot string // reply[4]
+	Timestamp string // reply[5]
+
+	XXXHeight    int
+	XXXTimestamp time.Time
+}
+
+func parseWork(reply []string) (*Work, error) {
+	if len(reply) != 6 {
+		return nil, fmt.Errorf("invalid work, len != 6")
+	}
+
+	w := &Work{
+		Header:    reply[0],
+		Seed:      reply[1],
+		Target:    reply[2],
+		Height:    reply[3],
+		StateRoot: reply[4],
+		Timestamp: reply[5],
+	}
+
+	height, err := strconv.ParseUint(strings.TrimPrefix(w.Height, "0x"), 16, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid work height %q", w.Height)
+	}
+	timestamp, err := strconv.ParseUint(strings.TrimPrefix(w.Timestamp, "0x"), 16, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid work timestamp %q", w.Timestamp)
+	}
+
+	w.XXXHeight = int(height)
+	w.XXXTimestamp = time.Unix(int64(timestamp), 0)
+	return w, nil
+}
+
+func (w *Work) key() string {
+	return strings.Join([]string{w.Header, w.Seed, w.Target, w.Height, w.StateRoot, w.Timestamp}, ",")
+}
+
+// 轮询挖矿工作, 工作变化时调用 onWork, 没有变化时调用 onIdle(可以为 nil);
+// 连接失败时只在状态变化时打印日志, 收到 SIGINT/SIGTERM 时返回.
+func (p *App) pollWork(interval time.Duration, onWork func(c *rpc.RPCClient, w *Work), onIdle func(c *rpc.RPCClient)) error {
+	c, err := p.rpcClient(time.Second * 3)
+	if err != nil {
+		return err
+	}
+
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(sigCh)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	var (
+		lastKey string
+		lastErr error
+	)
+	for {
+		reply, err := c.GetWork()
+		var w *Work
+		if err == nil {
+			w, err = parseWork(reply)
+		}
+
+		switch {
+		case err != nil:
+			if lastErr == nil {
+				log.Printf("GetWork: %s: %v", p.cfg.Host, err)
+			}
+		case lastErr != nil:
+			log.Printf("connect %s ok", p.cfg.Host)
+		}
+		lastErr = err
+
+		if w != nil {
+			if key := w.key(); key != lastKey {
+				lastKey = key
+				onWork(c, w)
+			} else if onIdle != nil {
+				onIdle(c)
+			}
+		}
+
+		select {
+		case sig := <-sigCh:
+			log.Printf("%v, exit", sig)
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+func (p *App) CmdGetWork() error {
+	return p.pollWork(time.Second, func(c *rpc.RPCClient, w *Work) {
+		s, _ := json.MarshalIndent(w, "", "\t")
+		fmt.Println(string(s))
+	}, nil)
+}
+
+// 工作监控记录
+type WorkRecord struct {
+	Time          time.Time
+	Height        int
+	Header        string
+	Seed          string
+	Target        string
+	Difficulty    *big.Int
+	SinceLast     float64  `json:",omitempty"` // 距离上一个工作的秒数
+	HeightTime    float64  `json:",omitempty"` // 高度变化时, 上一个高度持续的秒数
+	SeedChanged   bool     `json:",omitempty"`
+	TargetChanged bool     `json:",omitempty"`
+	Warnings      []string `json:",omitempty"` // 过期工作等问题
+}
+
+// 工作监控
+type workMonitor struct {
+	staleAfter time.Duration
+	now        func() time.Time
+
+	last       *Work
+	lastTime   time.Time
+	heightTime time.Time // 当前高度第一次出现的时间
+	lateWarned bool
+
+	works  int
+	stales int
+	blocks []time.Duration // 每个高度持续的时间
+}
+
+// 记录新的工作; chainHeight 为节点的最新区块高度, 未知时为 -1
+func (m *workMonitor) record(w *Work, chainHeight int64) *WorkRecord {
+	now := m.now()
+	r := &WorkRecord{
+		Time:       now,
+		Height:     w.XXXHeight,
+		Header:     w.Header,
+		Seed:       w.Seed,
+		Target:     w.Target,
+		Difficulty: util.TargetHexToDiff(w.Target),
+	}
+
+	if chainHeight >= 0 && int64(w.XXXHeight) <= chainHeight {
+		r.Warnings = append(r.Warnings, fmt.Sprintf("stale work: height %d, chain height %d", w.XXXHeight, chainHeight))
+	}
+	if age := now.Sub(w.XXXTimestamp); age > m.staleAfter {
+		r.Warnings = append(r.Warnings, fmt.Sprintf("old work timestamp: %v ago", age.Round(time.Second)))
+	}
+
+	if m.last != nil {
+		r.SinceLast = now.Sub(m.lastTime).Seconds()
+		r.SeedChanged = w.Seed != m.last.Seed
+		r.TargetChanged = w.Target != m.last.Target
+
+		switch {
+		case w.XXXHeight < m.last.XXXHeight:
+			r.Warnings = append(r.Warnings, fmt.Sprintf("height went back from %d", m.last.XXXHeight))
+		case w.XXXHeight > m.last.XXXHeight:
+			d := now.Sub(m.heightTime)
+			r.HeightTime = d.Seconds()
+			m.blocks = append(m.blocks, d)
+		}
+	}
+	if m.last == nil || w.XXXHeight != m.last.XXXHeight {
+		m.heightTime = now
+		m.lateWarned = false
+	}
+
+	m.last, m.lastTime = w, now
+	m.works++
+	if len(r.Warnings) > 0 {
+		m.stales++
+	}
+	return r
+}
+
+// 高度长时间没有变化时返回告警, 每个高度只告警一次
+func (m *workMonitor) checkLate() string {
+	if m.last == nil || m.lateWarned {
+		return ""
+	}
+	if d := m.now().Sub(m.heightTime); d > m.staleAfter {
+		m.lateWarned = true
+		m.stales++
+		return fmt.Sprintf("no new height for %v at height %d", d.Round(time.Second), m.last.XXXHeight)
+	}
+	return ""
+}
+
+func (m *workMonitor) summary() string {
+	var total time.Duration
+	for _, d := range m.blocks {
+		total += d
+	}
+	avg := time.Duration(0)
+	if len(m.blocks) > 0 {
+		avg = total / time.Duration(len(m.blocks))
+	}
+	return fmt.Sprintf("works: %d, height changes: %d, avg height time: %v, warnings: %d",
+		m.works, len(m.blocks), avg.Round(time.Millisecond), m.stales,
+	)
+}
+
+// 监控挖矿工作: 记录每个工作到达的时间, 高度持续的时间, seed/target 变化, 并标记过期的工作
+func (p *App) CmdWorkMonitor(interval, staleAfter time.Duration, format string) error {
+	if interval <= 0 {
+		interval = time.Second
+	}
+	if staleAfter <= 0 {
+		staleAfter = time.Minute * 2
+	}
+	m := &workMonitor{staleAfter: staleAfter, now: time.Now}
+
+	err := p.pollWork(interval, func(c *rpc.RPCClient, w *Work) {
+		chainHeight := int64(-1)
+		if b, err := c.GetLatestBlock(false); err != nil {
+			log.Printf("GetLatestBlock: %v", err)
+		} else if b != nil {
+			chainHeight = util.String2Big(b.Number).Int64()
+		}
+
+		r := m.record(w, chainHeight)
+		if format == "json" {
+			s, _ := json.Marshal(r)
+			fmt.Println(string(s))
+			return
+		}
+
+		line := fmt.Sprintf("%s height=%d diff=%s header=%s", r.Time.Format("15:04:05.000"), r.Height, r.Difficulty, r.Header)
+		if r.SinceLast > 0 {
+			line += fmt.Sprintf(" +%.1fs", r.SinceLast)
+		}
+		if r.HeightTime > 0 {
+			line += fmt.Sprintf(" height-time=%.1fs", r.HeightTime)
+		}
+		if r.SeedChanged {
+			line += " seed-changed"
+		}
+		if r.TargetChanged {
+			line += " target-changed"
+		}
+		fmt.Println(line)
+		for _, s := range r.Warnings {
+			fmt.Println("  WARNING:", s)
+		}
+	}, func(c *rpc.RPCClient) {
+		if s := m.checkLate(); s != "" {
+			if format == "json" {
+				data, _ := json.Marshal(map[string]interface{}{"Time": m.now(), "Warnings": []string{s}})
+				fmt.Println(string(data))
+			} else {
+				fmt.Println("  WARNING:", s)
+			}
+		}
+	})
+
+	log.Println(m.summary())
+	return err
+}
+
+// 提交挖矿结果
+func (p *App) CmdSubmitWork(nonce, header, mixDigest string) error {
+	for _, x := range []struct {
+		name, value string
+		size        int
+	}{
+		{"nonce", nonce, 8},
+		{"header", header, 32},
+		{"mix-digest", mixDigest, 32},
+	} {
+		b, err := hexutil.Decode(x.value)
+		if err != nil || len(b) != x.size {
+			return fmt.Errorf("invalid %s %q: expect 0x-prefixed %d bytes hex", x.name, x.value, x.size)
+		}
+	}
+
+	c, err := p.rpcClient(time.Second * 3)
+	if err != nil {
+		return err
+	}
+
+	ok, err := c.SubmitBlock([]string{nonce, header, mixDigest})
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("work rejected")
+	}
+	fmt.Println("work accepted")
+	return nil
+}
@@ -0,0 +1,57 @@
+package mainpkg
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWorkMonitor(t *testing.T) {
+	now := time.Unix(1600000000, 0)
+	m := &workMonitor{staleAfter: time.Minute, now: func() time.Time { return now }}
+
+	work := func(header string, height int, seed string) *Work {
+		w, err := parseWork([]string{
+			header, seed, "0x00000000ffff0000000000000000000000000000000000000000000000000000",
+			fmt.Sprintf("0x%x", height), "0x00", fmt.Sprintf("0x%x", now.Unix()-5),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return w
+	}
+
+	r := m.record(work("0x01", 10, "0xaa"), 9)
+	if len(r.Warnings) != 0 || r.Difficulty.String() != "4295032833" {
+		t.Fatalf("first = %+v", r)
+	}
+
+	now = now.Add(time.Second * 3)
+	r = m.record(work("0x02", 10, "0xaa"), 9)
+	if r.SinceLast != 3 || r.HeightTime != 0 || r.SeedChanged {
+		t.Fatalf("same height = %+v", r)
+	}
+
+	now = now.Add(time.Second * 12)
+	r = m.record(work("0x03", 11, "0xbb"), 11)
+	if r.HeightTime != 15 || !r.SeedChanged || len(r.Warnings) != 1 || !strings.HasPrefix(r.Warnings[0], "stale work") {
+		t.Fatalf("new height = %+v", r)
+	}
+
+	if s := m.checkLate(); s != "" {
+		t.Fatalf("late too early: %s", s)
+	}
+	now = now.Add(time.Minute * 2)
+	if s := m.checkLate(); !strings.HasPrefix(s, "no new height") {
+		t.Fatalf("late = %q", s)
+	}
+	if s := m.checkLate(); s != "" {
+		t.Fatalf("late warned twice: %s", s)
+	}
+
+	r = m.record(work("0x04", 9, "0xbb"), -1)
+	if len(r.Warnings) != 1 || !strings.HasPrefix(r.Warnings[0], "height went back") {
+		t.Fatalf("height back = %+v", r.Warnings)
+	}
+}
@@ -0,0 +1,118 @@
+// Prometheus 文本格式(0.0.4)的简单编码器, 不依赖 Prometheus 客户端库
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// 文本格式的 Content-Type
+const ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// 指标类型
+const (
+	Counter = "counter"
+	Gauge   = "gauge"
+	Summary = "summary"
+	Untyped = "untyped"
+)
+
+// 一个样本, Labels 按 名字, 值, 名字, 值... 排列
+type Sample struct {
+	Suffix string // 名字后缀, 比如 summary 的 _sum/_count
+	Labels []string
+	Value  float64
+}
+
+// 同名的一组指标
+type Family struct {
+	Name    string
+	Help    string
+	Type    string
+	Samples []Sample
+}
+
+func NewFamily(name, typ, help string) *Family {
+	return &Family{Name: name, Type: typ, Help: help}
+}
+
+// 添加样本, labels 为 名字, 值 交替的列表
+func (f *Family) Add(value float64, labels ...string) *Family {
+	f.Samples = append(f.Samples, Sample{Labels: labels, Value: value})
+	return f
+}
+
+// 添加带后缀的样本(summary 的 _sum 和 _count)
+func (f *Family) AddSuffix(suffix string, value float64, labels ...string) *Family {
+	f.Samples = append(f.Samples, Sample{Suffix: suffix, Labels: labels, Value: value})
+	return f
+}
+
+// 按顺序写出所有指标, 没有样本的指标被忽略
+func Write(w io.Writer, families []*Family) error {
+	bw := bufio.NewWriter(w)
+	for _, f := range families {
+		if len(f.Samples) == 0 {
+			continue
+		}
+		if f.Help != "" {
+			fmt.Fprintf(bw, "# HELP %s %s\n", f.Name, escapeHelp(f.Help))
+		}
+		typ := f.Type
+		if typ == "" {
+			typ = Untyped
+		}
+		fmt.Fprintf(bw, "# TYPE %s %s\n", f.Name, typ)
+
+		for _, s := range f.Samples {
+			if len(s.Labels)%2 != 0 {
+				return fmt.Errorf("metrics: %s: odd number of labels", f.Name)
+			}
+			bw.WriteString(f.Name)
+			bw.WriteString(s.Suffix)
+			if len(s.Labels) > 0 {
+				bw.WriteByte('{')
+				for i := 0; i < len(s.Labels); i += 2 {
+					if i > 0 {
+						bw.WriteByte(',')
+					}
+					fmt.Fprintf(bw, `%s="%s"`, s.Labels[i], escapeLabel(s.Labels[i+1]))
+				}
+				bw.WriteByte('}')
+			}
+			bw.WriteByte(' ')
+			bw.WriteString(formatValue(s.Value))
+			bw.WriteByte('\n')
+		}
+	}
+	return bw.Flush()
+}
+
+func formatValue(v float64) string {
+	switch {
+	case math.IsNaN(v):
+		return "NaN"
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+var (
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	labelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
+
+func escapeHelp(s string) string {
+	return helpEscaper.Replace(s)
+}
+
+func escapeLabel(s string) string {
+	return labelEscaper.Replace(s)
+}
@@ -0,0 +1,44 @@
+package metrics
+
+import (
+	"math"
+	"strings"
+	"testing"
+)
+
+func TestWrite(t *testing.T) {
+	height := NewFamily("hayek_block_height", Gauge, "Latest block height.").
+		Add(1234, "node", "http://a:8585").
+		Add(1e21, "node", `b"\`+"\n")
+	latency := NewFamily("hayek_rpc_duration_seconds", Summary, "RPC latency.\nper method").
+		AddSuffix("_sum", 0.25, "method", "hyk_getBalance").
+		AddSuffix("_count", 2, "method", "hyk_getBalance")
+	empty := NewFamily("hayek_empty", Counter, "")
+	up := &Family{Name: "up", Samples: []Sample{{Value: math.Inf(1)}, {Value: math.NaN()}}}
+
+	var buf strings.Builder
+	if err := Write(&buf, []*Family{height, latency, empty, up}); err != nil {
+		t.Fatal(err)
+	}
+
+	want := `# HELP hayek_block_height Latest block height.
+# TYPE hayek_block_height gauge
+hayek_block_height{node="http://a:8585"} 1234
+hayek_block_height{node="b\"\\\n"} 1e+21
+# HELP hayek_rpc_duration_seconds RPC latency.\nper method
+# TYPE hayek_rpc_duration_seconds summary
+hayek_rpc_duration_seconds_sum{method="hyk_getBalance"} 0.25
+hayek_rpc_duration_seconds_count{method="hyk_getBalance"} 2
+# TYPE up untyped
+up +Inf
+up NaN
+`
+	if buf.String() != want {
+		t.Fatalf("output:\n%s\nexpect:\n%s", buf.String(), want)
+	}
+
+	bad := NewFamily("bad", Gauge, "").Add(1, "node")
+	if err := Write(&buf, []*Family{bad}); err == nil {
+		t.Fatal("expect error for odd labels")
+	}
+}
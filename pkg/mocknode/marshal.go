@@ -0,0 +1,92 @@
+package mocknode
+
+import (
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+// 与 geth 相同的 JSON 格式
+
+func marshalBlock(b *block, full bool) map[string]interface{} {
+	head := b.Header()
+	m := map[string]interface{}{
+		"number":           (*hexutil.Big)(head.Number),
+		"hash":             b.Hash(),
+		"parentHash":       head.ParentHash,
+		"nonce":            head.Nonce,
+		"mixHash":          head.MixDigest,
+		"sha3Uncles":       head.UncleHash,
+		"logsBloom":        head.Bloom,
+		"stateRoot":        head.Root,
+		"miner":            head.Coinbase,
+		"difficulty":       (*hexutil.Big)(head.Difficulty),
+		"totalDifficulty":  (*hexutil.Big)(b.td),
+		"extraData":        hexutil.Bytes(head.Extra),
+		"size":             hexutil.Uint64(b.Size()),
+		"gasLimit":         hexutil.Uint64(head.GasLimit),
+		"gasUsed":          hexutil.Uint64(head.GasUsed),
+		"timestamp":        hexutil.Uint64(head.Time),
+		"transactionsRoot": head.TxHash,
+		"receiptsRoot":     head.ReceiptHash,
+		"uncles":           []common.Hash{},
+	}
+
+	txs := b.Transactions()
+	list := make([]interface{}, len(txs))
+	for i, tx := range txs {
+		if full {
+			list[i] = marshalTx(b, i)
+		} else {
+			list[i] = tx.Hash()
+		}
+	}
+	m["transactions"] = list
+	return m
+}
+
+func marshalPendingTx(tx *types.Transaction, from common.Address) map[string]interface{} {
+	v, r, s := tx.RawSignatureValues()
+	return map[string]interface{}{
+		"blockHash":        nil,
+		"blockNumber":      nil,
+		"from":             from,
+		"gas":              hexutil.Uint64(tx.Gas()),
+		"gasPrice":         (*hexutil.Big)(tx.GasPrice()),
+		"hash":             tx.Hash(),
+		"input":            hexutil.Bytes(tx.Data()),
+		"nonce":            hexutil.Uint64(tx.Nonce()),
+		"to":               tx.To(),
+		"transactionIndex": nil,
+		"value":            (*hexutil.Big)(tx.Value()),
+		"v":                (*hexutil.Big)(v),
+		"r":                (*hexutil.Big)(r),
+		"s":                (*hexutil.Big)(s),
+	}
+}
+
+func marshalTx(b *block, index int) map[string]interface{} {
+	m := marshalPendingTx(b.Transactions()[index], b.senders[index])
+	m["blockHash"] = b.Hash()
+	m["blockNumber"] = (*hexutil.Big)(b.Number())
+	m["transactionIndex"] = hexutil.Uint64(index)
+	return m
+}
+
+func marshalReceipt(b *block, index int) map[string]interface{} {
+	tx, r := b.Transactions()[index], b.receipts[index]
+	return map[string]interface{}{
+		"blockHash":         b.Hash(),
+		"blockNumber":       (*hexutil.Big)(b.Number()),
+		"transactionHash":   tx.Hash(),
+		"transactionIndex":  hexutil.Uint64(index),
+		"from":              b.senders[index],
+		"to":                tx.To(),
+		"gasUsed":           hexutil.Uint64(r.GasUsed),
+		"cumulativeGasUsed": hexutil.Uint64(r.CumulativeGasUsed),
+		"contractAddress":   nil,
+		"logs":              r.Logs,
+		"logsBloom":         r.Bloom,
+		"status":            hexutil.Uint64(r.Status),
+	}
+}
@@ -0,0 +1,475 @@
+// 模拟的 Hayek 节点
+//
+// 在内存中保存余额/nonce/区块/收据, 接受签名的原始交易(检查 EIP-155 签名),
+// 按需出块, 并通过 JSON-RPC 提供客户端使用的 hyk_* 和 net_* 方法. 用于测试和离线演示.
+package mocknode
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/rlp"
+
+	"xcoin/HayekTool/pkg/ethash"
+	"xcoin/HayekTool/pkg/rpc"
+)
+
+const (
+	DefaultGasLimit   = 8000000
+	DefaultDifficulty = 0x20000
+
+	txGas            = 21000 // 普通转账的 gas
+	txDataZeroGas    = 4     // 交易数据中每个 0 字节的 gas
+	txDataNonZeroGas = 68    // 交易数据中每个非 0 字节的 gas
+)
+
+type Config struct {
+	ChainID     *big.Int            // 默认 rpc.ChainID
+	Coinbase    string              // 出块地址, 收取手续费和区块奖励
+	BlockReward *big.Int            // 区块奖励(wei), 默认没有
+	Difficulty  *big.Int            // 区块难度, 默认 0x20000
+	GasLimit    uint64              // 区块 gas 上限, 默认 8000000
+	AutoMine    bool                // 收到交易后立即出块
+	Balances    map[string]*big.Int // 创世余额(wei)
+	Now         func() time.Time    // 出块时间, 默认 time.Now
+	OnBlock     func(*types.Block)  // 出块后调用(持有节点的锁, 不能再调用节点的方法)
+}
+
+type account struct {
+	Balance *big.Int
+	Nonce   uint64
+}
+
+type state map[common.Address]*account
+
+func (s state) copy() state {
+	q := make(state, len(s))
+	for k, v := range s {
+		q[k] = &account{Balance: new(big.Int).Set(v.Balance), Nonce: v.Nonce}
+	}
+	return q
+}
+
+func (s state) get(address common.Address) *account {
+	a := s[address]
+	if a == nil {
+		a = &account{Balance: new(big.Int)}
+		s[address] = a
+	}
+	return a
+}
+
+// 模拟的状态根: 按地址排序后的账户列表的哈希
+func (s state) root() common.Hash {
+	var keys []common.Address
+	for k := range s {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool { return strings.Compare(keys[i].Hex(), keys[j].Hex()) < 0 })
+
+	var list []interface{}
+	for _, k := range keys {
+		list = append(list, []interface{}{k, s[k].Balance, s[k].Nonce})
+	}
+	data, _ := rlp.EncodeToBytes(list)
+	return crypto.Keccak256Hash(data)
+}
+
+// 区块和出块后的状态
+type block struct {
+	*types.Block
+	receipts types.Receipts
+	senders  []common.Address
+	td       *big.Int
+	state    state
+}
+
+// 交易所在的位置
+type txLookup struct {
+	block *block
+	index int
+}
+
+type Node struct {
+	cfg    Config
+	signer types.Signer
+
+	mu      sync.Mutex
+	blocks  []*block
+	txs     map[common.Hash]*txLookup
+	pending []*types.Transaction
+	senders []common.Address // pending 交易的发送者
+	state   state            // 包含 pending 交易的状态
+	forks   int              // 回滚次数, 写入 extra 使分叉后的区块哈希不同
+
+	work    *types.Header // getWork 返回的区块头
+	light   *ethash.Light
+	rejectF func(tx *types.Transaction) error
+}
+
+func New(cfg Config) *Node {
+	if cfg.ChainID == nil {
+		cfg.ChainID = rpc.ChainID
+	}
+	if cfg.BlockReward == nil {
+		cfg.BlockReward = new(big.Int)
+	}
+	if cfg.Difficulty == nil {
+		cfg.Difficulty = big.NewInt(DefaultDifficulty)
+	}
+	if cfg.GasLimit == 0 {
+		cfg.GasLimit = DefaultGasLimit
+	}
+	if cfg.Now == nil {
+		cfg.Now = time.Now
+	}
+
+	n := &Node{
+		cfg:    cfg,
+		signer: types.NewEIP155Signer(cfg.ChainID),
+		txs:    make(map[common.Hash]*txLookup),
+		state:  make(state),
+		light:  ethash.NewLight(1),
+	}
+	for address, balance := range cfg.Balances {
+		n.state.get(common.HexToAddress(address)).Balance.Set(balance)
+	}
+
+	// 创世区块
+	header := &types.Header{
+		Number:     new(big.Int),
+		UncleHash:  types.EmptyUncleHash,
+		Root:       n.state.root(),
+		Difficulty: new(big.Int).Set(cfg.Difficulty),
+		GasLimit:   cfg.GasLimit,
+		Time:       uint64(cfg.Now().Unix()),
+		Extra:      []byte("hayek mock node"),
+	}
+	n.blocks = append(n.blocks, &block{
+		Block: types.NewBlock(header, nil, nil, nil),
+		td:    new(big.Int).Set(cfg.Difficulty),
+		state: n.state.copy(),
+	})
+	return n
+}
+
+// 设置地址的余额(立即生效, 不需要出块)
+func (n *Node) SetBalance(address string, wei *big.Int) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	addr := common.HexToAddress(address)
+	n.state.get(addr).Balance.Set(wei)
+	n.head().state.get(addr).Balance.Set(wei)
+	n.work = nil
+}
+
+// 最新区块中的余额
+func (n *Node) Balance(address string) *big.Int {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return new(big.Int).Set(n.head().state.get(common.HexToAddress(address)).Balance)
+}
+
+// 最新区块中的 nonce
+func (n *Node) Nonce(address string) uint64 {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.head().state.get(common.HexToAddress(address)).Nonce
+}
+
+// 最新区块高度
+func (n *Node) Height() uint64 {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.head().NumberU64()
+}
+
+// 按高度查询区块, 不存在时返回 nil
+func (n *Node) Block(height uint64) *types.Block {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if height >= uint64(len(n.blocks)) {
+		return nil
+	}
+	return n.blocks[height].Block
+}
+
+// 交易池中的交易数
+func (n *Node) Pending() int {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return len(n.pending)
+}
+
+// 设置交易检查函数, 返回错误时拒绝交易, 用于模拟节点拒绝或者网络错误
+func (n *Node) SetReject(f func(tx *types.Transaction) error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.rejectF = f
+}
+
+func (n *Node) head() *block {
+	return n.blocks[len(n.blocks)-1]
+}
+
+func intrinsicGas(data []byte) uint64 {
+	gas := uint64(txGas)
+	for _, b := range data {
+		if b == 0 {
+			gas += txDataZeroGas
+		} else {
+			gas += txDataNonZeroGas
+		}
+	}
+	return gas
+}
+
+// 接受签名的交易, 放入交易池; AutoMine 时立即出块
+func (n *Node) SendTransaction(tx *types.Transaction) (common.Hash, error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if !tx.Protected() {
+		return common.Hash{}, errors.New("only replay-protected (EIP-155) transactions allowed")
+	}
+	if tx.ChainId().Cmp(n.cfg.ChainID) != 0 {
+		return common.Hash{}, fmt.Errorf("invalid chain id: have %v, want %v", tx.ChainId(), n.cfg.ChainID)
+	}
+	from, err := types.Sender(n.signer, tx)
+	if err != nil {
+		return common.Hash{}, fmt.Errorf("invalid sender: %v", err)
+	}
+	if _, ok := n.txs[tx.Hash()]; ok {
+		return common.Hash{}, fmt.Errorf("known transaction: %x", tx.Hash())
+	}
+	for _, x := range n.pending {
+		if x.Hash() == tx.Hash() {
+			return common.Hash{}, fmt.Errorf("known transaction: %x", tx.Hash())
+		}
+	}
+	if tx.To() == nil {
+		return common.Hash{}, errors.New("contract creation not supported")
+	}
+
+	acc := n.state.get(from)
+	switch {
+	case tx.Nonce() < acc.Nonce:
+		return common.Hash{}, errors.New("nonce too low")
+	case tx.Nonce() > acc.Nonce:
+		return common.Hash{}, fmt.Errorf("nonce too high: have %d, want %d", tx.Nonce(), acc.Nonce)
+	}
+	gasUsed := intrinsicGas(tx.Data())
+	if tx.Gas() < gasUsed {
+		return common.Hash{}, errors.New("intrinsic gas too low")
+	}
+	if tx.Gas() > n.cfg.GasLimit {
+		return common.Hash{}, errors.New("exceeds block gas limit")
+	}
+	if acc.Balance.Cmp(tx.Cost()) < 0 {
+		return common.Hash{}, errors.New("insufficient funds for gas * price + value")
+	}
+	if n.rejectF != nil {
+		if err := n.rejectF(tx); err != nil {
+			return common.Hash{}, err
+		}
+	}
+
+	// 扣除实际使用的 gas, 手续费在出块时给 Coinbase
+	fee := new(big.Int).Mul(new(big.Int).SetUint64(gasUsed), tx.GasPrice())
+	acc.Balance.Sub(acc.Balance, fee)
+	acc.Balance.Sub(acc.Balance, tx.Value())
+	acc.Nonce++
+	to := n.state.get(*tx.To())
+	to.Balance.Add(to.Balance, tx.Value())
+
+	n.pending = append(n.pending, tx)
+	n.senders = append(n.senders, from)
+	n.work = nil
+
+	if n.cfg.AutoMine {
+		n.mine(nil)
+	}
+	return tx.Hash(), nil
+}
+
+// 解码并接受原始交易
+func (n *Node) SendRawTransaction(data []byte) (common.Hash, error) {
+	tx := new(types.Transaction)
+	if err := rlp.DecodeBytes(data, tx); err != nil {
+		return common.Hash{}, fmt.Errorf("invalid transaction: %v", err)
+	}
+	return n.SendTransaction(tx)
+}
+
+// 把交易池中的交易打包成新区块
+func (n *Node) Mine() *types.Block {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.mine(nil).Block
+}
+
+// 生成新区块的区块头和收据, 不修改状态
+func (n *Node) pendingBlock() (*types.Block, types.Receipts, state) {
+	parent := n.head()
+
+	st := n.state.copy()
+	coinbase := common.HexToAddress(n.cfg.Coinbase)
+
+	var (
+		receipts types.Receipts
+		gasUsed  uint64
+		fees     = new(big.Int)
+	)
+	for i, tx := range n.pending {
+		used := intrinsicGas(tx.Data())
+		gasUsed += used
+		fees.Add(fees, new(big.Int).Mul(new(big.Int).SetUint64(used), tx.GasPrice()))
+
+		r := types.NewReceipt(nil, false, gasUsed)
+		r.TxHash = tx.Hash()
+		r.GasUsed = used
+		r.Logs = []*types.Log{}
+		r.Bloom = types.CreateBloom(types.Receipts{r})
+		r.TransactionIndex = uint(i)
+		receipts = append(receipts, r)
+	}
+	cb := st.get(coinbase)
+	cb.Balance.Add(cb.Balance, fees)
+	cb.Balance.Add(cb.Balance, n.cfg.BlockReward)
+
+	now := uint64(n.cfg.Now().Unix())
+	if now <= parent.Time() {
+		now = parent.Time() + 1
+	}
+	header := &types.Header{
+		ParentHash: parent.Hash(),
+		UncleHash:  types.EmptyUncleHash,
+		Coinbase:   coinbase,
+		Root:       st.root(),
+		Difficulty: new(big.Int).Set(n.cfg.Difficulty),
+		Number:     new(big.Int).Add(parent.Number(), big.NewInt(1)),
+		GasLimit:   n.cfg.GasLimit,
+		GasUsed:    gasUsed,
+		Time:       now,
+	}
+	if n.forks > 0 {
+		header.Extra = []byte(fmt.Sprintf("fork %d", n.forks))
+	}
+	return types.NewBlock(header, n.pending, nil, receipts), receipts, st
+}
+
+// 出块; seal 不为空时使用其中的时间戳, nonce 和 mix digest
+func (n *Node) mine(seal *types.Header) *block {
+	b, receipts, st := n.pendingBlock()
+	if seal != nil {
+		header := b.Header()
+		header.Time, header.Nonce, header.MixDigest = seal.Time, seal.Nonce, seal.MixDigest
+		b = b.WithSeal(header)
+	}
+
+	for _, r := range receipts {
+		r.BlockHash = b.Hash()
+		r.BlockNumber = b.Number()
+	}
+
+	x := &block{
+		Block:    b,
+		receipts: receipts,
+		senders:  n.senders,
+		td:       new(big.Int).Add(n.head().td, b.Difficulty()),
+		state:    st,
+	}
+	n.blocks = append(n.blocks, x)
+	for i, tx := range b.Transactions() {
+		n.txs[tx.Hash()] = &txLookup{block: x, index: i}
+	}
+
+	n.state = st.copy()
+	n.pending, n.senders, n.work = nil, nil, nil
+
+	if n.cfg.OnBlock != nil {
+		n.cfg.OnBlock(b)
+	}
+	return x
+}
+
+// 丢弃最新的 depth 个区块和交易池, 之后出的块和原来的分支哈希不同
+func (n *Node) Rewind(depth int) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if depth <= 0 || depth >= len(n.blocks) {
+		return fmt.Errorf("invalid rewind depth %d, height %d", depth, len(n.blocks)-1)
+	}
+	for _, b := range n.blocks[len(n.blocks)-depth:] {
+		for _, tx := range b.Transactions() {
+			delete(n.txs, tx.Hash())
+		}
+	}
+	n.blocks = n.blocks[:len(n.blocks)-depth]
+	n.state = n.head().state.copy()
+	n.pending, n.senders, n.work = nil, nil, nil
+	n.forks++
+	return nil
+}
+
+// getWork 返回的工作: 区块头哈希, seed, target, 高度
+func (n *Node) Work() (header *types.Header, seed []byte, target *big.Int) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if n.work == nil {
+		b, _, _ := n.pendingBlock()
+		n.work = b.Header()
+	}
+	return types.CopyHeader(n.work), ethash.SeedHash(n.work.Number.Uint64() / ethash.EpochLength), workTarget(n.work.Difficulty)
+}
+
+// 2^256/difficulty, 难度为 1 时取 2^256-1
+func workTarget(difficulty *big.Int) *big.Int {
+	target := new(big.Int).Div(new(big.Int).Lsh(big.NewInt(1), 256), difficulty)
+	if target.BitLen() > 256 {
+		target.Sub(target, big.NewInt(1))
+	}
+	return target
+}
+
+// 提交挖矿结果, 验证通过后出块
+func (n *Node) SubmitWork(nonce types.BlockNonce, sealHash, mixDigest common.Hash) bool {
+	n.mu.Lock()
+	work := n.work
+	n.mu.Unlock()
+
+	if work == nil || ethash.SealHash(work) != sealHash {
+		return false
+	}
+
+	mix, result := n.light.Compute(work.Number.Uint64(), sealHash.Bytes(), nonce.Uint64())
+	if common.BytesToHash(mix) != mixDigest {
+		return false
+	}
+	if new(big.Int).SetBytes(result).Cmp(workTarget(work.Difficulty)) > 0 {
+		return false
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	// 提交期间有新交易时工作已经变化
+	if n.work == nil || ethash.SealHash(n.work) != sealHash {
+		return false
+	}
+	// 使用工作中的时间戳, 重新打包时时间可能已经变化
+	n.mine(&types.Header{Time: n.work.Time, Nonce: nonce, MixDigest: mixDigest})
+	return true
+}
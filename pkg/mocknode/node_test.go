@@ -0,0 +1,121 @@
+package mocknode
+
+import (
+	"math/big"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/rlp"
+
+	"xcoin/HayekTool/pkg/ethash"
+	"xcoin/HayekTool/pkg/rpc"
+)
+
+func signTx(t *testing.T, chainID *big.Int, nonce uint64, to common.Address, value int64) string {
+	key, _ := crypto.HexToECDSA("b71c71a67e1177ad4e901695e1b4b9ee17ae16c6668d313eac2f96dbcda3f291")
+	tx := types.NewTransaction(nonce, to, big.NewInt(value), 21000, big.NewInt(1), nil)
+	signed, err := types.SignTx(tx, types.NewEIP155Signer(chainID), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := rlp.EncodeToBytes(signed)
+	return hexutil.Encode(data)
+}
+
+func TestNode(t *testing.T) {
+	// 测试私钥对应的地址
+	from := "0x71562b71999873DB5b286dF957af199Ec94617F7"
+	to := common.HexToAddress("0x00000000000000000000000000000000000000aa")
+
+	// 每次取时间都前进一秒, getWork 和 submitWork 之间时间会变化
+	clock := time.Unix(1600000000, 0)
+	node := New(Config{
+		Coinbase:   "0x00000000000000000000000000000000000000cc",
+		Difficulty: big.NewInt(1),
+		Balances:   map[string]*big.Int{from: big.NewInt(1000000)},
+		Now: func() time.Time {
+			clock = clock.Add(time.Second)
+			return clock
+		},
+	})
+	server := httptest.NewServer(node)
+	defer server.Close()
+
+	client, _ := rpc.NewRPCClient("test", server.URL, time.Second)
+
+	if v, err := client.NetVersion(); err != nil || v != 20210 {
+		t.Fatalf("net_version = %v, %v", v, err)
+	}
+
+	// 其它链的签名被拒绝
+	if _, err := client.SendRawTransaction(signTx(t, big.NewInt(1), 0, to, 100)); err == nil {
+		t.Fatal("accepted tx for another chain")
+	}
+	if _, err := client.SendRawTransaction(signTx(t, rpc.ChainID, 1, to, 100)); err == nil {
+		t.Fatal("accepted tx with nonce gap")
+	}
+
+	hash, err := client.SendRawTransaction(signTx(t, rpc.ChainID, 0, to, 100))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n, _ := client.GetTransactionCount(from, "pending"); n != 1 {
+		t.Fatalf("pending nonce = %d", n)
+	}
+	if r, _ := client.GetTxReceipt(hash); r != nil {
+		t.Fatalf("receipt before mining: %+v", r)
+	}
+
+	node.Mine()
+	r, err := client.GetTxReceipt(hash)
+	if err != nil || r == nil || !r.Confirmed() || !r.Successful() {
+		t.Fatalf("receipt = %+v, %v", r, err)
+	}
+	if b, _ := client.GetBalance(to.Hex()); b.Int64() != 100 {
+		t.Fatalf("balance = %v", b)
+	}
+	if b, _ := client.GetBalance(from); b.Int64() != 1000000-100-21000 {
+		t.Fatalf("sender balance = %v", b)
+	}
+
+	block, err := client.GetBlockByHeight(1, true)
+	if err != nil || len(block.Transactions) != 1 || block.Transactions[0].Hash != hash {
+		t.Fatalf("block = %+v, %v", block, err)
+	}
+	if block.Hash != node.Block(1).Hash().Hex() {
+		t.Fatalf("block hash = %s", block.Hash)
+	}
+
+	// 回滚后交易丢失, 新区块哈希不同
+	old := node.Block(1).Hash()
+	if err := node.Rewind(1); err != nil {
+		t.Fatal(err)
+	}
+	if r, _ := client.GetTxReceipt(hash); r != nil {
+		t.Fatalf("receipt after rewind: %+v", r)
+	}
+	if node.Mine().Hash() == old {
+		t.Fatal("same block hash after rewind")
+	}
+
+	// getWork/submitWork
+	work, err := client.GetWork()
+	if err != nil || work[3] != "0x2" {
+		t.Fatalf("work = %v, %v", work, err)
+	}
+	if ok, _ := client.SubmitBlock([]string{"0x0000000000000001", work[0], common.Hash{}.Hex()}); ok {
+		t.Fatal("accepted wrong mix digest")
+	}
+	mix, _ := node.light.Compute(2, common.HexToHash(work[0]).Bytes(), 1)
+	if ok, err := client.SubmitBlock([]string{"0x0000000000000001", work[0], hexutil.Encode(mix)}); !ok || err != nil {
+		t.Fatalf("submit = %v, %v", ok, err)
+	}
+	if b := node.Block(2); b == nil || b.Nonce() != 1 || b.MixDigest() != common.BytesToHash(mix) || ethash.SealHash(b.Header()).Hex() != work[0] {
+		t.Fatalf("sealed block = %+v", b)
+	}
+}
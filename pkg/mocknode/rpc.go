@@ -0,0 +1,326 @@
+package mocknode
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+
+	"xcoin/HayekTool/pkg/ethash"
+	"xcoin/HayekTool/pkg/rpc"
+)
+
+const (
+	errCodeMethodNotFound = -32601
+	errCodeInvalidParams  = -32602
+	errCodeParse          = -32700
+	errCodeServer         = -32000
+)
+
+type request struct {
+	Id     *json.RawMessage  `json:"id"`
+	Method string            `json:"method"`
+	Params []json.RawMessage `json:"params"`
+}
+
+type response struct {
+	Jsonrpc string           `json:"jsonrpc"`
+	Id      *json.RawMessage `json:"id"`
+	Result  json.RawMessage  `json:"result,omitempty"`
+	Error   *rpcError        `json:"error,omitempty"`
+}
+
+type rpcError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *rpcError) Error() string {
+	return e.Message
+}
+
+func invalidParams(format string, args ...interface{}) error {
+	return &rpcError{Code: errCodeInvalidParams, Message: fmt.Sprintf(format, args...)}
+}
+
+// 处理 JSON-RPC 请求, 支持批量请求
+func (n *Node) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var raw json.RawMessage
+	if err := json.NewDecoder(r.Body).Decode(&raw); err != nil {
+		writeJSON(w, &response{Jsonrpc: "2.0", Error: &rpcError{Code: errCodeParse, Message: err.Error()}})
+		return
+	}
+
+	if trimmed := strings.TrimSpace(string(raw)); strings.HasPrefix(trimmed, "[") {
+		var reqs []*request
+		if err := json.Unmarshal(raw, &reqs); err != nil {
+			writeJSON(w, &response{Jsonrpc: "2.0", Error: &rpcError{Code: errCodeParse, Message: err.Error()}})
+			return
+		}
+		resps := make([]*response, 0, len(reqs))
+		for _, req := range reqs {
+			resps = append(resps, n.handle(req))
+		}
+		writeJSON(w, resps)
+		return
+	}
+
+	req := new(request)
+	if err := json.Unmarshal(raw, req); err != nil {
+		writeJSON(w, &response{Jsonrpc: "2.0", Error: &rpcError{Code: errCodeParse, Message: err.Error()}})
+		return
+	}
+	writeJSON(w, n.handle(req))
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+func (n *Node) handle(req *request) *response {
+	resp := &response{Jsonrpc: "2.0", Id: req.Id}
+
+	result, err := n.call(req.Method, req.Params)
+	if err != nil {
+		rpcErr, ok := err.(*rpcError)
+		if !ok {
+			rpcErr = &rpcError{Code: errCodeServer, Message: err.Error()}
+		}
+		resp.Error = rpcErr
+		return resp
+	}
+	data, err := json.Marshal(result)
+	if err != nil {
+		resp.Error = &rpcError{Code: errCodeServer, Message: err.Error()}
+		return resp
+	}
+	resp.Result = data
+	return resp
+}
+
+func (n *Node) call(method string, params []json.RawMessage) (interface{}, error) {
+	prefix := rpc.CoinId + "_"
+	switch {
+	case method == "net_version":
+		return n.cfg.ChainID.String(), nil
+	case method == "net_peerCount":
+		return hexutil.Uint64(0), nil
+	case method == "net_listening":
+		return true, nil
+	case strings.HasPrefix(method, prefix):
+		return n.callCoin(strings.TrimPrefix(method, prefix), params)
+	}
+	return nil, &rpcError{Code: errCodeMethodNotFound, Message: fmt.Sprintf("the method %s does not exist/is not available", method)}
+}
+
+func (n *Node) callCoin(method string, params []json.RawMessage) (interface{}, error) {
+	switch method {
+	case "chainId":
+		return (*hexutil.Big)(n.cfg.ChainID), nil
+
+	case "blockNumber":
+		return hexutil.Uint64(n.Height()), nil
+
+	case "getBalance", "getTransactionCount":
+		var (
+			address common.Address
+			number  string
+		)
+		if err := parseParams(params, 1, &address, &number); err != nil {
+			return nil, err
+		}
+		st, err := n.stateAt(number)
+		if err != nil {
+			return nil, err
+		}
+		acc := st.get(address)
+		if method == "getBalance" {
+			return (*hexutil.Big)(new(big.Int).Set(acc.Balance)), nil
+		}
+		return hexutil.Uint64(acc.Nonce), nil
+
+	case "sendRawTransaction":
+		var data hexutil.Bytes
+		if err := parseParams(params, 1, &data); err != nil {
+			return nil, err
+		}
+		return n.SendRawTransaction(data)
+
+	case "sendTransaction", "sign":
+		return nil, errors.New("unknown account")
+
+	case "getBlockByNumber":
+		var (
+			number string
+			full   bool
+		)
+		if err := parseParams(params, 1, &number, &full); err != nil {
+			return nil, err
+		}
+		return n.blockByNumber(number, full)
+
+	case "getBlockByHash":
+		var (
+			hash common.Hash
+			full bool
+		)
+		if err := parseParams(params, 1, &hash, &full); err != nil {
+			return nil, err
+		}
+		n.mu.Lock()
+		defer n.mu.Unlock()
+		for _, b := range n.blocks {
+			if b.Hash() == hash {
+				return marshalBlock(b, full), nil
+			}
+		}
+		return nil, nil
+
+	case "getUncleByBlockNumberAndIndex", "getUncleByBlockHashAndIndex":
+		// 模拟节点不产生叔块
+		return nil, nil
+
+	case "getTransactionByHash":
+		var hash common.Hash
+		if err := parseParams(params, 1, &hash); err != nil {
+			return nil, err
+		}
+		n.mu.Lock()
+		defer n.mu.Unlock()
+		if l := n.txs[hash]; l != nil {
+			return marshalTx(l.block, l.index), nil
+		}
+		for i, tx := range n.pending {
+			if tx.Hash() == hash {
+				return marshalPendingTx(tx, n.senders[i]), nil
+			}
+		}
+		return nil, nil
+
+	case "getTransactionReceipt":
+		var hash common.Hash
+		if err := parseParams(params, 1, &hash); err != nil {
+			return nil, err
+		}
+		n.mu.Lock()
+		defer n.mu.Unlock()
+		if l := n.txs[hash]; l != nil {
+			return marshalReceipt(l.block, l.index), nil
+		}
+		return nil, nil
+
+	case "getWork":
+		header, seed, target := n.Work()
+		sealHash := ethash.SealHash(header)
+		return []string{
+			sealHash.Hex(),
+			common.BytesToHash(seed).Hex(),
+			common.BytesToHash(target.Bytes()).Hex(),
+			hexutil.EncodeBig(header.Number),
+			header.Root.Hex(),
+			hexutil.EncodeUint64(header.Time),
+		}, nil
+
+	case "submitWork":
+		var (
+			nonce     types.BlockNonce
+			hash, mix common.Hash
+		)
+		if err := parseParams(params, 3, &nonce, &hash, &mix); err != nil {
+			return nil, err
+		}
+		return n.SubmitWork(nonce, hash, mix), nil
+
+	case "submitHashrate":
+		return true, nil
+	}
+	return nil, &rpcError{Code: errCodeMethodNotFound, Message: fmt.Sprintf("the method %s_%s does not exist/is not available", rpc.CoinId, method)}
+}
+
+// 按顺序解析参数, 至少需要 required 个
+func parseParams(params []json.RawMessage, required int, args ...interface{}) error {
+	if len(params) < required {
+		return invalidParams("missing value for required argument %d", len(params))
+	}
+	if len(params) > len(args) {
+		return invalidParams("too many arguments, want at most %d", len(args))
+	}
+	for i, p := range params {
+		if err := json.Unmarshal(p, args[i]); err != nil {
+			return invalidParams("invalid argument %d: %v", i, err)
+		}
+	}
+	return nil
+}
+
+// getBalance/getTransactionCount 的第二个参数: latest, pending, earliest 或者区块高度
+func (n *Node) stateAt(number string) (state, error) {
+	if number == "" {
+		number = "latest"
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if number == "pending" {
+		return n.state.copy(), nil
+	}
+	b, err := n.blockAt(number)
+	if err != nil {
+		return nil, err
+	}
+	if b == nil {
+		return nil, errors.New("header not found")
+	}
+	return b.state.copy(), nil
+}
+
+// 调用时需要持有锁
+func (n *Node) blockAt(number string) (*block, error) {
+	switch number {
+	case "latest", "pending":
+		return n.head(), nil
+	case "earliest":
+		return n.blocks[0], nil
+	}
+	height, err := hexutil.DecodeUint64(number)
+	if err != nil {
+		return nil, invalidParams("invalid block number %q: %v", number, err)
+	}
+	if height >= uint64(len(n.blocks)) {
+		return nil, nil
+	}
+	return n.blocks[height], nil
+}
+
+func (n *Node) blockByNumber(number string, full bool) (interface{}, error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if number == "pending" {
+		b, _, _ := n.pendingBlock()
+		x := &block{Block: b, senders: n.senders, td: new(big.Int).Add(n.head().td, b.Difficulty())}
+		m := marshalBlock(x, full)
+		// 与 geth 一致, pending 区块没有哈希和 nonce
+		m["hash"], m["nonce"], m["miner"] = nil, nil, nil
+		return m, nil
+	}
+
+	b, err := n.blockAt(number)
+	if err != nil || b == nil {
+		return nil, err
+	}
+	return marshalBlock(b, full), nil
+}
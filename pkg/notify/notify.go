@@ -0,0 +1,140 @@
+// 通知: 支付结果和错误通过 HTTP webhook 或者邮件(SMTP)发送给运维人员
+package notify
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+// 事件类型
+const (
+	EventPayoutsSuccess  = "payouts.success"
+	EventPayoutsFailure  = "payouts.failure"
+	EventPayoutsApproval = "payouts.approval" // 支付计划等待审批
+)
+
+// 通知事件, webhook 中以 JSON 格式发送
+type Event struct {
+	Type    string      `json:"type"`
+	Source  string      `json:"source"`
+	Time    time.Time   `json:"time"`
+	Subject string      `json:"subject"`
+	Text    string      `json:"text"`
+	Data    interface{} `json:"data,omitempty"`
+}
+
+// 通知后端
+type Notifier interface {
+	Notify(e *Event) error
+}
+
+// 通知配置
+type Config struct {
+	Webhooks     []WebhookConfig `json:",omitempty"` // HTTP 回调
+	SMTP         *SMTPConfig     `json:",omitempty"` // 邮件
+	FailuresOnly bool            // 只通知失败和待审批
+	Retries      int             // 失败重试次数, 默认3
+	RetryBackoff int64           // 第一次重试的间隔(秒), 之后每次翻倍, 默认1秒
+}
+
+type WebhookConfig struct {
+	URL    string
+	Secret string // HMAC-SHA256 签名的密钥, 为空时不签名
+}
+
+type SMTPConfig struct {
+	Addr     string   // 服务器地址, host:port
+	Username string   // 为空时不认证
+	Password string   //
+	From     string   // 发件人
+	To       []string // 收件人
+}
+
+// 把事件发送给多个后端, 失败时按指数退避重试
+type Dispatcher struct {
+	Notifiers    []Notifier
+	FailuresOnly bool
+	Retries      int
+	Backoff      time.Duration
+}
+
+func NewDispatcher(cfg *Config) *Dispatcher {
+	d := &Dispatcher{
+		FailuresOnly: cfg.FailuresOnly,
+		Retries:      cfg.Retries,
+		Backoff:      time.Duration(cfg.RetryBackoff) * time.Second,
+	}
+	if d.Retries <= 0 {
+		d.Retries = 3
+	}
+	if d.Backoff <= 0 {
+		d.Backoff = time.Second
+	}
+
+	for _, v := range cfg.Webhooks {
+		d.Notifiers = append(d.Notifiers, NewWebhook(v.URL, v.Secret))
+	}
+	if cfg.SMTP != nil {
+		d.Notifiers = append(d.Notifiers, NewSMTP(cfg.SMTP))
+	}
+	return d
+}
+
+// 发送给全部后端, 返回最后一个错误
+func (d *Dispatcher) Notify(e *Event) error {
+	if d.FailuresOnly && e.Type == EventPayoutsSuccess {
+		return nil
+	}
+
+	var lastErr error
+	for _, n := range d.Notifiers {
+		if err := d.notify(n, e); err != nil {
+			log.Printf("notify: %T: %v", n, err)
+			lastErr = err
+		}
+	}
+	return lastErr
+}
+
+func (d *Dispatcher) notify(n Notifier, e *Event) (err error) {
+	backoff := d.Backoff
+	for i := 0; i <= d.Retries; i++ {
+		if i > 0 {
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+		if err = n.Notify(e); err == nil {
+			return nil
+		}
+	}
+	return fmt.Errorf("failed after %d retries: %v", d.Retries, err)
+}
+
+// 检查配置, 返回字段名和错误
+func (cfg *Config) Validate() (field string, err error) {
+	for i, v := range cfg.Webhooks {
+		if !strings.HasPrefix(v.URL, "http://") && !strings.HasPrefix(v.URL, "https://") {
+			return fmt.Sprintf("Webhooks[%d].URL", i), fmt.Errorf("invalid url %q", v.URL)
+		}
+	}
+	if cfg.SMTP != nil {
+		if cfg.SMTP.Addr == "" {
+			return "SMTP.Addr", fmt.Errorf("empty")
+		}
+		if cfg.SMTP.From == "" {
+			return "SMTP.From", fmt.Errorf("empty")
+		}
+		if len(cfg.SMTP.To) == 0 {
+			return "SMTP.To", fmt.Errorf("empty")
+		}
+	}
+	if cfg.Retries < 0 {
+		return "Retries", fmt.Errorf("negative value %d", cfg.Retries)
+	}
+	if cfg.RetryBackoff < 0 {
+		return "RetryBackoff", fmt.Errorf("negative value %d", cfg.RetryBackoff)
+	}
+	return "", nil
+}
@@ -0,0 +1,187 @@
+package notify
+
+import (
+	"bufio"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestWebhookRetry(t *testing.T) {
+	var calls int32
+	var got Event
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// 前两次失败
+		if atomic.AddInt32(&calls, 1) <= 2 {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		body, _ := ioutil.ReadAll(r.Body)
+		if !Verify("secret", body, r.Header.Get(SignatureHeader)) {
+			t.Errorf("invalid signature: %s", r.Header.Get(SignatureHeader))
+		}
+		if err := json.Unmarshal(body, &got); err != nil {
+			t.Error(err)
+		}
+	}))
+	defer srv.Close()
+
+	d := NewDispatcher(&Config{
+		Webhooks: []WebhookConfig{{URL: srv.URL, Secret: "secret"}},
+	})
+	d.Backoff = time.Millisecond
+
+	err := d.Notify(&Event{Type: EventPayoutsFailure, Subject: "payouts failed", Text: "boom"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := atomic.LoadInt32(&calls); n != 3 {
+		t.Fatalf("expect 3 calls, got %d", n)
+	}
+	if got.Type != EventPayoutsFailure || got.Subject != "payouts failed" {
+		t.Fatalf("unexpected event: %+v", got)
+	}
+
+	// 重试次数用完
+	d.Retries = 0
+	atomic.StoreInt32(&calls, 0)
+	if err := d.Notify(&Event{Type: EventPayoutsFailure}); err == nil {
+		t.Fatal("expect error")
+	}
+}
+
+func TestFailuresOnly(t *testing.T) {
+	var calls int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+	}))
+	defer srv.Close()
+
+	d := NewDispatcher(&Config{
+		Webhooks:     []WebhookConfig{{URL: srv.URL}},
+		FailuresOnly: true,
+	})
+	d.Notify(&Event{Type: EventPayoutsSuccess})
+	d.Notify(&Event{Type: EventPayoutsFailure})
+
+	if n := atomic.LoadInt32(&calls); n != 1 {
+		t.Fatalf("expect 1 call, got %d", n)
+	}
+}
+
+func TestSMTP(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	mails := make(chan string, 1)
+	go fakeSMTPServer(ln, mails)
+
+	n := NewSMTP(&SMTPConfig{
+		Addr: ln.Addr().String(),
+		From: "hayek@example.com",
+		To:   []string{"ops@example.com"},
+	})
+	err = n.Notify(&Event{
+		Type:    EventPayoutsFailure,
+		Time:    time.Now(),
+		Subject: "payouts[farm1] failed",
+		Text:    "send to user0 failed",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case mail := <-mails:
+		for _, s := range []string{
+			"MAIL FROM:<hayek@example.com>",
+			"RCPT TO:<ops@example.com>",
+			"Subject: payouts[farm1] failed",
+			"send to user0 failed",
+		} {
+			if !strings.Contains(mail, s) {
+				t.Errorf("mail missing %q:\n%s", s, mail)
+			}
+		}
+	case <-time.After(time.Second * 3):
+		t.Fatal("timeout")
+	}
+}
+
+func TestSMTPHeaderInjection(t *testing.T) {
+	n := NewSMTP(&SMTPConfig{From: "hayek@example.com", To: []string{"ops@example.com"}})
+	mail := string(n.message(&Event{
+		Type:    EventPayoutsFailure,
+		Time:    time.Now(),
+		Subject: "payouts failed: bad reply\r\nBcc: evil@example.com\nX-Injected: 1",
+		Text:    "body",
+	}))
+
+	head := mail[:strings.Index(mail, "\r\n\r\n")]
+	for _, line := range strings.Split(head, "\r\n") {
+		if strings.HasPrefix(line, "Bcc:") || strings.HasPrefix(line, "X-Injected:") || strings.ContainsAny(line, "\r\n") {
+			t.Errorf("injected header line %q", line)
+		}
+	}
+	if !strings.Contains(head, "Subject: payouts failed: bad reply Bcc: evil@example.com X-Injected: 1\r\n") {
+		t.Errorf("unexpected subject:\n%s", head)
+	}
+}
+
+// 只支持发送一封邮件的 SMTP 服务器, 收到的命令和内容写入 mails
+func fakeSMTPServer(ln net.Listener, mails chan<- string) {
+	conn, err := ln.Accept()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	var (
+		r    = bufio.NewReader(conn)
+		mail strings.Builder
+		data bool
+	)
+	reply := func(s string) { conn.Write([]byte(s + "\r\n")) }
+
+	reply("220 localhost fake smtp")
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		mail.WriteString(line)
+
+		if data {
+			if line == ".\r\n" {
+				data = false
+				reply("250 ok")
+			}
+			continue
+		}
+
+		switch cmd := strings.ToUpper(strings.TrimSpace(line)); {
+		case strings.HasPrefix(cmd, "EHLO"), strings.HasPrefix(cmd, "HELO"):
+			reply("250 localhost")
+		case strings.HasPrefix(cmd, "DATA"):
+			data = true
+			reply("354 go ahead")
+		case strings.HasPrefix(cmd, "QUIT"):
+			reply("221 bye")
+			mails <- mail.String()
+			return
+		default:
+			reply("250 ok")
+		}
+	}
+}
@@ -0,0 +1,48 @@
+package notify
+
+import (
+	"bytes"
+	"fmt"
+	"mime"
+	"net"
+	"net/smtp"
+	"strings"
+	"time"
+)
+
+// 邮件通知
+type SMTP struct {
+	cfg SMTPConfig
+}
+
+func NewSMTP(cfg *SMTPConfig) *SMTP {
+	return &SMTP{cfg: *cfg}
+}
+
+func (p *SMTP) Notify(e *Event) error {
+	var auth smtp.Auth
+	if p.cfg.Username != "" {
+		host, _, _ := net.SplitHostPort(p.cfg.Addr)
+		auth = smtp.PlainAuth("", p.cfg.Username, p.cfg.Password, host)
+	}
+	return smtp.SendMail(p.cfg.Addr, auth, p.cfg.From, p.cfg.To, p.message(e))
+}
+
+func (p *SMTP) message(e *Event) []byte {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "From: %s\r\n", headerValue(p.cfg.From))
+	fmt.Fprintf(&buf, "To: %s\r\n", headerValue(strings.Join(p.cfg.To, ", ")))
+	fmt.Fprintf(&buf, "Subject: %s\r\n", mime.QEncoding.Encode("UTF-8", headerValue(e.Subject)))
+	fmt.Fprintf(&buf, "Date: %s\r\n", e.Time.Format(time.RFC1123Z))
+	fmt.Fprintf(&buf, "X-Hayek-Event: %s\r\n", headerValue(e.Type))
+	fmt.Fprintf(&buf, "Content-Type: text/plain; charset=UTF-8\r\n")
+	fmt.Fprintf(&buf, "\r\n")
+	buf.WriteString(strings.Replace(e.Text, "\n", "\r\n", -1))
+	buf.WriteString("\r\n")
+	return buf.Bytes()
+}
+
+// 邮件头的值不能包含换行, 否则可以插入其它邮件头(主题中包含节点返回的错误信息)
+func headerValue(s string) string {
+	return strings.NewReplacer("\r\n", " ", "\r", " ", "\n", " ").Replace(s)
+}
@@ -0,0 +1,73 @@
+package notify
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"time"
+)
+
+// 签名的 HTTP 头, 内容为 sha256=<hex(HMAC-SHA256(secret, body))>
+const SignatureHeader = "X-Hayek-Signature"
+
+// HTTP 回调, 以 POST 方式发送 JSON
+type Webhook struct {
+	URL    string
+	Secret string
+	client *http.Client
+}
+
+func NewWebhook(url, secret string) *Webhook {
+	return &Webhook{
+		URL:    url,
+		Secret: secret,
+		client: &http.Client{Timeout: time.Second * 10},
+	}
+}
+
+func (p *Webhook) Notify(e *Event) error {
+	body, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest("POST", p.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Hayek-Event", e.Type)
+	if p.Secret != "" {
+		req.Header.Set(SignatureHeader, Sign(p.Secret, body))
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook %s: %s", p.URL, resp.Status)
+	}
+	return nil
+}
+
+// 计算签名
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// 检查签名, 接收方使用
+func Verify(secret string, body []byte, signature string) bool {
+	return hmac.Equal([]byte(Sign(secret, body)), []byte(signature))
+}
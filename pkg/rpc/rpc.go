@@ -8,6 +8,7 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io/ioutil"
 	"math/big"
 	"net/http"
 	"strconv"
@@ -25,6 +26,9 @@ var (
 	ChainID = big.NewInt(20210)
 )
 
+// 节点不支持批量请求, 调用者可以改为逐个请求
+var ErrBatchUnsupported = errors.New("batch requests not supported")
+
 type RPCClient struct {
 	sync.RWMutex
 	sickRate         int64
@@ -64,13 +68,40 @@ type JSONRpcResp struct {
 	Error  map[string]interface{} `json:"error"`
 }
 
+// 节点返回的 JSON-RPC 错误
+type RPCError struct {
+	Code    int         `json:"code"`
+	Message string      `json:"message"`
+	Data    interface{} `json:"data,omitempty"`
+}
+
+func newRPCError(m map[string]interface{}) *RPCError {
+	e := &RPCError{Data: m["data"]}
+	if v, ok := m["code"].(float64); ok {
+		e.Code = int(v)
+	}
+	if v, ok := m["message"].(string); ok {
+		e.Message = v
+	} else {
+		e.Message = fmt.Sprint(m["message"])
+	}
+	return e
+}
+
+func (e *RPCError) Error() string {
+	return e.Message
+}
+
 type GetBlockReply struct {
 	Number           string   `json:"number"`
 	Hash             string   `json:"hash"`
 	ParentHash       string   `json:"parentHash"`
 	Nonce            string   `json:"nonce"`
+	MixHash          string   `json:"mixHash"`
 	Sha3Uncles       string   `json:"sha3Uncles"`
+	LogsBloom        string   `json:"logsBloom"`
 	TransactionsRoot string   `json:"transactionsRoot"`
+	ReceiptsRoot     string   `json:"receiptsRoot"`
 	StateRoot        string   `json:"stateRoot"`
 	Miner            string   `json:"miner"`
 	Difficulty       string   `json:"difficulty"`
@@ -96,9 +127,17 @@ type TxReceipt struct {
 	GasUsed           string `json:"gasUsed"`
 	ContractAddress   string `json:"contractAddress"`
 	LogsBloom         string `json:"logsBloom"`
+	Logs              []Log  `json:"logs"`
+	Root              string `json:"root"` // 拜占庭分叉之前的状态根, 之后为空
 	Status            string `json:"status"`
 }
 
+type Log struct {
+	Address string   `json:"address"`
+	Topics  []string `json:"topics"`
+	Data    string   `json:"data"`
+}
+
 func (r *TxReceipt) Confirmed() bool {
 	return len(r.BlockHash) > 0
 }
@@ -112,9 +151,30 @@ func (r *TxReceipt) Successful() bool {
 }
 
 type Tx struct {
-	Gas      string `json:"gas"`
-	GasPrice string `json:"gasPrice"`
-	Hash     string `json:"hash"`
+	Gas              string `json:"gas"`
+	GasPrice         string `json:"gasPrice"`
+	Hash             string `json:"hash"`
+	From             string `json:"from"`
+	To               string `json:"to"` // 创建合约时为空
+	Value            string `json:"value"`
+	Nonce            string `json:"nonce"`
+	Input            string `json:"input"`
+	BlockHash        string `json:"blockHash"`
+	BlockNumber      string `json:"blockNumber"`
+	TransactionIndex string `json:"transactionIndex"`
+	V                string `json:"v"`
+	R                string `json:"r"`
+	S                string `json:"s"`
+}
+
+// 不带完整交易查询区块时, transactions 只是交易哈希列表
+func (tx *Tx) UnmarshalJSON(data []byte) error {
+	if len(data) > 0 && data[0] == '"' {
+		*tx = Tx{}
+		return json.Unmarshal(data, &tx.Hash)
+	}
+	type plain Tx
+	return json.Unmarshal(data, (*plain)(tx))
 }
 
 func NewRPCClient(name, url string, timeout time.Duration) (*RPCClient, error) {
@@ -214,7 +274,16 @@ func (r *RPCClient) SubmitBlock(params []string) (bool, error) {
 }
 
 func (r *RPCClient) GetBalance(address string) (*big.Int, error) {
-	rpcResp, err := r.doPost(r.Url, CoinId+"_getBalance", []string{address, "latest"})
+	return r.getBalance(address, "latest")
+}
+
+// 指定高度的余额
+func (r *RPCClient) GetBalanceAt(address string, height int64) (*big.Int, error) {
+	return r.getBalance(address, fmt.Sprintf("0x%x", height))
+}
+
+func (r *RPCClient) getBalance(address, block string) (*big.Int, error) {
+	rpcResp, err := r.doPost(r.Url, CoinId+"_getBalance", []string{address, block})
 	if err != nil {
 		return nil, err
 	}
@@ -338,6 +407,23 @@ func (r *RPCClient) SendRawTransaction(hexData string) (string, error) {
 	return reply, err
 }
 
+// 调用任意方法, 返回原始的结果, 结果为 null 时返回 nil
+//
+// 节点返回错误时 err 为 *RPCError, 包含错误码.
+func (r *RPCClient) Call(method string, params []interface{}) (json.RawMessage, error) {
+	if params == nil {
+		params = []interface{}{}
+	}
+	rpcResp, err := r.doPost(r.Url, method, params)
+	if err != nil {
+		return nil, err
+	}
+	if rpcResp.Result == nil {
+		return nil, nil
+	}
+	return *rpcResp.Result, nil
+}
+
 func (r *RPCClient) doPost(url, method string, params interface{}) (*JSONRpcResp, error) {
 	jsonReq := map[string]interface{}{"jsonrpc": "2.0", "id": 0, "method": method, "params": params}
 	data, _ := json.Marshal(jsonReq)
@@ -365,11 +451,107 @@ func (r *RPCClient) doPost(url, method string, params interface{}) (*JSONRpcResp
 	}
 	if rpcResp.Error != nil {
 		r.markSick()
-		return nil, errors.New(rpcResp.Error["message"].(string))
+		return nil, newRPCError(rpcResp.Error)
 	}
 	return rpcResp, err
 }
 
+// 批量调用同一个方法, 返回的结果和 paramsList 一一对应
+func (r *RPCClient) doBatch(url, method string, paramsList []interface{}) ([]*JSONRpcResp, error) {
+	var jsonReq []map[string]interface{}
+	for i, params := range paramsList {
+		jsonReq = append(jsonReq, map[string]interface{}{"jsonrpc": "2.0", "id": i, "method": method, "params": params})
+	}
+	data, _ := json.Marshal(jsonReq)
+	req, err := http.NewRequest("POST", url, bytes.NewBuffer(data))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+	req.SetBasicAuth(r.login, r.password)
+	resp, err := r.client.Do(req)
+	if err != nil {
+		r.markSick()
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		r.markSick()
+		return nil, err
+	}
+
+	// 代理或者认证网关返回的错误状态不能当作不支持批量请求
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return nil, errors.New(resp.Status)
+	}
+	// 不支持批量请求的节点返回一个 JSON-RPC 错误对象而不是数组, 这不是节点故障
+	if bytes.HasPrefix(bytes.TrimSpace(body), []byte("{")) {
+		var x JSONRpcResp
+		if json.Unmarshal(body, &x) == nil && x.Error != nil {
+			return nil, ErrBatchUnsupported
+		}
+	}
+
+	var list []*JSONRpcResp
+	if err := json.Unmarshal(body, &list); err != nil {
+		r.markSick()
+		return nil, err
+	}
+
+	// 节点可以按任意顺序返回, 按 id 重新排列
+	results := make([]*JSONRpcResp, len(paramsList))
+	for _, x := range list {
+		var id int
+		if x == nil || x.Id == nil || json.Unmarshal(*x.Id, &id) != nil || id < 0 || id >= len(results) {
+			return nil, fmt.Errorf("batch %s: invalid response id", method)
+		}
+		if x.Error != nil {
+			return nil, fmt.Errorf("batch %s: %v", method, x.Error["message"])
+		}
+		results[id] = x
+	}
+	for i, x := range results {
+		if x == nil {
+			return nil, fmt.Errorf("batch %s: missing response %d", method, i)
+		}
+	}
+	return results, nil
+}
+
+// 批量查询区块, 不存在的区块为 nil; 节点不支持批量请求时逐个查询
+func (r *RPCClient) GetBlocksByHeight(heights []int64, fullList bool) ([]*GetBlockReply, error) {
+	var paramsList []interface{}
+	for _, height := range heights {
+		paramsList = append(paramsList, []interface{}{fmt.Sprintf("0x%x", height), fullList})
+	}
+
+	blocks := make([]*GetBlockReply, len(heights))
+	list, err := r.doBatch(r.Url, CoinId+"_getBlockByNumber", paramsList)
+	if err == ErrBatchUnsupported {
+		for i, height := range heights {
+			if blocks[i], err = r.GetBlockByHeight(height, fullList); err != nil {
+				return nil, err
+			}
+		}
+		return blocks, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	for i, x := range list {
+		if x.Result != nil {
+			if err := json.Unmarshal(*x.Result, &blocks[i]); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return blocks, nil
+}
+
 func (r *RPCClient) Check() (bool, error) {
 	_, err := r.GetWork()
 	if err != nil {
@@ -0,0 +1,73 @@
+package rpc
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestGetBlocksByHeightFallback(t *testing.T) {
+	var calls int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		body, _ := ioutil.ReadAll(r.Body)
+		if strings.HasPrefix(string(body), "[") {
+			fmt.Fprint(w, `{"jsonrpc":"2.0","id":null,"error":{"code":-32600,"message":"batch not supported"}}`)
+			return
+		}
+		var req struct {
+			Params []json.RawMessage
+		}
+		json.Unmarshal(body, &req)
+		fmt.Fprintf(w, `{"jsonrpc":"2.0","id":0,"result":{"number":%s}}`, req.Params[0])
+	}))
+	defer srv.Close()
+
+	c, _ := NewRPCClient("test", srv.URL, time.Second)
+	blocks, err := c.GetBlocksByHeight([]int64{1, 2}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(blocks) != 2 || blocks[0].Number != "0x1" || blocks[1].Number != "0x2" || calls != 3 {
+		t.Fatalf("blocks = %+v, calls = %d", blocks, calls)
+	}
+	if c.Sick() || c.FailsCount != 0 {
+		t.Fatal("client marked sick for unsupported batch")
+	}
+}
+
+func TestGetBlocksByHeightError(t *testing.T) {
+	var calls int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		http.Error(w, "overloaded", http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+
+	// 其它错误直接返回, 不逐个重试
+	c, _ := NewRPCClient("test", srv.URL, time.Second)
+	if _, err := c.GetBlocksByHeight([]int64{1, 2}, false); err == nil || calls != 1 {
+		t.Fatalf("err = %v, calls = %d", err, calls)
+	}
+}
+
+func TestGetBlocksByHeightForbidden(t *testing.T) {
+	var calls int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.WriteHeader(http.StatusForbidden)
+		fmt.Fprint(w, `{"error":"forbidden"}`)
+	}))
+	defer srv.Close()
+
+	// 认证网关返回的错误不能当作不支持批量请求
+	c, _ := NewRPCClient("test", srv.URL, time.Second)
+	if _, err := c.GetBlocksByHeight([]int64{1, 2}, false); err == nil || err == ErrBatchUnsupported || calls != 1 {
+		t.Fatalf("err = %v, calls = %d", err, calls)
+	}
+}
@@ -0,0 +1,655 @@
+// Stratum 挖矿代理
+//
+// 矿机使用 EthereumStratum/1.0 协议连接代理, 代理从节点的 getWork 获取工作,
+// 用 ethash 验证 share, 满足网络难度的结果通过 submitWork 提交给节点.
+package stratum
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common/hexutil"
+
+	"xcoin/HayekTool/pkg/util"
+)
+
+const (
+	DefaultListen     = ":8008"
+	DefaultDifficulty = 1.0 // share 难度, 1 表示 2^32 次哈希
+	DefaultMaxJobs    = 8
+	DefaultTimeout    = time.Minute * 10
+
+	protocolVersion = "EthereumStratum/1.0.0"
+	extranonceSize  = 2 // 代理分配的 nonce 前缀字节数, 矿机提交剩下的 6 字节
+	hashrateWindow  = time.Minute * 10
+	maxLineSize     = 1024
+)
+
+// 错误码
+const (
+	ErrOther         = 20
+	ErrJobNotFound   = 21 // 过期的任务
+	ErrDuplicate     = 22
+	ErrLowDifficulty = 23
+	ErrUnauthorized  = 24
+	ErrNotSubscribed = 25
+)
+
+// 节点接口, *rpc.RPCClient 实现了这个接口
+type Node interface {
+	GetWork() ([]string, error)
+	SubmitBlock(params []string) (bool, error)
+}
+
+// 计算 mix digest 和结果, *ethash.Light 实现了这个接口
+type Hasher interface {
+	Compute(height uint64, hash []byte, nonce uint64) (mix, result []byte)
+}
+
+type Config struct {
+	Listen       string
+	Difficulty   float64       // share 难度
+	PollInterval time.Duration // 轮询节点工作的间隔
+	MaxJobs      int           // 同一高度保留的任务数, 更早的任务按过期处理
+	Timeout      time.Duration // 连接空闲超时
+}
+
+// 矿工统计
+type WorkerStats struct {
+	Name            string
+	Connections     int
+	ValidShares     int64
+	StaleShares     int64
+	InvalidShares   int64
+	DuplicateShares int64
+	Blocks          int64 // 节点接受的区块
+	RejectedBlocks  int64 // 节点拒绝的区块
+	LastShare       time.Time
+	Hashrate        float64 // 最近 10 分钟的有效 share 估算(H/s)
+
+	shares []shareRecord
+}
+
+type shareRecord struct {
+	time   time.Time
+	hashes float64
+}
+
+type job struct {
+	id          string
+	header      []byte
+	seed        []byte
+	height      uint64
+	networkDiff *big.Int
+	submitted   map[uint64]bool
+}
+
+type Proxy struct {
+	cfg    Config
+	node   Node
+	hasher Hasher
+
+	shareDiff *big.Int // share 难度对应的哈希次数
+
+	mu         sync.Mutex
+	jobs       []*job // 当前高度的任务, 最新的在最后
+	jobSeq     uint64
+	lastWork   string
+	sessions   map[*session]bool
+	extranonce uint16          // 上一次分配的 nonce 前缀
+	prefixes   map[uint16]bool // 正在使用的 nonce 前缀, 回绕后跳过, 避免两个矿机搜索同样的 nonce
+	workers    map[string]*WorkerStats
+
+	listener net.Listener
+	quit     chan struct{}
+	wg       sync.WaitGroup
+}
+
+func New(cfg Config, node Node, hasher Hasher) *Proxy {
+	if cfg.Listen == "" {
+		cfg.Listen = DefaultListen
+	}
+	if cfg.Difficulty <= 0 {
+		cfg.Difficulty = DefaultDifficulty
+	}
+	if cfg.PollInterval <= 0 {
+		cfg.PollInterval = time.Second
+	}
+	if cfg.MaxJobs <= 0 {
+		cfg.MaxJobs = DefaultMaxJobs
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = DefaultTimeout
+	}
+
+	shareDiff, _ := new(big.Float).Mul(big.NewFloat(cfg.Difficulty), new(big.Float).SetInt(util.Pow32)).Int(nil)
+
+	return &Proxy{
+		cfg:       cfg,
+		node:      node,
+		hasher:    hasher,
+		shareDiff: shareDiff,
+		sessions:  make(map[*session]bool),
+		prefixes:  make(map[uint16]bool),
+		workers:   make(map[string]*WorkerStats),
+		quit:      make(chan struct{}),
+	}
+}
+
+// 开始监听并轮询节点的工作
+func (p *Proxy) Start() error {
+	if err := p.refresh(); err != nil {
+		log.Printf("stratum: GetWork: %v", err)
+	}
+
+	l, err := net.Listen("tcp", p.cfg.Listen)
+	if err != nil {
+		return err
+	}
+	p.listener = l
+	log.Printf("stratum: listen on %v, share difficulty %v", l.Addr(), p.cfg.Difficulty)
+
+	p.wg.Add(2)
+	go p.acceptLoop()
+	go p.pollLoop()
+	return nil
+}
+
+func (p *Proxy) Addr() net.Addr {
+	return p.listener.Addr()
+}
+
+// 停止监听, 关闭所有连接
+func (p *Proxy) Stop() {
+	close(p.quit)
+	p.listener.Close()
+
+	p.mu.Lock()
+	for s := range p.sessions {
+		s.conn.Close()
+	}
+	p.mu.Unlock()
+
+	p.wg.Wait()
+}
+
+// 矿工统计, 按名字排序
+func (p *Proxy) Stats() []WorkerStats {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var list []WorkerStats
+	now := time.Now()
+	for _, w := range p.workers {
+		w.trim(now)
+		var hashes float64
+		for _, s := range w.shares {
+			hashes += s.hashes
+		}
+		w.Hashrate = hashes / hashrateWindow.Seconds()
+
+		x := *w
+		x.shares = nil
+		list = append(list, x)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list
+}
+
+func (w *WorkerStats) trim(now time.Time) {
+	i := 0
+	for i < len(w.shares) && now.Sub(w.shares[i].time) > hashrateWindow {
+		i++
+	}
+	w.shares = w.shares[i:]
+}
+
+func (p *Proxy) acceptLoop() {
+	defer p.wg.Done()
+
+	for {
+		conn, err := p.listener.Accept()
+		if err != nil {
+			select {
+			case <-p.quit:
+				return
+			default:
+			}
+			log.Printf("stratum: accept: %v", err)
+			time.Sleep(time.Second)
+			continue
+		}
+
+		s := &session{proxy: p, conn: conn}
+		p.mu.Lock()
+		p.sessions[s] = true
+		p.mu.Unlock()
+
+		p.wg.Add(1)
+		go func() {
+			defer p.wg.Done()
+			s.serve()
+		}()
+	}
+}
+
+func (p *Proxy) pollLoop() {
+	defer p.wg.Done()
+
+	ticker := time.NewTicker(p.cfg.PollInterval)
+	defer ticker.Stop()
+
+	var lastErr error
+	for {
+		select {
+		case <-p.quit:
+			return
+		case <-ticker.C:
+		}
+
+		err := p.refresh()
+		if err != nil && lastErr == nil {
+			log.Printf("stratum: GetWork: %v", err)
+		}
+		if err == nil && lastErr != nil {
+			log.Printf("stratum: GetWork ok")
+		}
+		lastErr = err
+	}
+}
+
+// 获取节点的工作, 有变化时生成新任务并通知所有矿机
+func (p *Proxy) refresh() error {
+	work, err := p.node.GetWork()
+	if err != nil {
+		return err
+	}
+	if len(work) < 4 {
+		return fmt.Errorf("invalid work, len = %d", len(work))
+	}
+
+	header, err := hexutil.Decode(work[0])
+	if err != nil || len(header) != 32 {
+		return fmt.Errorf("invalid work header %q", work[0])
+	}
+	seed, err := hexutil.Decode(work[1])
+	if err != nil || len(seed) != 32 {
+		return fmt.Errorf("invalid work seed %q", work[1])
+	}
+	height, err := hexutil.DecodeUint64(work[3])
+	if err != nil {
+		return fmt.Errorf("invalid work height %q", work[3])
+	}
+
+	p.mu.Lock()
+	key := strings.Join(work[:4], ",")
+	if key == p.lastWork {
+		p.mu.Unlock()
+		return nil
+	}
+	p.lastWork = key
+
+	p.jobSeq++
+	j := &job{
+		id:          fmt.Sprintf("%x", p.jobSeq),
+		header:      header,
+		seed:        seed,
+		height:      height,
+		networkDiff: util.TargetHexToDiff(work[2]),
+		submitted:   make(map[uint64]bool),
+	}
+
+	// 高度变化时旧任务全部过期
+	clean := len(p.jobs) == 0 || p.jobs[len(p.jobs)-1].height != height
+	if clean {
+		p.jobs = nil
+	}
+	p.jobs = append(p.jobs, j)
+	if len(p.jobs) > p.cfg.MaxJobs {
+		p.jobs = p.jobs[len(p.jobs)-p.cfg.MaxJobs:]
+	}
+
+	var sessions []*session
+	for s := range p.sessions {
+		sessions = append(sessions, s)
+	}
+	p.mu.Unlock()
+
+	for _, s := range sessions {
+		if s.isAuthorized() {
+			s.notify(j, clean)
+		}
+	}
+	return nil
+}
+
+func (p *Proxy) currentJob() *job {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if len(p.jobs) == 0 {
+		return nil
+	}
+	return p.jobs[len(p.jobs)-1]
+}
+
+func (p *Proxy) worker(name string) *WorkerStats {
+	w := p.workers[name]
+	if w == nil {
+		w = &WorkerStats{Name: name}
+		p.workers[name] = w
+	}
+	return w
+}
+
+// 验证 share, 满足网络难度时提交给节点
+func (p *Proxy) submit(worker, jobId string, nonce uint64) *stratumError {
+	p.mu.Lock()
+	var j *job
+	for _, x := range p.jobs {
+		if x.id == jobId {
+			j = x
+		}
+	}
+	w := p.worker(worker)
+	if j == nil {
+		w.StaleShares++
+		p.mu.Unlock()
+		return &stratumError{ErrJobNotFound, "job not found"}
+	}
+	if j.submitted[nonce] {
+		w.DuplicateShares++
+		p.mu.Unlock()
+		return &stratumError{ErrDuplicate, "duplicate share"}
+	}
+	j.submitted[nonce] = true
+	p.mu.Unlock()
+
+	mix, result := p.hasher.Compute(j.height, j.header, nonce)
+	diff := util.TargetHexToDiff(hexutil.Encode(result))
+
+	p.mu.Lock()
+	if diff.Cmp(p.shareDiff) < 0 {
+		w.InvalidShares++
+		p.mu.Unlock()
+		return &stratumError{ErrLowDifficulty, "low difficulty share"}
+	}
+
+	now := time.Now()
+	hashes, _ := new(big.Float).SetInt(p.shareDiff).Float64()
+	w.ValidShares++
+	w.LastShare = now
+	w.shares = append(w.shares, shareRecord{time: now, hashes: hashes})
+	w.trim(now)
+	p.mu.Unlock()
+
+	if diff.Cmp(j.networkDiff) < 0 {
+		return nil
+	}
+
+	params := []string{
+		fmt.Sprintf("0x%016x", nonce),
+		hexutil.Encode(j.header),
+		hexutil.Encode(mix),
+	}
+	ok, err := p.node.SubmitBlock(params)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	switch {
+	case err != nil:
+		w.RejectedBlocks++
+		log.Printf("stratum: block from %s at height %d: submit: %v", worker, j.height, err)
+	case !ok:
+		w.RejectedBlocks++
+		log.Printf("stratum: block from %s at height %d rejected by node", worker, j.height)
+	default:
+		w.Blocks++
+		log.Printf("stratum: block from %s at height %d accepted, nonce %s", worker, j.height, params[0])
+	}
+	return nil
+}
+
+type stratumError struct {
+	code    int
+	message string
+}
+
+func (e *stratumError) MarshalJSON() ([]byte, error) {
+	return json.Marshal([]interface{}{e.code, e.message, nil})
+}
+
+// 矿机连接
+type session struct {
+	proxy *Proxy
+	conn  net.Conn
+
+	writeMu sync.Mutex
+
+	mu         sync.Mutex
+	extranonce []byte
+	worker     string
+}
+
+type request struct {
+	Id     *json.RawMessage `json:"id"`
+	Method string           `json:"method"`
+	Params []interface{}    `json:"params"`
+}
+
+func (r *request) param(i int) string {
+	if i < len(r.Params) {
+		if s, ok := r.Params[i].(string); ok {
+			return s
+		}
+	}
+	return ""
+}
+
+func (s *session) serve() {
+	p := s.proxy
+	defer func() {
+		s.conn.Close()
+
+		s.mu.Lock()
+		worker, extranonce := s.worker, s.extranonce
+		s.mu.Unlock()
+
+		p.mu.Lock()
+		delete(p.sessions, s)
+		if extranonce != nil {
+			delete(p.prefixes, binary.BigEndian.Uint16(extranonce))
+		}
+		if worker != "" {
+			p.worker(worker).Connections--
+		}
+		p.mu.Unlock()
+	}()
+
+	reader := bufio.NewReaderSize(s.conn, maxLineSize)
+	for {
+		s.conn.SetReadDeadline(time.Now().Add(p.cfg.Timeout))
+
+		line, isPrefix, err := reader.ReadLine()
+		if err != nil {
+			return
+		}
+		if isPrefix {
+			log.Printf("stratum: %v: line too long", s.conn.RemoteAddr())
+			return
+		}
+		if len(strings.TrimSpace(string(line))) == 0 {
+			continue
+		}
+
+		var req request
+		if err := json.Unmarshal(line, &req); err != nil {
+			log.Printf("stratum: %v: invalid request: %v", s.conn.RemoteAddr(), err)
+			return
+		}
+		if err := s.handle(&req); err != nil {
+			return
+		}
+	}
+}
+
+func (s *session) handle(req *request) error {
+	p := s.proxy
+
+	switch req.Method {
+	case "mining.subscribe":
+		// 重复订阅时使用同一个前缀
+		s.mu.Lock()
+		extranonce := s.extranonce
+		s.mu.Unlock()
+
+		if extranonce == nil {
+			p.mu.Lock()
+			prefix, ok := p.allocExtranonce()
+			p.mu.Unlock()
+			if !ok {
+				s.reply(req.Id, nil, &stratumError{ErrOther, "too many connections"})
+				return fmt.Errorf("no free extranonce")
+			}
+
+			extranonce = make([]byte, extranonceSize)
+			binary.BigEndian.PutUint16(extranonce, prefix)
+			s.mu.Lock()
+			s.extranonce = extranonce
+			s.mu.Unlock()
+		}
+
+		return s.reply(req.Id, []interface{}{
+			[]string{"mining.notify", hex.EncodeToString(extranonce), protocolVersion},
+			hex.EncodeToString(extranonce),
+		}, nil)
+
+	case "mining.extranonce.subscribe":
+		return s.reply(req.Id, true, nil)
+
+	case "mining.authorize":
+		s.mu.Lock()
+		subscribed := s.extranonce != nil
+		s.mu.Unlock()
+		if !subscribed {
+			return s.reply(req.Id, nil, &stratumError{ErrNotSubscribed, "not subscribed"})
+		}
+
+		name := req.param(0)
+		if name == "" {
+			return s.reply(req.Id, false, &stratumError{ErrUnauthorized, "missing worker name"})
+		}
+
+		s.mu.Lock()
+		old := s.worker
+		s.worker = name
+		s.mu.Unlock()
+
+		p.mu.Lock()
+		if old != "" {
+			p.worker(old).Connections--
+		}
+		p.worker(name).Connections++
+		p.mu.Unlock()
+
+		if err := s.reply(req.Id, true, nil); err != nil {
+			return err
+		}
+		if err := s.send("mining.set_difficulty", []interface{}{p.cfg.Difficulty}); err != nil {
+			return err
+		}
+		if j := p.currentJob(); j != nil {
+			return s.notify(j, true)
+		}
+		return nil
+
+	case "mining.submit":
+		s.mu.Lock()
+		worker, extranonce := s.worker, s.extranonce
+		s.mu.Unlock()
+		if worker == "" {
+			return s.reply(req.Id, false, &stratumError{ErrUnauthorized, "unauthorized worker"})
+		}
+
+		nonce2, err := hex.DecodeString(strings.TrimPrefix(req.param(2), "0x"))
+		if err != nil || len(nonce2) != 8-extranonceSize {
+			p.mu.Lock()
+			p.worker(worker).InvalidShares++
+			p.mu.Unlock()
+			return s.reply(req.Id, false, &stratumError{ErrOther, "invalid nonce"})
+		}
+		nonce := binary.BigEndian.Uint64(append(append([]byte{}, extranonce...), nonce2...))
+
+		if e := p.submit(worker, req.param(1), nonce); e != nil {
+			return s.reply(req.Id, false, e)
+		}
+		return s.reply(req.Id, true, nil)
+
+	default:
+		return s.reply(req.Id, nil, &stratumError{ErrOther, "unknown method " + req.Method})
+	}
+}
+
+func (s *session) isAuthorized() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.worker != ""
+}
+
+func (s *session) notify(j *job, clean bool) error {
+	return s.send("mining.notify", []interface{}{
+		j.id, hex.EncodeToString(j.seed), hex.EncodeToString(j.header), clean,
+	})
+}
+
+// 分配一个没有被其它连接使用的 nonce 前缀, 全部被占用时返回 false; 需要持有 p.mu
+func (p *Proxy) allocExtranonce() (uint16, bool) {
+	for i := 0; i < 1<<(8*extranonceSize); i++ {
+		p.extranonce++
+		if !p.prefixes[p.extranonce] {
+			p.prefixes[p.extranonce] = true
+			return p.extranonce, true
+		}
+	}
+	return 0, false
+}
+
+func (s *session) reply(id *json.RawMessage, result interface{}, e *stratumError) error {
+	msg := map[string]interface{}{"id": id, "result": result, "error": nil}
+	if e != nil {
+		msg["error"] = e
+	}
+	return s.write(msg)
+}
+
+func (s *session) send(method string, params []interface{}) error {
+	return s.write(map[string]interface{}{"id": nil, "method": method, "params": params})
+}
+
+func (s *session) write(msg interface{}) error {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+
+	s.conn.SetWriteDeadline(time.Now().Add(time.Second * 10))
+	if _, err := s.conn.Write(append(data, '\n')); err != nil {
+		return errors.New("stratum: write: " + err.Error())
+	}
+	return nil
+}
@@ -0,0 +1,206 @@
+package stratum
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+// 测试节点, 记录提交的结果
+type fakeNode struct {
+	mu        sync.Mutex
+	work      []string
+	submitted [][]string
+}
+
+func (n *fakeNode) setWork(header string, height int) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	// 网络难度 2^48
+	target := "0x" + fmt.Sprintf("%064x", new(big.Int).Lsh(big.NewInt(1), 256-48))
+	n.work = []string{header, "0x" + fmt.Sprintf("%064x", 0), target, fmt.Sprintf("0x%x", height), "0x00", "0x5f000000"}
+}
+
+func (n *fakeNode) GetWork() ([]string, error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.work, nil
+}
+
+func (n *fakeNode) SubmitBlock(params []string) (bool, error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.submitted = append(n.submitted, params)
+	return true, nil
+}
+
+// 结果的难度为 2^(nonce 最低字节), 方便构造不同难度的 share
+type fakeHasher struct{}
+
+func (fakeHasher) Compute(height uint64, hash []byte, nonce uint64) (mix, result []byte) {
+	n := new(big.Int).Rsh(new(big.Int).Lsh(big.NewInt(1), 256), uint(nonce&0xff))
+	b := n.Sub(n, big.NewInt(1)).Bytes()
+	result = make([]byte, 32)
+	copy(result[32-len(b):], b)
+	return make([]byte, 32), result
+}
+
+// 测试矿机
+type fakeMiner struct {
+	t      *testing.T
+	conn   net.Conn
+	reader *bufio.Reader
+	id     int
+}
+
+type message struct {
+	Id     *int
+	Method string
+	Params []interface{}
+	Result interface{}
+	Error  []interface{}
+}
+
+func dialMiner(t *testing.T, addr string) *fakeMiner {
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &fakeMiner{t: t, conn: conn, reader: bufio.NewReader(conn)}
+}
+
+func (m *fakeMiner) read() *message {
+	m.conn.SetReadDeadline(time.Now().Add(time.Second * 5))
+	line, err := m.reader.ReadBytes('\n')
+	if err != nil {
+		m.t.Fatal(err)
+	}
+	msg := new(message)
+	if err := json.Unmarshal(line, msg); err != nil {
+		m.t.Fatal(err)
+	}
+	return msg
+}
+
+// 发送请求, 返回响应; 之前收到的通知放在 notes 中
+func (m *fakeMiner) call(method string, params ...interface{}) (resp *message, notes []*message) {
+	m.id++
+	data, _ := json.Marshal(map[string]interface{}{"id": m.id, "method": method, "params": params})
+	if _, err := m.conn.Write(append(data, '\n')); err != nil {
+		m.t.Fatal(err)
+	}
+	for {
+		msg := m.read()
+		if msg.Id != nil && *msg.Id == m.id {
+			return msg, notes
+		}
+		notes = append(notes, msg)
+	}
+}
+
+func TestProxy(t *testing.T) {
+	node := new(fakeNode)
+	node.setWork("0x"+fmt.Sprintf("%064x", 1), 100)
+
+	p := New(Config{Listen: "127.0.0.1:0", Difficulty: 1, PollInterval: time.Millisecond * 20}, node, fakeHasher{})
+	if err := p.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer p.Stop()
+
+	m := dialMiner(t, p.Addr().String())
+	defer m.conn.Close()
+
+	if resp, _ := m.call("mining.submit", "w1", "1", "000000000000"); resp.Error == nil || resp.Error[0].(float64) != ErrUnauthorized {
+		t.Fatalf("submit before authorize: %+v", resp)
+	}
+
+	resp, _ := m.call("mining.subscribe", "test", protocolVersion)
+	extranonce := resp.Result.([]interface{})[1].(string)
+	if extranonce != "0001" {
+		t.Fatalf("extranonce = %v", extranonce)
+	}
+
+	m.call("mining.authorize", "w1", "x")
+	if msg := m.read(); msg.Method != "mining.set_difficulty" || msg.Params[0].(float64) != 1 {
+		t.Fatalf("set_difficulty: %+v", msg)
+	}
+	notify := m.read()
+	if notify.Method != "mining.notify" || notify.Params[0] != "1" || notify.Params[3] != true {
+		t.Fatalf("notify: %+v", notify)
+	}
+
+	submit := func(job string, nonce2 string) *message {
+		resp, _ := m.call("mining.submit", "w1", job, nonce2)
+		return resp
+	}
+	for _, tt := range []struct {
+		nonce2 string
+		err    int
+	}{
+		{"000000000028", 0},                // 难度 2^40, 有效 share
+		{"000000000028", ErrDuplicate},     // 重复
+		{"00000000000a", ErrLowDifficulty}, // 难度 2^10
+		{"000000000032", 0},                // 难度 2^50, 满足网络难度
+	} {
+		resp := submit("1", tt.nonce2)
+		if tt.err == 0 && resp.Error != nil || tt.err != 0 && (resp.Error == nil || int(resp.Error[0].(float64)) != tt.err) {
+			t.Fatalf("submit %s: %+v", tt.nonce2, resp)
+		}
+	}
+
+	node.mu.Lock()
+	if len(node.submitted) != 1 || node.submitted[0][0] != "0x0001000000000032" {
+		t.Fatalf("submitted = %v", node.submitted)
+	}
+	node.mu.Unlock()
+
+	// 新高度的任务, 旧任务过期
+	node.setWork("0x"+fmt.Sprintf("%064x", 2), 101)
+	if msg := m.read(); msg.Method != "mining.notify" || msg.Params[0] != "2" || msg.Params[3] != true {
+		t.Fatalf("notify: %+v", msg)
+	}
+	if resp := submit("1", "000000000030"); resp.Error == nil || int(resp.Error[0].(float64)) != ErrJobNotFound {
+		t.Fatalf("stale submit: %+v", resp)
+	}
+
+	stats := p.Stats()
+	if len(stats) != 1 {
+		t.Fatalf("stats = %+v", stats)
+	}
+	s := stats[0]
+	if s.Name != "w1" || s.Connections != 1 || s.ValidShares != 2 || s.DuplicateShares != 1 ||
+		s.InvalidShares != 1 || s.StaleShares != 1 || s.Blocks != 1 || s.Hashrate <= 0 {
+		t.Fatalf("stats = %+v", s)
+	}
+}
+
+func TestAllocExtranonce(t *testing.T) {
+	p := New(Config{}, nil, nil)
+
+	// 回绕后跳过正在使用的前缀
+	p.extranonce = 0xfffe
+	p.prefixes[0xffff] = true
+	p.prefixes[0] = true
+	if prefix, ok := p.allocExtranonce(); !ok || prefix != 1 {
+		t.Fatalf("prefix = %d, %v", prefix, ok)
+	}
+
+	// 全部被占用时拒绝新的连接
+	for i := 0; i < 1<<16; i++ {
+		p.prefixes[uint16(i)] = true
+	}
+	if _, ok := p.allocExtranonce(); ok {
+		t.Fatal("expect no free extranonce")
+	}
+	delete(p.prefixes, 0x1234)
+	if prefix, ok := p.allocExtranonce(); !ok || prefix != 0x1234 {
+		t.Fatalf("prefix = %d, %v", prefix, ok)
+	}
+}
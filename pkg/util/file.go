@@ -0,0 +1,59 @@
+package util
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+)
+
+// 原子地写入文件: 先写同目录下的临时文件并同步到磁盘, 再改名覆盖, 最后同步目录
+//
+// 中途退出或者断电时, 读到的要么是旧内容, 要么是完整的新内容.
+func WriteFileAtomic(path string, data []byte, perm os.FileMode) (err error) {
+	path = filepath.Clean(path)
+	dir := filepath.Dir(path)
+
+	f, err := ioutil.TempFile(dir, filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			os.Remove(f.Name())
+		}
+	}()
+
+	if _, err = f.Write(data); err == nil {
+		err = f.Sync()
+	}
+	if err1 := f.Close(); err == nil {
+		err = err1
+	}
+	if err == nil {
+		err = os.Chmod(f.Name(), perm)
+	}
+	if err != nil {
+		return err
+	}
+	if err = os.Rename(f.Name(), path); err != nil {
+		return err
+	}
+	return syncDir(dir)
+}
+
+// 同步目录, 保证改名本身落盘; Windows 不支持打开目录同步
+func syncDir(dir string) error {
+	if runtime.GOOS == "windows" {
+		return nil
+	}
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	err = d.Sync()
+	if err1 := d.Close(); err == nil {
+		err = err1
+	}
+	return err
+}
@@ -75,6 +75,11 @@ func TargetHexToDiff(targetHex string) *big.Int {
 	return new(big.Int).Div(pow256, new(big.Int).SetBytes(targetBytes))
 }
 
+// 难度对应的目标值, 满足难度的结果不大于目标值
+func DiffToTarget(diff *big.Int) *big.Int {
+	return new(big.Int).Div(pow256, diff)
+}
+
 func ValidateAddress(addy string, poolAddy string) bool {
 	return true
 }
@@ -149,3 +154,40 @@ func GenAddress(enableEIP55 bool) (key, addr string) {
 	}
 	return
 }
+
+// 解析带单位的金额, 返回 wei
+//
+// 支持的单位: wei, gwei(shannon), hyk(ether), 没有单位时按 hyk 计算, 比如 "1.5", "1.5 HYK", "100gwei".
+// 换算成 wei 后必须是整数.
+func ParseAmount(s string) (*big.Int, error) {
+	s = strings.TrimSpace(s)
+	i := strings.IndexFunc(s, func(r rune) bool {
+		return (r < '0' || r > '9') && r != '.'
+	})
+	num, unit := s, ""
+	if i >= 0 {
+		num, unit = s[:i], strings.ToLower(strings.TrimSpace(s[i:]))
+	}
+
+	var scale *big.Int
+	switch unit {
+	case "", "hyk", "ether":
+		scale = Ether
+	case "gwei", "shannon":
+		scale = Shannon
+	case "wei":
+		scale = big.NewInt(1)
+	default:
+		return nil, fmt.Errorf("invalid amount %q: unknown unit %q", s, unit)
+	}
+
+	v, ok := new(big.Rat).SetString(num)
+	if !ok || num == "" {
+		return nil, fmt.Errorf("invalid amount %q", s)
+	}
+	v.Mul(v, new(big.Rat).SetInt(scale))
+	if !v.IsInt() {
+		return nil, fmt.Errorf("invalid amount %q: fraction of wei", s)
+	}
+	return new(big.Int).Set(v.Num()), nil
+}
@@ -2,6 +2,10 @@ package util
 
 import (
 	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
 	"testing"
 )
 
@@ -38,3 +42,57 @@ func TestGetHashDifficultyWithBrokenHash(t *testing.T) {
 		t.Error("Must be no result and not ok")
 	}
 }
+
+func TestParseAmount(t *testing.T) {
+	for _, tt := range []struct {
+		s   string
+		wei string
+	}{
+		{"1", "1000000000000000000"},
+		{"1.5 HYK", "1500000000000000000"},
+		{"0.000000000000000001hyk", "1"},
+		{"100 gwei", "100000000000"},
+		{"2.5 Shannon", "2500000000"},
+		{"123 wei", "123"},
+		{"1 ether", "1000000000000000000"},
+	} {
+		v, err := ParseAmount(tt.s)
+		if err != nil || v.String() != tt.wei {
+			t.Errorf("ParseAmount(%q) = %v, %v, expect %s", tt.s, v, err, tt.wei)
+		}
+	}
+
+	for _, s := range []string{"", "HYK", "1.5 wei", "1 btc", "-1", "1e3", "1..2"} {
+		if v, err := ParseAmount(s); err == nil {
+			t.Errorf("ParseAmount(%q) = %v, expect error", s, v)
+		}
+	}
+}
+
+func TestWriteFileAtomic(t *testing.T) {
+	dir, err := ioutil.TempDir("", "util")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "state.json")
+	for _, s := range []string{"old", "new"} {
+		if err := WriteFileAtomic(path, []byte(s), 0640); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if data, _ := ioutil.ReadFile(path); string(data) != "new" {
+		t.Fatalf("content = %q", data)
+	}
+	if fi, _ := os.Stat(path); runtime.GOOS != "windows" && fi.Mode().Perm() != 0640 {
+		t.Fatalf("mode = %v", fi.Mode())
+	}
+	if files, _ := ioutil.ReadDir(dir); len(files) != 1 {
+		t.Fatalf("temp files left: %d files", len(files))
+	}
+
+	if err := WriteFileAtomic(filepath.Join(dir, "missing", "x"), nil, 0644); err == nil {
+		t.Fatal("expect error for missing directory")
+	}
+}